@@ -4,6 +4,7 @@ import (
 	"io"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/talostrading/sonic/internal"
 	"github.com/talostrading/sonic/sonicerrors"
@@ -25,6 +26,10 @@ type AsyncAdapter struct {
 	rw     io.ReadWriter
 	rc     syscall.RawConn
 	closed uint32
+
+	// The deadlines of AsyncReadTimeout and AsyncWriteTimeout.
+	readDeadline  deadline
+	writeDeadline deadline
 }
 
 // NewAsyncAdapter takes in an IO instance and an interface of syscall.Conn and io.ReadWriter
@@ -89,6 +94,16 @@ func (a *AsyncAdapter) AsyncReadAll(b []byte, cb AsyncCallback) {
 	a.scheduleRead(b, 0, true, cb)
 }
 
+// AsyncReadTimeout is like AsyncRead, but the read completes with sonicerrors.ErrTimeout if no data is read within the
+// timeout. A pending write is not affected.
+func (a *AsyncAdapter) AsyncReadTimeout(b []byte, timeout time.Duration, cb AsyncCallback) {
+	a.readDeadline.op = time.Now().Add(timeout)
+	a.scheduleRead(b, 0, false, func(err error, n int) {
+		a.readDeadline.op = time.Time{}
+		cb(err, n)
+	})
+}
+
 func (a *AsyncAdapter) asyncReadNow(b []byte, readBytes int, readAll bool, cb AsyncCallback) {
 	n, err := a.rw.Read(b[readBytes:])
 	readBytes += n
@@ -119,12 +134,16 @@ func (a *AsyncAdapter) scheduleRead(b []byte, readBytes int, readAll bool, cb As
 		cb(err, readBytes)
 	} else {
 		a.ioc.Register(&a.slot)
+		if err := a.readDeadline.arm(a.ioc, a.expireReads); err != nil {
+			a.abortReads(err)
+		}
 	}
 }
 
 func (a *AsyncAdapter) getReadHandler(b []byte, readBytes int, readAll bool, cb AsyncCallback) internal.Handler {
 	return func(err error) {
 		a.ioc.Deregister(&a.slot)
+		a.readDeadline.disarm()
 
 		if err != nil {
 			cb(err, readBytes)
@@ -152,6 +171,16 @@ func (a *AsyncAdapter) AsyncWriteAll(b []byte, cb AsyncCallback) {
 	a.scheduleWrite(b, 0, true, cb)
 }
 
+// AsyncWriteTimeout is like AsyncWrite, but the write completes with sonicerrors.ErrTimeout if no data is written
+// within the timeout. A pending read is not affected.
+func (a *AsyncAdapter) AsyncWriteTimeout(b []byte, timeout time.Duration, cb AsyncCallback) {
+	a.writeDeadline.op = time.Now().Add(timeout)
+	a.scheduleWrite(b, 0, false, func(err error, n int) {
+		a.writeDeadline.op = time.Time{}
+		cb(err, n)
+	})
+}
+
 func (a *AsyncAdapter) asyncWriteNow(b []byte, writtenBytes int, writeAll bool, cb AsyncCallback) {
 	n, err := a.rw.Write(b[writtenBytes:])
 	writtenBytes += n
@@ -182,12 +211,16 @@ func (a *AsyncAdapter) scheduleWrite(b []byte, writtenBytes int, writeAll bool,
 		cb(err, writtenBytes)
 	} else {
 		a.ioc.Register(&a.slot)
+		if err := a.writeDeadline.arm(a.ioc, a.expireWrites); err != nil {
+			a.abortWrites(err)
+		}
 	}
 }
 
 func (a *AsyncAdapter) getWriteHandler(b []byte, writtenBytes int, writeAll bool, cb AsyncCallback) internal.Handler {
 	return func(err error) {
 		a.ioc.Deregister(&a.slot)
+		a.writeDeadline.disarm()
 
 		if err != nil {
 			cb(err, writtenBytes)
@@ -202,6 +235,9 @@ func (a *AsyncAdapter) Close() error {
 		return io.EOF
 	}
 
+	a.readDeadline.close()
+	a.writeDeadline.close()
+
 	_ = a.ioc.poller.Del(&a.slot)
 
 	return syscall.Close(a.slot.Fd)
@@ -223,20 +259,36 @@ func (a *AsyncAdapter) Cancel() {
 }
 
 func (a *AsyncAdapter) cancelReads() {
+	a.abortReads(sonicerrors.ErrCancelled)
+}
+
+func (a *AsyncAdapter) cancelWrites() {
+	a.abortWrites(sonicerrors.ErrCancelled)
+}
+
+func (a *AsyncAdapter) expireReads(err error) {
+	a.abortReads(deadlineError(err))
+}
+
+func (a *AsyncAdapter) expireWrites(err error) {
+	a.abortWrites(deadlineError(err))
+}
+
+// abortReads completes the pending read, if any, with err.
+func (a *AsyncAdapter) abortReads(err error) {
 	if a.slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
-		err := a.ioc.poller.DelRead(&a.slot)
-		if err == nil {
-			err = sonicerrors.ErrCancelled
+		if derr := a.ioc.poller.DelRead(&a.slot); derr != nil {
+			err = derr
 		}
 		a.slot.Handlers[internal.ReadEvent](err)
 	}
 }
 
-func (a *AsyncAdapter) cancelWrites() {
+// abortWrites completes the pending write, if any, with err.
+func (a *AsyncAdapter) abortWrites(err error) {
 	if a.slot.Events&internal.PollerWriteEvent == internal.PollerWriteEvent {
-		err := a.ioc.poller.DelWrite(&a.slot)
-		if err == nil {
-			err = sonicerrors.ErrCancelled
+		if derr := a.ioc.poller.DelWrite(&a.slot); derr != nil {
+			err = derr
 		}
 		a.slot.Handlers[internal.WriteEvent](err)
 	}
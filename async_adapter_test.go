@@ -7,6 +7,8 @@ import (
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
 )
 
 var msg = []byte("hello, sonic!")
@@ -362,3 +364,60 @@ func TestAsyncWriteAll(t *testing.T) {
 		t.Fatalf("AsyncWriteAll completion handler not invoked. Did you call ioc.Run*/ioc.Poll*?")
 	}
 }
+
+func TestAsyncReadTimeout(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	done := make(chan struct{})
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:9086")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		client, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer client.Close()
+
+		// Never write anything such that the reads time out.
+		<-done
+	}()
+	defer close(done)
+
+	time.Sleep(10 * time.Millisecond)
+
+	client, err := net.Dial("tcp", "localhost:9086")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var adapter *AsyncAdapter
+	NewAsyncAdapter(ioc, client.(syscall.Conn), client, func(err error, a *AsyncAdapter) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		adapter = a
+	})
+	defer adapter.Close()
+
+	var readErr error
+	ran := false
+	start := time.Now()
+	adapter.AsyncReadTimeout(make([]byte, 128), 10*time.Millisecond, func(err error, _ int) {
+		ran = true
+		readErr = err
+	})
+	for !ran {
+		_ = ioc.RunOne()
+	}
+	if readErr != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a timeout err=%v", readErr)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("read timed out early")
+	}
+}
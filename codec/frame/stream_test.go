@@ -2,6 +2,7 @@ package frame
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
 )
 
 type testServer struct {
@@ -103,7 +105,10 @@ func runClient(port int, t *testing.T) {
 	var fn func(error, []byte)
 	fn = func(err error, frame []byte) {
 		if err != nil {
-			t.Fatal(err)
+			// The read pending when the IO is closed is cancelled.
+			if !errors.Is(err, sonicerrors.ErrCancelled) {
+				t.Fatal(err)
+			}
 		} else {
 			if string(frame) != "hello, world!" {
 				t.Fatalf("invalid frame on read %d", n)
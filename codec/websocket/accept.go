@@ -0,0 +1,351 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// maxUpgradeSize bounds the size of the HTTP upgrade request a server reads,
+// and of the upgrade response a client reads.
+const maxUpgradeSize = 16 * 1024
+
+// UpgradeCallback is invoked by a server with the upgrade request of a client,
+// once the request is validated. Header fields added to res are sent in the
+// upgrade response: setting Sec-WebSocket-Protocol selects a subprotocol
+// other than the one negotiated with SetSubprotocols.
+//
+// Returning an error rejects the upgrade. The response status is the one of a
+// *RejectError, or 403 Forbidden otherwise.
+type UpgradeCallback = func(req *http.Request, res http.Header) error
+
+// RejectError rejects an upgrade request with a custom response.
+type RejectError struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("upgrade rejected with status %d", e.StatusCode)
+}
+
+// Accept performs the handshake in the server role over stream, usually a
+// sonic.Conn accepted by a sonic.Listener. It blocks until the upgrade request
+// is read and answered, even if stream is nonblocking.
+func (s *WebsocketStream) Accept(stream sonic.Stream) error {
+	if s.role != RoleServer {
+		return ErrWrongHandshakeRole
+	}
+
+	s.reset()
+	s.hb = s.hb[:0]
+
+	var (
+		req *http.Request
+		n   int
+		err error
+	)
+	for req == nil {
+		if err = s.readUpgradeRequest(stream); err != nil {
+			return s.accepted(stream, 0, err)
+		}
+		if req, n, err = s.parseUpgradeRequest(); err != nil {
+			_ = s.writeAll(stream, rejectResponse(http.StatusBadRequest, nil, ""))
+			return s.accepted(stream, 0, err)
+		}
+	}
+
+	res, err := s.upgradeResponse(req)
+	if werr := s.writeAll(stream, res); err == nil {
+		err = werr
+	}
+	return s.accepted(stream, n, err)
+}
+
+// AsyncAccept performs the handshake in the server role over stream
+// asynchronously. See Accept.
+func (s *WebsocketStream) AsyncAccept(stream sonic.Stream, cb func(error)) {
+	if s.role != RoleServer {
+		cb(ErrWrongHandshakeRole)
+		return
+	}
+
+	s.reset()
+	s.hb = s.hb[:0]
+	s.asyncAccept(stream, cb)
+}
+
+func (s *WebsocketStream) asyncAccept(stream sonic.Stream, cb func(error)) {
+	if len(s.hb) == cap(s.hb) {
+		s.hb = append(s.hb, 0)[:len(s.hb)]
+	}
+
+	stream.AsyncRead(s.hb[len(s.hb):cap(s.hb)], func(err error, n int) {
+		s.hb = s.hb[:len(s.hb)+n]
+		if err != nil {
+			cb(s.accepted(stream, 0, err))
+			return
+		}
+
+		req, n, err := s.parseUpgradeRequest()
+		if err == nil && req == nil {
+			s.asyncAccept(stream, cb)
+			return
+		}
+
+		var res []byte
+		if err == nil {
+			res, err = s.upgradeResponse(req)
+		} else {
+			res = rejectResponse(http.StatusBadRequest, nil, "")
+		}
+		stream.AsyncWriteAll(res, func(werr error, _ int) {
+			if err == nil {
+				err = werr
+			}
+			cb(s.accepted(stream, n, err))
+		})
+	})
+}
+
+// readUpgradeRequest reads more of the upgrade request into hb, waiting for
+// stream to be readable if it is nonblocking.
+func (s *WebsocketStream) readUpgradeRequest(stream sonic.Stream) error {
+	if len(s.hb) == cap(s.hb) {
+		s.hb = append(s.hb, 0)[:len(s.hb)]
+	}
+
+	for {
+		n, err := stream.Read(s.hb[len(s.hb):cap(s.hb)])
+		s.hb = s.hb[:len(s.hb)+n]
+		if err == sonicerrors.ErrWouldBlock {
+			if err = s.wait(stream, unix.POLLIN); err == nil {
+				continue
+			}
+		}
+		return err
+	}
+}
+
+// parseUpgradeRequest parses the upgrade request in hb. It returns a nil
+// request if hb does not hold the full request yet, and otherwise the size of
+// the request.
+func (s *WebsocketStream) parseUpgradeRequest() (*http.Request, int, error) {
+	end := bytes.Index(s.hb, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(s.hb) >= maxUpgradeSize {
+			return nil, 0, fmt.Errorf(
+				"%w: request too large", ErrCannotUpgrade)
+		}
+		return nil, 0, nil
+	}
+	n := end + 4
+
+	rd := bufio.NewReader(bytes.NewReader(s.hb[:n]))
+	req, err := http.ReadRequest(rd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrCannotUpgrade, err)
+	}
+	return req, n, nil
+}
+
+// upgradeResponse validates the upgrade request and returns the response to
+// it. The response rejects the request if the returned error is not nil.
+func (s *WebsocketStream) upgradeResponse(req *http.Request) ([]byte, error) {
+	if err := s.validateUpgradeRequest(req); err != nil {
+		status := http.StatusBadRequest
+		header := http.Header{}
+		if errors.Is(err, ErrUnsupportedVersion) {
+			status = http.StatusUpgradeRequired
+			header.Set("Sec-WebSocket-Version", "13")
+		}
+		return rejectResponse(status, header, ""), err
+	}
+
+	header := http.Header{}
+	protocol := negotiateSubprotocol(s.subprotocols, req.Header)
+	if protocol != "" {
+		header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	var pmd *deflateStream
+	if s.deflateOpts != nil {
+		var ext string
+		if ext, pmd = s.deflateOpts.accept(req.Header); pmd != nil {
+			header.Set("Sec-WebSocket-Extensions", ext)
+		}
+	}
+
+	if s.upgradeCb != nil {
+		if err := s.upgradeCb(req, header); err != nil {
+			err = fmt.Errorf("%w: %w", ErrUpgradeRejected, err)
+
+			var rejectErr *RejectError
+			if errors.As(err, &rejectErr) {
+				return rejectResponse(
+					rejectErr.StatusCode,
+					rejectErr.Header,
+					rejectErr.Body,
+				), err
+			}
+			return rejectResponse(http.StatusForbidden, nil, ""), err
+		}
+	}
+	s.subprotocol = header.Get("Sec-WebSocket-Protocol")
+	s.pmd = pmd
+
+	res := bytes.NewBuffer(nil)
+	res.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	res.WriteString("Upgrade: websocket\r\n")
+	res.WriteString("Connection: Upgrade\r\n")
+	fmt.Fprintf(res, "Sec-WebSocket-Accept: %s\r\n",
+		MakeResponseKey([]byte(req.Header.Get("Sec-WebSocket-Key"))))
+	_ = header.Write(res)
+	res.WriteString("\r\n")
+	return res.Bytes(), nil
+}
+
+func (s *WebsocketStream) validateUpgradeRequest(req *http.Request) error {
+	if req.Method != http.MethodGet || !req.ProtoAtLeast(1, 1) {
+		return fmt.Errorf(
+			"%w: %s %s request", ErrCannotUpgrade, req.Proto, req.Method)
+	}
+
+	if !IsUpgradeReq(req) ||
+		!headerContainsToken(req.Header, "Connection", "upgrade") {
+		return fmt.Errorf("%w: not an upgrade request", ErrCannotUpgrade)
+	}
+
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return ErrUnsupportedVersion
+	}
+
+	key, err := base64.StdEncoding.DecodeString(
+		req.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return fmt.Errorf(
+			"%w: invalid Sec-WebSocket-Key", ErrCannotUpgrade)
+	}
+
+	return nil
+}
+
+// accepted completes the handshake of a server: it moves the bytes following
+// the upgrade request of size n to src and transitions into StateActive.
+func (s *WebsocketStream) accepted(
+	stream sonic.Stream,
+	n int,
+	err error,
+) error {
+	// Set even on failure, such that CloseNextLayer closes the stream.
+	s.stream = stream
+
+	if err == nil && len(s.hb) > n {
+		_, _ = s.src.Write(s.hb[n:])
+	}
+	s.hb = s.hb[:0]
+
+	if err != nil {
+		s.state = StateTerminated
+		return err
+	}
+
+	s.state = StateActive
+	return s.init(stream)
+}
+
+func rejectResponse(status int, header http.Header, body string) []byte {
+	res := bytes.NewBuffer(nil)
+	fmt.Fprintf(res, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	_ = header.Write(res)
+	fmt.Fprintf(res, "Content-Length: %d\r\n", len(body))
+	res.WriteString("Connection: close\r\n\r\n")
+	res.WriteString(body)
+	return res.Bytes()
+}
+
+// negotiateSubprotocol returns the first of the supported subprotocols, in
+// order of preference, the client offers in its Sec-WebSocket-Protocol header.
+func negotiateSubprotocol(supported []string, header http.Header) string {
+	offered := headerTokens(header, "Sec-WebSocket-Protocol")
+	for _, protocol := range supported {
+		for _, offer := range offered {
+			if protocol == offer {
+				return protocol
+			}
+		}
+	}
+	return ""
+}
+
+// headerTokens returns the comma separated tokens of all values of the header
+// field key.
+func headerTokens(header http.Header, key string) (tokens []string) {
+	for _, value := range header.Values(key) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerContainsToken(header http.Header, key, token string) bool {
+	for _, t := range headerTokens(header, key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAll writes b to stream, waiting for stream to be writable if it is
+// nonblocking.
+func (s *WebsocketStream) writeAll(stream sonic.Stream, b []byte) error {
+	for len(b) > 0 {
+		n, err := stream.Write(b)
+		b = b[n:]
+		if err == sonicerrors.ErrWouldBlock {
+			err = s.wait(stream, unix.POLLOUT)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poll waits for fd to be ready for events for at most timeout, or forever if
+// timeout is negative. It returns false if the timeout passed or if a signal
+// interrupted the wait.
+func poll(fd int, events int16, timeout time.Duration) (bool, error) {
+	ms := -1
+	if timeout >= 0 {
+		// Round up such that poll does not return before the timeout.
+		ms = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: events}}
+	n, err := unix.Poll(fds, ms)
+	if err == syscall.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, os.NewSyscallError("poll", err)
+	}
+	return n > 0, nil
+}
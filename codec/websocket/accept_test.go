@@ -0,0 +1,324 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func TestServerAsyncAccept(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(
+		ioc, "tcp", "localhost:8121",
+		sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetSubprotocols("v1", "v2")
+
+	var upgrade *http.Request
+	server.SetUpgradeCallback(func(req *http.Request, res http.Header) error {
+		upgrade = req
+		res.Set("X-Sonic", "yes")
+		return nil
+	})
+
+	b := make([]byte, 128)
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.AsyncAccept(conn, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertState(t, server, StateActive)
+
+			// Echo one message.
+			server.AsyncNextMessage(b, func(err error, n int, mt MessageType) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				server.AsyncWrite(b[:n], mt, func(err error) {
+					if err != nil {
+						t.Fatal(err)
+					}
+				})
+			})
+		})
+	})
+
+	client, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done = false
+		rb   = make([]byte, 128)
+		rn   int
+	)
+	client.AsyncHandshake("ws://localhost:8121", func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.AsyncWrite([]byte("hello"), TypeText, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.AsyncNextMessage(rb, func(err error, n int, mt MessageType) {
+				done = true
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mt != TypeText {
+					t.Fatalf("expected a text message, got %s", mt)
+				}
+				rn = n
+			})
+		})
+	}, ExtraHeader(true, "Sec-WebSocket-Protocol", "v3, v2", "v1"))
+
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if string(rb[:rn]) != "hello" {
+		t.Fatalf("expected the echo of hello, got %q", rb[:rn])
+	}
+	if server.Subprotocol() != "v1" {
+		t.Fatalf("negotiated subprotocol %q", server.Subprotocol())
+	}
+	if upgrade == nil || upgrade.Host != "localhost:8121" {
+		t.Fatal("upgrade callback not invoked")
+	}
+	if server.RawFd() < 0 || server.RemoteAddr() == nil {
+		t.Fatal("server has no next layer")
+	}
+
+	_ = client.CloseNextLayer()
+	_ = server.CloseNextLayer()
+}
+
+func TestServerAcceptRejects(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(
+		ioc, "tcp", "localhost:8122", sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type testCase struct {
+		name    string
+		req     string
+		reject  error
+		status  int
+		wantErr error
+	}
+	const valid = "GET /feed HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	cases := []testCase{
+		{
+			name:    "version",
+			req:     valid + "Sec-WebSocket-Version: 8\r\n\r\n",
+			status:  http.StatusUpgradeRequired,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name: "key",
+			req: "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n" +
+				"Connection: Upgrade\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			status:  http.StatusBadRequest,
+			wantErr: ErrCannotUpgrade,
+		},
+		{
+			name:    "not an upgrade",
+			req:     "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:  http.StatusBadRequest,
+			wantErr: ErrCannotUpgrade,
+		},
+		{
+			name:    "malformed",
+			req:     "hello\r\n\r\n",
+			status:  http.StatusBadRequest,
+			wantErr: ErrCannotUpgrade,
+		},
+		{
+			name: "rejected",
+			req:  valid + "Sec-WebSocket-Version: 13\r\n\r\n",
+			reject: &RejectError{
+				StatusCode: http.StatusUnauthorized,
+				Body:       "who are you",
+			},
+			status:  http.StatusUnauthorized,
+			wantErr: ErrUpgradeRejected,
+		},
+		{
+			name:    "forbidden",
+			req:     valid + "Sec-WebSocket-Version: 13\r\n\r\n",
+			reject:  errors.New("no"),
+			status:  http.StatusForbidden,
+			wantErr: ErrUpgradeRejected,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status := make(chan int, 1)
+			go func() {
+				conn, err := net.Dial("tcp", "localhost:8122")
+				if err != nil {
+					status <- 0
+					return
+				}
+				_, _ = fmt.Fprint(conn, tc.req)
+				res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+
+				// Close first, such that the listening port is not left in
+				// TIME_WAIT.
+				_ = conn.Close()
+				if err != nil {
+					status <- 0
+					return
+				}
+				status <- res.StatusCode
+			}()
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			server, err := NewWebsocketStream(ioc, nil, RoleServer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.SetUpgradeCallback(func(*http.Request, http.Header) error {
+				return tc.reject
+			})
+
+			if err := server.Accept(conn); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			assertState(t, server, StateTerminated)
+			if got := <-status; got != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, got)
+			}
+		})
+	}
+}
+
+func TestServerAcceptPipelinedFrame(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(
+		ioc, "tcp", "localhost:8123", sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:8123")
+		if err != nil {
+			result <- err
+			return
+		}
+		// The request is immediately followed by a frame.
+		f := AcquireFrame()
+		defer ReleaseFrame(f)
+		f.SetFin()
+		f.SetText()
+		f.SetPayload([]byte("early"))
+		f.Mask()
+
+		req := "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n" +
+			"Connection: Upgrade\r\nSec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+		b := bytes.NewBufferString(req)
+		if _, err := f.WriteTo(b); err != nil {
+			result <- err
+			return
+		}
+		if _, err := conn.Write(b.Bytes()); err != nil {
+			result <- err
+			return
+		}
+
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		_ = conn.Close()
+		if err == nil && !IsUpgradeRes(res) {
+			err = fmt.Errorf("unexpected status %d", res.StatusCode)
+		}
+		if err == nil && res.Header.Get("Sec-WebSocket-Accept") !=
+			"s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			err = fmt.Errorf("invalid accept key")
+		}
+		result <- err
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 128)
+	mt, n, err := server.NextMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != TypeText || string(b[:n]) != "early" {
+		t.Fatalf("unexpected message %s %q", mt, b[:n])
+	}
+	_ = server.CloseNextLayer()
+}
+
+func TestClientAcceptWrongRole(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Accept(nil); err != ErrWrongHandshakeRole {
+		t.Fatalf("expected ErrWrongHandshakeRole, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
 )
 
 const testDur = "WEBSOCKET_INTEGRATION_TEST_DUR"
@@ -87,7 +89,8 @@ func TestClientReadWrite(t *testing.T) {
 	}
 	onWrite = func(err error) {
 		if err != nil {
-			if err != io.EOF {
+			// The write pending when the IO is closed is cancelled.
+			if err != io.EOF && !errors.Is(err, sonicerrors.ErrCancelled) {
 				t.Fatal(err)
 			}
 		} else {
@@ -8,8 +8,13 @@ import (
 )
 
 var (
-	MaxMessageSize = 1024 * 512 // the maximum size of a message
-	CloseTimeout   = 5 * time.Second
+	// MaxMessageSize is the default maximum size of a message read or written
+	// by a stream, see WebsocketStream.SetMaxMessageSize.
+	MaxMessageSize = 1024 * 512
+
+	// CloseTimeout is the default time a stream waits for the close frame of
+	// the peer after starting the closing handshake.
+	CloseTimeout = 5 * time.Second
 )
 
 type Role uint8
@@ -24,7 +29,7 @@ func (r Role) String() string {
 	case RoleClient:
 		return "role_client"
 	case RoleServer:
-		return "role_client"
+		return "role_server"
 	default:
 		return "role_unknown"
 	}
@@ -105,6 +110,7 @@ func (s StreamState) String() string {
 }
 
 type AsyncMessageHandler = func(err error, n int, mt MessageType)
+type AsyncMessageViewHandler = func(err error, b []byte, mt MessageType)
 type AsyncFrameHandler = func(err error, f *Frame)
 type ControlCallback = func(mt MessageType, payload []byte)
 
@@ -140,15 +146,17 @@ type Stream interface {
 	// next layer.
 	NextLayer() sonic.Stream
 
-	// SupportsDeflate returns true if Deflate compression is supported.
+	// SupportsDeflate returns true if the permessage-deflate extension was
+	// negotiated during the handshake.
 	//
 	// https://datatracker.ietf.org/doc/html/rfc7692
 	SupportsDeflate() bool
 
-	// SupportsUTF8 returns true if UTF8 validity checks are supported.
+	// SupportsUTF8 returns true if the text messages and close reasons read
+	// are validated as UTF-8.
 	//
-	// Implementations should not do UTF8 checking by default. Callers
-	// should be able to turn it on when instantiating the Stream.
+	// Implementations validate them by default. Callers trusting their peer
+	// should be able to turn validation off.
 	SupportsUTF8() bool
 
 	// NextMessage reads the payload of the next message into the supplied
@@ -194,6 +202,24 @@ type Stream interface {
 	//    buffer
 	AsyncNextMessage([]byte, AsyncMessageHandler)
 
+	// NextMessageBuffer reads the payload of the next message into the
+	// supplied ByteBuffer, which grows as needed, and commits it to its read
+	// area. It blocks like NextMessage.
+	NextMessageBuffer(*sonic.ByteBuffer) (mt MessageType, n int, err error)
+
+	// AsyncNextMessageBuffer reads the payload of the next message into the
+	// supplied ByteBuffer asynchronously, like AsyncNextMessage.
+	AsyncNextMessageBuffer(*sonic.ByteBuffer, AsyncMessageHandler)
+
+	// NextMessageView reads the next message and returns a view of its
+	// payload which is valid until the next read. The payload of a message
+	// sent in a single frame is not copied. It blocks like NextMessage.
+	NextMessageView() (mt MessageType, b []byte, err error)
+
+	// AsyncNextMessageView reads the next message asynchronously and invokes
+	// the handler with a view of its payload, like NextMessageView.
+	AsyncNextMessageView(AsyncMessageViewHandler)
+
 	// AsyncNextFrame reads and returns the next frame asynchronously.
 	//
 	// This call first flushes any pending control frames to the underlying
@@ -242,8 +268,9 @@ type Stream interface {
 	//  - an error occurs during the write
 	//  - the message is successfully written to the underlying stream
 	//
-	// The message will be written as a single frame. Fragmentation should be
-	// handled by the caller through multiple calls to AsyncWriteFrame.
+	// The message will be written as a single frame, unless a fragment size
+	// is set with SetFragmentSize. Messages which are not available at once
+	// can be written with NextWriter.
 	Write(b []byte, mt MessageType) error
 
 	// AsyncWrite writes the supplied buffer as a single message with the given
@@ -258,10 +285,17 @@ type Stream interface {
 	//  - an error occurs during the write
 	//  - the message is successfully written to the underlying stream
 	//
-	// The message will be written as a single frame. Fragmentation should be
-	// handled by the caller through multiple calls to AsyncWriteFrame.
+	// The message will be written as a single frame, unless a fragment size
+	// is set with SetFragmentSize. Messages which are not available at once
+	// can be written with NextWriter.
 	AsyncWrite(b []byte, mt MessageType, cb func(err error))
 
+	// NextWriter returns a writer for the next message of the given type. The
+	// writer sends each part of the message as it is written, and the final
+	// frame when it is closed. Pending control frames are sent between the
+	// frames of the message.
+	NextWriter(mt MessageType) (*MessageWriter, error)
+
 	// Flush writes any pending control frames to the underlying stream.
 	//
 	// This call blocks.
@@ -284,7 +318,15 @@ type Stream interface {
 	// The call blocks until one of the following conditions is true:
 	//	- the request is sent and the response is received
 	//	- an error occurs
-	Handshake(addr string, extraHeaders ...Header) error
+	//
+	// The stream runs over a nonblocking sonic connection to addr. wss://
+	// endpoints are reached over a sonic/tls connection, which only speaks
+	// TLS 1.3, configured by the TLS configuration of the stream.
+	//
+	// opts configure this handshake only: they add request headers and
+	// cookies, offer subprotocols and set the socket options and the TLS
+	// configuration of the connection. See HandshakeOption.
+	Handshake(addr string, opts ...HandshakeOption) error
 
 	// AsyncHandshake performs the WebSocket handshake asynchronously in the
 	// client role.
@@ -296,25 +338,31 @@ type Stream interface {
 	// or not, the handler will not be invoked from within this function.
 	// Invocation of the handler will be performed in a manner equivalent to
 	// using sonic.Post(...).
-	AsyncHandshake(addr string, cb func(error), extraHeaders ...Header)
+	//
+	// The host of addr is looked up, connected to and, for wss:// endpoints,
+	// handshaken with TLS entirely on the IO of the stream. opts are the ones
+	// of Handshake.
+	AsyncHandshake(addr string, cb func(error), opts ...HandshakeOption)
 
-	// Accept performs the handshake in the server role.
+	// Accept performs the handshake in the server role over the supplied
+	// stream, usually a connection accepted by a sonic.Listener.
 	//
 	// The call blocks until one of the following conditions is true:
-	//	- the request is sent and the response is received
+	//	- the request is received and the response is sent
 	//	- an error occurs
-	Accept() error
+	//
+	// If the request is rejected, the rejection is sent and an error is
+	// returned.
+	Accept(stream sonic.Stream) error
 
-	// AsyncAccept performs the handshake asynchronously in the server role.
+	// AsyncAccept performs the handshake asynchronously in the server role
+	// over the supplied stream, usually a connection accepted by a
+	// sonic.Listener.
 	//
 	// This call does not block. The provided completion handler is called when
-	// the request is send and the response is received or when an error occurs.
-	//
-	// Regardless of  whether the asynchronous operation completes immediately
-	// or not, the handler will not be invoked from within this function.
-	// Invocation of the handler will be performed in a manner equivalent to
-	// using sonic.Post(...).
-	AsyncAccept(func(error))
+	// the request is received and the response is sent or when an error
+	// occurs.
+	AsyncAccept(stream sonic.Stream, cb func(error))
 
 	// AsyncClose sends a websocket close control frame asynchronously.
 	//
@@ -328,7 +376,9 @@ type Stream interface {
 	//
 	// After beginning the closing handshake, the program should not write
 	// further message data, pings, or pongs. Instead, the program should
-	// continue reading message data until an error occurs.
+	// continue reading message data until an error occurs. If the peer does
+	// not reply with a close frame within the close timeout, the stream fails
+	// and pending reads complete with ErrCloseTimeout.
 	AsyncClose(cc CloseCode, reason string, cb func(err error))
 
 	// Close sends a websocket close control frame asynchronously.
@@ -343,7 +393,9 @@ type Stream interface {
 	//
 	// After beginning the closing handshake, the program should not write
 	// further message data, pings, or pongs. Instead, the program should
-	// continue reading message data until an error occurs.
+	// continue reading message data until an error occurs. If the peer does
+	// not reply with a close frame within the close timeout, the stream fails
+	// and pending reads complete with ErrCloseTimeout.
 	Close(cc CloseCode, reason string) error
 
 	// SetControlCallback sets a function that will be invoked when a
@@ -358,9 +410,9 @@ type Stream interface {
 	ControlCallback() ControlCallback
 
 	// SetMaxMessageSize sets the maximum size of a message that can be read
-	// from or written to a peer.
-	//  - If a message exceeds the limit while reading, the connection is
-	//    closed abnormally.
+	// from or written to a peer. The limit only applies to this stream.
+	//  - If a message exceeds the limit while reading, the closing handshake
+	//    is started with CloseTooBig.
 	//  - If a message exceeds the limit while writing, the operation is
 	//    cancelled.
 	SetMaxMessageSize(bytes int)
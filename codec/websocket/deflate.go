@@ -0,0 +1,438 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/talostrading/sonic"
+)
+
+const (
+	deflateExtension = "permessage-deflate"
+
+	// deflateWindowSize is the size of the LZ77 window of compress/flate,
+	// which is the largest allowed by RFC 7692.
+	deflateWindowSize = 1 << 15
+)
+
+// deflateTail is appended to the payload of a compressed message before
+// inflating it. It is the empty block the sender strips from the end of the
+// payload, followed by a final empty block such that the inflater reaches
+// io.EOF at the end of the message.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// DeflateOptions configures the permessage-deflate extension which compresses
+// the payload of messages.
+//
+// https://datatracker.ietf.org/doc/html/rfc7692
+type DeflateOptions struct {
+	// ClientNoContextTakeover makes the client reset its compression context
+	// after each message. A client hints it, a server imposes it.
+	ClientNoContextTakeover bool
+
+	// ServerNoContextTakeover makes the server reset its compression context
+	// after each message. A client requests it, a server imposes it.
+	ServerNoContextTakeover bool
+
+	// ServerMaxWindowBits is the base-2 logarithm, between 8 and 15, of the
+	// largest LZ77 window a client allows the server to compress with. Zero
+	// means no limit.
+	//
+	// It is ignored by servers, which always compress with a window of 15 bits
+	// and decline the offers of clients requesting a smaller one.
+	ServerMaxWindowBits int
+
+	// CompressWrites makes Write and AsyncWrite compress messages once the
+	// extension is negotiated. Compressed messages are read regardless.
+	CompressWrites bool
+
+	// Level is the compression level of written messages, as defined by
+	// compress/flate. Zero means flate.DefaultCompression.
+	Level int
+}
+
+// offer returns the extension offer of a client.
+func (o *DeflateOptions) offer() string {
+	offer := deflateExtension
+	if o.ClientNoContextTakeover {
+		offer += "; client_no_context_takeover"
+	}
+	if o.ServerNoContextTakeover {
+		offer += "; server_no_context_takeover"
+	}
+	if o.ServerMaxWindowBits > 0 {
+		offer += "; server_max_window_bits=" +
+			strconv.Itoa(o.ServerMaxWindowBits)
+	}
+	return offer
+}
+
+// accept returns the response of a server to the first permessage-deflate
+// offer in the upgrade request header which it can honour. The returned
+// stream is nil if there is no such offer.
+func (o *DeflateOptions) accept(
+	header http.Header,
+) (res string, d *deflateStream) {
+	for _, ext := range headerTokens(header, "Sec-WebSocket-Extensions") {
+		name, params, err := parseExtension(ext)
+		if err != nil || name != deflateExtension {
+			continue
+		}
+
+		var (
+			ok            = true
+			clientReset   = o.ClientNoContextTakeover
+			serverReset   = o.ServerNoContextTakeover
+			maxWindowBits = false
+		)
+		for key, value := range params {
+			switch key {
+			case "client_no_context_takeover":
+				ok = ok && value == ""
+				clientReset = true
+			case "server_no_context_takeover":
+				ok = ok && value == ""
+				serverReset = true
+			case "server_max_window_bits":
+				// compress/flate cannot compress with a smaller window.
+				ok = ok && value == "15"
+				maxWindowBits = true
+			case "client_max_window_bits":
+				// Any window is fine to inflate with.
+				ok = ok && (value == "" || validWindowBits(value))
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		res = deflateExtension
+		if clientReset {
+			res += "; client_no_context_takeover"
+		}
+		if serverReset {
+			res += "; server_no_context_takeover"
+		}
+		if maxWindowBits {
+			res += "; server_max_window_bits=15"
+		}
+		return res, newDeflateStream(o, serverReset, clientReset)
+	}
+	return "", nil
+}
+
+// confirm parses the extension response of a server to the offer of a
+// client. The returned stream is nil if the server declined the offer.
+func (o *DeflateOptions) confirm(header http.Header) (*deflateStream, error) {
+	exts := headerTokens(header, "Sec-WebSocket-Extensions")
+	if len(exts) == 0 {
+		return nil, nil
+	}
+
+	invalid := fmt.Errorf(
+		"%w: invalid Sec-WebSocket-Extensions %q",
+		ErrCannotUpgrade, strings.Join(exts, ", "))
+
+	if len(exts) > 1 {
+		return nil, invalid
+	}
+	name, params, err := parseExtension(exts[0])
+	if err != nil || name != deflateExtension {
+		return nil, invalid
+	}
+
+	var (
+		clientReset = o.ClientNoContextTakeover
+		serverReset = false
+	)
+	for key, value := range params {
+		ok := value == ""
+		switch key {
+		case "client_no_context_takeover":
+			clientReset = true
+		case "server_no_context_takeover":
+			serverReset = true
+		case "server_max_window_bits":
+			bits, _ := strconv.Atoi(value)
+			ok = validWindowBits(value) &&
+				(o.ServerMaxWindowBits == 0 || bits <= o.ServerMaxWindowBits)
+		default:
+			// Including client_max_window_bits, which is not offered as
+			// compress/flate cannot compress with a smaller window.
+			ok = false
+		}
+		if !ok {
+			return nil, invalid
+		}
+	}
+
+	return newDeflateStream(o, clientReset, serverReset), nil
+}
+
+// deflateStream holds the compression state of a stream with
+// permessage-deflate negotiated.
+type deflateStream struct {
+	level    int
+	compress bool
+
+	// True if the compression context is reset after each written or read
+	// message.
+	writeReset bool
+	readReset  bool
+
+	fw *flate.Writer
+	wb bytes.Buffer
+
+	fr   io.ReadCloser
+	rr   bytes.Reader
+	rb   []byte // payload of the compressed message being read
+	dict []byte // tail of the previously inflated messages
+
+	// True if the message being read is compressed.
+	compressed bool
+}
+
+func newDeflateStream(
+	o *DeflateOptions,
+	writeReset, readReset bool,
+) *deflateStream {
+	level := o.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	return &deflateStream{
+		level:      level,
+		compress:   o.CompressWrites,
+		writeReset: writeReset,
+		readReset:  readReset,
+	}
+}
+
+// deflate compresses the message b. The returned payload is valid until the
+// next call.
+func (d *deflateStream) deflate(b []byte) (payload []byte, err error) {
+	d.wb.Reset()
+	if d.fw == nil {
+		if d.fw, err = flate.NewWriter(&d.wb, d.level); err != nil {
+			return nil, err
+		}
+	} else if d.writeReset {
+		d.fw.Reset(&d.wb)
+	}
+
+	if _, err = d.fw.Write(b); err == nil {
+		err = d.fw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip the empty block terminating the flush.
+	payload = d.wb.Bytes()
+	return payload[:len(payload)-4], nil
+}
+
+// reading is called with each data frame read. It returns true if the frame
+// is part of a compressed message.
+func (d *deflateStream) reading(f *Frame) bool {
+	if !f.IsContinuation() {
+		d.compressed = f.IsRSV1()
+		d.rb = d.rb[:0]
+	}
+	return d.compressed
+}
+
+// inflate decompresses the message buffered in rb into b. It returns
+// ErrMessageTooBig if b cannot hold the message.
+func (d *deflateStream) inflate(b []byte) (n int, err error) {
+	if err = d.resetReader(); err != nil {
+		return 0, err
+	}
+
+	n, err = d.fill(b)
+	if err == nil {
+		err = d.ended()
+	}
+	if err == io.EOF {
+		err = nil
+		d.remember(b[:n])
+	}
+	return n, err
+}
+
+// inflateBuffer decompresses the message buffered in rb into buf, growing it
+// as needed, and commits it to the read area of buf. It returns
+// ErrMessageTooBig if the message is over max bytes.
+func (d *deflateStream) inflateBuffer(
+	buf *sonic.ByteBuffer,
+	max int,
+) (n int, err error) {
+	if err = d.resetReader(); err != nil {
+		return 0, err
+	}
+
+	for err == nil {
+		room := max - n
+		if room == 0 {
+			err = d.ended()
+			break
+		}
+		if room > deflateWindowSize {
+			room = deflateWindowSize
+		}
+
+		var m int
+		buf.Reserve(room)
+		buf.Claim(func(b []byte) int {
+			m, err = d.fill(b[:room])
+			return m
+		})
+		n += m
+	}
+	buf.Commit(n)
+
+	if err == io.EOF {
+		err = nil
+		d.remember(buf.Data()[buf.ReadLen()-n:])
+	}
+	return n, err
+}
+
+func (d *deflateStream) resetReader() error {
+	d.rb = append(d.rb, deflateTail...)
+	d.rr.Reset(d.rb)
+
+	var dict []byte
+	if !d.readReset {
+		dict = d.dict
+	}
+	if d.fr == nil {
+		d.fr = flate.NewReaderDict(&d.rr, dict)
+		return nil
+	}
+	return d.fr.(flate.Resetter).Reset(&d.rr, dict)
+}
+
+// fill reads the inflated message into b until b is full or the message ends,
+// in which case io.EOF is returned.
+func (d *deflateStream) fill(b []byte) (n int, err error) {
+	for n < len(b) && err == nil {
+		var m int
+		m, err = d.fr.Read(b[n:])
+		n += m
+	}
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", ErrInvalidCompressedPayload, err)
+	}
+	return n, err
+}
+
+// ended returns io.EOF if the inflated message ends, and ErrMessageTooBig if
+// it does not.
+func (d *deflateStream) ended() error {
+	var one [1]byte
+	if n, err := d.fill(one[:]); n == 0 {
+		return err
+	}
+	return ErrMessageTooBig
+}
+
+// remember keeps the last window of the inflated message b as the dictionary
+// of the next one, if the context is taken over.
+func (d *deflateStream) remember(b []byte) {
+	if !d.readReset {
+		d.dict = append(d.dict, b...)
+		if over := len(d.dict) - deflateWindowSize; over > 0 {
+			d.dict = d.dict[:copy(d.dict, d.dict[over:])]
+		}
+	}
+}
+
+// parseExtension parses an extension of a Sec-WebSocket-Extensions header.
+// Parameters without a value map to an empty string.
+func parseExtension(ext string) (
+	name string,
+	params map[string]string,
+	err error,
+) {
+	parts := strings.Split(ext, ";")
+	name = strings.TrimSpace(parts[0])
+	params = make(map[string]string)
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		if _, ok := params[key]; ok || key == "" {
+			return "", nil, fmt.Errorf("invalid extension parameters %q", ext)
+		}
+		params[key] = value
+	}
+	return name, params, nil
+}
+
+func validWindowBits(value string) bool {
+	bits, err := strconv.Atoi(value)
+	return err == nil && bits >= 8 && bits <= 15
+}
+
+// SetDeflateOptions enables the permessage-deflate extension with the given
+// options for the next handshake, or disables it if opts is nil. Clients offer
+// the extension while servers accept the offers of clients.
+func (s *WebsocketStream) SetDeflateOptions(opts *DeflateOptions) {
+	s.deflateOpts = opts
+}
+
+// messagePayload returns the payload of the message b of type mt, compressed
+// if permessage-deflate is negotiated and enabled for writes. The compressed
+// payload is only valid until the next call.
+func (s *WebsocketStream) messagePayload(
+	b []byte,
+	mt MessageType,
+) (payload []byte, compressed bool, err error) {
+	if s.pmd == nil || !s.pmd.compress ||
+		(mt != TypeText && mt != TypeBinary) {
+		return b, false, nil
+	}
+
+	payload, err = s.pmd.deflate(b)
+	return payload, err == nil, err
+}
+
+// copyPayload copies the payload of the data frame f into b. The payload of a
+// compressed message is buffered until its last frame is read, at which point
+// it is inflated into b. It returns ErrMessageTooBig if b cannot hold the
+// payload.
+func (s *WebsocketStream) copyPayload(b []byte, f *Frame) (n int, err error) {
+	if s.pmd == nil || !s.pmd.reading(f) {
+		n = copy(b, f.Payload())
+		if n != f.PayloadLen() {
+			err = ErrMessageTooBig
+		}
+		return n, err
+	}
+
+	if len(s.pmd.rb)+f.PayloadLen() > s.maxMessageSize {
+		return 0, ErrMessageTooBig
+	}
+	s.pmd.rb = append(s.pmd.rb, f.Payload()...)
+	if !f.IsFin() {
+		return 0, nil
+	}
+
+	n, err = s.pmd.inflate(b)
+	if err == nil {
+		err = s.validateInflated(b[:n])
+	}
+	if err != nil && err != ErrMessageTooBig {
+		s.closeByUs(EncodeCloseFramePayload(CloseBadPayload, ""))
+	}
+	return n, err
+}
@@ -0,0 +1,284 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func TestDeflateInflateRFC7692(t *testing.T) {
+	// RFC 7692 Section 7.2.3.2: "Hello" twice with context takeover.
+	d := newDeflateStream(&DeflateOptions{}, false, false)
+
+	b := make([]byte, 16)
+	for _, payload := range [][]byte{
+		{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00},
+		{0xf2, 0x00, 0x11, 0x00, 0x00},
+	} {
+		d.rb = append(d.rb[:0], payload...)
+		n, err := d.inflate(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != "Hello" {
+			t.Fatalf("expected Hello, got %q", b[:n])
+		}
+	}
+
+	// Without context takeover the second message cannot be inflated.
+	d = newDeflateStream(&DeflateOptions{}, false, true)
+	d.rb = append(d.rb[:0], 0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00)
+	if _, err := d.inflate(b); err != nil {
+		t.Fatal(err)
+	}
+	d.rb = append(d.rb[:0], 0xf2, 0x00, 0x11, 0x00, 0x00)
+	if _, err := d.inflate(b); !errors.Is(err, ErrInvalidCompressedPayload) {
+		t.Fatalf("expected ErrInvalidCompressedPayload, got %v", err)
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		w := newDeflateStream(&DeflateOptions{}, reset, false)
+		r := newDeflateStream(&DeflateOptions{}, false, reset)
+
+		msg := bytes.Repeat([]byte("sonic websocket "), 64)
+		b := make([]byte, len(msg))
+		for i := 0; i < 3; i++ {
+			payload, err := w.deflate(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payload) >= len(msg) {
+				t.Fatalf("payload not compressed: %d bytes", len(payload))
+			}
+
+			r.rb = append(r.rb[:0], payload...)
+			n, err := r.inflate(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b[:n], msg) {
+				t.Fatal("round trip mismatch")
+			}
+		}
+
+		payload, _ := w.deflate(msg)
+		r.rb = append(r.rb[:0], payload...)
+		if _, err := r.inflate(b[:len(b)-1]); err != ErrMessageTooBig {
+			t.Fatalf("expected ErrMessageTooBig, got %v", err)
+		}
+	}
+}
+
+func TestDeflateNegotiation(t *testing.T) {
+	server := &DeflateOptions{}
+
+	type testCase struct {
+		offer string
+		res   string
+	}
+	cases := []testCase{
+		{"permessage-deflate", "permessage-deflate"},
+		{
+			"permessage-deflate; client_no_context_takeover",
+			"permessage-deflate; client_no_context_takeover",
+		},
+		{
+			"permessage-deflate; server_max_window_bits=10, " +
+				"permessage-deflate; client_max_window_bits",
+			"permessage-deflate",
+		},
+		{
+			"permessage-deflate; server_max_window_bits=15",
+			"permessage-deflate; server_max_window_bits=15",
+		},
+		{"permessage-deflate; unknown", ""},
+		{"x-webkit-deflate-frame", ""},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		header := http.Header{}
+		header.Set("Sec-WebSocket-Extensions", tc.offer)
+		res, d := server.accept(header)
+		if res != tc.res || (d != nil) != (res != "") {
+			t.Fatalf("offer %q: expected %q, got %q", tc.offer, tc.res, res)
+		}
+	}
+
+	client := &DeflateOptions{ServerMaxWindowBits: 12}
+	for res, ok := range map[string]bool{
+		"permessage-deflate":                               true,
+		"permessage-deflate; server_no_context_takeover":   true,
+		"permessage-deflate; server_max_window_bits=10":    true,
+		"permessage-deflate; server_max_window_bits=15":    false,
+		"permessage-deflate; client_max_window_bits=10":    false,
+		"permessage-deflate; server_no_context_takeover=1": false,
+		"permessage-deflate, permessage-deflate":           false,
+		"x-webkit-deflate-frame":                           false,
+	} {
+		header := http.Header{}
+		header.Set("Sec-WebSocket-Extensions", res)
+		d, err := client.confirm(header)
+		if ok != (err == nil) || ok != (d != nil) {
+			t.Fatalf("response %q: expected ok=%v, got %v", res, ok, err)
+		}
+	}
+}
+
+func TestDeflateStream(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(
+		ioc, "tcp", "localhost:8124",
+		sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetDeflateOptions(&DeflateOptions{CompressWrites: true})
+
+	var raw []byte
+	b := make([]byte, 4096)
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.AsyncAccept(conn, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Echo two messages.
+			var echo func(int)
+			echo = func(left int) {
+				server.AsyncNextMessage(b, func(
+					err error,
+					n int,
+					mt MessageType,
+				) {
+					if err != nil {
+						t.Fatal(err)
+					}
+					server.AsyncWrite(b[:n], mt, func(err error) {
+						if err != nil {
+							t.Fatal(err)
+						}
+						if left > 1 {
+							echo(left - 1)
+						}
+					})
+				})
+			}
+			echo(2)
+		})
+	})
+
+	client, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetDeflateOptions(&DeflateOptions{
+		ClientNoContextTakeover: true,
+		CompressWrites:          true,
+	})
+
+	msg := bytes.Repeat([]byte("deflate me "), 100)
+	done := false
+	client.AsyncHandshake("ws://localhost:8124", func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !client.SupportsDeflate() {
+			t.Fatal("permessage-deflate not negotiated")
+		}
+
+		var next func(int)
+		next = func(left int) {
+			client.AsyncWrite(msg, TypeBinary, func(err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.AsyncNextFrame(func(err error, f *Frame) {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !f.IsRSV1() || !f.IsFin() {
+						t.Fatal("expected a compressed message")
+					}
+					raw = append(raw[:0], f.Payload()...)
+
+					rb := make([]byte, 4096)
+					n, err := client.copyPayload(rb, f)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(rb[:n], msg) {
+						t.Fatalf("unexpected echo of %d bytes", n)
+					}
+					if left > 1 {
+						next(left - 1)
+					} else {
+						done = true
+					}
+				})
+			})
+		}
+		next(2)
+	})
+
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if !server.SupportsDeflate() || !server.pmd.readReset {
+		t.Fatal("server did not accept client_no_context_takeover")
+	}
+	if len(raw) >= len(msg)/4 {
+		t.Fatalf("message not compressed: %d bytes", len(raw))
+	}
+
+	_ = client.CloseNextLayer()
+	_ = server.CloseNextLayer()
+}
+
+func TestDeflateRSV1NotNegotiated(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	s, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := AcquireFrame()
+	defer ReleaseFrame(f)
+	f.SetFin()
+	f.SetText()
+	f.SetRSV1()
+	if err := s.verifyFrame(f); err != ErrNonZeroReservedBits {
+		t.Fatalf("expected ErrNonZeroReservedBits, got %v", err)
+	}
+
+	s.pmd = newDeflateStream(&DeflateOptions{}, false, false)
+	if err := s.verifyFrame(f); err != nil {
+		t.Fatal(err)
+	}
+
+	f.SetContinuation()
+	if err := s.verifyFrame(f); err != ErrNonZeroReservedBits {
+		t.Fatalf("expected ErrNonZeroReservedBits, got %v", err)
+	}
+}
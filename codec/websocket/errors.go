@@ -15,6 +15,12 @@ var (
 		"cannot upgrade connection to WebSocket",
 	)
 
+	ErrUnsupportedVersion = errors.New(
+		"unsupported WebSocket version, only version 13 is supported",
+	)
+
+	ErrUpgradeRejected = errors.New("upgrade rejected")
+
 	ErrMessageTooBig = errors.New("message too big")
 
 	ErrInvalidControlFrame = errors.New("invalid control frame")
@@ -38,4 +44,20 @@ var (
 	ErrExpectedContinuation = errors.New("expected continue frame")
 
 	ErrInvalidAddress = errors.New("invalid address")
+
+	ErrInvalidCompressedPayload = errors.New("invalid compressed payload")
+
+	ErrPongTimeout = errors.New("timed out waiting for a pong")
+
+	ErrIdleTimeout = errors.New("timed out waiting for a frame")
+
+	ErrCloseTimeout = errors.New("timed out waiting for the close frame")
+
+	ErrMessageInProgress = errors.New("a message is being written")
+
+	ErrWriterClosed = errors.New("message writer closed")
+
+	ErrInvalidMessageType = errors.New("invalid message type")
+
+	ErrInvalidUTF8 = errors.New("invalid UTF-8 in text message or close reason")
 )
@@ -21,13 +21,17 @@ type FrameCodec struct {
 	decodeFrame *Frame // frame we decode into
 	decodeBytes int    // number of bytes of the last successfully decoded frame
 	decodeReset bool   // true if we must reset the state on the next decode
+
+	maxFrameSize  int // maximum payload size of a decoded frame
+	maxReadBuffer int // maximum size src may grow to; zero if unbounded
 }
 
 func NewFrameCodec(src, dst *sonic.ByteBuffer) *FrameCodec {
 	return &FrameCodec{
-		decodeFrame: NewFrame(),
-		src:         src,
-		dst:         dst,
+		decodeFrame:  NewFrame(),
+		src:          src,
+		dst:          dst,
+		maxFrameSize: MaxMessageSize,
 	}
 }
 
@@ -56,6 +60,9 @@ func (c *FrameCodec) resetDecode() {
 //
 //	In this case we try to decode the first frame. The rest of the bytes stay
 //	in `src`. An appropriate error is returned if the frame is corrupt.
+//
+// ErrPayloadOverMaxSize is returned for a frame whose payload is over the
+// maximum frame size or which does not fit in the maximum size of `src`.
 func (c *FrameCodec) Decode(src *sonic.ByteBuffer) (*Frame, error) {
 	c.resetDecode()
 
@@ -82,18 +89,21 @@ func (c *FrameCodec) Decode(src *sonic.ByteBuffer) (*Frame, error) {
 		c.decodeFrame.mask = src.Data()[n-4 : n]
 	}
 
-	// check payload length
+	// check payload length; a length over 1<<63 is negative
 	npayload := c.decodeFrame.PayloadLen()
-	if npayload > MaxMessageSize {
+	if npayload < 0 || npayload > c.maxFrameSize {
 		return nil, ErrPayloadOverMaxSize
 	}
 
 	// prepare to read the payload
 	n += npayload
+	if c.maxReadBuffer > 0 && src.SaveLen()+n > c.maxReadBuffer {
+		return nil, ErrPayloadOverMaxSize
+	}
 	if err := src.PrepareRead(n); err != nil {
 		// the payload might be too big for our buffer so we must allocate
 		// enough for the next Decode call to succeed
-		src.Reserve(npayload)
+		src.Reserve(n - src.ReadLen() - src.WriteLen())
 		return nil, err
 	}
 
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// HandshakeOption configures a single client handshake, see Handshake and
+// AsyncHandshake. A Header is an option adding a request header field.
+type HandshakeOption interface {
+	applyHandshake(o *handshakeOptions)
+}
+
+// handshakeOptions holds the configuration of the ongoing client handshake.
+type handshakeOptions struct {
+	headers      []Header
+	subprotocols []string
+	cookies      []*http.Cookie
+	dialOpts     []sonicopts.Option
+	tls          *tls.Config
+}
+
+type handshakeOptionFunc func(o *handshakeOptions)
+
+func (f handshakeOptionFunc) applyHandshake(o *handshakeOptions) {
+	f(o)
+}
+
+func (h Header) applyHandshake(o *handshakeOptions) {
+	o.headers = append(o.headers, h)
+}
+
+// Subprotocols offers the subprotocols in the Sec-WebSocket-Protocol header
+// of the upgrade request, in order of preference. The handshake fails if the
+// server selects one which is not offered. They default to the ones set with
+// SetSubprotocols.
+func Subprotocols(protocols ...string) HandshakeOption {
+	return handshakeOptionFunc(func(o *handshakeOptions) {
+		o.subprotocols = protocols
+	})
+}
+
+// Cookies sends the cookies in the Cookie header of the upgrade request. The
+// cookies the server sets are in the Set-Cookie header of UpgradeResponse.
+func Cookies(cookies ...*http.Cookie) HandshakeOption {
+	return handshakeOptionFunc(func(o *handshakeOptions) {
+		o.cookies = append(o.cookies, cookies...)
+	})
+}
+
+// SourceAddr binds the connection to the local address addr before
+// connecting. It is a shorthand for DialOptions(sonicopts.BindSocket(addr)).
+func SourceAddr(addr net.Addr) HandshakeOption {
+	return DialOptions(sonicopts.BindSocket(addr))
+}
+
+// DialOptions applies the socket options, such as sonicopts.NoDelay,
+// sonicopts.SendBuffer or sonicopts.RecvBuffer, to the connection. They
+// override the default sonicopts.NoDelay(true). The connection is always
+// nonblocking, sonicopts.Nonblocking is ignored.
+func DialOptions(opts ...sonicopts.Option) HandshakeOption {
+	return handshakeOptionFunc(func(o *handshakeOptions) {
+		for _, opt := range opts {
+			o.dialOpts = sonicopts.AddOption(opt, o.dialOpts)
+		}
+	})
+}
+
+// TLSConfig secures the connection to wss:// endpoints with config instead of
+// the TLS configuration the stream is created with.
+func TLSConfig(config *tls.Config) HandshakeOption {
+	return handshakeOptionFunc(func(o *handshakeOptions) {
+		o.tls = config
+	})
+}
+
+// handshakeOptions returns the configuration of a client handshake made with
+// opts.
+func (s *WebsocketStream) handshakeOptions(
+	opts []HandshakeOption,
+) *handshakeOptions {
+	o := &handshakeOptions{
+		subprotocols: s.subprotocols,
+		dialOpts:     []sonicopts.Option{sonicopts.NoDelay(true)},
+		tls:          s.tls,
+	}
+	for _, opt := range opts {
+		opt.applyHandshake(o)
+	}
+	o.dialOpts = sonicopts.AddOption(sonicopts.Nonblocking(true), o.dialOpts)
+	return o
+}
+
+// UpgradeResponse returns the response of the server to the upgrade request
+// of the last client handshake, or nil if none was read. It is also returned
+// if the server rejects the upgrade, in which case its body is not read.
+func (s *WebsocketStream) UpgradeResponse() *http.Response {
+	return s.res
+}
@@ -0,0 +1,528 @@
+package websocket
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+	sonictls "github.com/talostrading/sonic/tls"
+)
+
+func TestClientSyncHandshake(t *testing.T) {
+	srv := &MockServer{}
+
+	go func() {
+		defer srv.Close()
+
+		err := srv.Accept("localhost:8130")
+		if err != nil {
+			panic(err)
+		}
+		srv.Write([]byte("hello"))
+
+		b := make([]byte, 128)
+		n, err := srv.Read(b)
+		if err != nil {
+			panic(err)
+		}
+		srv.Write(b[:n])
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	if err := ws.Handshake("ws://localhost:8130"); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, ws, StateActive)
+
+	if _, ok := ws.NextLayer().(sonic.Conn); !ok {
+		t.Fatalf("expected a sonic.Conn next layer, got %T", ws.NextLayer())
+	}
+	if ws.RawFd() < 0 || ws.RemoteAddr() == nil || ws.LocalAddr() == nil {
+		t.Fatal("stream has no connection")
+	}
+
+	b := make([]byte, 128)
+	for _, msg := range []string{"hello", "echo"} {
+		if msg == "echo" {
+			if err := ws.Write([]byte(msg), TypeText); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mt, n, err := ws.NextMessage(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != TypeText || string(b[:n]) != msg {
+			t.Fatalf("expected %q, got %q", msg, b[:n])
+		}
+	}
+}
+
+func TestClientAsyncHandshakeTLS(t *testing.T) {
+	cert, pool := newTestCertificate(t, "localhost")
+
+	srv := &MockServer{TLS: &tls.Config{Certificates: []tls.Certificate{cert}}}
+
+	go func() {
+		defer srv.Close()
+
+		err := srv.Accept("localhost:8131")
+		if err != nil {
+			panic(err)
+		}
+		srv.Write([]byte("hello"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, &tls.Config{RootCAs: pool}, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	var (
+		done    bool
+		readErr error
+		b       = make([]byte, 128)
+		n       int
+	)
+	ws.AsyncHandshake("wss://localhost:8131", func(err error) {
+		if err != nil {
+			done, readErr = true, err
+			return
+		}
+		ws.AsyncNextMessage(b, func(err error, nn int, _ MessageType) {
+			done, readErr, n = true, err, nn
+		})
+	})
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(b[:n]) != "hello" {
+		t.Fatalf("expected hello, got %q", b[:n])
+	}
+	if ws.RawFd() < 0 || ws.RemoteAddr() == nil {
+		t.Fatal("stream has no connection")
+	}
+}
+
+func TestClientAsyncHandshakeNotInline(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	returned, done := false, false
+	ws.AsyncHandshake("http://localhost:8132", func(err error) {
+		if !returned {
+			t.Fatal("handler invoked inline")
+		}
+		if err != ErrInvalidAddress {
+			t.Fatalf("expected ErrInvalidAddress, got %v", err)
+		}
+		done = true
+	})
+	returned = true
+
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	assertState(t, ws, StateTerminated)
+}
+
+func TestClientHandshakeOptions(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	var upgrade *http.Request
+	source := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	server, client, err := handshake(
+		t, ioc, 8138,
+		func(server *WebsocketStream) {
+			server.SetSubprotocols("chat", "superchat")
+			server.SetUpgradeCallback(
+				func(req *http.Request, res http.Header) error {
+					upgrade = req
+					res.Add("Set-Cookie", "token=xyz")
+					return nil
+				},
+			)
+		},
+		Subprotocols("superchat", "chat"),
+		Cookies(&http.Cookie{Name: "session", Value: "abc"}),
+		ExtraHeader(true, "X-Client", "sonic"),
+		SourceAddr(source),
+		DialOptions(sonicopts.RecvBuffer(64*1024), sonicopts.NoDelay(false)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseNextLayer()
+	defer client.CloseNextLayer()
+
+	offered := upgrade.Header.Get("Sec-WebSocket-Protocol")
+	if offered != "superchat, chat" {
+		t.Fatalf("wrong offered subprotocols %q", offered)
+	}
+	if c, err := upgrade.Cookie("session"); err != nil || c.Value != "abc" {
+		t.Fatalf("expected the session cookie, got %v %v", c, err)
+	}
+	if got := upgrade.Header.Get("X-Client"); got != "sonic" {
+		t.Fatalf("wrong extra header %q", got)
+	}
+
+	if client.Subprotocol() != "chat" || server.Subprotocol() != "chat" {
+		t.Fatalf(
+			"expected the chat subprotocol, got client=%q server=%q",
+			client.Subprotocol(), server.Subprotocol())
+	}
+	res := client.UpgradeResponse()
+	if res == nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wrong upgrade response %v", res)
+	}
+	cookies := res.Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "xyz" {
+		t.Fatalf("expected the token cookie, got %v", cookies)
+	}
+
+	local, ok := client.LocalAddr().(*net.TCPAddr)
+	if !ok || !local.IP.Equal(source.IP) {
+		t.Fatalf("wrong local address %s", client.LocalAddr())
+	}
+	noDelay, err := syscall.GetsockoptInt(
+		client.RawFd(), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if noDelay != 0 {
+		t.Fatal("expected the NoDelay option to override the default")
+	}
+}
+
+func TestClientHandshakeRejected(t *testing.T) {
+	type testCase struct {
+		name   string
+		port   int
+		setup  func(server *WebsocketStream)
+		status int
+	}
+	cases := []testCase{
+		{
+			name: "status",
+			port: 8139,
+			setup: func(server *WebsocketStream) {
+				server.SetUpgradeCallback(
+					func(*http.Request, http.Header) error {
+						return &RejectError{StatusCode: http.StatusUnauthorized}
+					},
+				)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "subprotocol",
+			port: 8140,
+			setup: func(server *WebsocketStream) {
+				server.SetUpgradeCallback(
+					func(_ *http.Request, res http.Header) error {
+						res.Set("Sec-WebSocket-Protocol", "other")
+						return nil
+					},
+				)
+			},
+			status: http.StatusSwitchingProtocols,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			server, client, err := handshake(
+				t, ioc, tc.port, tc.setup, Subprotocols("chat"))
+			defer server.CloseNextLayer()
+
+			if !errors.Is(err, ErrCannotUpgrade) {
+				t.Fatalf("expected ErrCannotUpgrade, got %v", err)
+			}
+			assertState(t, client, StateTerminated)
+			if res := client.UpgradeResponse(); res == nil ||
+				res.StatusCode != tc.status {
+				t.Fatalf("expected a %d response, got %v", tc.status, res)
+			}
+		})
+	}
+}
+
+func TestClientHandshakeTLSConfig(t *testing.T) {
+	cert, pool := newTestCertificate(t, "localhost")
+
+	srv := &MockServer{TLS: &tls.Config{Certificates: []tls.Certificate{cert}}}
+
+	go func() {
+		defer srv.Close()
+
+		err := srv.Accept("localhost:8141")
+		if err != nil {
+			panic(err)
+		}
+		srv.Write([]byte("hello"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	// The stream has no TLS configuration of its own.
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.CloseNextLayer()
+
+	err = ws.Handshake(
+		"wss://localhost:8141", TLSConfig(&tls.Config{RootCAs: pool}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 128)
+	_, n, err := ws.NextMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "hello" {
+		t.Fatalf("expected hello, got %q", b[:n])
+	}
+}
+
+func TestClientHandshakeTLS12(t *testing.T) {
+	type testCase struct {
+		name  string
+		port  int
+		async bool
+	}
+
+	cert, pool := newTestCertificate(t, "localhost")
+	cases := []testCase{
+		{name: "sync", port: 8145},
+		{name: "async", port: 8146, async: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := fmt.Sprintf("localhost:%d", tc.port)
+			srv := &MockServer{
+				TLS: &tls.Config{Certificates: []tls.Certificate{cert}},
+			}
+
+			go func() {
+				defer srv.Close()
+
+				err := srv.Accept(addr)
+				if err != nil {
+					panic(err)
+				}
+				srv.Write([]byte("hello"))
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			ws, err := NewWebsocketStream(ioc, &tls.Config{
+				RootCAs:    pool,
+				MaxVersion: tls.VersionTLS12,
+			}, RoleClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.CloseNextLayer()
+
+			if tc.async {
+				done := false
+				ws.AsyncHandshake("wss://"+addr, func(herr error) {
+					done, err = true, herr
+				})
+				for !done {
+					// The TLS handshake runs in a goroutine which posts its
+					// result, so the IO has nothing to wait on until then.
+					_ = ioc.RunOneFor(10 * time.Millisecond)
+				}
+			} else {
+				err = ws.Handshake("wss://" + addr)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stream, ok := ws.stream.(*tls12Stream)
+			if !ok {
+				t.Fatalf("expected a crypto/tls stream, got %T", ws.stream)
+			}
+			if v := stream.conn.ConnectionState().Version; v != tls.VersionTLS12 {
+				t.Fatalf("expected TLS 1.2, got %#04x", v)
+			}
+			if ws.RemoteAddr() == nil {
+				t.Fatal("stream has no connection")
+			}
+
+			b := make([]byte, 128)
+			_, n, err := ws.NextMessage(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b[:n]) != "hello" {
+				t.Fatalf("expected hello, got %q", b[:n])
+			}
+		})
+	}
+}
+
+func TestClientHandshakeNoTLS13(t *testing.T) {
+	cert, pool := newTestCertificate(t, "localhost")
+	srv := &MockServer{
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MaxVersion:   tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		defer srv.Close()
+
+		// The TLS handshake, and with it Accept, fails.
+		_ = srv.Accept("localhost:8147")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws, err := NewWebsocketStream(ioc, &tls.Config{RootCAs: pool}, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ws.Handshake("wss://localhost:8147")
+	if !errors.Is(err, sonictls.ErrVersion) {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+}
+
+// handshake makes a client handshake with opts to a server stream, configured
+// by setup, accepting on 127.0.0.1:port. It returns the error of the client
+// handshake.
+func handshake(
+	t *testing.T,
+	ioc *sonic.IO,
+	port int,
+	setup func(server *WebsocketStream),
+	opts ...HandshakeOption,
+) (server, client *WebsocketStream, err error) {
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	ln, err := sonic.Listen(
+		ioc, "tcp", addr,
+		sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server, err = NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup(server)
+
+	accepted, connected := false, false
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.AsyncAccept(conn, func(error) {
+			accepted = true
+		})
+	})
+	client.AsyncHandshake("ws://"+addr, func(herr error) {
+		connected, err = true, herr
+	}, opts...)
+
+	for !accepted || !connected {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	return server, client, err
+}
+
+// newTestCertificate returns a self-signed certificate valid for name along
+// with a pool trusting it.
+func newTestCertificate(
+	t *testing.T,
+	name string,
+) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{name},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
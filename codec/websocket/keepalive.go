@@ -0,0 +1,263 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+// SetKeepalive makes the stream ping the peer every interval once the
+// handshake completes. If timeout is positive and the peer does not reply with
+// a pong within timeout of a ping, the stream fails with ErrPongTimeout. A zero
+// interval disables keepalive pings.
+//
+// It returns an error if the timers of the pings cannot be created, in which
+// case the keepalive settings are left as they were.
+func (s *WebsocketStream) SetKeepalive(interval, timeout time.Duration) error {
+	if interval > 0 {
+		if err := s.newTimer(&s.pingTimer); err != nil {
+			return err
+		}
+		if timeout > 0 {
+			if err := s.newTimer(&s.pongTimer); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.pingInterval = interval
+	s.pongTimeout = timeout
+	if s.state == StateActive {
+		s.armKeepalive()
+	}
+	return nil
+}
+
+// SetIdleTimeout makes the stream fail with ErrIdleTimeout if no frame is read
+// from the peer for timeout once the handshake completes. Zero disables it.
+//
+// It returns an error if the timer of the timeout cannot be created, in which
+// case the idle timeout is left as it was.
+func (s *WebsocketStream) SetIdleTimeout(timeout time.Duration) error {
+	if timeout > 0 {
+		if err := s.newTimer(&s.idleTimer); err != nil {
+			return err
+		}
+	}
+
+	s.idleTimeout = timeout
+	if s.state == StateActive {
+		s.armIdle()
+	}
+	return nil
+}
+
+// SetCloseTimeout bounds the time the stream waits for the close frame of the
+// peer after starting the closing handshake with Close or AsyncClose. Once it
+// passes, the stream fails with ErrCloseTimeout. Zero waits forever. It
+// defaults to CloseTimeout.
+//
+// It returns an error if the timer of the timeout cannot be created, in which
+// case the close timeout is left as it was.
+func (s *WebsocketStream) SetCloseTimeout(timeout time.Duration) error {
+	if timeout > 0 {
+		if err := s.newTimer(&s.closeTimer); err != nil {
+			return err
+		}
+	}
+
+	s.closeTimeout = timeout
+	return nil
+}
+
+// newTimer creates the timer *t on the stream's IO, unless it already exists.
+func (s *WebsocketStream) newTimer(t **sonic.Timer) (err error) {
+	if *t == nil {
+		*t, err = sonic.NewTimer(s.ioc)
+	}
+	return err
+}
+
+// startTimers arms the keepalive and idle timers once the stream is active.
+func (s *WebsocketStream) startTimers() {
+	s.armKeepalive()
+	s.armIdle()
+}
+
+func (s *WebsocketStream) stopTimers() {
+	for _, t := range []*sonic.Timer{
+		s.pingTimer, s.pongTimer, s.idleTimer, s.closeTimer,
+	} {
+		if t != nil {
+			_ = t.Cancel()
+		}
+	}
+}
+
+func (s *WebsocketStream) armKeepalive() {
+	if s.pingTimer != nil {
+		_ = s.pingTimer.Cancel()
+	}
+	if s.pongTimer != nil {
+		_ = s.pongTimer.Cancel()
+	}
+	if s.pingInterval <= 0 {
+		return
+	}
+
+	_ = s.pingTimer.ScheduleRepeating(s.pingInterval, s.ping)
+}
+
+// ping is invoked every ping interval. It sends a ping and, if a pong timeout
+// is set, waits for the pong of the peer. It fails the stream with err if the
+// timer did not expire, such as when the IO is closed.
+func (s *WebsocketStream) ping(err error) {
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	if s.state != StateActive {
+		_ = s.pingTimer.Cancel()
+		return
+	}
+
+	f := AcquireFrame()
+	f.SetFin()
+	f.SetPing()
+	s.prepareWrite(f)
+	s.AsyncFlush(func(error) {})
+
+	if s.pongTimeout > 0 && s.state == StateActive {
+		if !s.pongTimer.Scheduled() {
+			s.pongDeadline = time.Now().Add(s.pongTimeout)
+			_ = s.pongTimer.ScheduleOnce(s.pongTimeout, func(err error) {
+				if err == nil {
+					err = ErrPongTimeout
+				}
+				s.fail(err)
+			})
+		}
+	}
+}
+
+// ponged is invoked when a pong is read.
+func (s *WebsocketStream) ponged() {
+	if s.pongTimer != nil && s.pongTimer.Scheduled() {
+		_ = s.pongTimer.Cancel()
+	}
+}
+
+func (s *WebsocketStream) armIdle() {
+	if s.idleTimer != nil {
+		_ = s.idleTimer.Cancel()
+	}
+	if s.idleTimeout <= 0 {
+		return
+	}
+
+	s.lastRead = time.Now()
+	_ = s.idleTimer.ScheduleOnce(s.idleTimeout, s.idle)
+}
+
+// idle is invoked once the idle timeout passes since the last frame read
+// when the timer was scheduled. It waits again if a frame was read since. Like
+// ping, it fails the stream with err if the timer did not expire.
+func (s *WebsocketStream) idle(err error) {
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	if left := s.idleTimeout - time.Since(s.lastRead); left > 0 {
+		_ = s.idleTimer.ScheduleOnce(left, s.idle)
+	} else {
+		s.fail(ErrIdleTimeout)
+	}
+}
+
+// closeByUs starts the closing handshake: it queues a close frame and bounds
+// the wait for the close frame of the peer.
+func (s *WebsocketStream) closeByUs(payload []byte) {
+	s.state = StateClosedByUs
+	s.prepareClose(payload)
+
+	s.stopTimers()
+	if s.closeTimeout > 0 {
+		s.closeDeadline = time.Now().Add(s.closeTimeout)
+		_ = s.closeTimer.ScheduleOnce(s.closeTimeout, func(err error) {
+			if err == nil {
+				err = ErrCloseTimeout
+			}
+			if s.state == StateClosedByUs {
+				s.fail(err)
+			}
+		})
+	}
+}
+
+// deadline returns the earliest expiry of the close, idle and pong timers
+// which are scheduled, along with the error the stream fails with once it
+// passes. It returns the zero time if none is scheduled.
+func (s *WebsocketStream) deadline() (at time.Time, err error) {
+	if scheduled(s.closeTimer) {
+		at, err = s.closeDeadline, ErrCloseTimeout
+	}
+	if scheduled(s.idleTimer) {
+		idle := s.lastRead.Add(s.idleTimeout)
+		if at.IsZero() || idle.Before(at) {
+			at, err = idle, ErrIdleTimeout
+		}
+	}
+	if scheduled(s.pongTimer) {
+		if at.IsZero() || s.pongDeadline.Before(at) {
+			at, err = s.pongDeadline, ErrPongTimeout
+		}
+	}
+	return at, err
+}
+
+func scheduled(t *sonic.Timer) bool {
+	return t != nil && t.Scheduled()
+}
+
+// checkDeadline fails the stream if the deadline of one of its timers passed.
+// The timers only fire while the IO runs, so blocking calls check their
+// deadlines instead.
+func (s *WebsocketStream) checkDeadline() error {
+	if at, err := s.deadline(); !at.IsZero() && !time.Now().Before(at) {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+// wait blocks until stream is ready for events. It fails the stream if the
+// deadline of one of its timers passes in the meantime.
+func (s *WebsocketStream) wait(stream sonic.Stream, events int16) error {
+	for {
+		timeout := time.Duration(-1)
+		if at, err := s.deadline(); !at.IsZero() {
+			if timeout = time.Until(at); timeout <= 0 {
+				s.fail(err)
+				return err
+			}
+		}
+
+		ready, err := poll(stream.RawFd(), events, timeout)
+		if ready || err != nil {
+			return err
+		}
+	}
+}
+
+// fail terminates the stream with err: pending operations complete with err
+// and the next layer is closed.
+func (s *WebsocketStream) fail(err error) {
+	s.stopTimers()
+	s.state = StateTerminated
+	s.err = err
+
+	if nl := s.NextLayer(); nl != nil {
+		nl.Cancel()
+	}
+	_ = s.CloseNextLayer()
+}
@@ -0,0 +1,326 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// connect returns a server and a client stream connected over localhost:port.
+// The streams are configured by setup before the handshake.
+func connect(
+	t *testing.T,
+	ioc *sonic.IO,
+	port int,
+	setup func(server, client *WebsocketStream),
+) (server, client *WebsocketStream) {
+	addr := fmt.Sprintf("localhost:%d", port)
+	ln, err := sonic.Listen(
+		ioc, "tcp", addr,
+		sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server, err = NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup(server, client)
+
+	accepted, connected := false, false
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.AsyncAccept(conn, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			accepted = true
+		})
+	})
+	client.AsyncHandshake("ws://"+addr, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		connected = true
+	})
+
+	for !accepted || !connected {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	return server, client
+}
+
+func TestKeepalive(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	server, client := connect(t, ioc, 8125, func(server, _ *WebsocketStream) {
+		if err := server.SetKeepalive(10*time.Millisecond, 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer client.CloseNextLayer()
+	defer server.CloseNextLayer()
+
+	pings := 0
+	client.SetControlCallback(func(mt MessageType, _ []byte) {
+		if mt == TypePing {
+			pings++
+		}
+	})
+
+	// The client replies to pings while reading.
+	var clientErr, serverErr error
+	b := make([]byte, 128)
+	client.AsyncNextMessage(b, func(err error, _ int, _ MessageType) {
+		clientErr = fmt.Errorf("client read completed with %v", err)
+	})
+	server.AsyncNextMessage(b, func(err error, _ int, _ MessageType) {
+		serverErr = fmt.Errorf("server read completed with %v", err)
+	})
+
+	start := time.Now()
+	for time.Since(start) < 200*time.Millisecond &&
+		clientErr == nil && serverErr == nil {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	if serverErr != nil {
+		t.Fatal(serverErr)
+	}
+	if pings < 5 {
+		t.Fatalf("expected at least 5 pings, got %d", pings)
+	}
+	assertState(t, server, StateActive)
+}
+
+func TestKeepaliveTimeouts(t *testing.T) {
+	type testCase struct {
+		name    string
+		port    int
+		setup   func(server, client *WebsocketStream)
+		wantErr error
+	}
+	cases := []testCase{
+		{
+			name: "pong",
+			port: 8126,
+			setup: func(server, _ *WebsocketStream) {
+				if err := server.SetKeepalive(10*time.Millisecond, 30*time.Millisecond); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrPongTimeout,
+		},
+		{
+			name: "idle",
+			port: 8127,
+			setup: func(server, _ *WebsocketStream) {
+				if err := server.SetIdleTimeout(30 * time.Millisecond); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrIdleTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			// The client does not read, so it does not reply to pings.
+			start := time.Now()
+			server, client := connect(t, ioc, tc.port, tc.setup)
+			defer client.CloseNextLayer()
+
+			var (
+				done bool
+				err  error
+			)
+			server.AsyncNextMessage(
+				make([]byte, 128),
+				func(rerr error, _ int, _ MessageType) {
+					done, err = true, rerr
+				},
+			)
+			for !done {
+				_ = ioc.RunOneFor(10 * time.Millisecond)
+			}
+
+			if err != tc.wantErr {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+				t.Fatalf("failed too early, after %s", elapsed)
+			}
+			assertState(t, server, StateTerminated)
+		})
+	}
+}
+
+func TestIdleTimeoutReset(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	server, client := connect(t, ioc, 8128, func(server, _ *WebsocketStream) {
+		if err := server.SetIdleTimeout(50 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer client.CloseNextLayer()
+	defer server.CloseNextLayer()
+
+	// The client writes more often than the idle timeout.
+	timer, err := sonic.NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+	err = timer.ScheduleRepeating(10*time.Millisecond, func(error) {
+		client.AsyncWrite([]byte("hello"), TypeText, func(err error) {})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		serverErr error
+		messages  int
+		b         = make([]byte, 128)
+		read      func()
+	)
+	read = func() {
+		server.AsyncNextMessage(b, func(err error, _ int, _ MessageType) {
+			if err != nil {
+				serverErr = err
+			} else {
+				messages++
+				read()
+			}
+		})
+	}
+	read()
+
+	start := time.Now()
+	for time.Since(start) < 200*time.Millisecond && serverErr == nil {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if serverErr != nil {
+		t.Fatalf("server failed with %v after %d messages", serverErr, messages)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	server, client := connect(t, ioc, 8129, func(_, _ *WebsocketStream) {})
+	defer server.CloseNextLayer()
+
+	// The server does not read, so it does not reply to the close frame.
+	if err := client.SetCloseTimeout(30 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done bool
+		err  error
+	)
+	client.AsyncClose(CloseNormal, "bye", func(cerr error) {
+		if cerr != nil {
+			t.Fatal(cerr)
+		}
+		client.AsyncNextFrame(func(rerr error, _ *Frame) {
+			done, err = true, rerr
+		})
+	})
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if err != ErrCloseTimeout {
+		t.Fatalf("expected ErrCloseTimeout, got %v", err)
+	}
+	assertState(t, client, StateTerminated)
+}
+
+func TestBlockingTimeouts(t *testing.T) {
+	type testCase struct {
+		name    string
+		port    int
+		setup   func(server, client *WebsocketStream)
+		read    func(client *WebsocketStream) error
+		wantErr error
+	}
+	cases := []testCase{
+		{
+			name: "close",
+			port: 8142,
+			setup: func(_, client *WebsocketStream) {
+				if err := client.SetCloseTimeout(30 * time.Millisecond); err != nil {
+					t.Fatal(err)
+				}
+			},
+			read: func(client *WebsocketStream) error {
+				if err := client.Close(CloseNormal, "bye"); err != nil {
+					return err
+				}
+				_, err := client.NextFrame()
+				return err
+			},
+			wantErr: ErrCloseTimeout,
+		},
+		{
+			name: "idle",
+			port: 8143,
+			setup: func(_, client *WebsocketStream) {
+				if err := client.SetIdleTimeout(30 * time.Millisecond); err != nil {
+					t.Fatal(err)
+				}
+			},
+			read: func(client *WebsocketStream) error {
+				_, err := client.NextFrame()
+				return err
+			},
+			wantErr: ErrIdleTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			// The server does not read, so it never replies. The IO does not
+			// run while the client blocks, so its timers cannot fire.
+			start := time.Now()
+			server, client := connect(t, ioc, tc.port, tc.setup)
+			defer server.CloseNextLayer()
+
+			if err := tc.read(client); err != tc.wantErr {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+				t.Fatalf("failed too early, after %s", elapsed)
+			}
+			assertState(t, client, StateTerminated)
+		})
+	}
+}
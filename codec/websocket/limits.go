@@ -0,0 +1,107 @@
+package websocket
+
+// maxFrameHeaderLen is the size of the largest frame header: 2 fixed bytes, 8
+// bytes of extended payload length and 4 bytes of mask.
+const maxFrameHeaderLen = 14
+
+// SetMaxMessageSize sets the maximum size of a message the stream reads or
+// writes. It defaults to MaxMessageSize.
+//
+// A message read over the limit fails with ErrMessageTooBig and starts the
+// closing handshake with CloseTooBig. A message written over the limit fails
+// with ErrMessageTooBig and nothing is sent.
+func (s *WebsocketStream) SetMaxMessageSize(bytes int) {
+	s.maxMessageSize = bytes
+	s.applyLimits()
+}
+
+// MaxMessageSize returns the maximum size of a message the stream reads or
+// writes.
+func (s *WebsocketStream) MaxMessageSize() int {
+	return s.maxMessageSize
+}
+
+// SetMaxFrameSize sets the maximum payload size of a frame the stream reads.
+// Zero, the default, bounds frames by the maximum message size only.
+//
+// A frame over the limit fails the read with ErrPayloadOverMaxSize. Since the
+// frame cannot be skipped, the stream sends a close frame with CloseTooBig and
+// terminates without waiting for the close frame of the peer.
+func (s *WebsocketStream) SetMaxFrameSize(bytes int) {
+	s.maxFrameSize = bytes
+	s.applyLimits()
+}
+
+// SetReadBufferSize reserves initial bytes in the buffer frames are read into
+// and bounds the size it may grow to by maxSize. A frame which does not fit in
+// maxSize bytes is handled like a frame over the maximum frame size. Zero
+// maxSize lets the buffer grow as needed.
+func (s *WebsocketStream) SetReadBufferSize(initial, maxSize int) {
+	s.src.Reserve(initial)
+	s.maxReadBuffer = maxSize
+	s.applyLimits()
+}
+
+// SetWriteBufferSize reserves initial bytes in the buffer frames are written
+// from and bounds the size a single frame may grow it to by maxSize. Writes
+// of frames which do not fit in maxSize bytes fail with ErrMessageTooBig; see
+// SetFragmentSize to split messages into smaller frames. Zero maxSize lets the
+// buffer grow as needed.
+func (s *WebsocketStream) SetWriteBufferSize(initial, maxSize int) {
+	s.dst.Reserve(initial)
+	s.maxWriteBuffer = maxSize
+}
+
+// applyLimits passes the limits of the stream to its frame codec.
+func (s *WebsocketStream) applyLimits() {
+	if s.codec == nil {
+		return
+	}
+
+	s.codec.maxFrameSize = s.maxMessageSize
+	if s.maxFrameSize > 0 && s.maxFrameSize < s.maxMessageSize {
+		s.codec.maxFrameSize = s.maxFrameSize
+	}
+	s.codec.maxReadBuffer = s.maxReadBuffer
+}
+
+// canWrite returns true if a message of n bytes is within the limits of the
+// stream.
+func (s *WebsocketStream) canWrite(n int) bool {
+	return n <= s.maxMessageSize && s.fitsWriteBuffer(n)
+}
+
+// fitsWriteBuffer returns true if the frames n bytes are written in fit in the
+// write buffer.
+func (s *WebsocketStream) fitsWriteBuffer(n int) bool {
+	if s.fragmentSize > 0 && n > s.fragmentSize {
+		n = s.fragmentSize
+	}
+	return s.maxWriteBuffer <= 0 || n+maxFrameHeaderLen <= s.maxWriteBuffer
+}
+
+// closeTooBig starts the closing handshake after a message over the limits is
+// read.
+func (s *WebsocketStream) closeTooBig() {
+	if s.state == StateActive {
+		s.closeByUs(EncodeCloseFramePayload(CloseTooBig, ""))
+	}
+}
+
+// frameTooBig terminates the stream after a frame over the limits is read,
+// once the close frame is sent.
+func (s *WebsocketStream) frameTooBig() error {
+	s.closeTooBig()
+	_ = s.Flush()
+	s.fail(ErrPayloadOverMaxSize)
+	return ErrPayloadOverMaxSize
+}
+
+// asyncFrameTooBig is the asynchronous counterpart of frameTooBig.
+func (s *WebsocketStream) asyncFrameTooBig(cb func(err error)) {
+	s.closeTooBig()
+	s.AsyncFlush(func(error) {
+		s.fail(ErrPayloadOverMaxSize)
+		cb(ErrPayloadOverMaxSize)
+	})
+}
@@ -0,0 +1,213 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+func TestDecodeFrameOverLimits(t *testing.T) {
+	type testCase struct {
+		name          string
+		frame         []byte
+		maxFrameSize  int
+		maxReadBuffer int
+	}
+	cases := []testCase{
+		{
+			name:         "frame size",
+			frame:        []byte{0x81, 5, 1, 2, 3, 4, 5},
+			maxFrameSize: 4,
+		},
+		{
+			name:          "read buffer",
+			frame:         []byte{0x81, 5, 1, 2, 3, 4, 5},
+			maxFrameSize:  5,
+			maxReadBuffer: 6,
+		},
+		{
+			name: "negative length",
+			frame: []byte{
+				0x81, 127, 0x80, 0, 0, 0, 0, 0, 0, 1,
+			},
+			maxFrameSize: 5,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := sonic.NewByteBuffer()
+			src.Write(tc.frame)
+
+			codec := NewFrameCodec(src, nil)
+			codec.maxFrameSize = tc.maxFrameSize
+			codec.maxReadBuffer = tc.maxReadBuffer
+
+			f, err := codec.Decode(src)
+			if err != ErrPayloadOverMaxSize {
+				t.Fatalf("expected ErrPayloadOverMaxSize, got %v", err)
+			}
+			if f != nil {
+				t.Fatal("should not have gotten a frame")
+			}
+		})
+	}
+
+	// The frame fits once the limits are raised.
+	src := sonic.NewByteBuffer()
+	src.Write(cases[1].frame)
+	codec := NewFrameCodec(src, nil)
+	codec.maxFrameSize = 5
+	codec.maxReadBuffer = 7
+	if _, err := codec.Decode(src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitsPerStream(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	small, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small.SetMaxMessageSize(16)
+	large.SetMaxMessageSize(1 << 20)
+	if MaxMessageSize != 1024*512 {
+		t.Fatal("package default changed")
+	}
+	if small.MaxMessageSize() != 16 || large.MaxMessageSize() != 1<<20 {
+		t.Fatal("wrong per-stream limits")
+	}
+
+	for _, s := range []*WebsocketStream{small, large} {
+		s.state = StateActive
+		if err := s.init(NewMockStream()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if small.codec.maxFrameSize != 16 || large.codec.maxFrameSize != 1<<20 {
+		t.Fatal("limits not applied to the codec")
+	}
+	small.SetMaxFrameSize(8)
+	large.SetMaxFrameSize(1 << 30)
+	if small.codec.maxFrameSize != 8 || large.codec.maxFrameSize != 1<<20 {
+		t.Fatal("frame limits not applied to the codec")
+	}
+
+	msg := make([]byte, 32)
+	if err := small.Write(msg, TypeBinary); err != ErrMessageTooBig {
+		t.Fatalf("expected ErrMessageTooBig, got %v", err)
+	}
+	if err := large.Write(msg, TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+
+	large.SetWriteBufferSize(0, 32)
+	if err := large.Write(msg, TypeBinary); err != ErrMessageTooBig {
+		t.Fatalf("expected ErrMessageTooBig, got %v", err)
+	}
+	if err := large.Write(msg[:32-maxFrameHeaderLen], TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitsCloseTooBig(t *testing.T) {
+	type testCase struct {
+		name    string
+		port    int
+		setup   func(server, client *WebsocketStream)
+		wantErr error
+		state   StreamState
+	}
+	cases := []testCase{
+		{
+			// The message is read, so the closing handshake is started.
+			name: "message",
+			port: 8133,
+			setup: func(server, _ *WebsocketStream) {
+				server.SetMaxMessageSize(16)
+				server.SetMaxFrameSize(10)
+			},
+			wantErr: ErrMessageTooBig,
+			state:   StateClosedByUs,
+		},
+		{
+			// The frame cannot be read, so the stream terminates.
+			name: "frame",
+			port: 8134,
+			setup: func(server, _ *WebsocketStream) {
+				server.SetMaxFrameSize(8)
+			},
+			wantErr: ErrPayloadOverMaxSize,
+			state:   StateTerminated,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ioc := sonic.MustIO()
+			defer ioc.Close()
+
+			server, client := connect(t, ioc, tc.port, tc.setup)
+			defer client.CloseNextLayer()
+			defer server.CloseNextLayer()
+
+			// Two frames of 10 bytes each.
+			payload := bytes.Repeat([]byte{'a'}, 10)
+			for _, fin := range []bool{false, true} {
+				f := AcquireFrame()
+				if fin {
+					f.SetFin()
+					f.SetContinuation()
+				} else {
+					f.SetText()
+				}
+				f.SetPayload(payload)
+				if err := client.WriteFrame(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var (
+				serverDone, clientDone bool
+				serverErr, clientErr   error
+				closeFrame             []byte
+			)
+			server.AsyncNextMessage(
+				make([]byte, 128),
+				func(err error, _ int, _ MessageType) {
+					serverDone, serverErr = true, err
+				},
+			)
+			client.AsyncNextFrame(func(err error, f *Frame) {
+				clientDone, clientErr = true, err
+				if err == nil && f.IsClose() {
+					closeFrame = append(closeFrame, f.Payload()...)
+				}
+			})
+			for !serverDone || !clientDone {
+				_ = ioc.RunOneFor(10 * time.Millisecond)
+			}
+
+			if serverErr != tc.wantErr {
+				t.Fatalf("expected %v, got %v", tc.wantErr, serverErr)
+			}
+			assertState(t, server, tc.state)
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			if cc, _ := DecodeCloseFramePayload(closeFrame); cc != CloseTooBig {
+				t.Fatalf("expected CloseTooBig, got %d", cc)
+			}
+		})
+	}
+}
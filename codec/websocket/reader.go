@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"github.com/talostrading/sonic"
+)
+
+// NextMessageBuffer reads the next message into buf, which grows as needed,
+// and commits it to the read area of buf. The write area of buf must be
+// empty. It returns the type and size of the message.
+//
+// Unlike NextMessage, messages are only bounded by the maximum message size of
+// the stream, not by the size of a caller supplied slice.
+func (s *WebsocketStream) NextMessageBuffer(
+	buf *sonic.ByteBuffer,
+) (mt MessageType, readBytes int, err error) {
+	return s.nextMessage(func(f *Frame, readBytes int) (int, error) {
+		return s.bufferPayload(buf, f, readBytes)
+	})
+}
+
+// AsyncNextMessageBuffer reads the next message into buf asynchronously. See
+// NextMessageBuffer.
+func (s *WebsocketStream) AsyncNextMessageBuffer(
+	buf *sonic.ByteBuffer,
+	cb AsyncMessageHandler,
+) {
+	s.asyncNextMessage(func(f *Frame, readBytes int) (int, error) {
+		return s.bufferPayload(buf, f, readBytes)
+	}, 0, false, TypeNone, cb)
+}
+
+// NextMessageView reads the next message and returns a view of its payload,
+// which is only valid until the next read from the stream.
+//
+// The payload of a message sent in a single uncompressed frame is not copied:
+// the view points into the buffer the stream reads frames into. Fragmented and
+// compressed messages are assembled in a buffer owned by the stream.
+func (s *WebsocketStream) NextMessageView() (
+	mt MessageType,
+	b []byte,
+	err error,
+) {
+	var view viewReader
+	mt, _, err = s.nextMessage(view.reader(s))
+	return mt, view.payload(s), err
+}
+
+// AsyncNextMessageView reads the next message asynchronously and invokes cb
+// with a view of its payload. See NextMessageView.
+func (s *WebsocketStream) AsyncNextMessageView(cb AsyncMessageViewHandler) {
+	view := &viewReader{}
+	s.asyncNextMessage(
+		view.reader(s), 0, false, TypeNone,
+		func(err error, _ int, mt MessageType) {
+			cb(err, view.payload(s), mt)
+		},
+	)
+}
+
+// viewReader reads the payload of a message read by NextMessageView.
+type viewReader struct {
+	direct bool   // true if the message is in a single uncompressed frame
+	b      []byte // the payload of the frame if direct
+}
+
+func (v *viewReader) reader(s *WebsocketStream) payloadReader {
+	return func(f *Frame, readBytes int) (int, error) {
+		if !f.IsContinuation() {
+			v.direct = f.IsFin() && !f.IsRSV1()
+			if v.direct {
+				v.b = f.Payload()
+				return len(v.b), nil
+			}
+
+			if s.mb == nil {
+				s.mb = sonic.NewByteBuffer()
+			}
+			s.mb.Reset()
+		}
+		return s.bufferPayload(s.mb, f, readBytes)
+	}
+}
+
+func (v *viewReader) payload(s *WebsocketStream) []byte {
+	if v.direct {
+		return v.b
+	}
+	if s.mb == nil {
+		return nil
+	}
+	return s.mb.Data()
+}
+
+// bufferPayload appends the payload of the data frame f to buf, growing it as
+// needed, and commits it to the read area of buf. The payload of a compressed
+// message is buffered until its last frame is read, at which point it is
+// inflated into buf. It returns ErrMessageTooBig if the message is over the
+// maximum message size.
+func (s *WebsocketStream) bufferPayload(
+	buf *sonic.ByteBuffer,
+	f *Frame,
+	readBytes int,
+) (n int, err error) {
+	if s.pmd == nil || !s.pmd.reading(f) {
+		if readBytes+f.PayloadLen() > s.maxMessageSize {
+			return 0, ErrMessageTooBig
+		}
+		n, _ = buf.Write(f.Payload())
+		buf.Commit(n)
+		return n, nil
+	}
+
+	if len(s.pmd.rb)+f.PayloadLen() > s.maxMessageSize {
+		return 0, ErrMessageTooBig
+	}
+	s.pmd.rb = append(s.pmd.rb, f.Payload()...)
+	if !f.IsFin() {
+		return 0, nil
+	}
+
+	n, err = s.pmd.inflateBuffer(buf, s.maxMessageSize)
+	if err == nil {
+		err = s.validateInflated(buf.Data()[buf.ReadLen()-n:])
+	}
+	if err != nil && err != ErrMessageTooBig {
+		s.closeByUs(EncodeCloseFramePayload(CloseBadPayload, ""))
+	}
+	return n, err
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+)
+
+func newActiveClient(t *testing.T, ioc *sonic.IO) *WebsocketStream {
+	ws, err := NewWebsocketStream(ioc, nil, RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.state = StateActive
+	if err := ws.init(NewMockStream()); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestNextMessageBuffer(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws := newActiveClient(t, ioc)
+
+	// A message of two fragments with a ping in between, each larger than the
+	// initial size of the buffer.
+	payload := bytes.Repeat([]byte{'a'}, 1000)
+	ws.src.Write([]byte{byte(OpcodeBinary), 126, 0x03, 0xe8})
+	ws.src.Write(payload)
+	ws.src.Write([]byte{byte(OpcodePing) | 1<<7, 0})
+	ws.src.Write([]byte{1 << 7, 126, 0x03, 0xe8})
+	ws.src.Write(payload)
+
+	buf := sonic.NewByteBuffer()
+	mt, n, err := ws.NextMessageBuffer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != TypeBinary || n != 2000 {
+		t.Fatalf("unexpected message type=%s n=%d", mt, n)
+	}
+	if !bytes.Equal(buf.Data(), bytes.Repeat([]byte{'a'}, 2000)) {
+		t.Fatal("invalid payload")
+	}
+
+	// The message is over the maximum message size.
+	ws.SetMaxMessageSize(1500)
+	ws.src.Write([]byte{byte(OpcodeBinary), 126, 0x03, 0xe8})
+	ws.src.Write(payload)
+	ws.src.Write([]byte{1 << 7, 126, 0x03, 0xe8})
+	ws.src.Write(payload)
+
+	buf.Reset()
+	if _, _, err := ws.NextMessageBuffer(buf); err != ErrMessageTooBig {
+		t.Fatalf("expected ErrMessageTooBig, got %v", err)
+	}
+	assertState(t, ws, StateClosedByUs)
+}
+
+func TestNextMessageView(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ws := newActiveClient(t, ioc)
+
+	ws.src.Write([]byte{byte(OpcodeText) | 1<<7, 5})
+	ws.src.Write([]byte("hello"))
+	ws.src.Write([]byte{byte(OpcodeText), 3})
+	ws.src.Write([]byte("hel"))
+	ws.src.Write([]byte{1 << 7, 2})
+	ws.src.Write([]byte("lo"))
+
+	// An unfragmented message is not copied out of the read buffer.
+	mt, b, err := ws.NextMessageView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != TypeText || string(b) != "hello" {
+		t.Fatalf("unexpected message type=%s payload=%q", mt, b)
+	}
+	if &b[0] != &ws.src.Data()[2] {
+		t.Fatal("payload copied out of the read buffer")
+	}
+
+	// A fragmented message is assembled.
+	done := false
+	ws.AsyncNextMessageView(func(err error, b []byte, mt MessageType) {
+		done = true
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != TypeText || string(b) != "hello" {
+			t.Fatalf("unexpected message type=%s payload=%q", mt, b)
+		}
+	})
+	if !done {
+		t.Fatal("handler not invoked")
+	}
+}
+
+func TestNextMessageViewDeflate(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	setup := func(server, client *WebsocketStream) {
+		server.SetDeflateOptions(&DeflateOptions{CompressWrites: true})
+		client.SetDeflateOptions(&DeflateOptions{})
+	}
+	server, client := connect(t, ioc, 8136, setup)
+	defer client.CloseNextLayer()
+	defer server.CloseNextLayer()
+	if !client.SupportsDeflate() {
+		t.Fatal("permessage-deflate not negotiated")
+	}
+
+	// Larger than the window of the inflater, fragmented after compression.
+	msg := make([]byte, 3*deflateWindowSize)
+	for i := range msg {
+		msg[i] = byte(i % 251)
+	}
+	server.SetFragmentSize(1024)
+	if err := server.Write(msg, TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Write(msg[:100], TypeBinary); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done int
+		errs []error
+		buf  = sonic.NewByteBuffer()
+	)
+	client.AsyncNextMessageBuffer(buf, func(err error, n int, _ MessageType) {
+		if err != nil || n != len(msg) || !bytes.Equal(buf.Data(), msg) {
+			errs = append(errs, err)
+		}
+		client.AsyncNextMessageView(func(err error, b []byte, _ MessageType) {
+			if err != nil || !bytes.Equal(b, msg[:100]) {
+				errs = append(errs, err)
+			}
+			done++
+		})
+		done++
+	})
+	for done < 2 {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if len(errs) > 0 {
+		t.Fatalf("invalid messages: %v", errs)
+	}
+}
@@ -26,14 +26,15 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/dns"
 	"github.com/talostrading/sonic/sonicerrors"
-	"github.com/talostrading/sonic/sonicopts"
+	sonictls "github.com/talostrading/sonic/tls"
+	"golang.org/x/sys/unix"
 )
 
 var (
@@ -41,6 +42,12 @@ var (
 	DialTimeout        = 5 * time.Second
 )
 
+// WebsocketStream is a websocket client or server stream running on an IO.
+//
+// Keepalive pings, the pong timeout, the idle timeout and the close timeout
+// are driven by timers of the stream's IO, see SetKeepalive, SetIdleTimeout
+// and SetCloseTimeout. They only fire while the IO runs or while a blocking
+// call such as NextFrame or Flush waits for the next layer.
 type WebsocketStream struct {
 	// async operations executor.
 	ioc *sonic.IO
@@ -48,12 +55,16 @@ type WebsocketStream struct {
 	// User provided TLS config; nil if we don't use TLS
 	tls *tls.Config
 
-	// Underlying transport stream that we async adapt from the net.Conn.
+	// TLS configuration of clients, derived from tls on the first handshake.
+	tlsConf *sonictls.Config
+
+	// Underlying transport stream: a sonic.Conn, or a TLS connection over one
+	// for wss:// endpoints.
 	stream sonic.Stream
-	conn   net.Conn
 
 	// Codec stream wrapping the underlying transport stream.
-	cs *sonic.BlockingCodecConn[*Frame, *Frame]
+	cs    *sonic.BlockingCodecConn[*Frame, *Frame]
+	codec *FrameCodec
 
 	// Websocket role: client or server.
 	role Role
@@ -70,7 +81,11 @@ type WebsocketStream struct {
 	// Buffer for stream writes.
 	dst *sonic.ByteBuffer
 
-	// Contains the handshake response. Is emptied after the
+	// Buffer in which NextMessageView assembles fragmented and compressed
+	// messages; created on first use.
+	mb *sonic.ByteBuffer
+
+	// Contains the upgrade request read by a server. Is emptied after the
 	// handshake is over.
 	hb []byte
 
@@ -81,13 +96,88 @@ type WebsocketStream struct {
 	// Optional callback invoked when a control frame is received.
 	ccb ControlCallback
 
-	// Used to establish a TCP connection to the peer with a timeout.
-	dialer *net.Dialer
+	// Looks up the host of the address a client connects to.
+	resolver *dns.Resolver
 
 	// The size of the currently read message.
 	messageSize int
+
+	// Subprotocols supported by a server, in order of preference.
+	subprotocols []string
+
+	// The negotiated subprotocol, if any.
+	subprotocol string
+
+	// The upgrade response read by a client, if any.
+	res *http.Response
+
+	// Optional callback invoked by a server with the upgrade request.
+	upgradeCb UpgradeCallback
+
+	// Options of the permessage-deflate extension; nil if it is disabled.
+	deflateOpts *DeflateOptions
+
+	// Compression state; nil if permessage-deflate is not negotiated.
+	pmd *deflateStream
+
+	// Keepalive pings and timeouts, see keepalive.go.
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	idleTimeout  time.Duration
+	closeTimeout time.Duration
+	pingTimer    *sonic.Timer
+	pongTimer    *sonic.Timer
+	idleTimer    *sonic.Timer
+	closeTimer   *sonic.Timer
+
+	// When the last frame was read; only set if idleTimeout is set.
+	lastRead time.Time
+
+	// When closeTimer and pongTimer expire, see deadline.
+	closeDeadline time.Time
+	pongDeadline  time.Time
+
+	// Limits on the messages and frames the stream reads and writes, see
+	// limits.go.
+	maxMessageSize int
+	maxFrameSize   int
+	maxReadBuffer  int
+	maxWriteBuffer int
+
+	// Size of the fragments messages are written in; zero if they are not
+	// fragmented. See writer.go.
+	fragmentSize int
+
+	// The writer of the message being written, if any.
+	writer *MessageWriter
+
+	// UTF-8 validation of the text messages read, see utf8.go. utf8Text is
+	// set if the message being read is validated, and utf8Inflated if it is
+	// compressed, in which case it is validated once inflated.
+	skipUTF8     bool
+	utf8Text     bool
+	utf8Inflated bool
+	utf8         utf8Validator
+
+	// The error the stream failed with after a timeout or after reading a
+	// frame over the limits, if any.
+	err error
+
+	// True while AsyncFlush writes the pending frames. Holds the callbacks of
+	// the calls made in the meantime.
+	flushing bool
+	flushCbs []func(err error)
 }
 
+// NewWebsocketStream creates a stream of the given role on ioc. Clients secure
+// connections to wss:// endpoints with tls, see also the TLSConfig option.
+//
+// Those connections use sonic/tls, which runs on the IO but only supports TLS
+// 1.3: the handshake with a server which does not support TLS 1.3 fails with
+// sonic/tls.ErrVersion. If tls caps MaxVersion below TLS 1.3, crypto/tls is
+// used instead. AsyncHandshake then dials and runs the TLS handshake in a
+// goroutine, and reads and writes of the stream block the IO until they
+// complete.
 func NewWebsocketStream(
 	ioc *sonic.IO,
 	tls *tls.Config,
@@ -101,16 +191,17 @@ func NewWebsocketStream(
 		dst:   sonic.NewByteBuffer(),
 		state: StateHandshake,
 		/* #nosec G401 */
-		hasher: sha1.New(),
-		hb:     make([]byte, 1024),
-		dialer: &net.Dialer{
-			Timeout: DialTimeout,
-		},
+		hasher:         sha1.New(),
+		hb:             make([]byte, 1024),
+		maxMessageSize: MaxMessageSize,
 	}
 
 	s.src.Reserve(4096)
 	s.dst.Reserve(4096)
 
+	if err := s.SetCloseTimeout(CloseTimeout); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
@@ -122,9 +213,13 @@ func (s *WebsocketStream) init(stream sonic.Stream) (err error) {
 	}
 
 	s.stream = stream
-	codec := NewFrameCodec(s.src, s.dst)
+	s.codec = NewFrameCodec(s.src, s.dst)
+	s.applyLimits()
 	s.cs, err = sonic.NewBlockingCodecConn[*Frame, *Frame](
-		stream, codec, s.src, s.dst)
+		stream, s.codec, s.src, s.dst)
+	if err == nil {
+		s.startTimers()
+	}
 	return
 }
 
@@ -132,7 +227,12 @@ func (s *WebsocketStream) reset() {
 	s.hb = s.hb[:cap(s.hb)]
 	s.state = StateHandshake
 	s.stream = nil
-	s.conn = nil
+	s.subprotocol = ""
+	s.res = nil
+	s.pmd = nil
+	s.writer = nil
+	s.err = nil
+	s.stopTimers()
 	s.src.Reset()
 	s.dst.Reset()
 }
@@ -145,11 +245,11 @@ func (s *WebsocketStream) NextLayer() sonic.Stream {
 }
 
 func (s *WebsocketStream) SupportsUTF8() bool {
-	return false
+	return !s.skipUTF8
 }
 
 func (s *WebsocketStream) SupportsDeflate() bool {
-	return false
+	return s.pmd != nil
 }
 
 func (s *WebsocketStream) canRead() bool {
@@ -161,7 +261,7 @@ func (s *WebsocketStream) NextFrame() (f *Frame, err error) {
 	err = s.Flush()
 
 	if errors.Is(err, ErrMessageTooBig) {
-		_ = s.Close(CloseGoingAway, "payload too big")
+		_ = s.Close(CloseTooBig, "payload too big")
 		return nil, err
 	}
 
@@ -172,7 +272,7 @@ func (s *WebsocketStream) NextFrame() (f *Frame, err error) {
 	if err == nil {
 		f, err = s.nextFrame()
 
-		if err == io.EOF {
+		if errors.Is(err, io.EOF) {
 			s.state = StateTerminated
 		}
 	}
@@ -181,7 +281,21 @@ func (s *WebsocketStream) NextFrame() (f *Frame, err error) {
 }
 
 func (s *WebsocketStream) nextFrame() (f *Frame, err error) {
+	// A peer which keeps sending must not hold off the timeouts.
+	if err = s.checkDeadline(); err != nil {
+		return nil, err
+	}
+
 	f, err = s.cs.ReadNext()
+	for err == sonicerrors.ErrWouldBlock {
+		// The next layer is nonblocking: wait for the rest of the frame.
+		if err = s.wait(s.stream, unix.POLLIN); err == nil {
+			f, err = s.cs.ReadNext()
+		}
+	}
+	if err == ErrPayloadOverMaxSize {
+		return nil, s.frameTooBig()
+	}
 	if err == nil {
 		err = s.handleFrame(f)
 	}
@@ -199,7 +313,7 @@ func (s *WebsocketStream) AsyncNextFrame(cb AsyncFrameHandler) {
 	// Not entirely sure about a NonblockingCodecStream.
 	s.AsyncFlush(func(err error) {
 		if errors.Is(err, ErrMessageTooBig) {
-			s.AsyncClose(CloseGoingAway, "payload too big", func(err error) {})
+			s.AsyncClose(CloseTooBig, "payload too big", func(err error) {})
 			cb(ErrMessageTooBig, nil)
 			return
 		}
@@ -211,6 +325,9 @@ func (s *WebsocketStream) AsyncNextFrame(cb AsyncFrameHandler) {
 		if err == nil {
 			s.asyncNextFrame(cb)
 		} else {
+			if s.err != nil {
+				err = s.err
+			}
 			s.state = StateTerminated
 			cb(err, nil)
 		}
@@ -219,17 +336,37 @@ func (s *WebsocketStream) AsyncNextFrame(cb AsyncFrameHandler) {
 
 func (s *WebsocketStream) asyncNextFrame(cb AsyncFrameHandler) {
 	s.cs.AsyncReadNext(func(err error, f *Frame) {
+		if err == ErrPayloadOverMaxSize {
+			s.asyncFrameTooBig(func(err error) { cb(err, nil) })
+			return
+		}
 		if err == nil {
 			err = s.handleFrame(f)
-		} else if err == io.EOF {
+		} else if errors.Is(err, io.EOF) {
 			s.state = StateTerminated
 		}
+		if err != nil && s.err != nil {
+			err = s.err
+		}
 		cb(err, f)
 	})
 }
 
 func (s *WebsocketStream) NextMessage(
 	b []byte,
+) (mt MessageType, readBytes int, err error) {
+	return s.nextMessage(func(f *Frame, readBytes int) (int, error) {
+		return s.copyPayload(b[readBytes:], f)
+	})
+}
+
+// payloadReader reads the payload of the data frame f of a message of which
+// readBytes are read so far. It returns the number of bytes the payload adds
+// to the message.
+type payloadReader = func(f *Frame, readBytes int) (int, error)
+
+func (s *WebsocketStream) nextMessage(
+	read payloadReader,
 ) (mt MessageType, readBytes int, err error) {
 	var (
 		f            *Frame
@@ -253,12 +390,17 @@ func (s *WebsocketStream) NextMessage(
 				mt = MessageType(f.Opcode())
 			}
 
-			n := copy(b[readBytes:], f.Payload())
+			var n int
+			n, err = read(f, readBytes)
 			readBytes += n
 
-			if readBytes > MaxMessageSize || n != f.PayloadLen() {
+			if err == nil && readBytes > s.maxMessageSize {
 				err = ErrMessageTooBig
-				_ = s.Close(CloseGoingAway, "payload too big")
+			}
+			if err == ErrMessageTooBig {
+				_ = s.Close(CloseTooBig, "payload too big")
+			}
+			if err != nil {
 				break
 			}
 
@@ -289,11 +431,13 @@ func (s *WebsocketStream) NextMessage(
 }
 
 func (s *WebsocketStream) AsyncNextMessage(b []byte, cb AsyncMessageHandler) {
-	s.asyncNextMessage(b, 0, false, TypeNone, cb)
+	s.asyncNextMessage(func(f *Frame, readBytes int) (int, error) {
+		return s.copyPayload(b[readBytes:], f)
+	}, 0, false, TypeNone, cb)
 }
 
 func (s *WebsocketStream) asyncNextMessage(
-	b []byte,
+	read payloadReader,
 	readBytes int,
 	continuation bool,
 	mt MessageType,
@@ -308,22 +452,26 @@ func (s *WebsocketStream) asyncNextMessage(
 					s.ccb(MessageType(f.Opcode()), f.payload)
 				}
 
-				s.asyncNextMessage(b, readBytes, continuation, mt, cb)
+				s.asyncNextMessage(read, readBytes, continuation, mt, cb)
 			} else {
 				if mt == TypeNone {
 					mt = MessageType(f.Opcode())
 				}
 
-				n := copy(b[readBytes:], f.Payload())
+				n, err := read(f, readBytes)
 				readBytes += n
 
-				if readBytes > MaxMessageSize || n != f.PayloadLen() {
+				if err == nil && readBytes > s.maxMessageSize {
 					err = ErrMessageTooBig
+				}
+				if err == ErrMessageTooBig {
 					s.AsyncClose(
-						CloseGoingAway,
+						CloseTooBig,
 						"payload too big",
 						func(err error) {},
 					)
+				}
+				if err != nil {
 					cb(err, readBytes, mt)
 					return
 				}
@@ -346,7 +494,7 @@ func (s *WebsocketStream) asyncNextMessage(
 				if err != nil || !continuation {
 					cb(err, readBytes, mt)
 				} else {
-					s.asyncNextMessage(b, readBytes, continuation, mt, cb)
+					s.asyncNextMessage(read, readBytes, continuation, mt, cb)
 				}
 			}
 		}
@@ -354,9 +502,18 @@ func (s *WebsocketStream) asyncNextMessage(
 }
 
 func (s *WebsocketStream) handleFrame(f *Frame) (err error) {
+	if s.idleTimeout > 0 {
+		s.lastRead = time.Now()
+	}
+
 	err = s.verifyFrame(f)
 
 	if err == nil {
+		if s.role == RoleServer {
+			// Frames from clients are always masked, see verifyFrame.
+			f.Unmask()
+		}
+
 		if f.IsControl() {
 			err = s.handleControlFrame(f)
 		} else {
@@ -364,16 +521,24 @@ func (s *WebsocketStream) handleFrame(f *Frame) (err error) {
 		}
 	}
 
-	if err != nil {
-		s.state = StateClosedByUs
-		s.prepareClose(EncodeCloseFramePayload(CloseProtocolError, ""))
+	if err != nil && s.state == StateActive {
+		cc := CloseProtocolError
+		if err == ErrInvalidUTF8 {
+			cc = CloseBadPayload
+		}
+		s.closeByUs(EncodeCloseFramePayload(cc, ""))
 	}
 
 	return err
 }
 
 func (s *WebsocketStream) verifyFrame(f *Frame) error {
-	if f.IsRSV1() || f.IsRSV2() || f.IsRSV3() {
+	if f.IsRSV2() || f.IsRSV3() {
+		return ErrNonZeroReservedBits
+	}
+
+	// RSV1 marks the first frame of a compressed message.
+	if f.IsRSV1() && (s.pmd == nil || f.IsControl() || f.IsContinuation()) {
 		return ErrNonZeroReservedBits
 	}
 
@@ -407,21 +572,28 @@ func (s *WebsocketStream) handleControlFrame(f *Frame) (err error) {
 			if s.role == RoleClient {
 				pongFrame.Mask()
 			}
-			s.pending = append(s.pending, pongFrame)
+			s.enqueue(pongFrame)
 		}
 	case OpcodePong:
+		s.ponged()
 	case OpcodeClose:
 		switch s.state {
 		case StateHandshake:
 			panic("unreachable")
 		case StateActive:
 			s.state = StateClosedByPeer
-			s.prepareClose(f.payload)
+			if err = s.validateCloseReason(f.payload); err != nil {
+				s.prepareClose(EncodeCloseFramePayload(CloseBadPayload, ""))
+			} else {
+				s.prepareClose(f.payload)
+			}
+			s.stopTimers()
 		case StateClosedByPeer, StateCloseAcked:
 			// ignore
 		case StateClosedByUs:
 			// we received a reply from the peer
 			s.state = StateCloseAcked
+			s.stopTimers()
 		case StateTerminated:
 			panic("unreachable")
 		}
@@ -436,21 +608,25 @@ func (s *WebsocketStream) handleDataFrame(f *Frame) error {
 	if IsReserved(f.Opcode()) {
 		return ErrReservedOpcode
 	}
-	return nil
+	return s.validateFrame(f)
 }
 
 func (s *WebsocketStream) Write(b []byte, mt MessageType) error {
-	if len(b) > MaxMessageSize {
+	if !s.canWrite(len(b)) {
 		return ErrMessageTooBig
 	}
 
+	if s.writer != nil {
+		return ErrMessageInProgress
+	}
+
 	if s.state == StateActive {
-		f := AcquireFrame()
-		f.SetFin()
-		f.SetOpcode(Opcode(mt))
-		f.SetPayload(b)
+		payload, compressed, err := s.messagePayload(b, mt)
+		if err != nil {
+			return err
+		}
 
-		s.prepareWrite(f)
+		s.queueFrames(payload, Opcode(mt), compressed, true)
 		return s.Flush()
 	}
 
@@ -472,18 +648,24 @@ func (s *WebsocketStream) AsyncWrite(
 	mt MessageType,
 	cb func(err error),
 ) {
-	if len(b) > MaxMessageSize {
+	if !s.canWrite(len(b)) {
 		cb(ErrMessageTooBig)
 		return
 	}
 
+	if s.writer != nil {
+		cb(ErrMessageInProgress)
+		return
+	}
+
 	if s.state == StateActive {
-		f := AcquireFrame()
-		f.SetFin()
-		f.SetOpcode(Opcode(mt))
-		f.SetPayload(b)
+		payload, compressed, err := s.messagePayload(b, mt)
+		if err != nil {
+			cb(err)
+			return
+		}
 
-		s.prepareWrite(f)
+		s.queueFrames(payload, Opcode(mt), compressed, true)
 		s.AsyncFlush(cb)
 	} else {
 		cb(sonicerrors.ErrCancelled)
@@ -512,7 +694,23 @@ func (s *WebsocketStream) prepareWrite(f *Frame) {
 		}
 	}
 
-	s.pending = append(s.pending, f)
+	s.enqueue(f)
+}
+
+// enqueue adds f to the frames pending to be sent. Pings and pongs go ahead of
+// the data frames which are not being sent yet, such that they are sent
+// between the fragments of a message rather than after all of them.
+func (s *WebsocketStream) enqueue(f *Frame) {
+	i := len(s.pending)
+	if f.IsPing() || f.IsPong() {
+		for i > 0 && !s.pending[i-1].IsControl() {
+			i--
+		}
+	}
+
+	s.pending = append(s.pending, nil)
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = f
 }
 
 func (s *WebsocketStream) AsyncClose(
@@ -522,8 +720,7 @@ func (s *WebsocketStream) AsyncClose(
 ) {
 	switch s.state {
 	case StateActive:
-		s.state = StateClosedByUs
-		s.prepareClose(EncodeCloseFramePayload(cc, reason))
+		s.closeByUs(EncodeCloseFramePayload(cc, reason))
 		s.AsyncFlush(cb)
 	case StateClosedByUs, StateHandshake:
 		cb(sonicerrors.ErrCancelled)
@@ -535,8 +732,7 @@ func (s *WebsocketStream) AsyncClose(
 func (s *WebsocketStream) Close(cc CloseCode, reason string) error {
 	switch s.state {
 	case StateActive:
-		s.state = StateClosedByUs
-		s.prepareClose(EncodeCloseFramePayload(cc, reason))
+		s.closeByUs(EncodeCloseFramePayload(cc, reason))
 		return s.Flush()
 	case StateClosedByUs, StateHandshake:
 		return sonicerrors.ErrCancelled
@@ -561,6 +757,13 @@ func (s *WebsocketStream) Flush() (err error) {
 	flushed := 0
 	for i := 0; i < len(s.pending); i++ {
 		_, err = s.cs.WriteNext(s.pending[i])
+		for err == sonicerrors.ErrWouldBlock {
+			// The next layer is nonblocking: the rest of the frame is left in
+			// dst until it becomes writable.
+			if err = s.wait(s.stream, unix.POLLOUT); err == nil {
+				_, err = s.dst.WriteTo(s.stream)
+			}
+		}
 		if err != nil {
 			break
 		}
@@ -573,6 +776,29 @@ func (s *WebsocketStream) Flush() (err error) {
 }
 
 func (s *WebsocketStream) AsyncFlush(cb func(err error)) {
+	if s.flushing {
+		// The ongoing flush writes the frames pending now as well.
+		s.flushCbs = append(s.flushCbs, cb)
+		return
+	}
+
+	s.flushing = true
+	s.asyncFlush(func(err error) {
+		s.flushing = false
+		if err != nil && s.err != nil {
+			err = s.err
+		}
+
+		cbs := s.flushCbs
+		s.flushCbs = nil
+		cb(err)
+		for _, cb := range cbs {
+			cb(err)
+		}
+	})
+}
+
+func (s *WebsocketStream) asyncFlush(cb func(err error)) {
 	if len(s.pending) == 0 {
 		cb(nil)
 	} else {
@@ -585,7 +811,7 @@ func (s *WebsocketStream) AsyncFlush(cb func(err error)) {
 			if err != nil {
 				cb(err)
 			} else {
-				s.AsyncFlush(cb)
+				s.asyncFlush(cb)
 			}
 		})
 	}
@@ -599,9 +825,16 @@ func (s *WebsocketStream) State() StreamState {
 	return s.state
 }
 
+// SetResolver sets the resolver looking up the host of the address
+// AsyncHandshake connects to. By default, a resolver reading the system
+// configuration is created on the first AsyncHandshake.
+func (s *WebsocketStream) SetResolver(resolver *dns.Resolver) {
+	s.resolver = resolver
+}
+
 func (s *WebsocketStream) Handshake(
 	addr string,
-	extraHeaders ...Header,
+	opts ...HandshakeOption,
 ) (err error) {
 	if s.role != RoleClient {
 		return ErrWrongHandshakeRole
@@ -609,30 +842,25 @@ func (s *WebsocketStream) Handshake(
 
 	s.reset()
 
-	var stream sonic.Stream
-
-	done := make(chan struct{}, 1)
-	s.handshake(addr, extraHeaders, func(rerr error, rstream sonic.Stream) {
-		err = rerr
-		stream = rstream
-		done <- struct{}{}
-	})
-	<-done
-
-	if err != nil {
-		s.state = StateTerminated
-	} else {
-		s.state = StateActive
-		err = s.init(stream)
+	var (
+		hs     = s.handshakeOptions(opts)
+		url    *url.URL
+		stream sonic.Stream
+	)
+	url, err = s.resolve(addr)
+	if err == nil {
+		stream, err = s.dial(url, hs)
 	}
-
-	return
+	if err == nil {
+		err = s.upgrade(url, stream, hs)
+	}
+	return s.handshaken(stream, err)
 }
 
 func (s *WebsocketStream) AsyncHandshake(
 	addr string,
 	cb func(error),
-	extraHeaders ...Header,
+	opts ...HandshakeOption,
 ) {
 	if s.role != RoleClient {
 		cb(ErrWrongHandshakeRole)
@@ -641,40 +869,50 @@ func (s *WebsocketStream) AsyncHandshake(
 
 	s.reset()
 
-	// I know, this is horrible, but if you help me write a TLS client for sonic
-	// we can asynchronously dial endpoints and remove the need for a goroutine
-	go func() {
-		s.handshake(addr, extraHeaders, func(err error, stream sonic.Stream) {
-			// TODO maybe report this error somehow although this is very fatal
-			_ = s.ioc.Post(func() {
-				if err != nil {
-					s.state = StateTerminated
-				} else {
-					s.state = StateActive
-					err = s.init(stream)
-				}
-				cb(err)
-			})
-		})
-	}()
-}
+	// The handler must not be invoked from within this function, even if the
+	// handshake completes immediately.
+	returned := false
+	complete := func(err error) {
+		if returned {
+			cb(err)
+		} else {
+			_ = s.ioc.Post(func() { cb(err) })
+		}
+	}
+	defer func() { returned = true }()
 
-func (s *WebsocketStream) handshake(
-	addr string,
-	headers []Header,
-	cb func(err error, stream sonic.Stream),
-) {
+	hs := s.handshakeOptions(opts)
 	url, err := s.resolve(addr)
 	if err != nil {
-		cb(err, nil)
-	} else {
-		s.dial(url, func(err error, stream sonic.Stream) {
-			if err == nil {
-				err = s.upgrade(url, stream, headers)
-			}
-			cb(err, stream)
+		complete(s.handshaken(nil, err))
+		return
+	}
+
+	s.asyncDial(url, hs, func(err error, stream sonic.Stream) {
+		if err != nil {
+			complete(s.handshaken(stream, err))
+			return
+		}
+		s.asyncUpgrade(url, stream, hs, func(err error) {
+			complete(s.handshaken(stream, err))
 		})
+	})
+}
+
+// handshaken completes the handshake of a client over stream: it transitions
+// into StateActive, or into StateTerminated and closes stream if err is not
+// nil.
+func (s *WebsocketStream) handshaken(stream sonic.Stream, err error) error {
+	if err != nil {
+		if stream != nil {
+			_ = stream.Close()
+		}
+		s.state = StateTerminated
+		return err
 	}
+
+	s.state = StateActive
+	return s.init(stream)
 }
 
 func (s *WebsocketStream) resolve(addr string) (url *url.URL, err error) {
@@ -693,78 +931,182 @@ func (s *WebsocketStream) resolve(addr string) (url *url.URL, err error) {
 	return
 }
 
-func (s *WebsocketStream) dial(
+// hostPort returns the host and port of url, defaulting the port to the one
+// of its scheme.
+func (s *WebsocketStream) hostPort(
 	url *url.URL,
-	cb func(err error, stream sonic.Stream),
-) {
-	var (
-		err error
-		sc  syscall.Conn
-
-		port = url.Port()
-	)
+	hs *handshakeOptions,
+) (host, port string, err error) {
+	host, port = url.Hostname(), url.Port()
 
 	switch url.Scheme {
 	case "http":
 		if port == "" {
 			port = "80"
 		}
-		addr := url.Hostname() + ":" + port
-		s.conn, err = net.DialTimeout("tcp", addr, DialTimeout)
-		if err == nil {
-			sc = s.conn.(syscall.Conn)
-		} else {
-			// This is needed otherwise the net.Conn interface will be pointing
-			// to a nil pointer. Calling something like CloseNextLayer will
-			// produce a panic then.
-			s.conn = nil
-		}
 	case "https":
-		if s.tls == nil {
-			err = fmt.Errorf(
+		if hs.tls == nil {
+			return "", "", fmt.Errorf(
 				"wss:// scheme endpoints require a TLS configuration",
 			)
 		}
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return "", "", fmt.Errorf("invalid url scheme=%s", url.Scheme)
+	}
 
-		if err == nil {
-			if port == "" {
-				port = "443"
+	return host, port, nil
+}
+
+// dial connects to url, blocking until the connection, and the TLS handshake
+// for wss:// endpoints, complete.
+func (s *WebsocketStream) dial(
+	url *url.URL,
+	hs *handshakeOptions,
+) (sonic.Stream, error) {
+	host, port, err := s.hostPort(url, hs)
+	if err != nil {
+		return nil, err
+	}
+	if url.Scheme == "https" && needsTLS12(hs.tls) {
+		return s.connectTLS12(host, port, hs)
+	}
+
+	conn, err := sonic.DialTimeout(
+		s.ioc, "tcp", net.JoinHostPort(host, port), DialTimeout,
+		hs.dialOpts...)
+	if err != nil || url.Scheme != "https" {
+		return conn, err
+	}
+
+	config, err := s.tlsConfig(host, hs)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	tc := sonictls.Client(conn, config)
+	if err := tc.Handshake(); err != nil {
+		_ = tc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// asyncDial connects to url asynchronously: it looks up the host, connects to
+// its addresses in turn until one accepts, and runs the TLS handshake for
+// wss:// endpoints.
+func (s *WebsocketStream) asyncDial(
+	url *url.URL,
+	hs *handshakeOptions,
+	cb func(err error, stream sonic.Stream),
+) {
+	host, port, err := s.hostPort(url, hs)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+	if url.Scheme == "https" && needsTLS12(hs.tls) {
+		s.asyncConnectTLS12(host, port, hs, cb)
+		return
+	}
+
+	if s.resolver == nil {
+		if s.resolver, err = dns.NewResolver(s.ioc, nil); err != nil {
+			cb(err, nil)
+			return
+		}
+	}
+
+	s.resolver.AsyncLookupIP("ip", host, func(err error, ips []net.IP) {
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+
+		s.asyncConnect(ips, port, hs, func(err error, conn sonic.Conn) {
+			if err != nil || url.Scheme != "https" {
+				cb(err, conn)
+				return
 			}
-			addr := url.Hostname() + ":" + port
-			s.conn, err = tls.DialWithDialer(s.dialer, "tcp", addr, s.tls)
-			if err == nil {
-				sc = s.conn.(*tls.Conn).NetConn().(syscall.Conn)
-			} else {
-				// This is needed otherwise the net.Conn interface will be
-				// pointing to a nil pointer. Calling something like
-				// CloseNextLayer will produce a panic then.
-				s.conn = nil
+
+			config, err := s.tlsConfig(host, hs)
+			if err != nil {
+				_ = conn.Close()
+				cb(err, nil)
+				return
+			}
+			tc := sonictls.Client(conn, config)
+			tc.AsyncHandshake(func(err error) {
+				if err != nil {
+					_ = tc.Close()
+					cb(err, nil)
+					return
+				}
+				cb(nil, tc)
+			})
+		})
+	})
+}
+
+// asyncConnect connects to the first of ips accepting a connection on port.
+func (s *WebsocketStream) asyncConnect(
+	ips []net.IP,
+	port string,
+	hs *handshakeOptions,
+	cb sonic.DialCallback,
+) {
+	addr := net.JoinHostPort(ips[0].String(), port)
+	sonic.AsyncDialTimeout(
+		s.ioc, "tcp", addr, DialTimeout,
+		func(err error, conn sonic.Conn) {
+			if err != nil && len(ips) > 1 {
+				s.asyncConnect(ips[1:], port, hs, cb)
+				return
+			}
+			cb(err, conn)
+		},
+		hs.dialOpts...,
+	)
+}
+
+// tlsConfig returns the TLS configuration of a connection to host, derived
+// from the one of the handshake: the one the stream is created with, unless
+// overridden with the TLSConfig option.
+func (s *WebsocketStream) tlsConfig(
+	host string,
+	hs *handshakeOptions,
+) (conf *sonictls.Config, err error) {
+	if hs.tls == s.tls {
+		if s.tlsConf == nil {
+			if s.tlsConf, err = sonictls.NewConfig(s.tls); err != nil {
+				return nil, err
 			}
 		}
-	default:
-		err = fmt.Errorf("invalid url scheme=%s", url.Scheme)
+		conf = s.tlsConf
+	} else if conf, err = sonictls.NewConfig(hs.tls); err != nil {
+		return nil, err
 	}
 
-	if err == nil {
-		// s.ioc is not used by this constructor, so there is NO a race
-		// condition on the io context.
-		sonic.NewAsyncAdapter(
-			s.ioc, sc, s.conn, func(err error, stream *sonic.AsyncAdapter) {
-				cb(err, stream)
-			}, sonicopts.NoDelay(true))
-	} else {
-		cb(err, nil)
+	if conf.ServerName != "" {
+		return conf, nil
 	}
+	config := *conf
+	config.Config = conf.Config.Clone()
+	config.ServerName = host
+	return &config, nil
 }
 
-func (s *WebsocketStream) upgrade(
+// makeUpgradeRequest writes the upgrade request to dst and returns it along
+// with the expected value of the Sec-WebSocket-Accept header of the response.
+func (s *WebsocketStream) makeUpgradeRequest(
 	uri *url.URL,
-	stream sonic.Stream,
-	headers []Header,
-) error {
-	req, err := http.NewRequest("GET", uri.String(), nil)
+	hs *handshakeOptions,
+) (req *http.Request, expectedKey string, err error) {
+	req, err = http.NewRequest("GET", uri.String(), nil)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
 	sentKey, expectedKey := s.makeHandshakeKey()
@@ -772,8 +1114,18 @@ func (s *WebsocketStream) upgrade(
 	req.Header.Set("Connection", "upgrade")
 	req.Header.Set("Sec-WebSocket-Key", string(sentKey))
 	req.Header.Set("Sec-Websocket-Version", "13")
+	if s.deflateOpts != nil {
+		req.Header.Set("Sec-WebSocket-Extensions", s.deflateOpts.offer())
+	}
+	if len(hs.subprotocols) > 0 {
+		req.Header.Set(
+			"Sec-WebSocket-Protocol", strings.Join(hs.subprotocols, ", "))
+	}
+	for _, cookie := range hs.cookies {
+		req.AddCookie(cookie)
+	}
 
-	for _, header := range headers {
+	for _, header := range hs.headers {
 		if header.CanonicalKey {
 			req.Header.Del(header.Key)
 			for _, value := range header.Values {
@@ -788,47 +1140,149 @@ func (s *WebsocketStream) upgrade(
 		}
 	}
 
-	err = req.Write(stream)
-	if err != nil {
-		return err
+	if err = req.Write(s.dst); err != nil {
+		return nil, "", err
 	}
+	s.dst.Commit(s.dst.WriteLen())
 
-	s.hb = s.hb[:cap(s.hb)]
-	n, err := stream.Read(s.hb)
+	return req, expectedKey, nil
+}
+
+// upgrade sends the upgrade request over stream and reads the response,
+// blocking until both complete.
+func (s *WebsocketStream) upgrade(
+	uri *url.URL,
+	stream sonic.Stream,
+	hs *handshakeOptions,
+) error {
+	req, expectedKey, err := s.makeUpgradeRequest(uri, hs)
 	if err != nil {
 		return err
 	}
-	s.hb = s.hb[:n]
-	rd := bytes.NewReader(s.hb)
-	res, err := http.ReadResponse(bufio.NewReader(rd), req)
-	if err != nil {
-		return err
+
+	for s.dst.ReadLen() > 0 {
+		_, err = s.dst.WriteTo(stream)
+		if err == sonicerrors.ErrWouldBlock {
+			err = s.wait(stream, unix.POLLOUT)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		s.src.Reserve(512)
+		n, err := s.src.ReadFrom(stream)
+		if err == sonicerrors.ErrWouldBlock {
+			err = s.wait(stream, unix.POLLIN)
+		}
+		if err != nil {
+			return err
+		}
+		s.src.Commit(int(n))
+
+		done, err := s.readUpgradeResponse(req, expectedKey)
+		if done || err != nil {
+			return err
+		}
 	}
+}
 
-	rawRes, err := httputil.DumpResponse(res, true)
+// asyncUpgrade sends the upgrade request over stream and reads the response
+// asynchronously.
+func (s *WebsocketStream) asyncUpgrade(
+	uri *url.URL,
+	stream sonic.Stream,
+	hs *handshakeOptions,
+	cb func(error),
+) {
+	req, expectedKey, err := s.makeUpgradeRequest(uri, hs)
 	if err != nil {
-		return err
+		cb(err)
+		return
 	}
 
-	resLen := len(rawRes)
-	extra := len(s.hb) - resLen
-	if extra > 0 {
-		// we got some frames as well with the handshake so we can put
-		// them in src for later decoding before clearing the handshake
-		// buffer
-		_, _ = s.src.Write(s.hb[resLen:])
+	s.dst.AsyncWriteTo(stream, func(err error, _ int) {
+		if err != nil {
+			cb(err)
+		} else {
+			s.asyncReadUpgradeResponse(stream, req, expectedKey, cb)
+		}
+	})
+}
+
+func (s *WebsocketStream) asyncReadUpgradeResponse(
+	stream sonic.Stream,
+	req *http.Request,
+	expectedKey string,
+	cb func(error),
+) {
+	s.src.Reserve(512)
+	s.src.AsyncReadFrom(stream, func(err error, n int) {
+		if err != nil {
+			cb(err)
+			return
+		}
+		s.src.Commit(n)
+
+		done, err := s.readUpgradeResponse(req, expectedKey)
+		if done || err != nil {
+			cb(err)
+		} else {
+			s.asyncReadUpgradeResponse(stream, req, expectedKey, cb)
+		}
+	})
+}
+
+// readUpgradeResponse parses and validates the upgrade response in src, if
+// src holds all of it. The response is consumed from src, such that the frames
+// sent right after it are decoded from src later.
+func (s *WebsocketStream) readUpgradeResponse(
+	req *http.Request,
+	expectedKey string,
+) (done bool, err error) {
+	b := s.src.Data()
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(b) >= maxUpgradeSize {
+			return true, fmt.Errorf(
+				"%w: response too large", ErrCannotUpgrade)
+		}
+		return false, nil
+	}
+	n := end + 4
+
+	res, err := http.ReadResponse(
+		bufio.NewReader(bytes.NewReader(b[:n])), req)
+	s.src.Consume(n)
+	if err != nil {
+		return true, err
 	}
-	s.hb = s.hb[:0]
+	res.Body = http.NoBody
+	s.res = res
 
 	if !IsUpgradeRes(res) {
-		return ErrCannotUpgrade
+		return true, ErrCannotUpgrade
 	}
 
 	if key := res.Header.Get("Sec-WebSocket-Accept"); key != expectedKey {
-		return ErrCannotUpgrade
+		return true, ErrCannotUpgrade
 	}
 
-	return nil
+	if protocol := res.Header.Get("Sec-WebSocket-Protocol"); protocol != "" {
+		offered := headerTokens(req.Header, "Sec-WebSocket-Protocol")
+		if negotiateSubprotocol(offered, res.Header) != protocol {
+			return true, fmt.Errorf(
+				"%w: subprotocol %s not offered", ErrCannotUpgrade, protocol)
+		}
+		s.subprotocol = protocol
+	}
+
+	if s.deflateOpts != nil {
+		s.pmd, err = s.deflateOpts.confirm(res.Header)
+	}
+
+	return true, err
 }
 
 // makeHandshakeKey generates the key of Sec-WebSocket-Key header as well as the
@@ -851,12 +1305,22 @@ func (s *WebsocketStream) makeHandshakeKey() (req, res string) {
 	return
 }
 
-func (s *WebsocketStream) Accept() error {
-	panic("implement me")
+// SetSubprotocols sets the subprotocols a server supports, in order of
+// preference. The first one the client offers is selected. A client offers
+// them in its handshakes, unless the Subprotocols option overrides them.
+func (s *WebsocketStream) SetSubprotocols(protocols ...string) {
+	s.subprotocols = protocols
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake, if any.
+func (s *WebsocketStream) Subprotocol() string {
+	return s.subprotocol
 }
 
-func (s *WebsocketStream) AsyncAccept(func(error)) {
-	panic("implement me")
+// SetUpgradeCallback sets a function invoked by a server with the upgrade
+// request of a client, which may add response headers or reject the upgrade.
+func (s *WebsocketStream) SetUpgradeCallback(cb UpgradeCallback) {
+	s.upgradeCb = cb
 }
 
 func (s *WebsocketStream) SetControlCallback(ccb ControlCallback) {
@@ -867,32 +1331,48 @@ func (s *WebsocketStream) ControlCallback() ControlCallback {
 	return s.ccb
 }
 
-func (s *WebsocketStream) SetMaxMessageSize(bytes int) {
-	// This is just for checking against the length returned in the frame
-	// header. The sizes of the buffers in which we read or write the messages
-	// are dynamically adjusted in frame_codec.
-	MaxMessageSize = bytes
-}
-
 func (s *WebsocketStream) RemoteAddr() net.Addr {
-	return s.conn.RemoteAddr()
+	if conn := s.netConn(); conn != nil {
+		return conn.RemoteAddr()
+	}
+	return nil
 }
 
 func (s *WebsocketStream) LocalAddr() net.Addr {
-	return s.conn.LocalAddr()
+	if conn := s.netConn(); conn != nil {
+		return conn.LocalAddr()
+	}
+	return nil
+}
+
+// netConn returns the connection the stream runs over, which is the next
+// layer of the TLS connection for wss:// endpoints.
+func (s *WebsocketStream) netConn() net.Conn {
+	stream := s.stream
+	switch tc := stream.(type) {
+	case *sonictls.Conn:
+		stream = tc.NextLayer()
+	case *tls12Stream:
+		return tc.conn
+	}
+	if conn, ok := stream.(sonic.Conn); ok {
+		return conn
+	}
+	return nil
 }
 
 func (s *WebsocketStream) RawFd() int {
 	if s.NextLayer() != nil {
-		return s.NextLayer().(*sonic.AsyncAdapter).RawFd()
+		return s.NextLayer().RawFd()
 	}
 	return -1
 }
 
 func (s *WebsocketStream) CloseNextLayer() (err error) {
-	if s.conn != nil {
-		err = s.conn.Close()
-		s.conn = nil
+	s.stopTimers()
+	if s.stream != nil {
+		err = s.stream.Close()
+		s.stream = nil
 	}
 	return
 }
@@ -155,8 +155,10 @@ func TestClientFailedHandshakeNoServer(t *testing.T) {
 
 func TestClientSuccessfulHandshake(t *testing.T) {
 	srv := &MockServer{}
+	srvDone := make(chan struct{})
 
 	go func() {
+		defer close(srvDone)
 		defer srv.Close()
 
 		err := srv.Accept("localhost:8080")
@@ -176,26 +178,32 @@ func TestClientSuccessfulHandshake(t *testing.T) {
 
 	assertState(t, ws, StateHandshake)
 
+	done := false
 	ws.AsyncHandshake("ws://localhost:8080", func(err error) {
+		done = true
 		if err != nil {
-			assertState(t, ws, StateTerminated)
-		} else {
-			assertState(t, ws, StateActive)
+			t.Fatal(err)
 		}
+		assertState(t, ws, StateActive)
 	})
 
-	for {
-		if srv.IsClosed() {
-			break
+	// The stream has nothing pending once the handshake completes, so the
+	// server closing the connection afterwards does not wake the IO: wait for
+	// the handshake, not for the server.
+	for !done {
+		if err := ioc.RunOne(); err != nil {
+			t.Fatal(err)
 		}
-		ioc.RunOne()
 	}
+	<-srvDone
 }
 
 func TestClientSuccessfulHandshakeWithExtraHeaders(t *testing.T) {
 	srv := &MockServer{}
+	srvDone := make(chan struct{})
 
 	go func() {
+		defer close(srvDone)
 		defer srv.Close()
 
 		err := srv.Accept("localhost:8080")
@@ -226,14 +234,15 @@ func TestClientSuccessfulHandshakeWithExtraHeaders(t *testing.T) {
 		"k6": {"v62"},
 	}
 
+	done := false
 	ws.AsyncHandshake(
 		"ws://localhost:8080",
 		func(err error) {
+			done = true
 			if err != nil {
-				assertState(t, ws, StateTerminated)
-			} else {
-				assertState(t, ws, StateActive)
+				t.Fatal(err)
 			}
+			assertState(t, ws, StateActive)
 		},
 		ExtraHeader(true, "k1", "v1"),
 		ExtraHeader(true, "k2", "v21", "v22"),
@@ -243,9 +252,12 @@ func TestClientSuccessfulHandshakeWithExtraHeaders(t *testing.T) {
 		ExtraHeader(false, "k6", "v61"), ExtraHeader(false, "k6", "v62"),
 	)
 
-	for !srv.IsClosed() {
-		ioc.RunOne()
+	for !done {
+		if err := ioc.RunOne(); err != nil {
+			t.Fatal(err)
+		}
 	}
+	<-srvDone
 
 	for key := range expected {
 		given := srv.Upgrade.Header.Values(key)
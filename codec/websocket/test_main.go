@@ -3,6 +3,7 @@ package websocket
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
@@ -19,11 +20,18 @@ type MockServer struct {
 	closed int32
 	port   int32
 
+	// TLS makes the server accept wss:// connections if not nil.
+	TLS *tls.Config
+
 	Upgrade *http.Request
 }
 
 func (s *MockServer) Accept(addr string) (err error) {
-	s.ln, err = net.Listen("tcp", addr)
+	if s.TLS != nil {
+		s.ln, err = tls.Listen("tcp", addr, s.TLS)
+	} else {
+		s.ln, err = net.Listen("tcp", addr)
+	}
 	if err != nil {
 		return err
 	}
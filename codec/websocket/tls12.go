@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// sonic/tls only supports TLS 1.3. Connections to wss:// endpoints whose TLS
+// configuration caps the version below it are secured with crypto/tls instead
+// and adapted to the IO with a sonic.AsyncAdapter. crypto/tls reads and writes
+// block, as does its handshake, which a client dialing asynchronously runs in a
+// goroutine.
+
+// needsTLS12 returns true if connections secured with config must not use
+// sonic/tls since config caps the version below TLS 1.3.
+func needsTLS12(config *tls.Config) bool {
+	return config.MaxVersion != 0 && config.MaxVersion < tls.VersionTLS13
+}
+
+// tls12Stream is a crypto/tls connection adapted to the IO. crypto/tls owns
+// the file descriptor, so it is closed through the connection.
+type tls12Stream struct {
+	*sonic.AsyncAdapter
+	conn *tls.Conn
+}
+
+func (s *tls12Stream) Close() error {
+	s.AsyncAdapter.Cancel()
+	return s.conn.Close()
+}
+
+func (s *tls12Stream) AsyncClose(cb func(err error)) {
+	cb(s.Close())
+}
+
+// tls12Conn returns the bytes crypto/tls reads along with an error, such as
+// the io.EOF of a close_notify right behind them, without the error: sonic
+// drops the bytes of reads which fail. crypto/tls returns the error again on
+// the next read.
+type tls12Conn struct {
+	*tls.Conn
+}
+
+func (c tls12Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+// dialTLS12 connects to host:port with crypto/tls, blocking until the
+// handshake completes.
+func dialTLS12(host, port string, hs *handshakeOptions) (*tls.Conn, error) {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	for _, opt := range hs.dialOpts {
+		if opt.Type() == sonicopts.TypeBindSocket {
+			dialer.LocalAddr = opt.Value().(net.Addr)
+		}
+	}
+	return tls.DialWithDialer(
+		dialer, "tcp", net.JoinHostPort(host, port), hs.tls)
+}
+
+// adaptTLS12 adapts conn to the IO, applying the socket options of the
+// handshake which are not applied by dialTLS12.
+func (s *WebsocketStream) adaptTLS12(
+	conn *tls.Conn,
+	hs *handshakeOptions,
+) (stream sonic.Stream, err error) {
+	var opts []sonicopts.Option
+	for _, opt := range hs.dialOpts {
+		switch opt.Type() {
+		case sonicopts.TypeBindSocket, sonicopts.TypeNonblocking:
+		default:
+			opts = append(opts, opt)
+		}
+	}
+
+	sonic.NewAsyncAdapter(
+		s.ioc, conn.NetConn().(syscall.Conn), tls12Conn{conn},
+		func(aerr error, adapter *sonic.AsyncAdapter) {
+			err = aerr
+			if err == nil {
+				stream = &tls12Stream{AsyncAdapter: adapter, conn: conn}
+			}
+		}, opts...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// connectTLS12 is dialTLS12 followed by adaptTLS12.
+func (s *WebsocketStream) connectTLS12(
+	host, port string,
+	hs *handshakeOptions,
+) (sonic.Stream, error) {
+	conn, err := dialTLS12(host, port, hs)
+	if err != nil {
+		return nil, err
+	}
+	return s.adaptTLS12(conn, hs)
+}
+
+// asyncConnectTLS12 is like connectTLS12, but dials in a goroutine such that
+// the IO is not blocked. cb runs on the IO.
+func (s *WebsocketStream) asyncConnectTLS12(
+	host, port string,
+	hs *handshakeOptions,
+	cb func(err error, stream sonic.Stream),
+) {
+	go func() {
+		conn, err := dialTLS12(host, port, hs)
+		_ = s.ioc.Post(func() {
+			if err != nil {
+				cb(err, nil)
+				return
+			}
+			stream, err := s.adaptTLS12(conn, hs)
+			cb(err, stream)
+		})
+	}()
+}
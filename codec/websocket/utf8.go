@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// utf8Validator validates UTF-8 text incrementally, such that a message can
+// be validated frame by frame with sequences split across frames. Invalid
+// sequences are detected at their first invalid byte.
+type utf8Validator struct {
+	need   int  // number of continuation bytes left in the current sequence
+	lo, hi byte // range of the next continuation byte
+}
+
+// write validates the next bytes of the text. It returns false as soon as b
+// holds an invalid sequence.
+func (v *utf8Validator) write(b []byte) bool {
+	for i := 0; i < len(b); {
+		if v.need == 0 {
+			// Skip ASCII, 8 bytes at a time.
+			for i+8 <= len(b) &&
+				binary.LittleEndian.Uint64(b[i:])&0x8080808080808080 == 0 {
+				i += 8
+			}
+			for i < len(b) && b[i] < utf8.RuneSelf {
+				i++
+			}
+			if i == len(b) {
+				break
+			}
+
+			// Well-formed byte sequences, RFC 3629 Section 4.
+			v.lo, v.hi = 0x80, 0xbf
+			switch c := b[i]; {
+			case c >= 0xc2 && c <= 0xdf:
+				v.need = 1
+			case c == 0xe0:
+				v.need, v.lo = 2, 0xa0
+			case c == 0xed:
+				v.need, v.hi = 2, 0x9f
+			case c >= 0xe1 && c <= 0xef:
+				v.need = 2
+			case c == 0xf0:
+				v.need, v.lo = 3, 0x90
+			case c >= 0xf1 && c <= 0xf3:
+				v.need = 3
+			case c == 0xf4:
+				v.need, v.hi = 3, 0x8f
+			default:
+				return false
+			}
+			i++
+			continue
+		}
+
+		if c := b[i]; c < v.lo || c > v.hi {
+			return false
+		}
+		v.need--
+		v.lo, v.hi = 0x80, 0xbf
+		i++
+	}
+	return true
+}
+
+// complete returns true if the text written so far does not end in the middle
+// of a sequence.
+func (v *utf8Validator) complete() bool {
+	return v.need == 0
+}
+
+func (v *utf8Validator) reset() {
+	v.need = 0
+}
+
+// SetUTF8Validation enables or disables the validation of the text messages
+// and close reasons the stream reads. It is enabled by default: an invalid
+// text message fails the read with ErrInvalidUTF8 and starts the closing
+// handshake with CloseBadPayload, as soon as the frame holding the invalid
+// sequence is read.
+//
+// Disabling it saves the validation cost on every text message, which is
+// only safe with trusted peers.
+func (s *WebsocketStream) SetUTF8Validation(enabled bool) {
+	s.skipUTF8 = !enabled
+}
+
+// validateFrame validates the payload of the data frame f if it is part of a
+// text message. Compressed messages are validated once inflated instead, see
+// validateInflated.
+func (s *WebsocketStream) validateFrame(f *Frame) error {
+	if !f.IsContinuation() {
+		s.utf8Text = f.IsText() && !s.skipUTF8
+		s.utf8Inflated = f.IsRSV1()
+		s.utf8.reset()
+	}
+	if !s.utf8Text || s.utf8Inflated {
+		return nil
+	}
+
+	if !s.utf8.write(f.Payload()) || (f.IsFin() && !s.utf8.complete()) {
+		return ErrInvalidUTF8
+	}
+	return nil
+}
+
+// validateInflated validates b, the inflated payload of a compressed message,
+// if it is a text message.
+func (s *WebsocketStream) validateInflated(b []byte) error {
+	if s.utf8Text && !utf8.Valid(b) {
+		return ErrInvalidUTF8
+	}
+	return nil
+}
+
+// validateCloseReason validates the reason of the close frame payload b.
+func (s *WebsocketStream) validateCloseReason(b []byte) error {
+	if !s.skipUTF8 && len(b) > 2 && !utf8.Valid(b[2:]) {
+		return ErrInvalidUTF8
+	}
+	return nil
+}
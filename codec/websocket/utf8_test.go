@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/talostrading/sonic"
+)
+
+func TestUTF8Validator(t *testing.T) {
+	cases := [][]byte{
+		[]byte("hello, websocket"),
+		[]byte("κόσμε"),
+		[]byte("a long enough ASCII prefix for the fast path: €𝄞"),
+		{0xed, 0x9f, 0xbf},                // U+D7FF
+		{0xee, 0x80, 0x80},                // U+E000
+		{0xf4, 0x8f, 0xbf, 0xbf},          // U+10FFFF
+		{0xc0, 0xaf},                      // overlong
+		{0xe0, 0x80, 0xaf},                // overlong
+		{0xed, 0xa0, 0x80},                // surrogate
+		{0xf4, 0x90, 0x80, 0x80},          // over U+10FFFF
+		{0xf5, 0x80, 0x80, 0x80},          // invalid lead byte
+		{0xce, 0xba, 0xe1, 0xbd},          // truncated
+		{0x80},                            // unexpected continuation
+		[]byte("κόσμε\xed\xa0\x80edited"), // surrogate in the middle
+	}
+
+	for _, b := range cases {
+		want := utf8.Valid(b)
+
+		// Validate b split at every position.
+		for i := 0; i <= len(b); i++ {
+			var v utf8Validator
+			valid := v.write(b[:i]) && v.write(b[i:]) && v.complete()
+			if valid != want {
+				t.Fatalf("%x split at %d: expected valid=%v", b, i, want)
+			}
+		}
+	}
+
+	// Invalid sequences are detected at their first invalid byte.
+	for _, b := range [][]byte{
+		{0xed, 0xa0},
+		{0xf4, 0x90},
+		{0xe0, 0x9f},
+		{0xf5},
+	} {
+		var v utf8Validator
+		if v.write(b) {
+			t.Fatalf("%x: expected to fail fast", b)
+		}
+	}
+}
+
+func TestUTF8ValidationStream(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	// The euro sign split across two fragments is valid.
+	ws := newActiveClient(t, ioc)
+	if !ws.SupportsUTF8() {
+		t.Fatal("UTF-8 validation should be enabled by default")
+	}
+	ws.src.Write([]byte{byte(OpcodeText), 2, 'a', 0xe2})
+	ws.src.Write([]byte{1 << 7, 2, 0x82, 0xac})
+	b := make([]byte, 16)
+	if _, n, err := ws.NextMessage(b); err != nil || string(b[:n]) != "a€" {
+		t.Fatalf("unexpected message %q err=%v", b[:n], err)
+	}
+
+	// An invalid first fragment fails the read before the second is read.
+	ws.src.Write([]byte{byte(OpcodeText), 2, 'a', 0xc0})
+	if _, _, err := ws.NextMessage(b); err != ErrInvalidUTF8 {
+		t.Fatalf("expected ErrInvalidUTF8, got %v", err)
+	}
+	assertState(t, ws, StateClosedByUs)
+	assertCloseCode(t, ws, CloseBadPayload)
+
+	// Binary messages are not validated, and neither are text messages once
+	// validation is disabled.
+	for _, opcode := range []Opcode{OpcodeText, OpcodeBinary} {
+		ws = newActiveClient(t, ioc)
+		ws.SetUTF8Validation(opcode == OpcodeBinary)
+		ws.src.Write([]byte{byte(opcode) | 1<<7, 2, 0xed, 0xa0})
+		if _, _, err := ws.NextMessage(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An invalid close reason is answered with CloseBadPayload.
+	ws = newActiveClient(t, ioc)
+	ws.src.Write([]byte{byte(OpcodeClose) | 1<<7, 4, 0x03, 0xe8, 0xed, 0xa0})
+	if _, err := ws.NextFrame(); err != ErrInvalidUTF8 {
+		t.Fatalf("expected ErrInvalidUTF8, got %v", err)
+	}
+	assertState(t, ws, StateClosedByPeer)
+	assertCloseCode(t, ws, CloseBadPayload)
+}
+
+// assertCloseCode asserts that the last frame pending on ws is a close frame
+// with the code cc.
+func assertCloseCode(t *testing.T, ws *WebsocketStream, cc CloseCode) {
+	if len(ws.pending) == 0 {
+		t.Fatal("no pending close frame")
+	}
+	f := ws.pending[len(ws.pending)-1]
+	if f.IsMasked() {
+		f.Unmask()
+	}
+	if !f.IsClose() {
+		t.Fatal("last pending frame is not a close frame")
+	}
+	if given, _ := DecodeCloseFramePayload(f.Payload()); given != cc {
+		t.Fatalf("expected close code %d, got %d", cc, given)
+	}
+}
@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// SetFragmentSize makes the stream write messages in frames of at most bytes
+// of payload: larger messages are sent as a first frame followed by
+// continuation frames. Zero, the default, writes each message in one frame,
+// or each write of a MessageWriter in one frame.
+//
+// Pings and pongs are sent between the fragments of a message.
+func (s *WebsocketStream) SetFragmentSize(bytes int) {
+	s.fragmentSize = bytes
+}
+
+// queueFrames queues the frames carrying payload: it is split into frames of
+// at most the fragment size, the first of which has the given opcode, and the
+// last of which is final if fin is set. The RSV1 bit of the first frame is set
+// if compressed is set.
+func (s *WebsocketStream) queueFrames(
+	payload []byte,
+	opcode Opcode,
+	compressed, fin bool,
+) {
+	for {
+		n := len(payload)
+		if s.fragmentSize > 0 && n > s.fragmentSize {
+			n = s.fragmentSize
+		}
+
+		f := AcquireFrame()
+		f.SetOpcode(opcode)
+		if compressed {
+			f.SetRSV1()
+		}
+		if fin && n == len(payload) {
+			f.SetFin()
+		}
+		f.SetPayload(payload[:n])
+		s.prepareWrite(f)
+
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return
+		}
+		opcode, compressed = OpcodeContinuation, false
+	}
+}
+
+// MessageWriter writes a message as it becomes available: each write sends the
+// written bytes right away in one or more frames, and Close sends the final
+// frame. Messages written through a MessageWriter are not compressed.
+//
+// MessageWriters are returned by NextWriter.
+type MessageWriter struct {
+	s      *WebsocketStream
+	opcode Opcode // of the next frame
+	n      int    // number of bytes written so far
+}
+
+// NextWriter returns a writer for the next message, of type mt, which must be
+// TypeText or TypeBinary. No other message can be written until the writer is
+// closed.
+func (s *WebsocketStream) NextWriter(mt MessageType) (*MessageWriter, error) {
+	if mt != TypeText && mt != TypeBinary {
+		return nil, ErrInvalidMessageType
+	}
+	if s.writer != nil {
+		return nil, ErrMessageInProgress
+	}
+	if s.state != StateActive {
+		return nil, sonicerrors.ErrCancelled
+	}
+
+	s.writer = &MessageWriter{s: s, opcode: Opcode(mt)}
+	return s.writer, nil
+}
+
+// Write writes b as the next part of the message, blocking until it is sent.
+func (w *MessageWriter) Write(b []byte) (n int, err error) {
+	if err := w.prepare(b, false); err != nil {
+		return 0, err
+	}
+	return len(b), w.s.Flush()
+}
+
+// AsyncWrite writes b as the next part of the message asynchronously.
+//
+// b can be reused once the call returns.
+func (w *MessageWriter) AsyncWrite(b []byte, cb func(err error)) {
+	if err := w.prepare(b, false); err != nil {
+		cb(err)
+	} else {
+		w.s.AsyncFlush(cb)
+	}
+}
+
+// Close ends the message by sending its final frame, blocking until it is
+// sent.
+func (w *MessageWriter) Close() error {
+	if err := w.prepare(nil, true); err != nil {
+		return err
+	}
+	return w.s.Flush()
+}
+
+// AsyncClose ends the message by sending its final frame asynchronously.
+func (w *MessageWriter) AsyncClose(cb func(err error)) {
+	if err := w.prepare(nil, true); err != nil {
+		cb(err)
+	} else {
+		w.s.AsyncFlush(cb)
+	}
+}
+
+// prepare queues the frames carrying b, the last of which ends the message if
+// fin is set.
+func (w *MessageWriter) prepare(b []byte, fin bool) error {
+	if w.s.writer != w {
+		return ErrWriterClosed
+	}
+	if w.s.state != StateActive {
+		return sonicerrors.ErrCancelled
+	}
+	if w.n+len(b) > w.s.maxMessageSize || !w.s.fitsWriteBuffer(len(b)) {
+		return ErrMessageTooBig
+	}
+
+	if len(b) == 0 && !fin {
+		return nil
+	}
+
+	w.s.queueFrames(b, w.opcode, false, fin)
+	w.n += len(b)
+	w.opcode = OpcodeContinuation
+	if fin {
+		w.s.writer = nil
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// decodeFrames decodes the frames written to stream.
+func decodeFrames(t *testing.T, stream *MockStream) (frames []*Frame) {
+	codec := NewFrameCodec(stream.b, nil)
+	for {
+		f, err := codec.Decode(stream.b)
+		if errors.Is(err, sonicerrors.ErrNeedMore) {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewFrame()
+		copy(c.header, f.header)
+		c.SetPayload(f.Payload())
+		frames = append(frames, c)
+	}
+}
+
+func TestWriteFragmented(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	s, err := NewWebsocketStream(ioc, nil, RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewMockStream()
+	s.state = StateActive
+	if err := s.init(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetFragmentSize(4)
+	if err := s.Write([]byte("hello world"), TypeText); err != nil {
+		t.Fatal(err)
+	}
+
+	type expected struct {
+		opcode  Opcode
+		fin     bool
+		payload string
+	}
+	check := func(frames []*Frame, want []expected) {
+		if len(frames) != len(want) {
+			t.Fatalf("expected %d frames, got %d", len(want), len(frames))
+		}
+		for i, f := range frames {
+			if f.Opcode() != want[i].opcode || f.IsFin() != want[i].fin ||
+				string(f.Payload()) != want[i].payload {
+				t.Fatalf("unexpected frame %d: %s", i, f)
+			}
+		}
+	}
+	check(decodeFrames(t, stream), []expected{
+		{OpcodeText, false, "hell"},
+		{OpcodeContinuation, false, "o wo"},
+		{OpcodeContinuation, true, "rld"},
+	})
+
+	// A pong goes ahead of the fragments which are not sent yet.
+	s.queueFrames([]byte("abcdef"), OpcodeBinary, false, true)
+	ping := AcquireFrame()
+	defer ReleaseFrame(ping)
+	ping.SetFin()
+	ping.SetPing()
+	ping.SetPayload([]byte("ping"))
+	if err := s.handleControlFrame(ping); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	check(decodeFrames(t, stream), []expected{
+		{OpcodePong, true, "ping"},
+		{OpcodeBinary, false, "abcd"},
+		{OpcodeContinuation, true, "ef"},
+	})
+}
+
+func TestMessageWriter(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	server, client := connect(t, ioc, 8135, func(_, _ *WebsocketStream) {})
+	defer client.CloseNextLayer()
+	defer server.CloseNextLayer()
+
+	w, err := client.NextWriter(TypeText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NextWriter(TypeText); err != ErrMessageInProgress {
+		t.Fatalf("expected ErrMessageInProgress, got %v", err)
+	}
+	if err := client.Write([]byte("x"), TypeText); err != ErrMessageInProgress {
+		t.Fatalf("expected ErrMessageInProgress, got %v", err)
+	}
+
+	if _, err := w.Write([]byte("hello ")); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done bool
+		msg  []byte
+		mt   MessageType
+		errs []error
+		b    = make([]byte, 128)
+	)
+	server.SetControlCallback(func(MessageType, []byte) {})
+	server.AsyncNextMessage(b, func(err error, n int, t MessageType) {
+		done, msg, mt = true, b[:n], t
+		if err != nil {
+			errs = append(errs, err)
+		}
+	})
+
+	// The server pings while the message is being written.
+	ping := AcquireFrame()
+	ping.SetFin()
+	ping.SetPing()
+	server.AsyncWriteFrame(ping, func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	})
+	client.AsyncNextFrame(func(err error, f *Frame) {
+		if err == nil && !f.IsPing() {
+			err = errors.New("expected a ping")
+		}
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		w.AsyncWrite([]byte("world"), func(err error) {
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			w.AsyncClose(func(err error) {
+				if err != nil {
+					errs = append(errs, err)
+				}
+			})
+		})
+	})
+
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if mt != TypeText || string(msg) != "hello world" {
+		t.Fatalf("expected hello world, got %q", msg)
+	}
+
+	if _, err := w.Write([]byte("late")); err != ErrWriterClosed {
+		t.Fatalf("expected ErrWriterClosed, got %v", err)
+	}
+	if err := client.Write([]byte("next"), TypeText); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,7 +1,6 @@
 package sonic
 
 import (
-	"fmt"
 	"net"
 	"time"
 
@@ -30,6 +29,10 @@ func Dial(
 	return DialTimeout(ioc, network, addr, 10*time.Second, opts...)
 }
 
+// DialTimeout is like Dial, but gives up once the timeout passes.
+//
+// For TCP, sonicopts.HappyEyeballs makes it race the IPv6 and IPv4 addresses of the host. RemoteAddr then reports the
+// address which won.
 func DialTimeout(
 	ioc *IO, network, addr string,
 	timeout time.Duration,
@@ -43,13 +46,90 @@ func DialTimeout(
 	return newConn(ioc, fd, localAddr, remoteAddr), nil
 }
 
+// AsyncDial establishes a stream based connection to the specified address asynchronously, like Dial, but without
+// blocking the event loop while the connection is established. It gives up after 10 seconds, see AsyncDialTimeout.
+//
+// The host of addr must be an IP address, since resolving a name would block the event loop. AsyncDial fails with
+// sonicerrors.ErrHostNotIP otherwise. Names can be resolved asynchronously with dns.Resolver beforehand.
+//
+// The connection is always nonblocking, sonicopts.Nonblocking is ignored.
+func AsyncDial(
+	ioc *IO,
+	network, addr string,
+	cb DialCallback,
+	opts ...sonicopts.Option,
+) {
+	AsyncDialTimeout(ioc, network, addr, 10*time.Second, cb, opts...)
+}
+
+// AsyncDialTimeout is like AsyncDial, but the dial completes with sonicerrors.ErrTimeout if the connection is not
+// established within the timeout.
+//
+// cb is called inline if the connection is established, or fails, right away.
+func AsyncDialTimeout(
+	ioc *IO,
+	network, addr string,
+	timeout time.Duration,
+	cb DialCallback,
+	opts ...sonicopts.Option,
+) {
+	fd, remoteAddr, inProgress, err := internal.ConnectAsync(network, addr, opts...)
+	if err != nil {
+		cb(err, nil)
+		return
+	}
+
+	c := newConn(ioc, fd, nil, remoteAddr)
+	if !inProgress {
+		c.connected(nil, cb)
+		return
+	}
+
+	// The connection is established once the socket becomes writable. The pending write is the connect, so it times out
+	// like one.
+	c.writeDeadline.op = time.Now().Add(timeout)
+	c.slot.Set(internal.WriteEvent, func(err error) {
+		ioc.Deregister(&c.slot)
+		c.writeDeadline.disarm()
+		c.writeDeadline.op = time.Time{}
+
+		if err == nil {
+			err = internal.SocketError(fd)
+		}
+		c.connected(err, cb)
+	})
+
+	if err := ioc.SetWrite(&c.slot); err != nil {
+		c.writeDeadline.op = time.Time{}
+		c.connected(err, cb)
+		return
+	}
+	ioc.Register(&c.slot)
+	if err := c.writeDeadline.arm(ioc, c.expireWrites); err != nil {
+		c.abortWrites(err)
+	}
+}
+
+// connected completes an asynchronous dial. It closes the connection if err is not nil.
+func (c *conn) connected(err error, cb DialCallback) {
+	if err == nil {
+		c.localAddr, err = internal.SocketAddress(c.fd)
+	}
+	if err != nil {
+		_ = c.Close()
+		cb(err, nil)
+		return
+	}
+	cb(nil, c)
+}
+
 func newConn(
 	ioc *IO,
 	fd int,
 	localAddr, remoteAddr net.Addr,
 ) *conn {
 	return &conn{
-		file:       &file{ioc: ioc, slot: internal.Slot{Fd: fd}},
+		file:       &file{ioc: ioc, slot: internal.Slot{Fd: fd, Persistent: true}},
 		fd:         fd,
 		localAddr:  localAddr,
 		remoteAddr: remoteAddr,
@@ -63,14 +143,26 @@ func (c *conn) RemoteAddr() net.Addr {
 	return c.remoteAddr
 }
 
+// SetDeadline sets both the read and write deadlines, see SetReadDeadline and SetWriteDeadline.
 func (c *conn) SetDeadline(t time.Time) error {
-	return fmt.Errorf("not supported")
+	if err := c.setReadDeadline(t); err != nil {
+		return err
+	}
+	return c.setWriteDeadline(t)
 }
+
+// SetReadDeadline sets the deadline of reads. A zero value disables it.
+//
+// Once the deadline passes, the pending AsyncRead, if any, completes with sonicerrors.ErrTimeout, and so do new reads,
+// both synchronous and asynchronous, until the deadline is extended. On a blocking connection, Read waits for data
+// at most until the deadline. The new deadline applies to a pending AsyncRead as well.
 func (c *conn) SetReadDeadline(t time.Time) error {
-	return fmt.Errorf("not supported")
+	return c.setReadDeadline(t)
 }
+
+// SetWriteDeadline sets the deadline of writes. A zero value disables it. It behaves like SetReadDeadline, for writes.
 func (c *conn) SetWriteDeadline(t time.Time) error {
-	return fmt.Errorf("not supported")
+	return c.setWriteDeadline(t)
 }
 
 func (c *conn) RawFd() int {
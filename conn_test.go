@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/talostrading/sonic/sonicerrors"
 	"github.com/talostrading/sonic/sonicopts"
 )
 
@@ -205,7 +206,7 @@ func TestConnAsyncTCPEchoClient(t *testing.T) {
 	var onAsyncRead AsyncCallback
 	onAsyncRead = func(err error, n int) {
 		if err != nil {
-			if err != io.EOF {
+			if !errors.Is(err, io.EOF) {
 				t.Fatal(err)
 			}
 		} else {
@@ -274,7 +275,7 @@ func TestConnReadHandlesError(t *testing.T) {
 	var onAsyncRead AsyncCallback
 	onAsyncRead = func(err error, n int) {
 		if err != nil {
-			if err != io.EOF {
+			if !errors.Is(err, io.EOF) {
 				t.Fatal(err)
 			} else {
 				done = true
@@ -349,3 +350,761 @@ func TestConnWriteHandlesError(t *testing.T) {
 		t.Fatal("test did not run to completion")
 	}
 }
+
+func TestConnAsyncReadPeerReset(t *testing.T) {
+	marker := make(chan struct{}, 1)
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:8089")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		marker <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+
+		<-marker
+
+		// Closing with a zero linger timeout sends a RST instead of a FIN.
+		if err := conn.(*net.TCPConn).SetLinger(0); err != nil {
+			panic(err)
+		}
+		conn.Close()
+	}()
+	<-marker
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8089")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		done    = false
+		readErr error
+	)
+	conn.AsyncRead(make([]byte, 128), func(err error, _ int) {
+		done = true
+		readErr = err
+	})
+	if done {
+		t.Fatal("read should have been scheduled")
+	}
+
+	marker <- struct{}{}
+
+	for !done {
+		_ = ioc.RunOne()
+	}
+
+	if !errors.Is(readErr, sonicerrors.ErrPeerReset) {
+		t.Fatalf("expected peer reset err=%v", readErr)
+	}
+	if !errors.Is(readErr, syscall.ECONNRESET) {
+		t.Fatalf("expected ECONNRESET err=%v", readErr)
+	}
+}
+
+func TestConnAsyncReadWritePeerReset(t *testing.T) {
+	marker := make(chan struct{}, 1)
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:8090")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		marker <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+
+		<-marker
+
+		if err := conn.(*net.TCPConn).SetLinger(0); err != nil {
+			panic(err)
+		}
+		conn.Close()
+	}()
+	<-marker
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8090")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Fill the send buffer such that the write below gets scheduled. The peer never reads.
+	b := make([]byte, 64*1024)
+	for {
+		_, err := conn.Write(b)
+		if err == sonicerrors.ErrWouldBlock {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		readDone, writeDone bool
+		readErr, writeErr   error
+	)
+	conn.AsyncRead(make([]byte, 128), func(err error, _ int) {
+		readDone = true
+		readErr = err
+	})
+	conn.AsyncWriteAll(b, func(err error, _ int) {
+		writeDone = true
+		writeErr = err
+	})
+	if readDone || writeDone {
+		t.Fatal("read and write should have been scheduled")
+	}
+
+	marker <- struct{}{}
+
+	for !readDone || !writeDone {
+		_ = ioc.RunOne()
+	}
+
+	if !errors.Is(readErr, sonicerrors.ErrPeerReset) {
+		t.Fatalf("expected peer reset on read err=%v", readErr)
+	}
+	if !errors.Is(writeErr, sonicerrors.ErrPeerReset) {
+		t.Fatalf("expected peer reset on write err=%v", writeErr)
+	}
+}
+
+func TestConnAsyncReadPeerHalfClosed(t *testing.T) {
+	marker := make(chan struct{}, 1)
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:8091")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		marker <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		<-marker
+
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			panic(err)
+		}
+
+		<-marker
+	}()
+	<-marker
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8091")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		done    = false
+		readErr error
+	)
+	conn.AsyncRead(make([]byte, 128), func(err error, _ int) {
+		done = true
+		readErr = err
+	})
+	if done {
+		t.Fatal("read should have been scheduled")
+	}
+
+	marker <- struct{}{}
+
+	for !done {
+		_ = ioc.RunOne()
+	}
+	marker <- struct{}{}
+
+	if !errors.Is(readErr, sonicerrors.ErrPeerHalfClosed) {
+		t.Fatalf("expected peer half-closed err=%v", readErr)
+	}
+	if !errors.Is(readErr, io.EOF) {
+		t.Fatalf("expected the half-close to be an EOF err=%v", readErr)
+	}
+}
+
+func TestConnAsyncReadDeadline(t *testing.T) {
+	marker := make(chan struct{}, 1)
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:8095")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		marker <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		<-marker
+
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			panic(err)
+		}
+
+		<-marker
+	}()
+	<-marker
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8095")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		done    = false
+		readErr error
+		n       int
+	)
+	b := make([]byte, 128)
+	start := time.Now()
+	conn.AsyncRead(b, func(err error, _ int) {
+		done = true
+		readErr = err
+	})
+	if done {
+		t.Fatal("read should have been scheduled")
+	}
+	for !done {
+		_ = ioc.RunOne()
+	}
+	if !errors.Is(readErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected a timeout err=%v", readErr)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("read timed out before the deadline")
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+
+	// The deadline passed, so reads fail until it is extended.
+	done = false
+	conn.AsyncRead(b, func(err error, _ int) {
+		done = true
+		readErr = err
+	})
+	if !done || !errors.Is(readErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected the read to time out right away err=%v", readErr)
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	done = false
+	conn.AsyncRead(b, func(err error, nn int) {
+		done = true
+		readErr = err
+		n = nn
+	})
+	marker <- struct{}{}
+	for !done {
+		_ = ioc.RunOne()
+	}
+	marker <- struct{}{}
+
+	if readErr != nil || string(b[:n]) != "hello" {
+		t.Fatalf("expected to read hello, read %q err=%v", b[:n], readErr)
+	}
+}
+
+func TestConnReadDeadlineBlocking(t *testing.T) {
+	marker := make(chan struct{}, 1)
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:8096")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		marker <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		<-marker
+
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			panic(err)
+		}
+
+		<-marker
+	}()
+	<-marker
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8096", sonicopts.Nonblocking(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 128)
+	start := time.Now()
+	if _, err := conn.Read(b); !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected a timeout err=%v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("read timed out before the deadline")
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	marker <- struct{}{}
+	n, err := conn.Read(b)
+	marker <- struct{}{}
+	if err != nil || string(b[:n]) != "hello" {
+		t.Fatalf("expected to read hello, read %q err=%v", b[:n], err)
+	}
+}
+
+func TestConnAsyncReadTimeoutKeepsWrites(t *testing.T) {
+	marker := make(chan struct{}, 1)
+	go func() {
+		ln, err := net.Listen("tcp", "localhost:8097")
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+
+		marker <- struct{}{}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		// Do not read until the client's read timed out, such that its write stays pending.
+		<-marker
+
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	<-marker
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8097")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Fill the socket's send buffer such that the next write is pending.
+	b := make([]byte, 64*1024)
+	for {
+		_, err := conn.Write(b)
+		if err == sonicerrors.ErrWouldBlock {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		written  = false
+		writeErr error
+	)
+	conn.AsyncWrite(b, func(err error, _ int) {
+		written = true
+		writeErr = err
+	})
+	if written {
+		t.Fatalf("write should be pending err=%v", writeErr)
+	}
+
+	var (
+		read    = false
+		readErr error
+	)
+	conn.AsyncReadTimeout(make([]byte, 128), 10*time.Millisecond, func(err error, _ int) {
+		read = true
+		readErr = err
+	})
+	for !read {
+		_ = ioc.RunOne()
+	}
+	if !errors.Is(readErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected the read to time out err=%v", readErr)
+	}
+	if written {
+		t.Fatalf("the write should still be pending err=%v", writeErr)
+	}
+
+	marker <- struct{}{}
+	for !written {
+		_ = ioc.RunOne()
+	}
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+}
+
+func TestListenerAsyncAcceptTimeout(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:8098", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		done      = false
+		acceptErr error
+		accepted  Conn
+	)
+	ln.AsyncAcceptTimeout(10*time.Millisecond, func(err error, conn Conn) {
+		done = true
+		acceptErr = err
+	})
+	for !done {
+		_ = ioc.RunOne()
+	}
+	if !errors.Is(acceptErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected the accept to time out err=%v", acceptErr)
+	}
+
+	done = false
+	ln.AsyncAcceptTimeout(5*time.Second, func(err error, conn Conn) {
+		done = true
+		acceptErr = err
+		accepted = conn
+	})
+	client, err := net.Dial("tcp", "localhost:8098")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !done {
+		_ = ioc.RunOne()
+	}
+	if acceptErr != nil {
+		t.Fatal(acceptErr)
+	}
+
+	// Close the client first such that the listening port does not end up in TIME_WAIT.
+	client.Close()
+	accepted.Close()
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
+
+func TestAsyncDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:8099")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		done    = false
+		dialErr error
+		conn    Conn
+	)
+	AsyncDial(ioc, "tcp", "127.0.0.1:8099", func(err error, c Conn) {
+		done = true
+		dialErr = err
+		conn = c
+	})
+	for !done {
+		_ = ioc.RunOne()
+	}
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer conn.Close()
+
+	if conn.LocalAddr() == nil || conn.RemoteAddr().String() != "127.0.0.1:8099" {
+		t.Fatalf("wrong addresses local=%v remote=%v", conn.LocalAddr(), conn.RemoteAddr())
+	}
+
+	done = false
+	b := []byte("hello")
+	conn.AsyncWriteAll(b, func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AsyncReadAll(b, func(err error, n int) {
+			done = true
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+	for !done {
+		_ = ioc.RunOne()
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected the echo of hello, got %s", b)
+	}
+}
+
+func TestAsyncDialRefused(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		done    = false
+		dialErr error
+	)
+	AsyncDial(ioc, "tcp", "127.0.0.1:8100", func(err error, conn Conn) {
+		done = true
+		dialErr = err
+		if conn != nil {
+			t.Fatal("expected no connection")
+		}
+	})
+	for !done {
+		_ = ioc.RunOne()
+	}
+	if !errors.Is(dialErr, syscall.ECONNREFUSED) {
+		t.Fatalf("expected the connection to be refused err=%v", dialErr)
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
+
+func TestDialNonblockingRefused(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "127.0.0.1:8100", sonicopts.Nonblocking(true))
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected the connection to be refused err=%v", err)
+	}
+	if conn != nil {
+		t.Fatal("expected no connection")
+	}
+}
+
+func TestAsyncDialHostNotIP(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	called := false
+	AsyncDial(ioc, "tcp", "localhost:8102", func(err error, conn Conn) {
+		called = true
+		if !errors.Is(err, sonicerrors.ErrHostNotIP) {
+			t.Fatalf("expected the host to be rejected err=%v", err)
+		}
+		if conn != nil {
+			t.Fatal("expected no connection")
+		}
+	})
+	if !called {
+		t.Fatal("expected the callback to be called inline")
+	}
+}
+
+func TestAsyncDialTimeout(t *testing.T) {
+	// A listener which never accepts, with a backlog of 0, takes in at most one connection. The handshake of the next
+	// one never completes.
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Port: 8101, Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	var (
+		done    = false
+		dialErr error
+		conns   []Conn
+	)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < 8 && !errors.Is(dialErr, sonicerrors.ErrTimeout); i++ {
+		done = false
+		AsyncDialTimeout(ioc, "tcp", "127.0.0.1:8101", 100*time.Millisecond, func(err error, conn Conn) {
+			done = true
+			dialErr = err
+			if conn != nil {
+				conns = append(conns, conn)
+			}
+		})
+		for !done {
+			_ = ioc.RunOne()
+		}
+		if dialErr != nil && !errors.Is(dialErr, sonicerrors.ErrTimeout) {
+			t.Fatal(dialErr)
+		}
+	}
+	if !errors.Is(dialErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected the dial to time out err=%v", dialErr)
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
+
+func TestDialIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:8106")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := Dial(ioc, "tcp", "[::1]:8106")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.RemoteAddr().String() != "[::1]:8106" {
+		t.Fatalf("wrong remote address %s", conn.RemoteAddr())
+	}
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); !ok || !local.IP.Equal(net.IPv6loopback) {
+		t.Fatalf("wrong local address %s", conn.LocalAddr())
+	}
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:8107")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	conn, err := DialTimeout(
+		ioc, "tcp", "localhost:8107", time.Second,
+		sonicopts.HappyEyeballs(250*time.Millisecond), sonicopts.NoDelay(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("expected to connect to %s, got %s", ln.Addr(), conn.RemoteAddr())
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:8137")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ioc := MustIO()
+	defer ioc.Close()
+
+	source := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	conn, err := Dial(
+		ioc, "tcp", "127.0.0.1:8137",
+		sonicopts.BindSocket(source),
+		sonicopts.SendBuffer(64*1024),
+		sonicopts.RecvBuffer(64*1024),
+		sonicopts.NoDelay(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); !ok || !local.IP.Equal(source.IP) {
+		t.Fatalf("wrong local address %s", conn.LocalAddr())
+	}
+	for _, opt := range []int{syscall.SO_SNDBUF, syscall.SO_RCVBUF} {
+		// The kernel may round the size up, Linux doubles it.
+		size, err := syscall.GetsockoptInt(conn.RawFd(), syscall.SOL_SOCKET, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size < 64*1024 {
+			t.Fatalf("expected a buffer of at least 64KiB, got %d", size)
+		}
+	}
+	noDelay, err := syscall.GetsockoptInt(conn.RawFd(), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if noDelay == 0 {
+		t.Fatal("expected TCP_NODELAY to be set after binding the socket")
+	}
+}
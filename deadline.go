@@ -0,0 +1,169 @@
+package sonic
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// deadline is the deadline of one direction, read or write, of a file. The pending asynchronous operation in that
+// direction, if any, fails with sonicerrors.ErrTimeout once the deadline passes.
+type deadline struct {
+	// at is the deadline of all operations, see conn.SetReadDeadline and conn.SetWriteDeadline.
+	at time.Time
+
+	// op is the deadline of the current operation only, see AsyncReadTimeout and AsyncWriteTimeout.
+	op time.Time
+
+	// blocking is true if the file was blocking when the deadline was set, in which case synchronous operations wait
+	// for it with poll.
+	blocking bool
+
+	// timer is created when an asynchronous operation first becomes pending with a deadline set. It is only scheduled
+	// while an operation is pending.
+	timer *Timer
+}
+
+func (d *deadline) set() bool {
+	return !d.at.IsZero()
+}
+
+func (d *deadline) expired() bool {
+	return d.set() && !time.Now().Before(d.at)
+}
+
+// due returns the earliest of at and op, or zero if neither is set.
+func (d *deadline) due() time.Time {
+	if d.op.IsZero() || (d.set() && d.at.Before(d.op)) {
+		return d.at
+	}
+	return d.op
+}
+
+// arm schedules expire to run once the pending operation is due, if it has a deadline. expire gets the error of the
+// timer, see deadlineError.
+func (d *deadline) arm(ioc *IO, expire func(err error)) error {
+	due := d.due()
+	if due.IsZero() {
+		d.disarm()
+		return nil
+	}
+
+	if d.timer == nil {
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			return err
+		}
+		d.timer = timer
+	} else if err := d.timer.Cancel(); err != nil {
+		return err
+	}
+	return d.timer.ScheduleAt(due, expire)
+}
+
+// disarm is called once the pending operation completes.
+func (d *deadline) disarm() {
+	if d.timer != nil && d.timer.Scheduled() {
+		_ = d.timer.Cancel()
+	}
+}
+
+func (d *deadline) close() {
+	if d.timer != nil {
+		_ = d.timer.Close()
+	}
+}
+
+func (d *deadline) update(fd int, t time.Time) error {
+	d.at = t
+	if !d.set() {
+		return nil
+	}
+
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	if err != nil {
+		return os.NewSyscallError("fcntl", err)
+	}
+	d.blocking = flags&unix.O_NONBLOCK != unix.O_NONBLOCK
+	return nil
+}
+
+func (f *file) setReadDeadline(t time.Time) error {
+	if err := f.readDeadline.update(f.slot.Fd, t); err != nil {
+		return err
+	}
+	if f.slot.Events&internal.PollerReadEvent != internal.PollerReadEvent {
+		return nil
+	}
+
+	// Apply the new deadline to the pending read.
+	return f.readDeadline.arm(f.ioc, f.expireReads)
+}
+
+func (f *file) setWriteDeadline(t time.Time) error {
+	if err := f.writeDeadline.update(f.slot.Fd, t); err != nil {
+		return err
+	}
+	if f.slot.Events&internal.PollerWriteEvent != internal.PollerWriteEvent {
+		return nil
+	}
+
+	// Apply the new deadline to the pending write.
+	return f.writeDeadline.arm(f.ioc, f.expireWrites)
+}
+
+// deadlineError returns the error of an operation whose deadline timer completed with err: sonicerrors.ErrTimeout if
+// the deadline passed, or err if the timer did not expire.
+func deadlineError(err error) error {
+	if err == nil {
+		return sonicerrors.ErrTimeout
+	}
+	return err
+}
+
+func (f *file) expireReads(err error) {
+	f.abortReads(deadlineError(err))
+}
+
+func (f *file) expireWrites(err error) {
+	f.abortWrites(deadlineError(err))
+}
+
+// wait waits until the file is ready for events or the deadline passes. Only blocking files wait: a read or write on
+// a nonblocking file returns sonicerrors.ErrWouldBlock instead.
+func (f *file) wait(events int16, d *deadline) error {
+	if !d.set() {
+		return nil
+	}
+	if d.expired() {
+		return sonicerrors.ErrTimeout
+	}
+	if !d.blocking {
+		return nil
+	}
+
+	fds := []unix.PollFd{{Fd: int32(f.slot.Fd), Events: events}}
+	for {
+		timeout := time.Until(d.at)
+		if timeout <= 0 {
+			return sonicerrors.ErrTimeout
+		}
+
+		// Round up such that poll does not return before the deadline.
+		n, err := unix.Poll(fds, int((timeout+time.Millisecond-1)/time.Millisecond))
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return os.NewSyscallError("poll", err)
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+}
@@ -3,6 +3,7 @@ package sonic
 import (
 	"io"
 	"net"
+	"time"
 )
 
 const (
@@ -17,6 +18,7 @@ const (
 
 type AsyncCallback func(error, int)
 type AcceptCallback func(error, Conn)
+type DialCallback func(error, Conn)
 type AcceptPacketCallback func(error, PacketConn)
 
 // AsyncReader is the interface that wraps the AsyncRead and AsyncReadAll methods.
@@ -76,12 +78,24 @@ type AsyncWriterTo interface {
 	AsyncWriteTo(AsyncWriter, AsyncCallback)
 }
 
+// AsyncTimeoutReadWriter schedules asynchronous reads and writes which time out individually.
+type AsyncTimeoutReadWriter interface {
+	// AsyncReadTimeout is like AsyncRead, but the read completes with sonicerrors.ErrTimeout if no data is read within
+	// the timeout. Pending writes are not affected.
+	AsyncReadTimeout(b []byte, timeout time.Duration, cb AsyncCallback)
+
+	// AsyncWriteTimeout is like AsyncWrite, but the write completes with sonicerrors.ErrTimeout if no data is written
+	// within the timeout. Pending reads are not affected.
+	AsyncWriteTimeout(b []byte, timeout time.Duration, cb AsyncCallback)
+}
+
 type FileDescriptor interface {
 	RawFd() int
 
 	io.Closer
 	io.ReadWriter
 	AsyncReadWriter
+	AsyncTimeoutReadWriter
 	AsyncCanceller
 }
 
@@ -172,6 +186,10 @@ type Listener interface {
 	// AsyncAccept waits for and returns the next connection to the listener asynchronously.
 	AsyncAccept(AcceptCallback)
 
+	// AsyncAcceptTimeout is like AsyncAccept, but completes with sonicerrors.ErrTimeout if no connection is accepted
+	// within the timeout.
+	AsyncAcceptTimeout(time.Duration, AcceptCallback)
+
 	// Close closes the listener.
 	Close() error
 
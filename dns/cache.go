@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"time"
+)
+
+type cacheKey struct {
+	name string
+	t    Type
+}
+
+type cacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// cache holds answers until their TTL expires. Expired entries are evicted when looked up, or when the cache grows
+// past its capacity.
+type cache struct {
+	entries  map[cacheKey]cacheEntry
+	capacity int
+}
+
+func newCache(capacity int) *cache {
+	return &cache{
+		entries:  make(map[cacheKey]cacheEntry),
+		capacity: capacity,
+	}
+}
+
+// get returns a copy of the cached answer, such that callers can modify it.
+func (c *cache) get(name string, t Type, now time.Time) ([]net.IP, bool) {
+	key := cacheKey{name, t}
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return copyIPs(e.ips), true
+}
+
+// put caches a copy of ips for ttl.
+func (c *cache) put(name string, t Type, ips []net.IP, ttl time.Duration, now time.Time) {
+	if ttl <= 0 || c.capacity <= 0 {
+		return
+	}
+
+	if len(c.entries) >= c.capacity {
+		c.evict(now)
+	}
+	if len(c.entries) >= c.capacity {
+		// Still full of live entries, drop an arbitrary one.
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[cacheKey{name, t}] = cacheEntry{ips: copyIPs(ips), expires: now.Add(ttl)}
+}
+
+func (c *cache) evict(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func copyIPs(ips []net.IP) []net.IP {
+	cp := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		cp[i] = append(net.IP(nil), ip...)
+	}
+	return cp
+}
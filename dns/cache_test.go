@@ -0,0 +1,30 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCacheCopies(t *testing.T) {
+	var (
+		c   = newCache(DefaultCacheSize)
+		now = time.Now()
+		ips = []net.IP{net.ParseIP("10.0.0.1").To4()}
+	)
+	c.put("example.com", TypeA, ips, time.Minute, now)
+
+	// Neither the answer that was put nor the one that was got are shared with the cache.
+	ips[0][3] = 2
+	got, ok := c.get("example.com", TypeA, now)
+	if !ok || !got[0].Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("expected 10.0.0.1, got %v", got)
+	}
+	got[0][3] = 3
+	got[0] = net.IPv4(10, 0, 0, 4)
+
+	got, ok = c.get("example.com", TypeA, now)
+	if !ok || len(got) != 1 || !got[0].Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("expected 10.0.0.1, got %v", got)
+	}
+}
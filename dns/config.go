@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultResolvConf = "/etc/resolv.conf"
+	DefaultHosts      = "/etc/hosts"
+
+	DefaultTimeout  = 5 * time.Second
+	DefaultAttempts = 2
+)
+
+// Config configures a Resolver. It mirrors the subset of resolv.conf(5) a stub resolver needs.
+type Config struct {
+	// Nameservers are the addresses, as ip:port, of the nameservers. They are queried in order.
+	Nameservers []string
+
+	// Timeout is how long to wait for the answer of a nameserver before querying the next one.
+	Timeout time.Duration
+
+	// Attempts is the number of times each nameserver is queried before giving up.
+	Attempts int
+
+	// Hosts is the path of the hosts file consulted before querying the nameservers. Empty disables it.
+	Hosts string
+}
+
+// DefaultConfig returns the configuration of the system, as given by /etc/resolv.conf and /etc/hosts.
+func DefaultConfig() (*Config, error) {
+	return ReadConfig(DefaultResolvConf)
+}
+
+// ReadConfig reads the configuration from the resolv.conf(5) file at path. The search and domain directives are not
+// supported: names are always queried as fully qualified.
+func ReadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseConfig(f)
+}
+
+func parseConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{
+		Timeout:  DefaultTimeout,
+		Attempts: DefaultAttempts,
+		Hosts:    DefaultHosts,
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			// IPv6 nameservers are skipped as queries are sent from IPv4 sockets.
+			if ip := net.ParseIP(fields[1]).To4(); ip != nil {
+				cfg.Nameservers = append(cfg.Nameservers, net.JoinHostPort(ip.String(), "53"))
+			}
+		case "options":
+			for _, opt := range fields[1:] {
+				key, value, _ := strings.Cut(opt, ":")
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					continue
+				}
+				switch key {
+				case "timeout":
+					cfg.Timeout = time.Duration(n) * time.Second
+				case "attempts":
+					cfg.Attempts = n
+				}
+			}
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// hosts maps lowercased, rooted names to their addresses, as listed in a hosts(5) file.
+type hosts map[string][]net.IP
+
+func readHosts(path string) (hosts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseHosts(f)
+}
+
+func parseHosts(r io.Reader) (hosts, error) {
+	h := make(hosts)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) < 2 {
+			continue
+		}
+
+		// Zones, as in fe80::1%lo0, are not supported.
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = fqdn(name)
+			h[name] = append(h[name], ip)
+		}
+	}
+	return h, scanner.Err()
+}
+
+// lookup returns the addresses of name of type t.
+func (h hosts) lookup(name string, t Type) (ips []net.IP) {
+	for _, ip := range h[name] {
+		if (t == TypeA) == (ip.To4() != nil) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func stripComment(line string) string {
+	if i := strings.IndexAny(line, "#;"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(`
+# comment
+nameserver 10.0.0.1
+nameserver 10.0.0.2 ; trailing comment
+nameserver ::1
+nameserver not-an-ip
+search example.com
+options ndots:2 timeout:3 attempts:4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Nameservers) != 2 || cfg.Nameservers[0] != "10.0.0.1:53" || cfg.Nameservers[1] != "10.0.0.2:53" {
+		t.Fatalf("wrong nameservers %v", cfg.Nameservers)
+	}
+	if cfg.Timeout != 3*time.Second {
+		t.Fatalf("wrong timeout %s", cfg.Timeout)
+	}
+	if cfg.Attempts != 4 {
+		t.Fatalf("wrong attempts %d", cfg.Attempts)
+	}
+	if cfg.Hosts != DefaultHosts {
+		t.Fatalf("wrong hosts %s", cfg.Hosts)
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	h, err := parseHosts(strings.NewReader(`
+127.0.0.1 localhost Local.Example # comment
+::1 localhost
+10.0.0.1 other
+bogus line
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ips := h.lookup("localhost.", TypeA)
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("wrong A addresses %v", ips)
+	}
+	ips = h.lookup("localhost.", TypeAAAA)
+	if len(ips) != 1 || !ips[0].Equal(net.IPv6loopback) {
+		t.Fatalf("wrong AAAA addresses %v", ips)
+	}
+	if ips := h.lookup("local.example.", TypeA); len(ips) != 1 {
+		t.Fatalf("expected names to be case insensitive, got %v", ips)
+	}
+	if ips := h.lookup("other.", TypeAAAA); len(ips) != 0 {
+		t.Fatalf("expected no AAAA address, got %v", ips)
+	}
+}
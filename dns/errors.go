@@ -0,0 +1,22 @@
+package dns
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the name does not exist or has no records of the requested type.
+	ErrNotFound = errors.New("dns: no such host")
+
+	// ErrServerFailure is returned when all nameservers failed to answer the query.
+	ErrServerFailure = errors.New("dns: server failure")
+
+	// ErrNoNameservers is returned when the configuration does not list any nameserver.
+	ErrNoNameservers = errors.New("dns: no nameservers")
+
+	// ErrInvalidName is returned when the name cannot be encoded in a query.
+	ErrInvalidName = errors.New("dns: invalid name")
+
+	// ErrInvalidMessage is returned when a response cannot be decoded.
+	ErrInvalidMessage = errors.New("dns: invalid message")
+
+	ErrUnknownNetwork = errors.New("dns: unknown network, must be ip, ip4 or ip6")
+)
@@ -0,0 +1,267 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+// Type is the type of a resource record, see RFC 1035 section 3.2.2 and RFC 3596.
+type Type uint16
+
+const (
+	TypeA     Type = 1
+	TypeCNAME Type = 5
+	TypeAAAA  Type = 28
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeA:
+		return "A"
+	case TypeCNAME:
+		return "CNAME"
+	case TypeAAAA:
+		return "AAAA"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	classINET uint16 = 1
+
+	headerLen = 12
+
+	// maxUDPLen is the maximum size of a message over UDP without EDNS, see RFC 1035 section 2.3.4.
+	maxUDPLen = 512
+
+	maxNameLen  = 255
+	maxLabelLen = 63
+
+	// maxPointers bounds the compression pointers followed while decoding a name, such that a malicious message cannot
+	// make us loop.
+	maxPointers = 16
+)
+
+// Header flags, see RFC 1035 section 4.1.1.
+const (
+	flagResponse         uint16 = 1 << 15
+	flagTruncated        uint16 = 1 << 9
+	flagRecursionDesired uint16 = 1 << 8
+	rcodeMask            uint16 = 0xF
+)
+
+// RCode is the response code of a message.
+type RCode uint8
+
+const (
+	RCodeSuccess        RCode = 0
+	RCodeFormatError    RCode = 1
+	RCodeServerFailure  RCode = 2
+	RCodeNameError      RCode = 3
+	RCodeNotImplemented RCode = 4
+	RCodeRefused        RCode = 5
+)
+
+// Record is a resource record of the answer section of a response. Only A and AAAA records carry an IP; the data
+// of other records is not decoded.
+type Record struct {
+	Name string
+	Type Type
+	TTL  uint32
+	IP   net.IP
+}
+
+// Response is a decoded response to a query.
+type Response struct {
+	ID        uint16
+	Truncated bool
+	RCode     RCode
+
+	// Name and Type are those of the first question.
+	Name string
+	Type Type
+
+	Answers []Record
+}
+
+// fqdn returns name lowercased and rooted with a trailing dot.
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// appendQuery appends a recursive query for the records of type t of name to b.
+func appendQuery(b []byte, id uint16, name string, t Type) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, flagRecursionDesired)
+	b = binary.BigEndian.AppendUint16(b, 1) // questions
+	b = binary.BigEndian.AppendUint16(b, 0) // answers
+	b = binary.BigEndian.AppendUint16(b, 0) // authorities
+	b = binary.BigEndian.AppendUint16(b, 0) // additionals
+
+	b, err := appendName(b, name)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(t))
+	b = binary.BigEndian.AppendUint16(b, classINET)
+	return b, nil
+}
+
+// appendName appends the uncompressed encoding of name to b, see RFC 1035 section 3.1.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = fqdn(name)
+	if name == "." {
+		return append(b, 0), nil
+	}
+	if len(name) > maxNameLen {
+		return nil, ErrInvalidName
+	}
+
+	for _, label := range strings.Split(name[:len(name)-1], ".") {
+		if len(label) == 0 || len(label) > maxLabelLen {
+			return nil, ErrInvalidName
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// parseResponse decodes a response. The authority and additional sections are not decoded.
+func parseResponse(b []byte) (*Response, error) {
+	if len(b) < headerLen {
+		return nil, ErrInvalidMessage
+	}
+
+	flags := binary.BigEndian.Uint16(b[2:])
+	if flags&flagResponse == 0 {
+		return nil, ErrInvalidMessage
+	}
+
+	res := &Response{
+		ID:        binary.BigEndian.Uint16(b),
+		Truncated: flags&flagTruncated != 0,
+		RCode:     RCode(flags & rcodeMask),
+	}
+	questions := int(binary.BigEndian.Uint16(b[4:]))
+	answers := int(binary.BigEndian.Uint16(b[6:]))
+
+	off := headerLen
+	for i := 0; i < questions; i++ {
+		name, n, err := parseName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if len(b) < off+4 {
+			return nil, ErrInvalidMessage
+		}
+		if i == 0 {
+			res.Name = name
+			res.Type = Type(binary.BigEndian.Uint16(b[off:]))
+		}
+		off += 4
+	}
+
+	// A truncated response may end in the middle of the answer section.
+	if res.Truncated {
+		return res, nil
+	}
+
+	for i := 0; i < answers; i++ {
+		name, n, err := parseName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if len(b) < off+10 {
+			return nil, ErrInvalidMessage
+		}
+
+		r := Record{
+			Name: name,
+			Type: Type(binary.BigEndian.Uint16(b[off:])),
+			TTL:  binary.BigEndian.Uint32(b[off+4:]),
+		}
+		class := binary.BigEndian.Uint16(b[off+2:])
+		dataLen := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if len(b) < off+dataLen {
+			return nil, ErrInvalidMessage
+		}
+		data := b[off : off+dataLen]
+		off += dataLen
+
+		if class != classINET {
+			continue
+		}
+		switch r.Type {
+		case TypeA:
+			if len(data) != net.IPv4len {
+				return nil, ErrInvalidMessage
+			}
+			r.IP = net.IPv4(data[0], data[1], data[2], data[3])
+		case TypeAAAA:
+			if len(data) != net.IPv6len {
+				return nil, ErrInvalidMessage
+			}
+			r.IP = append(net.IP(nil), data...)
+		}
+		res.Answers = append(res.Answers, r)
+	}
+
+	return res, nil
+}
+
+// parseName decodes the possibly compressed name at off in b. It returns the name, lowercased and rooted, and the
+// offset right after it.
+func parseName(b []byte, off int) (name string, next int, err error) {
+	var (
+		sb       strings.Builder
+		pointers = 0
+	)
+	next = -1
+	for {
+		if off >= len(b) {
+			return "", 0, ErrInvalidMessage
+		}
+
+		n := int(b[off])
+		switch n & 0xC0 {
+		case 0x00:
+			off++
+			if n == 0 {
+				if next < 0 {
+					next = off
+				}
+				if sb.Len() == 0 {
+					return ".", next, nil
+				}
+				return strings.ToLower(sb.String()), next, nil
+			}
+			if len(b) < off+n || sb.Len()+n+1 > maxNameLen {
+				return "", 0, ErrInvalidMessage
+			}
+			sb.Write(b[off : off+n])
+			sb.WriteByte('.')
+			off += n
+		case 0xC0:
+			if len(b) < off+2 || pointers == maxPointers {
+				return "", 0, ErrInvalidMessage
+			}
+			pointers++
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+		default:
+			return "", 0, ErrInvalidMessage
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// appendResponse appends the response to the query req, with the given answers, to b. Answer names are compressed
+// with pointers to the question.
+func appendResponse(b, req []byte, rcode RCode, truncated bool, answers []Record) []byte {
+	_, next, err := parseName(req, headerLen)
+	if err != nil {
+		panic(err)
+	}
+	question := req[headerLen : next+4]
+
+	flags := flagResponse | flagRecursionDesired | uint16(rcode)
+	if truncated {
+		flags |= flagTruncated
+	}
+	b = binary.BigEndian.AppendUint16(b, binary.BigEndian.Uint16(req))
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(answers)))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = append(b, question...)
+
+	for _, r := range answers {
+		b = binary.BigEndian.AppendUint16(b, 0xC000|headerLen)
+		b = binary.BigEndian.AppendUint16(b, uint16(r.Type))
+		b = binary.BigEndian.AppendUint16(b, classINET)
+		b = binary.BigEndian.AppendUint32(b, r.TTL)
+		ip := r.IP.To4()
+		if r.Type == TypeAAAA {
+			ip = r.IP.To16()
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(ip)))
+		b = append(b, ip...)
+	}
+	return b
+}
+
+func TestQueryResponse(t *testing.T) {
+	req, err := appendQuery(nil, 42, "Example.COM", TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := appendResponse(nil, req, RCodeSuccess, false, []Record{
+		{Type: TypeAAAA, TTL: 10, IP: net.ParseIP("::1")},
+		{Type: TypeAAAA, TTL: 20, IP: net.ParseIP("::2")},
+	})
+	res, err := parseResponse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.ID != 42 || res.Name != "example.com." || res.Type != TypeAAAA || res.RCode != RCodeSuccess {
+		t.Fatalf("wrong response %+v", res)
+	}
+	if len(res.Answers) != 2 {
+		t.Fatalf("expected 2 answers, got %d", len(res.Answers))
+	}
+	for i, r := range res.Answers {
+		if r.Name != "example.com." || r.Type != TypeAAAA || r.TTL != uint32(10*(i+1)) {
+			t.Fatalf("wrong answer %+v", r)
+		}
+	}
+	if !res.Answers[0].IP.Equal(net.ParseIP("::1")) || !res.Answers[1].IP.Equal(net.ParseIP("::2")) {
+		t.Fatalf("wrong addresses %v %v", res.Answers[0].IP, res.Answers[1].IP)
+	}
+}
+
+func TestQueryInvalidName(t *testing.T) {
+	long := make([]byte, maxLabelLen+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+
+	for _, name := range []string{"a..b", string(long) + ".com"} {
+		if _, err := appendQuery(nil, 1, name, TypeA); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("expected name=%s to be invalid err=%v", name, err)
+		}
+	}
+}
+
+func TestParseNamePointerLoop(t *testing.T) {
+	b := make([]byte, headerLen+2)
+	binary.BigEndian.PutUint16(b[headerLen:], 0xC000|headerLen)
+
+	if _, _, err := parseName(b, headerLen); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected the message to be invalid err=%v", err)
+	}
+}
+
+func TestParseResponseTruncated(t *testing.T) {
+	req, err := appendQuery(nil, 1, "example.com", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := appendResponse(nil, req, RCodeSuccess, false, []Record{{Type: TypeA, TTL: 1, IP: net.IPv4(1, 2, 3, 4)}})
+	for n := 0; n < len(b); n++ {
+		if _, err := parseResponse(b[:n]); !errors.Is(err, ErrInvalidMessage) {
+			t.Fatalf("expected the message cut at %d to be invalid err=%v", n, err)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// DefaultCacheSize is the number of answers a Resolver caches.
+const DefaultCacheSize = 1024
+
+// LookupCallback is called with the addresses a lookup resolved to. ips is not empty if err is nil.
+type LookupCallback func(err error, ips []net.IP)
+
+// Resolver is a stub resolver: it asks the configured nameservers to resolve names recursively.
+//
+// Queries are sent over UDP and retried over TCP if the answer does not fit in a datagram. Names are looked up in the
+// hosts file first, and answers are cached for their TTL.
+//
+// A Resolver is bound to an IO and, like the rest of sonic, must only be used from the goroutine running the IO.
+type Resolver struct {
+	ioc   *sonic.IO
+	cfg   *Config
+	hosts hosts
+	cache *cache
+}
+
+// NewResolver creates a Resolver configured by cfg. A nil cfg reads the system configuration, see DefaultConfig.
+func NewResolver(ioc *sonic.IO, cfg *Config) (*Resolver, error) {
+	if cfg == nil {
+		var err error
+		cfg, err = DefaultConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Resolver{
+		ioc:   ioc,
+		cfg:   cfg,
+		cache: newCache(DefaultCacheSize),
+	}
+	if cfg.Hosts != "" {
+		h, err := readHosts(cfg.Hosts)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		r.hosts = h
+	}
+	return r, nil
+}
+
+// AsyncLookupIP resolves host to its addresses asynchronously. network is ip4 for IPv4 addresses, ip6 for IPv6
+// addresses, or ip for both, IPv4 first.
+//
+// cb runs on the IO. It is called inline if host is an IP address, is listed in the hosts file, or is cached.
+func (r *Resolver) AsyncLookupIP(network, host string, cb LookupCallback) {
+	var types []Type
+	switch network {
+	case "ip":
+		types = []Type{TypeA, TypeAAAA}
+	case "ip4":
+		types = []Type{TypeA}
+	case "ip6":
+		types = []Type{TypeAAAA}
+	default:
+		cb(ErrUnknownNetwork, nil)
+		return
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, t := range types {
+			if (t == TypeA) == (ip.To4() != nil) {
+				cb(nil, []net.IP{ip})
+				return
+			}
+		}
+		cb(ErrNotFound, nil)
+		return
+	}
+
+	if len(types) == 1 {
+		r.AsyncLookup(host, types[0], cb)
+		return
+	}
+
+	var (
+		pending = len(types)
+		results = make([][]net.IP, len(types))
+		errs    = make([]error, len(types))
+	)
+	for i, t := range types {
+		i := i
+		r.AsyncLookup(host, t, func(err error, ips []net.IP) {
+			results[i], errs[i] = ips, err
+			pending--
+			if pending > 0 {
+				return
+			}
+
+			var all []net.IP
+			for _, ips := range results {
+				all = append(all, ips...)
+			}
+			if len(all) > 0 {
+				cb(nil, all)
+				return
+			}
+
+			// Report why the lookup failed rather than the absence of records of one of the types.
+			err = ErrNotFound
+			for _, e := range errs {
+				if !errors.Is(e, ErrNotFound) {
+					err = e
+					break
+				}
+			}
+			cb(err, nil)
+		})
+	}
+}
+
+// AsyncLookup resolves name to its addresses of type t, which must be TypeA or TypeAAAA, asynchronously.
+//
+// cb runs on the IO. It is called inline if name is listed in the hosts file, or is cached.
+func (r *Resolver) AsyncLookup(name string, t Type, cb LookupCallback) {
+	if t != TypeA && t != TypeAAAA {
+		cb(fmt.Errorf("dns: cannot look up records of type %s", t), nil)
+		return
+	}
+
+	name = fqdn(name)
+	if ips := r.hosts.lookup(name, t); len(ips) > 0 {
+		cb(nil, ips)
+		return
+	}
+	if ips, ok := r.cache.get(name, t, time.Now()); ok {
+		cb(nil, ips)
+		return
+	}
+
+	if len(r.cfg.Nameservers) == 0 {
+		cb(ErrNoNameservers, nil)
+		return
+	}
+
+	q := &query{
+		r:    r,
+		name: name,
+		t:    t,
+		cb:   cb,
+	}
+	q.send()
+}
+
+// query is a lookup in flight. It tries each nameserver in turn until one answers, Config.Attempts times.
+type query struct {
+	r    *Resolver
+	name string
+	t    Type
+	cb   LookupCallback
+
+	id  uint16
+	req []byte
+	b   []byte
+
+	server  int
+	attempt int
+
+	// udp is the socket of the current attempt. A new one is created for each attempt, such that late answers to a
+	// previous attempt are dropped.
+	udp   sonic.PacketConn
+	timer *sonic.Timer
+
+	// err is why the last attempt failed.
+	err error
+}
+
+// send queries the current nameserver over UDP.
+func (q *query) send() {
+	addr, err := net.ResolveUDPAddr("udp", q.r.cfg.Nameservers[q.server])
+	if err != nil {
+		q.next(err)
+		return
+	}
+
+	if err := q.prepare(); err != nil {
+		q.finish(err, nil)
+		return
+	}
+
+	q.udp, err = sonic.NewPacketConn(q.r.ioc, "udp", "")
+	if err != nil {
+		q.next(err)
+		return
+	}
+
+	if q.timer == nil {
+		q.timer, err = sonic.NewTimer(q.r.ioc)
+		if err != nil {
+			q.finish(err, nil)
+			return
+		}
+	}
+	if err := q.timer.ScheduleOnce(q.r.cfg.Timeout, q.onTimeout); err != nil {
+		q.finish(err, nil)
+		return
+	}
+
+	q.udp.AsyncWriteTo(q.req, addr, func(err error) {
+		if err != nil {
+			q.next(err)
+			return
+		}
+		q.receive(addr)
+	})
+}
+
+// prepare encodes the query with a fresh, random id.
+func (q *query) prepare() (err error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	q.id = binary.BigEndian.Uint16(id[:])
+
+	q.req, err = appendQuery(q.req[:0], q.id, q.name, q.t)
+	if err != nil {
+		return err
+	}
+
+	if q.b == nil {
+		q.b = make([]byte, maxUDPLen)
+	}
+	return nil
+}
+
+// receive reads datagrams until the answer from addr arrives. Anything else is dropped.
+func (q *query) receive(addr *net.UDPAddr) {
+	q.udp.AsyncReadFrom(q.b, func(err error, n int, from net.Addr) {
+		if err != nil {
+			q.next(err)
+			return
+		}
+
+		// The type of from depends on the PacketConn, compare the ip:port forms.
+		if from == nil || from.String() != addr.String() {
+			q.receive(addr)
+			return
+		}
+		res, err := parseResponse(q.b[:n])
+		if err != nil || !q.answers(res) {
+			q.receive(addr)
+			return
+		}
+
+		q.stop()
+		if res.Truncated {
+			q.sendTCP()
+			return
+		}
+		q.handle(res)
+	})
+}
+
+// answers returns true if res is the answer to the query.
+func (q *query) answers(res *Response) bool {
+	return res.ID == q.id && res.Name == q.name && res.Type == q.t
+}
+
+// sendTCP queries the current nameserver over TCP, after its answer over UDP was truncated.
+func (q *query) sendTCP() {
+	timeout := q.r.cfg.Timeout
+	sonic.AsyncDialTimeout(q.r.ioc, "tcp", q.r.cfg.Nameservers[q.server], timeout, func(err error, conn sonic.Conn) {
+		if err != nil {
+			q.next(err)
+			return
+		}
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			_ = conn.Close()
+			q.next(err)
+			return
+		}
+
+		// Over TCP, messages are prefixed with their length, see RFC 1035 section 4.2.2.
+		req := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(q.req)), uint16(len(q.req)))
+		req = append(req, q.req...)
+		conn.AsyncWriteAll(req, func(err error, _ int) {
+			if err != nil {
+				_ = conn.Close()
+				q.next(err)
+				return
+			}
+
+			var prefix [2]byte
+			readFull(conn, prefix[:], func(err error) {
+				if err != nil {
+					_ = conn.Close()
+					q.next(err)
+					return
+				}
+
+				b := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+				readFull(conn, b, func(err error) {
+					_ = conn.Close()
+					if err != nil {
+						q.next(err)
+						return
+					}
+
+					res, err := parseResponse(b)
+					if err != nil {
+						q.next(err)
+						return
+					}
+					if !q.answers(res) {
+						q.next(ErrInvalidMessage)
+						return
+					}
+					q.handle(res)
+				})
+			})
+		})
+	})
+}
+
+// handle completes the query with the answer res, or moves on to the next nameserver if it failed to answer.
+func (q *query) handle(res *Response) {
+	switch res.RCode {
+	case RCodeSuccess:
+	case RCodeNameError:
+		q.finish(ErrNotFound, nil)
+		return
+	default:
+		q.next(fmt.Errorf("dns: response code %d", res.RCode))
+		return
+	}
+
+	var (
+		ips []net.IP
+		ttl uint32
+	)
+	for _, r := range res.Answers {
+		if r.Type != q.t {
+			continue
+		}
+		if len(ips) == 0 || r.TTL < ttl {
+			ttl = r.TTL
+		}
+		ips = append(ips, r.IP)
+	}
+	if len(ips) == 0 {
+		q.finish(ErrNotFound, nil)
+		return
+	}
+
+	q.r.cache.put(q.name, q.t, ips, time.Duration(ttl)*time.Second, time.Now())
+	q.finish(nil, ips)
+}
+
+// onTimeout is called by the query's timer. err is not nil if the timer did not expire, in which case the query fails.
+func (q *query) onTimeout(err error) {
+	if err != nil {
+		q.finish(err, nil)
+		return
+	}
+	q.next(sonicerrors.ErrTimeout)
+}
+
+// next queries the next nameserver, after the current one failed with err.
+func (q *query) next(err error) {
+	q.stop()
+	q.err = err
+
+	q.server++
+	if q.server == len(q.r.cfg.Nameservers) {
+		q.server = 0
+		q.attempt++
+	}
+	if q.attempt >= q.r.cfg.Attempts {
+		q.finish(fmt.Errorf("%w: %w", ErrServerFailure, q.err), nil)
+		return
+	}
+	q.send()
+}
+
+// stop stops the current attempt over UDP.
+func (q *query) stop() {
+	if q.udp != nil {
+		_ = q.udp.Close()
+		q.udp = nil
+	}
+	if q.timer != nil {
+		_ = q.timer.Cancel()
+	}
+}
+
+func (q *query) finish(err error, ips []net.IP) {
+	q.stop()
+	if q.timer != nil {
+		_ = q.timer.Close()
+		q.timer = nil
+	}
+	q.cb(err, ips)
+}
+
+// readFull reads len(b) bytes into b.
+func readFull(conn sonic.Conn, b []byte, cb func(error)) {
+	conn.AsyncRead(b, func(err error, n int) {
+		if err != nil {
+			cb(err)
+			return
+		}
+		if n < len(b) {
+			readFull(conn, b[n:], cb)
+			return
+		}
+		cb(nil)
+	})
+}
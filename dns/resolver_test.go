@@ -0,0 +1,288 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// testServer is a stand-in nameserver on loopback, answering over UDP and TCP:
+//   - a.test. has an A and an AAAA record. Each answer is preceded by one with the wrong id, which must be dropped.
+//   - big.test. does not fit in a datagram: it is truncated over UDP.
+//   - missing.test. does not exist.
+//   - nodata.test. exists, without records.
+//   - slow.test. is never answered.
+//   - flaky.test. is answered from the second query on.
+type testServer struct {
+	udp *net.UDPConn
+	tcp *net.TCPListener
+
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+func newTestServer(t *testing.T, addr string) *testServer {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.ListenTCP("tcp", &net.TCPAddr{IP: udpAddr.IP, Port: udpAddr.Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{udp: udp, tcp: tcp, queries: make(map[string]int)}
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+func (s *testServer) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+func (s *testServer) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[name]
+}
+
+func (s *testServer) serveUDP() {
+	b := make([]byte, maxUDPLen)
+	for {
+		n, from, err := s.udp.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		for _, res := range s.answer(b[:n], false) {
+			_, _ = s.udp.WriteToUDP(res, from)
+		}
+	}
+}
+
+func (s *testServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+
+			var prefix [2]byte
+			if _, err := io.ReadFull(conn, prefix[:]); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			for _, res := range s.answer(req, true) {
+				_, _ = conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(res))))
+				_, _ = conn.Write(res)
+			}
+
+			// Wait for the client to close first, such that the listening port does not end up in TIME_WAIT.
+			_, _ = io.Copy(io.Discard, conn)
+		}()
+	}
+}
+
+func (s *testServer) answer(req []byte, tcp bool) [][]byte {
+	name, next, err := parseName(req, headerLen)
+	if err != nil {
+		return nil
+	}
+	t := Type(binary.BigEndian.Uint16(req[next:]))
+
+	s.mu.Lock()
+	s.queries[name]++
+	n := s.queries[name]
+	s.mu.Unlock()
+
+	switch name {
+	case "a.test.":
+		ip := net.IPv4(1, 2, 3, 4)
+		if t == TypeAAAA {
+			ip = net.ParseIP("::1234")
+		}
+		res := appendResponse(nil, req, RCodeSuccess, false, []Record{{Type: t, TTL: 60, IP: ip}})
+		spoofed := append([]byte(nil), res...)
+		binary.BigEndian.PutUint16(spoofed, binary.BigEndian.Uint16(req)+1)
+		return [][]byte{spoofed, res}
+	case "big.test.":
+		if !tcp || t != TypeA {
+			return [][]byte{appendResponse(nil, req, RCodeSuccess, !tcp, nil)}
+		}
+		return [][]byte{appendResponse(nil, req, RCodeSuccess, false, []Record{
+			{Type: TypeA, TTL: 60, IP: net.IPv4(5, 6, 7, 8)},
+			{Type: TypeA, TTL: 60, IP: net.IPv4(5, 6, 7, 9)},
+		})}
+	case "nodata.test.":
+		return [][]byte{appendResponse(nil, req, RCodeSuccess, false, nil)}
+	case "slow.test.":
+		return nil
+	case "flaky.test.":
+		if n == 1 {
+			return nil
+		}
+		return [][]byte{appendResponse(nil, req, RCodeSuccess, false, []Record{
+			{Type: TypeA, TTL: 60, IP: net.IPv4(9, 9, 9, 9)},
+		})}
+	default:
+		return [][]byte{appendResponse(nil, req, RCodeNameError, false, nil)}
+	}
+}
+
+func lookup(t *testing.T, ioc *sonic.IO, r *Resolver, network, host string) ([]net.IP, error) {
+	var (
+		done      = false
+		lookupErr error
+		ips       []net.IP
+	)
+	r.AsyncLookupIP(network, host, func(err error, addrs []net.IP) {
+		done = true
+		lookupErr = err
+		ips = addrs
+	})
+	for !done {
+		_ = ioc.RunOne()
+	}
+	return ips, lookupErr
+}
+
+func TestResolver(t *testing.T) {
+	server := newTestServer(t, "127.0.0.1:8102")
+	defer server.Close()
+
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsPath, []byte("10.1.1.1 hosted.test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	r, err := NewResolver(ioc, &Config{
+		Nameservers: []string{"127.0.0.1:8102"},
+		Timeout:     100 * time.Millisecond,
+		Attempts:    2,
+		Hosts:       hostsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("both types", func(t *testing.T) {
+		ips, err := lookup(t, ioc, r, "ip", "a.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 2 || !ips[0].Equal(net.IPv4(1, 2, 3, 4)) || !ips[1].Equal(net.ParseIP("::1234")) {
+			t.Fatalf("wrong addresses %v", ips)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		before := server.count("a.test.")
+		ips, err := lookup(t, ioc, r, "ip4", "A.TEST.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.IPv4(1, 2, 3, 4)) {
+			t.Fatalf("wrong addresses %v", ips)
+		}
+		if after := server.count("a.test."); after != before {
+			t.Fatalf("expected the answer to be cached, got %d more queries", after-before)
+		}
+	})
+
+	t.Run("hosts", func(t *testing.T) {
+		ips, err := lookup(t, ioc, r, "ip4", "hosted.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.IPv4(10, 1, 1, 1)) {
+			t.Fatalf("wrong addresses %v", ips)
+		}
+		if n := server.count("hosted.test."); n != 0 {
+			t.Fatalf("expected no queries, got %d", n)
+		}
+	})
+
+	t.Run("literal", func(t *testing.T) {
+		ips, err := lookup(t, ioc, r, "ip", "10.2.2.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.IPv4(10, 2, 2, 2)) {
+			t.Fatalf("wrong addresses %v", ips)
+		}
+		if _, err := lookup(t, ioc, r, "ip6", "10.2.2.2"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected no IPv6 address err=%v", err)
+		}
+	})
+
+	t.Run("tcp fallback", func(t *testing.T) {
+		ips, err := lookup(t, ioc, r, "ip4", "big.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 2 || !ips[0].Equal(net.IPv4(5, 6, 7, 8)) || !ips[1].Equal(net.IPv4(5, 6, 7, 9)) {
+			t.Fatalf("wrong addresses %v", ips)
+		}
+		if n := server.count("big.test."); n != 2 {
+			t.Fatalf("expected a query over UDP and one over TCP, got %d", n)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		for _, host := range []string{"missing.test", "nodata.test"} {
+			if _, err := lookup(t, ioc, r, "ip", host); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected host=%s to not be found err=%v", host, err)
+			}
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		ips, err := lookup(t, ioc, r, "ip4", "flaky.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.IPv4(9, 9, 9, 9)) {
+			t.Fatalf("wrong addresses %v", ips)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+		_, err := lookup(t, ioc, r, "ip4", "slow.test")
+		if !errors.Is(err, ErrServerFailure) || !errors.Is(err, sonicerrors.ErrTimeout) {
+			t.Fatalf("expected the lookup to time out err=%v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("expected both attempts to time out, took %s", elapsed)
+		}
+		if n := server.count("slow.test."); n != 2 {
+			t.Fatalf("expected 2 queries, got %d", n)
+		}
+	})
+
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
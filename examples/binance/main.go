@@ -67,6 +67,9 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	stream.SetDeflateOptions(&websocket.DeflateOptions{
+		ClientNoContextTakeover: true,
+	})
 
 	run(stream)
 
@@ -94,7 +94,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	err = ticker.ScheduleRepeating(*rate, func() {
+	err = ticker.ScheduleRepeating(*rate, func(error) {
 		prepare()
 		_, err = peer.Write(b, multicastAddr)
 		for errors.Is(err, sonicerrors.ErrWouldBlock) ||
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/talostrading/sonic"
+)
+
+var (
+	addr = flag.String("addr", ":8080", "server address")
+	n    = flag.Int("n", 4, "number of IOs in the pool")
+	pin  = flag.Bool("pin", false, "if true, pin the i-th IO to the i-th CPU")
+)
+
+// This example runs an echo server on a pool of IOs. Each IO has its own listener on the same address, and the kernel
+// spreads incoming connections across them through SO_REUSEPORT.
+func main() {
+	flag.Parse()
+
+	var cpus []int
+	if *pin {
+		for i := 0; i < *n; i++ {
+			cpus = append(cpus, i)
+		}
+	}
+
+	pool, err := sonic.NewIOPool(*n, cpus)
+	if err != nil {
+		panic(err)
+	}
+
+	lns, err := pool.Listen("tcp", *addr)
+	if err != nil {
+		panic(err)
+	}
+
+	for i, ln := range lns {
+		i, ln := i, ln
+
+		var onAccept sonic.AcceptCallback
+		onAccept = func(err error, conn sonic.Conn) {
+			ln.AsyncAccept(onAccept)
+
+			if err != nil {
+				fmt.Println("could not accept", err)
+				return
+			}
+			fmt.Printf("io %d accepted %s\n", i, conn.RemoteAddr())
+
+			b := make([]byte, 128)
+			var onRead sonic.AsyncCallback
+			onRead = func(err error, n int) {
+				if err != nil {
+					conn.Close()
+					return
+				}
+				conn.AsyncWriteAll(b[:n], func(err error, _ int) {
+					if err != nil {
+						conn.Close()
+						return
+					}
+					conn.AsyncRead(b, onRead)
+				})
+			}
+			conn.AsyncRead(b, onRead)
+		}
+		ln.AsyncAccept(onAccept)
+	}
+
+	pool.Start()
+	fmt.Printf("listening on %s with %d IOs\n", *addr, pool.Size())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+
+	if err := pool.Close(); err != nil {
+		fmt.Println("pool closed with errors", err)
+	}
+}
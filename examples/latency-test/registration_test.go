@@ -0,0 +1,66 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/talostrading/sonic/internal"
+)
+
+// BenchmarkURegistration* compare transient registrations, in which an fd is added to and removed from epoll on every
+// read, with persistent registrations, in which the fd stays in epoll and is rearmed on demand. The ctls/op metric is
+// the number of epoll_ctl calls made per read. Linux only.
+
+func benchmarkRegistration(b *testing.B, persistent bool) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	p, err := internal.NewPoller()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+
+	buf := make([]byte, 8)
+	slot := internal.Slot{Fd: fds[0], Persistent: persistent}
+	slot.Set(internal.ReadEvent, func(err error) {
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := syscall.Read(fds[0], buf); err != nil {
+			b.Fatal(err)
+		}
+	})
+
+	ctls := p.(interface{ Ctls() uint64 })
+	start := ctls.Ctls()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := syscall.Write(fds[1], buf); err != nil {
+			b.Fatal(err)
+		}
+		if err := p.SetRead(&slot); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := p.Poll(-1); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(ctls.Ctls()-start)/float64(b.N), "ctls/op")
+	_ = p.Del(&slot)
+}
+
+func BenchmarkURegistrationTransient(b *testing.B) {
+	benchmarkRegistration(b, false)
+}
+
+func BenchmarkURegistrationPersistent(b *testing.B) {
+	benchmarkRegistration(b, true)
+}
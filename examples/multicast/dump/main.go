@@ -97,7 +97,7 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		t.ScheduleRepeating(5*time.Second, func() {
+		t.ScheduleRepeating(5*time.Second, func(error) {
 			fmt.Println("------------------------")
 			for msg, count := range received {
 				if count != 1 {
@@ -112,7 +112,7 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		err = t.ScheduleRepeating(*rate, func() {
+		err = t.ScheduleRepeating(*rate, func(error) {
 			prepare()
 			for i := 0; i < *duplicate; i++ {
 				_, err := p.Write(b, maddr)
@@ -18,7 +18,7 @@ func main() {
 	defer timer.Close()
 
 	fmt.Println("timer armed: ", time.Now())
-	err = timer.ScheduleOnce(time.Second, func() {
+	err = timer.ScheduleOnce(time.Second, func(error) {
 		fmt.Println("timer fired: ", time.Now())
 	})
 	if err != nil {
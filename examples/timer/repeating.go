@@ -18,7 +18,7 @@ func main() {
 
 	fmt.Println("timer armed: ", time.Now())
 	i := 0
-	err = timer.ScheduleRepeating(time.Second, func() {
+	err = timer.ScheduleRepeating(time.Second, func(error) {
 		i++
 		fmt.Println(i, "timer fired: ", time.Now())
 		if i == 5 {
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+
+	"github.com/talostrading/sonic"
+	sonictls "github.com/talostrading/sonic/tls"
+)
+
+var (
+	certPath = flag.String("cert", "../async_adapter/tls/certs/client.pem", "client certificate path")
+	keyPath  = flag.String("key", "../async_adapter/tls/certs/client.key", "client private key path")
+	addr     = flag.String("addr", "127.0.0.1:8080", "tls server address")
+)
+
+// Connects to the server of examples/async_adapter/tls without an AsyncAdapter: the handshake and the record layer run
+// on the event loop.
+func main() {
+	flag.Parse()
+
+	cert, err := tls.LoadX509KeyPair(*certPath, *keyPath)
+	if err != nil {
+		panic(err)
+	}
+	cfg, err := sonictls.NewConfig(&tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	sonictls.AsyncDial(ioc, "tcp", *addr, cfg, func(err error, conn *sonictls.Conn) {
+		if err != nil {
+			panic(err)
+		}
+
+		conn.AsyncWrite([]byte("hello"), func(err error, _ int) {
+			if err != nil {
+				panic(err)
+			}
+
+			b := make([]byte, 128)
+			conn.AsyncRead(b, func(err error, n int) {
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("client read", string(b[:n]))
+				_ = conn.Close()
+				ioc.Close()
+			})
+		})
+	})
+
+	ioc.Run()
+}
@@ -1,5 +1,65 @@
 package main
 
+import (
+	"fmt"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/codec/websocket"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
 func main() {
-	panic("implement me")
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8080", sonicopts.Nonblocking(true))
+	if err != nil {
+		panic(err)
+	}
+
+	var onAccept func(error, sonic.Conn)
+	onAccept = func(err error, conn sonic.Conn) {
+		if err != nil {
+			panic(err)
+		}
+
+		server, err := websocket.NewWebsocketStream(ioc, nil, websocket.RoleServer)
+		if err != nil {
+			panic(err)
+		}
+
+		server.AsyncAccept(conn, func(err error) {
+			if err != nil {
+				fmt.Println("could not accept", err)
+				_ = conn.Close()
+			} else {
+				echo(server, make([]byte, 1024))
+			}
+		})
+
+		ln.AsyncAccept(onAccept)
+	}
+	ln.AsyncAccept(onAccept)
+
+	for {
+		ioc.RunOneFor(0) // poll
+	}
+}
+
+func echo(server websocket.Stream, b []byte) {
+	server.AsyncNextMessage(b, func(err error, n int, mt websocket.MessageType) {
+		if err != nil {
+			fmt.Println("closing", err)
+			_ = server.CloseNextLayer()
+		} else {
+			server.AsyncWrite(b[:n], mt, func(err error) {
+				if err != nil {
+					fmt.Println("closing", err)
+					_ = server.CloseNextLayer()
+				} else {
+					echo(server, b)
+				}
+			})
+		}
+	})
 }
@@ -5,6 +5,9 @@ import (
 	"os"
 	"sync/atomic"
 	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 
 	"github.com/talostrading/sonic/internal"
 	"github.com/talostrading/sonic/sonicerrors"
@@ -23,6 +26,11 @@ type file struct {
 	// we limit the number of dispatched reads to MaxCallbackDispatch.
 	// If we hit that limit, we schedule an async read/write which results in clearing the stack.
 	dispatched int
+
+	// The deadlines of reads and writes, see conn.SetReadDeadline, conn.SetWriteDeadline, AsyncReadTimeout and
+	// AsyncWriteTimeout.
+	readDeadline  deadline
+	writeDeadline deadline
 }
 
 func Open(ioc *IO, path string, flags int, mode os.FileMode) (File, error) {
@@ -33,12 +41,16 @@ func Open(ioc *IO, path string, flags int, mode os.FileMode) (File, error) {
 
 	f := &file{
 		ioc:  ioc,
-		slot: internal.Slot{Fd: fd},
+		slot: internal.Slot{Fd: fd, Persistent: true},
 	}
 	return f, nil
 }
 
 func (f *file) Read(b []byte) (int, error) {
+	if err := f.wait(unix.POLLIN, &f.readDeadline); err != nil {
+		return 0, err
+	}
+
 	n, err := syscall.Read(f.slot.Fd, b)
 
 	if err != nil {
@@ -61,6 +73,10 @@ func (f *file) Read(b []byte) (int, error) {
 }
 
 func (f *file) Write(b []byte) (int, error) {
+	if err := f.wait(unix.POLLOUT, &f.writeDeadline); err != nil {
+		return 0, err
+	}
+
 	n, err := syscall.Write(f.slot.Fd, b)
 
 	if err != nil {
@@ -90,6 +106,17 @@ func (f *file) AsyncReadAll(b []byte, cb AsyncCallback) {
 	f.asyncRead(b, true, cb)
 }
 
+// AsyncReadTimeout is like AsyncRead, but the read completes with sonicerrors.ErrTimeout if no data is read within the
+// timeout. A pending write is not affected. If the timeout is negative or 0, the read times out unless data is
+// available right away.
+func (f *file) AsyncReadTimeout(b []byte, timeout time.Duration, cb AsyncCallback) {
+	f.readDeadline.op = time.Now().Add(timeout)
+	f.asyncRead(b, false, func(err error, n int) {
+		f.readDeadline.op = time.Time{}
+		cb(err, n)
+	})
+}
+
 func (f *file) asyncRead(b []byte, readAll bool, cb AsyncCallback) {
 	if f.dispatched < MaxCallbackDispatch {
 		f.asyncReadNow(b, 0, readAll, func(err error, n int) {
@@ -133,19 +160,29 @@ func (f *file) scheduleRead(b []byte, readBytes int, readAll bool, cb AsyncCallb
 		return
 	}
 
-	handler := f.getReadHandler(b, readBytes, readAll, cb)
-	f.slot.Set(internal.ReadEvent, handler)
+	var err error
+	if f.ioc.submitter != nil {
+		f.slot.Set(internal.ReadEvent, f.getSubmittedReadHandler(b, readBytes, readAll, cb))
+		err = f.ioc.submitter.SubmitRead(&f.slot, b[readBytes:])
+	} else {
+		f.slot.Set(internal.ReadEvent, f.getReadHandler(b, readBytes, readAll, cb))
+		err = f.ioc.SetRead(&f.slot)
+	}
 
-	if err := f.ioc.SetRead(&f.slot); err != nil {
+	if err != nil {
 		cb(err, readBytes)
 	} else {
 		f.ioc.Register(&f.slot)
+		if err := f.readDeadline.arm(f.ioc, f.expireReads); err != nil {
+			f.abortReads(err)
+		}
 	}
 }
 
 func (f *file) getReadHandler(b []byte, readBytes int, readAll bool, cb AsyncCallback) internal.Handler {
 	return func(err error) {
 		f.ioc.Deregister(&f.slot)
+		f.readDeadline.disarm()
 		if err != nil {
 			cb(err, readBytes)
 		} else {
@@ -154,6 +191,30 @@ func (f *file) getReadHandler(b []byte, readBytes int, readAll bool, cb AsyncCal
 	}
 }
 
+// getSubmittedReadHandler returns the handler of a read submitted to the IO's internal.Submitter, which made the read
+// already.
+func (f *file) getSubmittedReadHandler(b []byte, readBytes int, readAll bool, cb AsyncCallback) internal.Handler {
+	return func(err error) {
+		f.ioc.Deregister(&f.slot)
+		f.readDeadline.disarm()
+
+		if err == nil && f.slot.Result(internal.ReadEvent) == 0 {
+			err = io.EOF
+		}
+		if err != nil {
+			cb(err, readBytes)
+			return
+		}
+
+		readBytes += f.slot.Result(internal.ReadEvent)
+		if readAll && readBytes != len(b) {
+			f.asyncReadNow(b, readBytes, readAll, cb)
+		} else {
+			cb(nil, readBytes)
+		}
+	}
+}
+
 func (f *file) AsyncWrite(b []byte, cb AsyncCallback) {
 	f.asyncWrite(b, false, cb)
 }
@@ -162,6 +223,17 @@ func (f *file) AsyncWriteAll(b []byte, cb AsyncCallback) {
 	f.asyncWrite(b, true, cb)
 }
 
+// AsyncWriteTimeout is like AsyncWrite, but the write completes with sonicerrors.ErrTimeout if no data is written
+// within the timeout. A pending read is not affected. If the timeout is negative or 0, the write times out unless it
+// can complete right away.
+func (f *file) AsyncWriteTimeout(b []byte, timeout time.Duration, cb AsyncCallback) {
+	f.writeDeadline.op = time.Now().Add(timeout)
+	f.asyncWrite(b, false, func(err error, n int) {
+		f.writeDeadline.op = time.Time{}
+		cb(err, n)
+	})
+}
+
 func (f *file) asyncWrite(b []byte, writeAll bool, cb AsyncCallback) {
 	if f.dispatched < MaxCallbackDispatch {
 		f.asyncWriteNow(b, 0, writeAll, func(err error, n int) {
@@ -205,19 +277,29 @@ func (f *file) scheduleWrite(b []byte, writtenBytes int, writeAll bool, cb Async
 		return
 	}
 
-	handler := f.getWriteHandler(b, writtenBytes, writeAll, cb)
-	f.slot.Set(internal.WriteEvent, handler)
+	var err error
+	if f.ioc.submitter != nil {
+		f.slot.Set(internal.WriteEvent, f.getSubmittedWriteHandler(b, writtenBytes, writeAll, cb))
+		err = f.ioc.submitter.SubmitWrite(&f.slot, b[writtenBytes:])
+	} else {
+		f.slot.Set(internal.WriteEvent, f.getWriteHandler(b, writtenBytes, writeAll, cb))
+		err = f.ioc.SetWrite(&f.slot)
+	}
 
-	if err := f.ioc.SetWrite(&f.slot); err != nil {
+	if err != nil {
 		cb(err, writtenBytes)
 	} else {
 		f.ioc.Register(&f.slot)
+		if err := f.writeDeadline.arm(f.ioc, f.expireWrites); err != nil {
+			f.abortWrites(err)
+		}
 	}
 }
 
 func (f *file) getWriteHandler(b []byte, writtenBytes int, writeAll bool, cb AsyncCallback) internal.Handler {
 	return func(err error) {
 		f.ioc.Deregister(&f.slot)
+		f.writeDeadline.disarm()
 
 		if err != nil {
 			cb(err, writtenBytes)
@@ -227,11 +309,37 @@ func (f *file) getWriteHandler(b []byte, writtenBytes int, writeAll bool, cb Asy
 	}
 }
 
+// getSubmittedWriteHandler is like getSubmittedReadHandler, for writes.
+func (f *file) getSubmittedWriteHandler(b []byte, writtenBytes int, writeAll bool, cb AsyncCallback) internal.Handler {
+	return func(err error) {
+		f.ioc.Deregister(&f.slot)
+		f.writeDeadline.disarm()
+
+		if err == nil && f.slot.Result(internal.WriteEvent) == 0 {
+			err = io.EOF
+		}
+		if err != nil {
+			cb(err, writtenBytes)
+			return
+		}
+
+		writtenBytes += f.slot.Result(internal.WriteEvent)
+		if writeAll && writtenBytes != len(b) {
+			f.asyncWriteNow(b, writtenBytes, writeAll, cb)
+		} else {
+			cb(nil, writtenBytes)
+		}
+	}
+}
+
 func (f *file) Close() error {
 	if !atomic.CompareAndSwapUint32(&f.closed, 0, 1) {
 		return io.EOF
 	}
 
+	f.readDeadline.close()
+	f.writeDeadline.close()
+
 	err := f.ioc.poller.Del(&f.slot)
 	if err != nil {
 		return err
@@ -254,20 +362,28 @@ func (f *file) Cancel() {
 }
 
 func (f *file) cancelReads() {
+	f.abortReads(sonicerrors.ErrCancelled)
+}
+
+func (f *file) cancelWrites() {
+	f.abortWrites(sonicerrors.ErrCancelled)
+}
+
+// abortReads completes the pending read, if any, with err.
+func (f *file) abortReads(err error) {
 	if f.slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
-		err := f.ioc.poller.DelRead(&f.slot)
-		if err == nil {
-			err = sonicerrors.ErrCancelled
+		if derr := f.ioc.poller.DelRead(&f.slot); derr != nil {
+			err = derr
 		}
 		f.slot.Handlers[internal.ReadEvent](err)
 	}
 }
 
-func (f *file) cancelWrites() {
+// abortWrites completes the pending write, if any, with err.
+func (f *file) abortWrites(err error) {
 	if f.slot.Events&internal.PollerWriteEvent == internal.PollerWriteEvent {
-		err := f.ioc.poller.DelWrite(&f.slot)
-		if err == nil {
-			err = sonicerrors.ErrCancelled
+		if derr := f.ioc.poller.DelWrite(&f.slot); derr != nil {
+			err = derr
 		}
 		f.slot.Handlers[internal.WriteEvent](err)
 	}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The runtime's monotonic clock is CLOCK_MONOTONIC on Linux but reading it goes through the vDSO, unlike
+// unix.ClockGettime, which makes a syscall. So CLOCK_MONOTONIC is read once and then advanced with the runtime's clock.
+var (
+	monoEpoch      = time.Now()
+	monoEpochNanos = clockMonotonic()
+)
+
+func clockMonotonic() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		panic(err)
+	}
+	return ts.Nano()
+}
+
+// ClockNow returns the current time, in nanoseconds, of CLOCK_REALTIME if realtime is true, or of CLOCK_MONOTONIC
+// otherwise. Deadlines passed to ITimer.SetAt are expressed on the same clock.
+func ClockNow(realtime bool) int64 {
+	if realtime {
+		return time.Now().UnixNano()
+	}
+	return monoEpochNanos + int64(time.Since(monoEpoch))
+}
+
+// ClockAt converts t to nanoseconds of CLOCK_REALTIME if realtime is true, or of CLOCK_MONOTONIC otherwise, without
+// reading the clock. Monotonic conversions are only exact if t carries a monotonic reading, like the values returned by
+// time.Now.
+func ClockAt(realtime bool, t time.Time) int64 {
+	if realtime {
+		return t.UnixNano()
+	}
+	return monoEpochNanos + int64(t.Sub(monoEpoch))
+}
@@ -25,14 +25,62 @@ type Slot struct {
 	// Callbacks registered with this Slot. The poller dispatches the appropriate read or write callback when it
 	// receives an event that's in Events.
 	Handlers [MaxEvent]Handler
+
+	// Persistent slots stay registered with the Poller from the first SetRead/SetWrite until Del, instead of being
+	// added and removed on every event. On Linux, the registration is one-shot and rearmed on demand, which saves an
+	// epoll_ctl call per event. Callers must set it up at construction time and must call Del before closing Fd.
+	//
+	// Pollers that do not need it, like kqueue and io_uring, ignore it.
+	Persistent bool
+
+	// registered is true if Fd is in the Poller's interest list. Only used by persistent slots.
+	registered bool
+
+	// armed are the events the registration of a persistent slot can currently report. It might be a superset of
+	// Events, as deregistering interest in an event is lazy for persistent slots.
+	armed PollerEvent
+
+	// requests are the user_data of the pending io_uring requests of the slot, indexed by their read or write tag.
+	// Only used by the io_uring Poller.
+	requests [2]uint64
+
+	// results are the number of bytes transferred by the last read and write submitted to a Submitter, indexed by
+	// event type.
+	results [MaxEvent]int
 }
 
 func (s *Slot) Set(et EventType, h Handler) {
 	s.Handlers[et] = h
 }
 
+// Result returns the number of bytes transferred by the last read or write submitted to a Submitter for the slot. It
+// is only meaningful in the handler of et, when it is called without an error.
+func (s *Slot) Result(et EventType) int {
+	return s.results[et]
+}
+
+// Submitter is implemented by Pollers which make reads and writes themselves, like io_uring, instead of only reporting
+// readiness.
+type Submitter interface {
+	// SubmitRead registers interest in read events on the provided slot, like SetRead, and reads into b once it is
+	// readable. The read handler of the slot is called once the read completes, see Slot.Result.
+	//
+	// b must not be used until then. If the read is cancelled with DelRead or Del, it might still have read some data,
+	// which is lost.
+	SubmitRead(slot *Slot, b []byte) error
+
+	// SubmitWrite is like SubmitRead, but writes b once the slot is writable.
+	SubmitWrite(slot *Slot, b []byte) error
+}
+
 type ITimer interface {
-	Set(time.Duration, func()) error
+	// Set arms the timer to call the callback once, after the duration. The callback gets the error of the poller if
+	// it fails to wait for the timer.
+	Set(time.Duration, func(error)) error
+
+	// SetAt arms the timer to call the callback once its clock reaches the deadline, in nanoseconds. See ClockNow.
+	SetAt(int64, func(error)) error
+
 	Unset() error
 	Close() error
 }
@@ -55,6 +103,11 @@ type Poller interface {
 	// Post is safe for concurrent use.
 	Post(func()) error
 
+	// Wake makes the Poller return from a blocking Poll call, if any, without dispatching anything.
+	//
+	// Wake is safe for concurrent use.
+	Wake() error
+
 	// Posted returns the number of handlers registered with Post.
 	//
 	// Posted is safe for concurrent use.
@@ -75,6 +128,9 @@ type Poller interface {
 	// Del deregisters interest in all events on the provided slot.
 	Del(slot *Slot) error
 
+	// SetMonitor makes the Poller report to the provided PollMonitor. A nil PollMonitor disables reporting.
+	SetMonitor(PollMonitor)
+
 	// Close closes the Poller. No calls to Poll should be made after Close.
 	//
 	// Close is safe for concurrent use.
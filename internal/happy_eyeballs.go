@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// ConnectHappyEyeballs connects to the TCP endpoint addr like ConnectTimeout, but races the IPv6 and IPv4 addresses of
+// its host as described by RFC 8305, starting connection attempts delay apart. remoteAddr is the address which won.
+//
+// network must be tcp, tcp4 or tcp6. The latter two restrict the race to one family.
+func ConnectHappyEyeballs(
+	network, addr string,
+	timeout, delay time.Duration,
+	opts ...sonicopts.Option,
+) (fd int, localAddr, remoteAddr net.Addr, err error) {
+	deadline := time.Now().Add(timeout)
+
+	candidates, err := resolveCandidates(network, addr, deadline)
+	if err != nil {
+		return -1, nil, nil, err
+	}
+
+	fd, remoteAddr, err = connectRace(candidates, deadline, delay, opts...)
+	if err != nil {
+		return -1, nil, nil, err
+	}
+
+	localAddr, err = SocketAddress(fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, nil, err
+	}
+	return fd, localAddr, remoteAddr, nil
+}
+
+func resolveCandidates(network, addr string, deadline time.Time) ([]*net.TCPAddr, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errUnknownNetwork
+	}
+
+	host, service, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	port, err := net.DefaultResolver.LookupPort(ctx, network, service)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := interleave(network, ips, port)
+	if len(candidates) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	return candidates, nil
+}
+
+// interleave orders the addresses alternating between IPv6 and IPv4, IPv6 first, and otherwise in the order given by
+// the resolver, see RFC 8305 section 4.
+func interleave(network string, ips []net.IPAddr, port int) (candidates []*net.TCPAddr) {
+	var v6, v4 []*net.TCPAddr
+	for _, ip := range ips {
+		addr := &net.TCPAddr{IP: ip.IP, Port: port, Zone: ip.Zone}
+		if ip.IP.To4() != nil {
+			if network != "tcp6" {
+				v4 = append(v4, addr)
+			}
+		} else if network != "tcp4" {
+			v6 = append(v6, addr)
+		}
+	}
+
+	for len(v6) > 0 || len(v4) > 0 {
+		if len(v6) > 0 {
+			candidates = append(candidates, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			candidates = append(candidates, v4[0])
+			v4 = v4[1:]
+		}
+	}
+	return candidates
+}
+
+// connectRace connects to the candidates in order, starting the next attempt once delay passes without the pending
+// ones succeeding, or as soon as one fails. It returns the first connection established and closes the others.
+func connectRace(
+	candidates []*net.TCPAddr,
+	deadline time.Time,
+	delay time.Duration,
+	opts ...sonicopts.Option,
+) (fd int, remoteAddr net.Addr, err error) {
+	var (
+		pending []int
+		addrs   []*net.TCPAddr
+		fds     []unix.PollFd
+		next    = 0
+		nextAt  time.Time
+	)
+	defer func() {
+		for _, pfd := range pending {
+			if pfd != fd {
+				_ = syscall.Close(pfd)
+			}
+		}
+	}()
+
+	won := func(i int) (int, net.Addr, error) {
+		fd := pending[i]
+		if err := restoreNonblocking(fd, opts...); err != nil {
+			return -1, nil, err
+		}
+		return fd, addrs[i], nil
+	}
+
+	for {
+		now := time.Now()
+		if !now.Before(deadline) {
+			return -1, nil, sonicerrors.ErrTimeout
+		}
+
+		if next < len(candidates) && (len(pending) == 0 || !now.Before(nextAt)) {
+			addr := candidates[next]
+			next++
+			nextAt = now.Add(delay)
+
+			cfd, inProgress, cerr := startConnect(addr, opts...)
+			if cerr != nil {
+				// The attempt failed right away, so the next one starts right away too, see RFC 8305 section 5.
+				err = cerr
+				nextAt = now
+				continue
+			}
+			pending = append(pending, cfd)
+			addrs = append(addrs, addr)
+			if !inProgress {
+				return won(len(pending) - 1)
+			}
+			continue
+		}
+
+		if len(pending) == 0 {
+			return -1, nil, err
+		}
+
+		wait := deadline.Sub(now)
+		if next < len(candidates) && nextAt.Sub(now) < wait {
+			wait = nextAt.Sub(now)
+		}
+
+		fds = fds[:0]
+		for _, pfd := range pending {
+			fds = append(fds, unix.PollFd{Fd: int32(pfd), Events: unix.POLLOUT})
+		}
+		// Round up such that poll does not return before the deadline.
+		n, perr := unix.Poll(fds, int((wait+time.Millisecond-1)/time.Millisecond))
+		if perr != nil {
+			if perr == syscall.EINTR {
+				continue
+			}
+			return -1, nil, os.NewSyscallError("poll", perr)
+		}
+		if n == 0 {
+			continue
+		}
+
+		for i := len(fds) - 1; i >= 0; i-- {
+			if fds[i].Revents == 0 {
+				continue
+			}
+
+			errno, gerr := syscall.GetsockoptInt(pending[i], syscall.SOL_SOCKET, syscall.SO_ERROR)
+			if gerr == nil && errno == 0 {
+				return won(i)
+			}
+			if gerr != nil {
+				err = os.NewSyscallError("getsockopt", gerr)
+			} else {
+				err = os.NewSyscallError("connect", syscall.Errno(errno))
+			}
+
+			_ = syscall.Close(pending[i])
+			pending = append(pending[:i], pending[i+1:]...)
+			addrs = append(addrs[:i], addrs[i+1:]...)
+
+			// Start the next attempt right away.
+			nextAt = time.Now()
+		}
+	}
+}
+
+// startConnect starts connecting a nonblocking socket to addr.
+func startConnect(addr *net.TCPAddr, opts ...sonicopts.Option) (fd int, inProgress bool, err error) {
+	domain := syscall.AF_INET
+	if addr.IP.To4() == nil {
+		domain = syscall.AF_INET6
+	}
+
+	fd, err = socket(domain, syscall.SOCK_STREAM, 0, true)
+	if err != nil {
+		return -1, false, err
+	}
+
+	inProgress, err = connectAsync(fd, addr, opts...)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return -1, false, err
+	}
+	return fd, inProgress, nil
+}
+
+// restoreNonblocking applies sonicopts.Nonblocking, if given, once the connection is established. Connection attempts
+// are always nonblocking such that they can race.
+func restoreNonblocking(fd int, opts ...sonicopts.Option) error {
+	for _, opt := range opts {
+		if opt.Type() == sonicopts.TypeNonblocking {
+			v := opt.Value().(bool)
+			if err := syscall.SetNonblock(fd, v); err != nil {
+				return os.NewSyscallError("set_nonblock", err)
+			}
+		}
+	}
+	return nil
+}
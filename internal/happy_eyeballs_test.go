@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestHappyEyeballsInterleave(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("10.0.0.3")},
+		{IP: net.ParseIP("::1")},
+		{IP: net.ParseIP("::2")},
+	}
+
+	expect := func(network string, want ...string) {
+		t.Helper()
+
+		candidates := interleave(network, ips, 80)
+		if len(candidates) != len(want) {
+			t.Fatalf("network=%s expected %v, got %v", network, want, candidates)
+		}
+		for i, addr := range candidates {
+			if addr.String() != want[i] {
+				t.Fatalf("network=%s expected %v, got %v", network, want, candidates)
+			}
+		}
+	}
+
+	expect("tcp", "[::1]:80", "10.0.0.1:80", "[::2]:80", "10.0.0.2:80", "10.0.0.3:80")
+	expect("tcp4", "10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80")
+	expect("tcp6", "[::1]:80", "[::2]:80")
+}
+
+// blackhole returns a listener on addr whose accept queue is full, such that new connections to it never complete.
+func blackhole(t *testing.T, addr *net.TCPAddr) (close func()) {
+	domain := syscall.AF_INET
+	if addr.IP.To4() == nil {
+		domain = syscall.AF_INET6
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Bind(fd, ToSockaddr(addr)); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []net.Conn
+	close = func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		syscall.Close(fd)
+	}
+	for i := 0; i < 8; i++ {
+		conn, err := net.DialTimeout("tcp", addr.String(), 100*time.Millisecond)
+		if err != nil {
+			return close
+		}
+		conns = append(conns, conn)
+	}
+	close()
+	t.Fatalf("could not fill the accept queue of %s", addr)
+	return nil
+}
+
+func TestHappyEyeballsStaggered(t *testing.T) {
+	v6 := &net.TCPAddr{IP: net.IPv6loopback, Port: 8103}
+	v4 := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8103}
+
+	closeBlackhole := blackhole(t, v6)
+	defer closeBlackhole()
+
+	ln, err := net.ListenTCP("tcp4", v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	delay := 50 * time.Millisecond
+	start := time.Now()
+	fd, remoteAddr, err := connectRace([]*net.TCPAddr{v6, v4}, start.Add(5*time.Second), delay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+
+	if remoteAddr.String() != v4.String() {
+		t.Fatalf("expected %s to win, got %s", v4, remoteAddr)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("expected the IPv4 attempt to start after %s, took %s", delay, elapsed)
+	}
+}
+
+func TestHappyEyeballsFailover(t *testing.T) {
+	// Nothing listens on the IPv6 address, so the IPv4 attempt starts as soon as the IPv6 one is refused.
+	v6 := &net.TCPAddr{IP: net.IPv6loopback, Port: 8104}
+	v4 := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8104}
+
+	ln, err := net.ListenTCP("tcp4", v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	delay := 5 * time.Second
+	start := time.Now()
+	fd, remoteAddr, err := connectRace([]*net.TCPAddr{v6, v4}, start.Add(2*delay), delay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+
+	if remoteAddr.String() != v4.String() {
+		t.Fatalf("expected %s to win, got %s", v4, remoteAddr)
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Fatalf("expected the IPv4 attempt to start once the IPv6 one failed, took %s", elapsed)
+	}
+}
+
+func TestHappyEyeballsTimeout(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8105}
+
+	closeBlackhole := blackhole(t, addr)
+	defer closeBlackhole()
+
+	_, _, err := connectRace([]*net.TCPAddr{addr}, time.Now().Add(50*time.Millisecond), time.Second)
+	if !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected the race to time out err=%v", err)
+	}
+}
+
+func TestHappyEyeballsImmediateFailure(t *testing.T) {
+	// The first attempt never completes. The second fails right away since TCP cannot connect to a multicast address,
+	// so the third starts right after it instead of a delay later.
+	pending := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8106}
+	failing := &net.TCPAddr{IP: net.IPv4(224, 0, 0, 1), Port: 8106}
+	v4 := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 8106}
+
+	closeBlackhole := blackhole(t, pending)
+	defer closeBlackhole()
+
+	if fd, _, err := startConnect(failing); err == nil {
+		syscall.Close(fd)
+		t.Skipf("connecting to %s does not fail right away", failing)
+	}
+
+	ln, err := net.ListenTCP("tcp4", v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	delay := time.Second
+	start := time.Now()
+	fd, remoteAddr, err := connectRace([]*net.TCPAddr{pending, failing, v4}, start.Add(5*delay), delay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+
+	if remoteAddr.String() != v4.String() {
+		t.Fatalf("expected %s to win, got %s", v4, remoteAddr)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Fatalf("expected the third attempt to start right after the second failed, took %s", elapsed)
+	}
+}
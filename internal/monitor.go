@@ -0,0 +1,57 @@
+package internal
+
+import "time"
+
+// PollMonitor is notified by a Poller about the time it spends waiting for events and dispatching handlers.
+type PollMonitor interface {
+	// OnWait is called after the Poller stops waiting for events, with the time it waited and the number of events it
+	// is about to dispatch.
+	OnWait(waited time.Duration, events int)
+
+	// OnHandler is called after the Poller dispatches a handler, with the time the handler took.
+	OnHandler(took time.Duration)
+}
+
+// monitored is embedded by Pollers which can report to a PollMonitor. All methods are cheap if no PollMonitor is set.
+type monitored struct {
+	monitor PollMonitor
+}
+
+func (m *monitored) SetMonitor(monitor PollMonitor) {
+	m.monitor = monitor
+}
+
+func (m *monitored) waitStart() (start time.Time) {
+	if m.monitor != nil {
+		start = time.Now()
+	}
+	return
+}
+
+func (m *monitored) waitEnd(start time.Time, events int) {
+	if m.monitor != nil {
+		m.monitor.OnWait(time.Since(start), events)
+	}
+}
+
+func (m *monitored) call(handler Handler, err error) {
+	if m.monitor == nil {
+		handler(err)
+		return
+	}
+
+	start := time.Now()
+	handler(err)
+	m.monitor.OnHandler(time.Since(start))
+}
+
+func (m *monitored) callPost(handler func()) {
+	if m.monitor == nil {
+		handler()
+		return
+	}
+
+	start := time.Now()
+	handler()
+	m.monitor.OnHandler(time.Since(start))
+}
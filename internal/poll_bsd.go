@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -41,6 +40,9 @@ type poller struct {
 	// fd is the file descriptor returned by calling kqueue().
 	fd int
 
+	// monitored reports to an optional PollMonitor.
+	monitored
+
 	// changes contains events we want to watch for
 	changes []syscall.Kevent_t
 
@@ -53,17 +55,11 @@ type poller struct {
 	// The read end of the pipe is registered for reads with kqueue.
 	waker *Pipe
 
-	// posts maintains the posts set by the client to be
-	// executed in the poller's goroutine. Adding a handler
-	// entails writing a single byte to the write end of the wakeupPipe.
-	posts []func()
-
-	// lck synchronizes access to the handlers slice.
-	// This is needed because multiple goroutines can call ioc.Post(...)
-	// on the same IO object.
-	lck sync.Mutex
+	// postQueue holds the handlers scheduled with Post.
+	postQueue
 
-	// pending is the number of pending handlers the poller needs to execute
+	// pending is the number of events registered with SetRead/SetWrite which did not occur yet. It is only touched
+	// in the poller's goroutine. Handlers scheduled with Post are accounted for in postQueue.
 	pending int64
 
 	// closed is true if the close() has been called on fd
@@ -101,10 +97,11 @@ func NewPoller() (Poller, error) {
 	}
 
 	p := &poller{
-		waker:   pipe,
-		fd:      kqueueFd,
-		changes: make([]syscall.Kevent_t, 0, 128),
-		events:  make([]syscall.Kevent_t, 128),
+		waker:     pipe,
+		fd:        kqueueFd,
+		changes:   make([]syscall.Kevent_t, 0, 128),
+		events:    make([]syscall.Kevent_t, 128),
+		postQueue: newPostQueue(),
 	}
 
 	err = p.setRead(p.waker.ReadFd(), syscall.EV_ADD, &p.waker.slot)
@@ -118,8 +115,13 @@ func NewPoller() (Poller, error) {
 	return p, nil
 }
 
+// NewUringPoller is only supported on Linux.
+func NewUringPoller() (Poller, error) {
+	return nil, errors.New("io_uring is only supported on linux")
+}
+
 func (p *poller) Pending() int64 {
-	return p.pending
+	return p.pending + int64(p.Posted())
 }
 
 func (p *poller) Close() error {
@@ -136,10 +138,7 @@ func (p *poller) Closed() bool {
 }
 
 func (p *poller) Post(handler func()) error {
-	p.lck.Lock()
-	p.posts = append(p.posts, handler)
-	p.pending++
-	p.lck.Unlock()
+	p.push(handler)
 
 	// Concurrent writes are thread safe for pipes if less
 	// than 512 bytes are written.
@@ -147,11 +146,9 @@ func (p *poller) Post(handler func()) error {
 	return err
 }
 
-func (p *poller) Posted() int {
-	p.lck.Lock()
-	defer p.lck.Unlock()
-
-	return len(p.posts)
+func (p *poller) Wake() error {
+	_, err := p.waker.Write(oneByte[:])
+	return err
 }
 
 func (p *poller) Poll(timeoutMs int) (n int, err error) {
@@ -164,7 +161,14 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 	changelist := p.changes
 	p.changes = p.changes[:0]
 
+	start := p.waitStart()
 	n, err = syscall.Kevent(p.fd, changelist, p.events, timeout)
+	// Interrupted waits count as waiting too. n is -1 if kevent failed.
+	events := n
+	if events < 0 {
+		events = 0
+	}
+	p.waitEnd(start, events)
 
 	if err != nil {
 		return n, err
@@ -196,19 +200,46 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 		if events&slot.Events&PollerReadEvent == PollerReadEvent {
 			p.pending--
 			slot.Events ^= PollerReadEvent
-			slot.Handlers[ReadEvent](nil)
+			p.call(slot.Handlers[ReadEvent], pollError(event))
 		}
 
 		if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
 			p.pending--
 			slot.Events ^= PollerWriteEvent
-			slot.Handlers[WriteEvent](nil)
+			p.call(slot.Handlers[WriteEvent], pollError(event))
 		}
 	}
 
 	return n, nil
 }
 
+// pollError translates the flags kqueue set on event into the error delivered to the pending handler. A nil error means
+// the handler should retry its operation, which then either makes progress or fails with the appropriate error.
+func pollError(event *syscall.Kevent_t) error {
+	if event.Flags&syscall.EV_ERROR == syscall.EV_ERROR && event.Data != 0 {
+		return &sonicerrors.SocketError{Errno: syscall.Errno(event.Data)}
+	}
+
+	if event.Flags&syscall.EV_EOF != syscall.EV_EOF {
+		return nil
+	}
+
+	if event.Fflags != 0 {
+		// The pending socket error, e.g. ECONNRESET.
+		return &sonicerrors.SocketError{Errno: syscall.Errno(event.Fflags)}
+	}
+
+	if -PollerEvent(event.Filter) == PollerWriteEvent {
+		return &sonicerrors.SocketError{Errno: syscall.EPIPE}
+	}
+
+	if event.Data > 0 {
+		// The peer shut down its write side but there is still something to read.
+		return nil
+	}
+	return sonicerrors.ErrPeerHalfClosed
+}
+
 func (p *poller) executePost() {
 	for {
 		_, err := p.waker.Read(oneByte[:])
@@ -217,13 +248,7 @@ func (p *poller) executePost() {
 		}
 	}
 
-	p.lck.Lock()
-	for _, handler := range p.posts {
-		handler()
-		p.pending--
-	}
-	p.posts = p.posts[:0]
-	p.lck.Unlock()
+	p.runPosts(p.callPost)
 }
 
 func (p *poller) SetRead(slot *Slot) error {
@@ -275,7 +300,7 @@ func (p *poller) Del(slot *Slot) error {
 	if err == nil {
 		return p.DelWrite(slot)
 	}
-	return nil
+	return err
 }
 
 func (p *poller) set(fd int, ev syscall.Kevent_t) error {
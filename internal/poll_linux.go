@@ -7,12 +7,12 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"unsafe"
 
 	"github.com/talostrading/sonic/sonicerrors"
+	"golang.org/x/sys/unix"
 )
 
 type PollerEvent uint32
@@ -20,6 +20,11 @@ type PollerEvent uint32
 const (
 	PollerReadEvent  = PollerEvent(syscall.EPOLLIN)
 	PollerWriteEvent = PollerEvent(syscall.EPOLLOUT)
+
+	// The following are reported by epoll but never set in a Slot's event mask.
+	pollerErrorEvent      = PollerEvent(syscall.EPOLLERR)
+	pollerHangupEvent     = PollerEvent(syscall.EPOLLHUP)
+	pollerPeerHangupEvent = PollerEvent(syscall.EPOLLRDHUP)
 )
 
 func init() {
@@ -40,6 +45,10 @@ type Event struct {
 
 func createEvent(event PollerEvent, slot *Slot) Event {
 	ev := Event{Mask: uint32(event)}
+	if event&PollerReadEvent == PollerReadEvent {
+		// Learn about the peer shutting down its write side without having to issue another read.
+		ev.Mask |= uint32(pollerPeerHangupEvent)
+	}
 	/* #nosec G103 -- the use of unsafe has been audited */
 	*(**Slot)(unsafe.Pointer(&ev.Data)) = slot
 	return ev
@@ -51,6 +60,9 @@ type poller struct {
 	// fd is the file descriptor returned by calling epoll_create1(0).
 	fd int
 
+	// monitored reports to an optional PollMonitor.
+	monitored
+
 	// events contains the events which occurred.
 	// events is a subset of changelist.
 	events []Event
@@ -60,17 +72,11 @@ type poller struct {
 	// The read end of the pipe is registered for reads with kqueue.
 	waker *EventFd
 
-	// posts maintains the posts set by the client to be
-	// executed in the poller's goroutine. Adding a handler
-	// entails writing a single byte to the write end of the wakeupPipe.
-	posts []func()
+	// postQueue holds the handlers scheduled with Post.
+	postQueue
 
-	// lck synchronizes access to the posts slice.
-	// This is needed because multiple goroutines can call ioc.Post(...)
-	// on the same IO object.
-	lck sync.Mutex
-
-	// pending is the number of pending posts the poller needs to execute
+	// pending is the number of events registered with SetRead/SetWrite which did not occur yet. It is only touched
+	// in the poller's goroutine. Handlers scheduled with Post are accounted for in postQueue.
 	pending int64
 
 	// closed is true if the close() has been called on fd
@@ -78,6 +84,9 @@ type poller struct {
 
 	// TODO proper waker interface
 	wakerBytes [8]byte
+
+	// ctls is the number of epoll_ctl calls made by the poller.
+	ctls uint64
 }
 
 func NewPoller() (Poller, error) {
@@ -93,9 +102,10 @@ func NewPoller() (Poller, error) {
 	}
 
 	p := &poller{
-		fd:     epollFd,
-		waker:  eventFd,
-		events: make([]Event, 128),
+		fd:        epollFd,
+		waker:     eventFd,
+		events:    make([]Event, 128),
+		postQueue: newPostQueue(),
 	}
 
 	err = p.SetRead(p.waker.Slot())
@@ -111,7 +121,7 @@ func NewPoller() (Poller, error) {
 }
 
 func (p *poller) Pending() int64 {
-	return p.pending
+	return p.pending + int64(p.Posted())
 }
 
 func (p *poller) Close() error {
@@ -128,24 +138,21 @@ func (p *poller) Closed() bool {
 }
 
 func (p *poller) Post(handler func()) error {
-	p.lck.Lock()
-	p.posts = append(p.posts, handler)
-	p.pending++
-	p.lck.Unlock()
+	p.push(handler)
 
 	// Concurrent writes are thread safe for eventfds.
 	_, err := p.waker.Write(1)
 	return err
 }
 
-func (p *poller) Posted() int {
-	p.lck.Lock()
-	defer p.lck.Unlock()
-
-	return len(p.posts)
+func (p *poller) Wake() error {
+	_, err := p.waker.Write(1)
+	return err
 }
 
 func (p *poller) Poll(timeoutMs int) (n int, err error) {
+	start := p.waitStart()
+
 	/* #nosec G103 -- the use of unsafe has been audited */
 	nn, _, errno := syscall.Syscall6(
 		syscall.SYS_EPOLL_WAIT,
@@ -157,6 +164,13 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 	)
 	n = int(nn)
 
+	// Interrupted waits count as waiting too. n is -1 if epoll_wait failed.
+	events := n
+	if events < 0 {
+		events = 0
+	}
+	p.waitEnd(start, events)
+
 	if errno != 0 {
 		err = errno // we need to convert
 	}
@@ -186,22 +200,106 @@ func (p *poller) Poll(timeoutMs int) (n int, err error) {
 			continue
 		}
 
+		if slot.Persistent {
+			// The registration is one-shot so the kernel disarmed it.
+			slot.armed = 0
+		}
+
+		var readErr, writeErr error
+		if slot.Events != 0 && events&(pollerErrorEvent|pollerHangupEvent|pollerPeerHangupEvent) != 0 {
+			readErr, writeErr = pollErrors(slot.Fd, events)
+			events |= PollerReadEvent
+			if events&(pollerErrorEvent|pollerHangupEvent) != 0 {
+				events |= PollerWriteEvent
+			}
+		}
+
 		if events&slot.Events&PollerReadEvent == PollerReadEvent {
-			// TODO this errors should be reported
-			_ = p.DelRead(slot)
-			slot.Handlers[ReadEvent](nil)
+			err := p.clear(slot, PollerReadEvent)
+			if readErr != nil {
+				err = readErr
+			}
+			p.call(slot.Handlers[ReadEvent], err)
 		}
 
 		if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
-			// TODO this errors should be reported
-			_ = p.DelWrite(slot)
-			slot.Handlers[WriteEvent](nil)
+			err := p.clear(slot, PollerWriteEvent)
+			if writeErr != nil {
+				err = writeErr
+			}
+			p.call(slot.Handlers[WriteEvent], err)
+		}
+
+		if slot.Persistent && slot.registered && slot.Events&^slot.armed != 0 {
+			// Some of the interest did not fire and no handler rearmed it.
+			p.rearm(slot)
 		}
 	}
 
 	return n, nil
 }
 
+// pollErrors translates the error conditions reported by the kernel for fd into the errors delivered to the pending read
+// and write handlers. A nil error means the handler should retry its operation, which then either makes progress or
+// fails with the appropriate error.
+func pollErrors(fd int, events PollerEvent) (readErr, writeErr error) {
+	if events&pollerErrorEvent == pollerErrorEvent {
+		// The error is nil if fd is not a socket, in which case the next read/write reports it.
+		err := SocketError(fd)
+		return err, err
+	}
+
+	if events&pollerHangupEvent == pollerHangupEvent {
+		// Both directions are shut down. Reads drain whatever is left and then see EOF. Writes cannot succeed.
+		return nil, &sonicerrors.SocketError{Errno: syscall.EPIPE}
+	}
+
+	// The peer shut down its write side. Let the read drain whatever is left, if anything.
+	if n, err := unix.IoctlGetInt(fd, unix.TIOCINQ); err == nil && n > 0 {
+		return nil, nil
+	}
+	return sonicerrors.ErrPeerHalfClosed, nil
+}
+
+// clear removes the interest of slot in event after event occurred. Persistent slots stay registered.
+func (p *poller) clear(slot *Slot, event PollerEvent) error {
+	if slot.Persistent {
+		p.pending--
+		slot.Events ^= event
+		return nil
+	}
+	if event == PollerReadEvent {
+		return p.DelRead(slot)
+	}
+	return p.DelWrite(slot)
+}
+
+// rearm rearms the one-shot registration of a persistent slot with its current interest. If that fails, the error is
+// delivered to the pending handlers.
+func (p *poller) rearm(slot *Slot) {
+	err := p.modify(slot.Fd, createPersistentEvent(slot.Events, slot))
+	if err == nil {
+		slot.armed = slot.Events
+		return
+	}
+
+	if slot.Events&PollerReadEvent == PollerReadEvent {
+		p.pending--
+		slot.Events ^= PollerReadEvent
+		p.call(slot.Handlers[ReadEvent], err)
+	}
+	if slot.Events&PollerWriteEvent == PollerWriteEvent {
+		p.pending--
+		slot.Events ^= PollerWriteEvent
+		p.call(slot.Handlers[WriteEvent], err)
+	}
+}
+
+// Ctls returns the number of epoll_ctl calls made by the poller.
+func (p *poller) Ctls() uint64 {
+	return p.ctls
+}
+
 func (p *poller) dispatch() {
 	for {
 		_, err := p.waker.Read(p.wakerBytes[:])
@@ -210,13 +308,7 @@ func (p *poller) dispatch() {
 		}
 	}
 
-	p.lck.Lock()
-	for _, handler := range p.posts {
-		handler()
-		p.pending--
-	}
-	p.posts = p.posts[:0]
-	p.lck.Unlock()
+	p.runPosts(p.callPost)
 }
 
 func (p *poller) SetRead(slot *Slot) error {
@@ -228,6 +320,10 @@ func (p *poller) SetWrite(slot *Slot) error {
 }
 
 func (p *poller) setRW(fd int, slot *Slot, flag PollerEvent) error {
+	if slot.Persistent {
+		return p.setPersistent(slot, flag)
+	}
+
 	events := &slot.Events
 	if *events&flag != flag {
 		p.pending++
@@ -243,7 +339,44 @@ func (p *poller) setRW(fd int, slot *Slot, flag PollerEvent) error {
 	return nil
 }
 
+func (p *poller) setPersistent(slot *Slot, flag PollerEvent) error {
+	if slot.Events&flag == flag {
+		return nil
+	}
+
+	p.pending++
+	slot.Events |= flag
+
+	if slot.armed&flag == flag {
+		// Still armed from a previous registration of interest.
+		return nil
+	}
+
+	var err error
+	if slot.registered {
+		err = p.modify(slot.Fd, createPersistentEvent(slot.Events, slot))
+	} else {
+		err = p.add(slot.Fd, createPersistentEvent(slot.Events, slot))
+		slot.registered = err == nil
+	}
+	if err != nil {
+		p.pending--
+		slot.Events ^= flag
+		return err
+	}
+	slot.armed = slot.Events
+	return nil
+}
+
+func createPersistentEvent(event PollerEvent, slot *Slot) Event {
+	ev := createEvent(event, slot)
+	ev.Mask |= syscall.EPOLLONESHOT
+	return ev
+}
+
 func (p *poller) add(fd int, event Event) error {
+	p.ctls++
+
 	/* #nosec G103 -- the use of unsafe has been audited */
 	_, _, errno := syscall.Syscall6(
 		syscall.SYS_EPOLL_CTL,
@@ -260,6 +393,7 @@ func (p *poller) add(fd int, event Event) error {
 }
 
 func (p *poller) modify(fd int, event Event) error {
+	p.ctls++
 	/* #nosec G103 -- the use of unsafe has been audited */
 	_, _, errno := syscall.Syscall6(
 		syscall.SYS_EPOLL_CTL,
@@ -277,11 +411,22 @@ func (p *poller) modify(fd int, event Event) error {
 }
 
 func (p *poller) Del(slot *Slot) error {
+	if slot.Persistent {
+		_ = p.DelRead(slot)
+		_ = p.DelWrite(slot)
+		if !slot.registered {
+			return nil
+		}
+		slot.registered = false
+		slot.armed = 0
+		return p.del(slot.Fd)
+	}
+
 	err := p.DelRead(slot)
 	if err == nil {
 		return p.DelWrite(slot)
 	}
-	return nil
+	return err
 }
 
 func (p *poller) DelRead(slot *Slot) error {
@@ -289,6 +434,10 @@ func (p *poller) DelRead(slot *Slot) error {
 	if *events&PollerReadEvent == PollerReadEvent {
 		p.pending--
 		*events ^= PollerReadEvent
+		if slot.Persistent {
+			// Lazy: the registration stays armed and a spurious event, if any, is ignored.
+			return nil
+		}
 		if *events != 0 {
 			return p.modify(slot.Fd, createEvent(*events, slot))
 		}
@@ -302,6 +451,9 @@ func (p *poller) DelWrite(slot *Slot) error {
 	if *events&PollerWriteEvent == PollerWriteEvent {
 		p.pending--
 		*events ^= PollerWriteEvent
+		if slot.Persistent {
+			return nil
+		}
 		if *events != 0 {
 			return p.modify(slot.Fd, createEvent(*events, slot))
 		}
@@ -311,6 +463,7 @@ func (p *poller) DelWrite(slot *Slot) error {
 }
 
 func (p *poller) del(fd int) error {
+	p.ctls++
 	_, _, errno := syscall.Syscall6(
 		syscall.SYS_EPOLL_CTL,
 		uintptr(p.fd),
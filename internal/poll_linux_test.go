@@ -59,3 +59,139 @@ func BenchmarkSyscall(b *testing.B) {
 
 	b.ReportAllocs()
 }
+
+func countCtls(t *testing.T, persistent bool, n int) uint64 {
+	p, err := NewPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	pipe, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+	if err := pipe.SetReadNonblock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		slot  = Slot{Fd: pipe.ReadFd(), Persistent: persistent}
+		b     [1]byte
+		reads = 0
+	)
+	slot.Set(ReadEvent, func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pipe.Read(b[:]); err != nil {
+			t.Fatal(err)
+		}
+		reads++
+	})
+
+	start := p.(*poller).Ctls()
+	for i := 0; i < n; i++ {
+		if err := p.SetRead(&slot); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pipe.Write(b[:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Poll(-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads != n {
+		t.Fatalf("expected %d reads, got %d", n, reads)
+	}
+	if p.Pending() != 0 {
+		t.Fatalf("expected no pending events, got %d", p.Pending())
+	}
+
+	if err := p.Del(&slot); err != nil {
+		t.Fatal(err)
+	}
+	return p.(*poller).Ctls() - start
+}
+
+func TestPollerPersistentSlot(t *testing.T) {
+	// add + del per event
+	if ctls := countCtls(t, false, 10); ctls != 20 {
+		t.Fatalf("expected 20 epoll_ctl calls, got %d", ctls)
+	}
+
+	// add, then a rearm per event after the first, then del
+	if ctls := countCtls(t, true, 10); ctls != 11 {
+		t.Fatalf("expected 11 epoll_ctl calls, got %d", ctls)
+	}
+}
+
+func TestPollerPersistentSlotLazyDel(t *testing.T) {
+	p, err := NewPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	pipe, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+
+	fired := 0
+	slot := Slot{Fd: pipe.ReadFd(), Persistent: true}
+	slot.Set(ReadEvent, func(err error) {
+		fired++
+	})
+
+	if err := p.SetRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	ctls := p.(*poller).Ctls()
+
+	// Deregistering and registering interest again does not touch the kernel while the slot is armed.
+	if err := p.DelRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if p.Pending() != 0 {
+		t.Fatalf("expected no pending events, got %d", p.Pending())
+	}
+	if err := p.SetRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if p.(*poller).Ctls() != ctls {
+		t.Fatal("expected no epoll_ctl calls")
+	}
+
+	// A spurious event is ignored.
+	if err := p.DelRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pipe.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Poll(0); err != nil {
+		t.Fatal(err)
+	}
+	if fired != 0 {
+		t.Fatal("handler should not have been dispatched")
+	}
+
+	// The registration is rearmed on demand.
+	if err := p.SetRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Poll(0); err != nil {
+		t.Fatal(err)
+	}
+	if fired != 1 {
+		t.Fatal("handler should have been dispatched")
+	}
+
+	if err := p.Del(&slot); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,814 @@
+//go:build linux
+
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// io_uring constants, see include/uapi/linux/io_uring.h. golang.org/x/sys/unix does not export them yet.
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringFeatExtArg     = 1 << 8
+
+	ioringEnterGetEvents = 1 << 0
+	ioringEnterExtArg    = 1 << 3
+
+	ioringOpPollAdd     = 6
+	ioringOpPollRemove  = 7
+	ioringOpAsyncCancel = 14
+	ioringOpRead        = 22
+	ioringOpWrite       = 23
+
+	// UringEntries is the number of submission queue entries of an io_uring Poller. The completion queue is twice as
+	// big.
+	UringEntries = 256
+)
+
+// The user_data of a request identifies its entry in the request table of the poller, the generation of that entry and,
+// in the lowest bit, whether it is the read or the write request of its Slot:
+//
+//	| generation (32 bits) | index (31 bits) | tag (1 bit) |
+//
+// An entry moves to the next generation when its request completes or is removed. Completions which arrive after
+// that, for a request we removed or for a Slot which was reused since, do not match the generation of the entry and are
+// ignored. Unlike a raw Slot pointer, the table also keeps the Slots of pending requests alive.
+const (
+	uringReadTag  uint64 = 0
+	uringWriteTag uint64 = 1
+	uringTagMask  uint64 = 1
+
+	uringIndexMask uint64 = 1<<31 - 1
+
+	// uringIgnore is the user_data of submissions whose completion we are not interested in, like poll removals.
+	// Generations start at 1, so it is never the user_data of a request in the table.
+	uringIgnore uint64 = 0
+)
+
+// uringRequest is an entry of the request table of a uringPoller.
+type uringRequest struct {
+	slot *Slot // nil if the entry is free or if its request was cancelled
+	gen  uint32
+
+	// io is true for the read and write requests of a Submitter, and false for poll requests.
+	io bool
+
+	// buf is the buffer of a read or write request. The kernel might access it until the request completes, so the
+	// entry of a cancelled read or write request stays taken, without a slot, until then.
+	buf []byte
+}
+
+type uringSQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringCQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uringGetEventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	pad       uint32
+	ts        uint64
+}
+
+var (
+	_ Poller    = &uringPoller{}
+	_ Submitter = &uringPoller{}
+)
+
+// uringPoller is a Poller backed by io_uring. Read and write interest is expressed through one-shot IORING_OP_POLL_ADD
+// requests, so it has the exact same readiness semantics as the epoll backed poller. The difference is that
+// registering and deregistering interest does not cost a syscall: the requests are batched in the submission queue and
+// handed to the kernel in the next Poll, along with the wait for completions.
+//
+// As a Submitter, it also makes reads and writes with IORING_OP_READ and IORING_OP_WRITE requests, which complete once
+// the data is transferred instead of once the file is ready.
+type uringPoller struct {
+	// fd is the file descriptor returned by io_uring_setup.
+	fd int
+
+	// monitored reports to an optional PollMonitor.
+	monitored
+
+	// The memory shared with the kernel.
+	sqRing     []byte
+	cqRing     []byte
+	sqeMem     []byte
+	singleMmap bool
+
+	sqHead *uint32
+	sqTail *uint32
+	sqMask uint32
+	sqes   []uringSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []uringCQE
+
+	// toSubmit is the number of submission queue entries not yet handed to the kernel.
+	toSubmit uint32
+
+	// requests is the table of requests, see uringUserData. free holds the indices of its free entries.
+	requests []uringRequest
+	free     []uint32
+
+	// waker is used to wake up the process when the client calls ioc.Post(...), thus dispatching the provided handler.
+	waker      *EventFd
+	wakerBytes [8]byte
+
+	// postQueue holds the handlers scheduled with Post.
+	postQueue
+
+	// pending is the number of events registered with SetRead/SetWrite which did not occur yet. It is only touched
+	// in the poller's goroutine. Handlers scheduled with Post are accounted for in postQueue.
+	pending int64
+
+	// closed is true if the close() has been called on fd
+	closed uint32
+
+	// polling is the number of Poll calls in progress. The rings are unmapped once the poller is closed and no Poll
+	// uses them anymore, as Close might be called by a handler dispatched from Poll. unmapped guards against unmapping
+	// them twice.
+	polling  int32
+	unmapped uint32
+}
+
+// NewUringPoller creates a Poller backed by io_uring. It requires Linux 5.11 or newer.
+func NewUringPoller() (Poller, error) {
+	var params uringParams
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, UringEntries, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+
+	p := &uringPoller{fd: int(fd), postQueue: newPostQueue()}
+
+	if params.features&ioringFeatExtArg == 0 {
+		_ = syscall.Close(p.fd)
+		return nil, errors.New("io_uring: kernel does not support IORING_FEAT_EXT_ARG, Linux 5.11 or newer is required")
+	}
+
+	if err := p.mmap(&params); err != nil {
+		p.munmap()
+		_ = syscall.Close(p.fd)
+		return nil, err
+	}
+
+	waker, err := NewEventFd(true)
+	if err != nil {
+		p.munmap()
+		_ = syscall.Close(p.fd)
+		return nil, err
+	}
+	p.waker = waker
+	p.armWaker()
+
+	return p, nil
+}
+
+func (p *uringPoller) mmap(params *uringParams) (err error) {
+	sqSize := int(params.sqOff.array + params.sqEntries*4)
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+
+	if params.features&ioringFeatSingleMmap != 0 {
+		if cqSize > sqSize {
+			sqSize = cqSize
+		}
+	}
+
+	p.sqRing, err = syscall.Mmap(
+		p.fd, ioringOffSQRing, sqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return os.NewSyscallError("mmap sq_ring", err)
+	}
+
+	if params.features&ioringFeatSingleMmap != 0 {
+		p.cqRing = p.sqRing
+		p.singleMmap = true
+	} else {
+		p.cqRing, err = syscall.Mmap(
+			p.fd, ioringOffCQRing, cqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			return os.NewSyscallError("mmap cq_ring", err)
+		}
+	}
+
+	sqeSize := int(params.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	p.sqeMem, err = syscall.Mmap(
+		p.fd, ioringOffSQEs, sqeSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return os.NewSyscallError("mmap sqes", err)
+	}
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	{
+		p.sqHead = (*uint32)(unsafe.Pointer(&p.sqRing[params.sqOff.head]))
+		p.sqTail = (*uint32)(unsafe.Pointer(&p.sqRing[params.sqOff.tail]))
+		p.sqMask = *(*uint32)(unsafe.Pointer(&p.sqRing[params.sqOff.ringMask]))
+		p.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&p.sqeMem[0])), params.sqEntries)
+
+		// We always submit the entries in order, so the indirection array is the identity.
+		array := unsafe.Slice((*uint32)(unsafe.Pointer(&p.sqRing[params.sqOff.array])), params.sqEntries)
+		for i := range array {
+			array[i] = uint32(i)
+		}
+
+		p.cqHead = (*uint32)(unsafe.Pointer(&p.cqRing[params.cqOff.head]))
+		p.cqTail = (*uint32)(unsafe.Pointer(&p.cqRing[params.cqOff.tail]))
+		p.cqMask = *(*uint32)(unsafe.Pointer(&p.cqRing[params.cqOff.ringMask]))
+		p.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&p.cqRing[params.cqOff.cqes])), params.cqEntries)
+	}
+
+	return nil
+}
+
+func (p *uringPoller) munmap() {
+	if p.sqeMem != nil {
+		_ = syscall.Munmap(p.sqeMem)
+		p.sqeMem = nil
+	}
+	if p.cqRing != nil && !p.singleMmap {
+		_ = syscall.Munmap(p.cqRing)
+	}
+	p.cqRing = nil
+	if p.sqRing != nil {
+		_ = syscall.Munmap(p.sqRing)
+		p.sqRing = nil
+	}
+}
+
+func (p *uringPoller) Pending() int64 {
+	return p.pending + int64(p.Posted())
+}
+
+func (p *uringPoller) Close() error {
+	if !atomic.CompareAndSwapUint32(&p.closed, 0, 1) {
+		return io.EOF
+	}
+
+	_ = p.waker.Close()
+	err := syscall.Close(p.fd)
+	if atomic.LoadInt32(&p.polling) == 0 {
+		p.unmap()
+	}
+	return err
+}
+
+// donePolling is called when a Poll returns. It unmaps the rings if the poller was closed in the meantime.
+func (p *uringPoller) donePolling() {
+	if atomic.AddInt32(&p.polling, -1) == 0 && p.Closed() {
+		p.unmap()
+	}
+}
+
+func (p *uringPoller) unmap() {
+	if atomic.CompareAndSwapUint32(&p.unmapped, 0, 1) {
+		p.munmap()
+	}
+}
+
+func (p *uringPoller) Closed() bool {
+	return atomic.LoadUint32(&p.closed) == 1
+}
+
+func (p *uringPoller) Post(handler func()) error {
+	p.push(handler)
+
+	// Concurrent writes are thread safe for eventfds.
+	_, err := p.waker.Write(1)
+	return err
+}
+
+func (p *uringPoller) Wake() error {
+	_, err := p.waker.Write(1)
+	return err
+}
+
+// getSQE returns the next free submission queue entry. If the submission queue is full, the queued entries are handed
+// to the kernel first. The kernel might accept only some of them, so we keep submitting until an entry is free, and
+// give up with EBUSY if it accepts none.
+func (p *uringPoller) getSQE() (*uringSQE, error) {
+	if p.Closed() {
+		return nil, io.EOF
+	}
+
+	tail := *p.sqTail
+	for tail-atomic.LoadUint32(p.sqHead) >= uint32(len(p.sqes)) {
+		n, err := p.enter(0, 0, nil)
+		if err != nil {
+			return nil, os.NewSyscallError("io_uring_enter", err)
+		}
+		if n == 0 {
+			return nil, os.NewSyscallError("io_uring_enter", syscall.EBUSY)
+		}
+	}
+
+	sqe := &p.sqes[tail&p.sqMask]
+	*sqe = uringSQE{}
+	atomic.StoreUint32(p.sqTail, tail+1)
+	p.toSubmit++
+	return sqe, nil
+}
+
+func (p *uringPoller) pollAdd(fd int, events uint32, userData uint64) error {
+	sqe, err := p.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpPollAdd
+	sqe.fd = int32(fd)
+	sqe.opFlags = events
+	sqe.userData = userData
+	return nil
+}
+
+// pollRemove cancels the poll request identified by userData. Unlike additions, removals are submitted right away: a
+// pending poll request holds a reference to the polled file, so the file would otherwise outlive a close(2) until the
+// next Poll.
+func (p *uringPoller) pollRemove(userData uint64) error {
+	sqe, err := p.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpPollRemove
+	sqe.fd = -1
+	sqe.addr = userData
+	sqe.userData = uringIgnore
+
+	if _, err := p.enter(0, 0, nil); err != nil {
+		return os.NewSyscallError("io_uring_enter", err)
+	}
+	return nil
+}
+
+// submit queues a read or write request of b on fd.
+func (p *uringPoller) submit(opcode uint8, fd int, b []byte, userData uint64) error {
+	sqe, err := p.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = opcode
+	sqe.fd = int32(fd)
+	if len(b) > 0 {
+		/* #nosec G103 -- the use of unsafe has been audited */
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&b[0])))
+	}
+	sqe.len = uint32(len(b))
+	// Streams have no offsets, so we read from and write to the current position of the file.
+	sqe.off = ^uint64(0)
+	sqe.userData = userData
+	return nil
+}
+
+// asyncCancel cancels the read or write request identified by userData. Like pollRemove, it is submitted right away.
+func (p *uringPoller) asyncCancel(userData uint64) error {
+	sqe, err := p.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpAsyncCancel
+	sqe.fd = -1
+	sqe.addr = userData
+	sqe.userData = uringIgnore
+
+	if _, err := p.enter(0, 0, nil); err != nil {
+		return os.NewSyscallError("io_uring_enter", err)
+	}
+	return nil
+}
+
+func (p *uringPoller) armWaker() {
+	// This can only fail if the ring is broken, in which case the next Poll reports the error.
+	userData := p.addRequest(p.waker.Slot(), uringReadTag)
+	if err := p.pollAdd(p.waker.Fd(), uint32(PollerReadEvent), userData); err != nil {
+		p.releaseRequest(userData)
+	}
+}
+
+// addRequest takes a free entry of the request table for the request of slot with tag and returns the user_data of the
+// request.
+func (p *uringPoller) addRequest(slot *Slot, tag uint64) uint64 {
+	var index uint32
+	if n := len(p.free); n > 0 {
+		index = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else {
+		index = uint32(len(p.requests))
+		p.requests = append(p.requests, uringRequest{gen: 1})
+	}
+
+	req := &p.requests[index]
+	req.slot = slot
+	userData := uringUserData(index, req.gen, tag)
+	slot.requests[tag] = userData
+	return userData
+}
+
+// request returns the entry of the request identified by userData, or nil if the request completed or was removed
+// already.
+func (p *uringPoller) request(userData uint64) *uringRequest {
+	index := uringIndex(userData)
+	if index >= uint32(len(p.requests)) {
+		return nil
+	}
+	req := &p.requests[index]
+	if req.gen != uint32(userData>>32) {
+		return nil
+	}
+	return req
+}
+
+// releaseRequest frees the entry of the request identified by userData and moves it to the next generation, such that
+// the completion of the request, if any, is ignored.
+func (p *uringPoller) releaseRequest(userData uint64) {
+	index := uringIndex(userData)
+	req := &p.requests[index]
+	req.slot = nil
+	req.io = false
+	req.buf = nil
+	req.gen++
+	if req.gen == 0 {
+		req.gen = 1
+	}
+	p.free = append(p.free, index)
+}
+
+func uringUserData(index, gen uint32, tag uint64) uint64 {
+	return uint64(gen)<<32 | uint64(index)<<1 | tag
+}
+
+func uringIndex(userData uint64) uint32 {
+	return uint32((userData >> 1) & uringIndexMask)
+}
+
+// enter submits the queued entries and optionally waits for at least minComplete completions.
+func (p *uringPoller) enter(minComplete uint32, flags uintptr, arg *uringGetEventsArg) (int, error) {
+	var (
+		argPtr uintptr
+		argSz  uintptr
+	)
+	if arg != nil {
+		flags |= ioringEnterExtArg
+		/* #nosec G103 -- the use of unsafe has been audited */
+		argPtr = uintptr(unsafe.Pointer(arg))
+		argSz = unsafe.Sizeof(*arg)
+	}
+
+	/* #nosec G103 -- the use of unsafe has been audited */
+	n, _, errno := syscall.Syscall6(
+		sysIOUringEnter,
+		uintptr(p.fd),
+		uintptr(p.toSubmit),
+		uintptr(minComplete),
+		flags,
+		argPtr,
+		argSz,
+	)
+	if errno != 0 {
+		return 0, errno
+	}
+	p.toSubmit -= uint32(n)
+	return int(n), nil
+}
+
+func (p *uringPoller) Poll(timeoutMs int) (n int, err error) {
+	atomic.AddInt32(&p.polling, 1)
+	defer p.donePolling()
+
+	if p.Closed() {
+		return 0, io.EOF
+	}
+
+	ready := atomic.LoadUint32(p.cqTail) != *p.cqHead
+
+	start := p.waitStart()
+	switch {
+	case ready || timeoutMs == 0:
+		if p.toSubmit > 0 {
+			_, err = p.enter(0, 0, nil)
+		}
+	case timeoutMs < 0:
+		_, err = p.enter(1, ioringEnterGetEvents, nil)
+	default:
+		ts := syscall.NsecToTimespec(int64(timeoutMs) * 1e6)
+		/* #nosec G103 -- the use of unsafe has been audited */
+		arg := uringGetEventsArg{ts: uint64(uintptr(unsafe.Pointer(&ts)))}
+		_, err = p.enter(1, ioringEnterGetEvents, &arg)
+	}
+
+	// Interrupted waits count as waiting too.
+	p.waitEnd(start, int(atomic.LoadUint32(p.cqTail)-*p.cqHead))
+
+	// ETIME means the wait timed out and EBUSY means the completion queue overflowed. In both cases, we reap whatever
+	// completions are available.
+	if err != nil && err != syscall.ETIME && err != syscall.EBUSY {
+		return 0, err
+	}
+
+	n = p.reap()
+
+	if n == 0 && timeoutMs >= 0 {
+		return 0, sonicerrors.ErrTimeout
+	}
+
+	return n, nil
+}
+
+func (p *uringPoller) reap() (n int) {
+	head := *p.cqHead
+	for head != atomic.LoadUint32(p.cqTail) {
+		cqe := p.cqes[head&p.cqMask]
+		head++
+		atomic.StoreUint32(p.cqHead, head)
+
+		if cqe.userData == uringIgnore {
+			continue
+		}
+
+		// The completion of a request we removed, possibly after it completed already, is stale.
+		req := p.request(cqe.userData)
+		if req == nil {
+			continue
+		}
+		slot, io := req.slot, req.io
+		p.releaseRequest(cqe.userData)
+		if slot == nil {
+			// A cancelled read or write request completed, so the kernel is done with its buffer.
+			continue
+		}
+		tag := cqe.userData & uringTagMask
+
+		if slot == p.waker.Slot() {
+			n++
+			p.dispatch()
+			if p.Closed() {
+				return n
+			}
+			p.armWaker()
+			continue
+		}
+
+		if io {
+			n++
+			p.complete(slot, tag, cqe.res)
+			if p.Closed() {
+				return n
+			}
+			continue
+		}
+
+		// The completed request's own direction, plus the other direction if the kernel reported an error condition
+		// that concerns the whole file.
+		var (
+			events            PollerEvent
+			readErr, writeErr error
+		)
+		if tag == uringReadTag {
+			events = PollerReadEvent
+		} else {
+			events = PollerWriteEvent
+		}
+		if cqe.res < 0 {
+			readErr = os.NewSyscallError("io_uring poll_add", syscall.Errno(-cqe.res))
+			writeErr = readErr
+		} else if revents := PollerEvent(cqe.res); revents&(pollerErrorEvent|pollerHangupEvent|pollerPeerHangupEvent) != 0 {
+			readErr, writeErr = pollErrors(slot.Fd, revents)
+			if revents&(pollerErrorEvent|pollerHangupEvent) != 0 {
+				events |= PollerReadEvent | PollerWriteEvent
+			}
+		}
+
+		// The Slot might have deregistered its interest before the completion was reaped. In that case, the interest
+		// bit is no longer set and we ignore the completion.
+		if events&slot.Events&PollerReadEvent == PollerReadEvent {
+			n++
+			err := p.clear(slot, PollerReadEvent, tag == uringReadTag)
+			if readErr != nil {
+				err = readErr
+			}
+			p.call(slot.Handlers[ReadEvent], err)
+		}
+		if events&slot.Events&PollerWriteEvent == PollerWriteEvent {
+			n++
+			err := p.clear(slot, PollerWriteEvent, tag == uringWriteTag)
+			if writeErr != nil {
+				err = writeErr
+			}
+			p.call(slot.Handlers[WriteEvent], err)
+		}
+
+		// A handler closed the poller. The rings stay mapped until Poll returns, but there is nothing left to reap.
+		if p.Closed() {
+			return n
+		}
+	}
+	return n
+}
+
+// complete calls the handler of the read or write request of slot with tag, whose result is res.
+func (p *uringPoller) complete(slot *Slot, tag uint64, res int32) {
+	event, et := PollerReadEvent, ReadEvent
+	if tag == uringWriteTag {
+		event, et = PollerWriteEvent, WriteEvent
+	}
+	p.pending--
+	slot.Events ^= event
+
+	// Like read(2) and write(2), which the readiness based path makes, the error is an errno.
+	var err error
+	if res < 0 {
+		slot.results[et] = 0
+		err = syscall.Errno(-res)
+	} else {
+		slot.results[et] = int(res)
+	}
+	p.call(slot.Handlers[et], err)
+}
+
+// clear removes the interest of slot in event. The poll request is cancelled unless it is the one that just completed.
+func (p *uringPoller) clear(slot *Slot, event PollerEvent, completed bool) error {
+	if !completed {
+		if event == PollerReadEvent {
+			return p.DelRead(slot)
+		}
+		return p.DelWrite(slot)
+	}
+	p.pending--
+	slot.Events ^= event
+	return nil
+}
+
+func (p *uringPoller) dispatch() {
+	for {
+		_, err := p.waker.Read(p.wakerBytes[:])
+		if err != nil {
+			break
+		}
+	}
+
+	p.runPosts(p.callPost)
+}
+
+func (p *uringPoller) SetRead(slot *Slot) error {
+	if slot.Events&PollerReadEvent == PollerReadEvent {
+		return nil
+	}
+	mask := uint32(PollerReadEvent | pollerPeerHangupEvent)
+	userData := p.addRequest(slot, uringReadTag)
+	if err := p.pollAdd(slot.Fd, mask, userData); err != nil {
+		p.releaseRequest(userData)
+		return err
+	}
+	p.pending++
+	slot.Events |= PollerReadEvent
+	return nil
+}
+
+func (p *uringPoller) SetWrite(slot *Slot) error {
+	if slot.Events&PollerWriteEvent == PollerWriteEvent {
+		return nil
+	}
+	userData := p.addRequest(slot, uringWriteTag)
+	if err := p.pollAdd(slot.Fd, uint32(PollerWriteEvent), userData); err != nil {
+		p.releaseRequest(userData)
+		return err
+	}
+	p.pending++
+	slot.Events |= PollerWriteEvent
+	return nil
+}
+
+func (p *uringPoller) SubmitRead(slot *Slot, b []byte) error {
+	return p.submitIO(slot, PollerReadEvent, uringReadTag, ioringOpRead, b)
+}
+
+func (p *uringPoller) SubmitWrite(slot *Slot, b []byte) error {
+	return p.submitIO(slot, PollerWriteEvent, uringWriteTag, ioringOpWrite, b)
+}
+
+func (p *uringPoller) submitIO(slot *Slot, event PollerEvent, tag uint64, opcode uint8, b []byte) error {
+	if slot.Events&event == event {
+		return nil
+	}
+	userData := p.addRequest(slot, tag)
+	req := &p.requests[uringIndex(userData)]
+	req.io = true
+	req.buf = b
+	if err := p.submit(opcode, slot.Fd, b, userData); err != nil {
+		p.releaseRequest(userData)
+		return err
+	}
+	p.pending++
+	slot.Events |= event
+	return nil
+}
+
+func (p *uringPoller) DelRead(slot *Slot) error {
+	if slot.Events&PollerReadEvent != PollerReadEvent {
+		return nil
+	}
+	return p.del(slot, PollerReadEvent, uringReadTag)
+}
+
+func (p *uringPoller) DelWrite(slot *Slot) error {
+	if slot.Events&PollerWriteEvent != PollerWriteEvent {
+		return nil
+	}
+	return p.del(slot, PollerWriteEvent, uringWriteTag)
+}
+
+// del cancels the pending request of slot for event, which has tag.
+func (p *uringPoller) del(slot *Slot, event PollerEvent, tag uint64) error {
+	p.pending--
+	slot.Events ^= event
+	userData := slot.requests[tag]
+
+	req := &p.requests[uringIndex(userData)]
+	if !req.io {
+		p.releaseRequest(userData)
+		return p.pollRemove(userData)
+	}
+
+	// The kernel might still access the buffer until the request completes, which releases the entry, see reap.
+	req.slot = nil
+	return p.asyncCancel(userData)
+}
+
+func (p *uringPoller) Del(slot *Slot) error {
+	err := p.DelRead(slot)
+	if err == nil {
+		return p.DelWrite(slot)
+	}
+	return err
+}
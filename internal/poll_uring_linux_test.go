@@ -0,0 +1,255 @@
+//go:build linux
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func mustUringPoller(t *testing.T) *uringPoller {
+	p, err := NewUringPoller()
+	if err != nil {
+		t.Skipf("io_uring not available err=%v", err)
+	}
+	return p.(*uringPoller)
+}
+
+func mustReadablePipe(t *testing.T) *Pipe {
+	pipe, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pipe.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	return pipe
+}
+
+func TestUringPollerFullSubmissionQueue(t *testing.T) {
+	p := mustUringPoller(t)
+	defer p.Close()
+
+	pipe := mustReadablePipe(t)
+	defer pipe.Close()
+
+	// More poll requests than the submission queue holds, all for the same readable pipe.
+	var (
+		slots = make([]Slot, UringEntries+16)
+		fired = 0
+	)
+	for i := range slots {
+		slots[i].Fd = pipe.ReadFd()
+		slots[i].Set(ReadEvent, func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			fired++
+		})
+		if err := p.SetRead(&slots[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for fired < len(slots) {
+		if _, err := p.Poll(-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Pending() != 0 {
+		t.Fatalf("expected no pending events, got %d", p.Pending())
+	}
+}
+
+func TestUringPollerStaleCompletion(t *testing.T) {
+	p := mustUringPoller(t)
+	defer p.Close()
+
+	readable := mustReadablePipe(t)
+	defer readable.Close()
+	idle, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	fired := 0
+	slot := Slot{Fd: readable.ReadFd()}
+	slot.Set(ReadEvent, func(error) {
+		fired++
+	})
+
+	// The poll request completes right away as it is submitted along with its removal.
+	if err := p.SetRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DelRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+
+	// The slot is reused for a file which is not readable. The completion of the first request must not be
+	// dispatched to it.
+	slot.Fd = idle.ReadFd()
+	if err := p.SetRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Poll(0); err != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if fired != 0 {
+		t.Fatal("stale completion dispatched")
+	}
+
+	if err := p.DelRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUringPollerCloseInHandler(t *testing.T) {
+	p := mustUringPoller(t)
+
+	var pipes [2]*Pipe
+	for i := range pipes {
+		pipes[i] = mustReadablePipe(t)
+		defer pipes[i].Close()
+	}
+
+	var (
+		slots [2]Slot
+		fired = 0
+	)
+	for i := range slots {
+		slots[i].Fd = pipes[i].ReadFd()
+		slots[i].Set(ReadEvent, func(error) {
+			fired++
+			_ = p.Close()
+		})
+		if err := p.SetRead(&slots[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both completions are ready, but the rings must stay mapped until Poll returns.
+	if _, err := p.Poll(-1); err != nil {
+		t.Fatal(err)
+	}
+	if fired != 1 {
+		t.Fatalf("expected a single dispatch after close, got %d", fired)
+	}
+	if !p.Closed() || p.sqRing != nil {
+		t.Fatal("expected the poller to be closed and unmapped")
+	}
+	if _, err := p.Poll(0); err == nil {
+		t.Fatal("expected Poll to fail once closed")
+	}
+}
+
+func TestUringPollerSubmit(t *testing.T) {
+	p := mustUringPoller(t)
+	defer p.Close()
+
+	pipe, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+	if err := pipe.SetReadNonblock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		readErr, writeErr error
+		reads, writes     = 0, 0
+		rb                = make([]byte, 16)
+		reader            = Slot{Fd: pipe.ReadFd()}
+		writer            = Slot{Fd: pipe.WriteFd()}
+	)
+	reader.Set(ReadEvent, func(err error) {
+		reads++
+		readErr = err
+	})
+	writer.Set(WriteEvent, func(err error) {
+		writes++
+		writeErr = err
+	})
+
+	// The pipe is empty, so the read stays pending.
+	if err := p.SubmitRead(&reader, rb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Poll(0); err != sonicerrors.ErrTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if err := p.SubmitWrite(&writer, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if p.Pending() != 2 {
+		t.Fatalf("expected 2 pending events, got %d", p.Pending())
+	}
+
+	for reads == 0 || writes == 0 {
+		if _, err := p.Poll(-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if readErr != nil || writeErr != nil {
+		t.Fatalf("expected no errors read=%v write=%v", readErr, writeErr)
+	}
+	if n := writer.Result(WriteEvent); n != 5 {
+		t.Fatalf("expected 5 bytes written, got %d", n)
+	}
+	if n := reader.Result(ReadEvent); string(rb[:n]) != "hello" {
+		t.Fatalf("expected to read hello, got %q", rb[:n])
+	}
+	if reads != 1 || writes != 1 || p.Pending() != 0 || reader.Events != 0 || writer.Events != 0 {
+		t.Fatal("expected the read and the write to complete once")
+	}
+}
+
+func TestUringPollerSubmitCancel(t *testing.T) {
+	p := mustUringPoller(t)
+	defer p.Close()
+
+	pipe, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+	if err := pipe.SetReadNonblock(); err != nil {
+		t.Fatal(err)
+	}
+
+	fired := 0
+	slot := Slot{Fd: pipe.ReadFd()}
+	slot.Set(ReadEvent, func(error) {
+		fired++
+	})
+
+	if err := p.SubmitRead(&slot, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	userData := slot.requests[uringReadTag]
+	if err := p.DelRead(&slot); err != nil {
+		t.Fatal(err)
+	}
+
+	// The kernel might still use the buffer, so the entry is only released once the read completes.
+	req := p.request(userData)
+	if req == nil || req.buf == nil || req.slot != nil {
+		t.Fatal("expected the entry of the cancelled read to keep its buffer")
+	}
+	if p.Pending() != 0 {
+		t.Fatalf("expected no pending events, got %d", p.Pending())
+	}
+
+	for i := 0; p.request(userData) != nil; i++ {
+		if i == 100 {
+			t.Fatal("expected the cancelled read to complete")
+		}
+		_, _ = p.Poll(1)
+	}
+	if fired != 0 {
+		t.Fatal("cancelled read dispatched")
+	}
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"sync/atomic"
+
+	"github.com/talostrading/sonic/util"
+)
+
+// postQueue holds the handlers scheduled with Post. It is embedded by Pollers.
+type postQueue struct {
+	posts *util.MPSCQueue[func()]
+
+	// posted is the number of handlers scheduled with Post which did not run yet. Updated atomically as Post can be
+	// called from any goroutine.
+	posted int64
+}
+
+func newPostQueue() postQueue {
+	return postQueue{posts: util.NewMPSCQueue[func()]()}
+}
+
+func (q *postQueue) push(handler func()) {
+	atomic.AddInt64(&q.posted, 1)
+	q.posts.Push(handler)
+}
+
+func (q *postQueue) Posted() int {
+	return int(atomic.LoadInt64(&q.posted))
+}
+
+// runPosts runs the handlers posted so far with the provided call function, outside of any lock. Handlers posted in the
+// meantime, including by the handlers themselves, run in the next call, so a handler which keeps posting itself does
+// not starve the Poller.
+func (q *postQueue) runPosts(call func(func())) {
+	n := atomic.LoadInt64(&q.posted)
+	for i := int64(0); i < n; i++ {
+		handler, ok := q.posts.Pop()
+		if !ok {
+			// The producer did not finish pushing. It wakes up the Poller once it does.
+			return
+		}
+		atomic.AddInt64(&q.posted, -1)
+		call(handler)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"syscall"
 	"time"
 
@@ -54,7 +55,7 @@ func CreateSocketTCP(
 	}
 
 	domain, socketType := syscall.AF_INET, syscall.SOCK_STREAM
-	if len(tcpAddr.Zone) > 0 {
+	if len(tcpAddr.Zone) > 0 || (tcpAddr.IP != nil && tcpAddr.IP.To4() == nil) {
 		domain = syscall.AF_INET6
 	}
 	if tcpAddr.IP.IsUnspecified() {
@@ -111,6 +112,11 @@ func ConnectTimeout(
 ) (fd int, localAddr, remoteAddr net.Addr, err error) {
 	switch network[:3] {
 	case "tcp":
+		for _, opt := range opts {
+			if opt.Type() == sonicopts.TypeHappyEyeballs {
+				return ConnectHappyEyeballs(network, addr, timeout, opt.Value().(time.Duration), opts...)
+			}
+		}
 		return ConnectTCP(network, addr, timeout, opts...)
 	case "udp":
 		return ConnectUDP(network, addr, timeout, opts...)
@@ -151,15 +157,92 @@ func connect(fd int, remoteAddr net.Addr, timeout time.Duration, opts ...sonicop
 			return sonicerrors.ErrTimeout
 		}
 
-		_, err = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+		errno, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
 		if err != nil {
 			return os.NewSyscallError("getsockopt", err)
 		}
+		if errno != 0 {
+			return os.NewSyscallError("connect", syscall.Errno(errno))
+		}
 	}
 
 	return nil
 }
 
+// ConnectAsync starts connecting a nonblocking socket to the specified endpoint. It takes the same options as Connect,
+// except for sonicopts.Nonblocking: the socket is always nonblocking.
+//
+// The host of addr must be an IP address, else ConnectAsync fails with sonicerrors.ErrHostNotIP: resolving a name
+// blocks.
+//
+// If inProgress is true, the connection is not yet established. The caller must wait for fd to become writable and
+// then check the outcome with SocketError.
+func ConnectAsync(
+	network, addr string,
+	opts ...sonicopts.Option,
+) (fd int, remoteAddr net.Addr, inProgress bool, err error) {
+	if err := checkHostIP(addr); err != nil {
+		return -1, nil, false, err
+	}
+
+	switch network[:3] {
+	case "tcp":
+		fd, remoteAddr, err = CreateSocketTCP(network, addr, true)
+	case "udp":
+		fd, remoteAddr, err = CreateSocketUDP(network, addr)
+	case "uni":
+		return -1, nil, false, fmt.Errorf("unix domain not supported")
+	default:
+		return -1, nil, false, errUnknownNetwork
+	}
+	if err != nil {
+		return -1, nil, false, err
+	}
+
+	inProgress, err = connectAsync(fd, remoteAddr, opts...)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return -1, nil, false, err
+	}
+	return fd, remoteAddr, inProgress, nil
+}
+
+// checkHostIP returns sonicerrors.ErrHostNotIP if the host of addr is neither empty nor an IP address, possibly with
+// an IPv6 zone.
+func checkHostIP(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return fmt.Errorf("%w: %s", sonicerrors.ErrHostNotIP, host)
+	}
+	return nil
+}
+
+func connectAsync(fd int, remoteAddr net.Addr, opts ...sonicopts.Option) (inProgress bool, err error) {
+	if err := ApplyOpts(fd, append(opts[:len(opts):len(opts)], sonicopts.Nonblocking(true))...); err != nil {
+		return false, err
+	}
+
+	if err := maybeBindBeforeConnect(fd, opts...); err != nil {
+		return false, err
+	}
+
+	if err := syscall.Connect(fd, ToSockaddr(remoteAddr)); err != nil {
+		// EINTR does not abort a connect, it completes asynchronously, like EINPROGRESS.
+		// https://man7.org/linux/man-pages/man2/connect.2.html#EINPROGRESS
+		if err != syscall.EINPROGRESS && err != syscall.EAGAIN && err != syscall.EINTR {
+			return false, os.NewSyscallError("connect", err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
 func ConnectTCP(
 	network, addr string,
 	timeout time.Duration,
@@ -303,9 +386,30 @@ func ApplyOpts(fd int, opts ...sonicopts.Option) error {
 			); err != nil {
 				return os.NewSyscallError(fmt.Sprintf("tcp_no_delay(%v)", v), err)
 			}
+		case sonicopts.TypeSendBuffer:
+			v := opt.Value().(int)
+			if err := syscall.SetsockoptInt(
+				fd,
+				syscall.SOL_SOCKET,
+				syscall.SO_SNDBUF,
+				v,
+			); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("send_buffer(%d)", v), err)
+			}
+		case sonicopts.TypeRecvBuffer:
+			v := opt.Value().(int)
+			if err := syscall.SetsockoptInt(
+				fd,
+				syscall.SOL_SOCKET,
+				syscall.SO_RCVBUF,
+				v,
+			); err != nil {
+				return os.NewSyscallError(fmt.Sprintf("recv_buffer(%d)", v), err)
+			}
 		case sonicopts.TypeBindSocket:
-			addr := opt.Value().(net.Addr)
-			return syscall.Bind(fd, ToSockaddr(addr))
+			// Not a socket option, see maybeBindBeforeConnect.
+		case sonicopts.TypeHappyEyeballs:
+			// Not a socket option, see ConnectTimeout.
 		default:
 			return fmt.Errorf("unsupported socket option %s", t)
 		}
@@ -326,3 +430,13 @@ func Sockaddr(fd int) (syscall.Sockaddr, error) {
 	addr, err := syscall.Getsockname(fd)
 	return addr, err
 }
+
+// SocketError returns and clears the pending error on the socket fd. It returns nil if there is no pending error or if
+// fd is not a socket.
+func SocketError(fd int) error {
+	errno, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil || errno == 0 {
+		return nil
+	}
+	return &sonicerrors.SocketError{Errno: syscall.Errno(errno)}
+}
@@ -11,13 +11,17 @@ import (
 var _ ITimer = &Timer{}
 
 type Timer struct {
-	fd     int
-	poller *poller
-	slot   Slot
+	fd       int
+	poller   *poller
+	slot     Slot
+	realtime bool
 }
 
-func NewTimer(p Poller) (*Timer, error) {
+// NewTimer creates a timer backed by EVFILT_TIMER. realtime selects the clock SetAt deadlines are expressed in, see
+// ClockNow.
+func NewTimer(p Poller, realtime bool) (*Timer, error) {
 	t := &Timer{
+		realtime: realtime,
 		/* #nosec G404 -- randint is used as a timer file descriptor */
 		fd:     rand.Int(), // TODO figure out something better
 		poller: p.(*poller),
@@ -26,13 +30,13 @@ func NewTimer(p Poller) (*Timer, error) {
 	return t, nil
 }
 
-func (t *Timer) Set(dur time.Duration, cb func()) error {
+func (t *Timer) Set(dur time.Duration, cb func(error)) error {
 	// Make sure there's not another timer setup on the same fd.
 	if err := t.Unset(); err != nil {
 		return err
 	}
 
-	t.slot.Set(ReadEvent, func(_ error) { cb() })
+	t.slot.Set(ReadEvent, cb)
 
 	err := t.poller.set(t.fd, createEvent(
 		syscall.EV_ADD|syscall.EV_ENABLE|syscall.EV_ONESHOT,
@@ -48,6 +52,18 @@ func (t *Timer) Set(dur time.Duration, cb func()) error {
 	return err
 }
 
+// SetAt arms the timer to call cb once the timer's clock reaches deadline.
+//
+// EVFILT_TIMER only takes relative timeouts portably, so the deadline is converted to a delay when the timer is armed.
+// Unlike on Linux, changes of the system time made after that are not followed.
+func (t *Timer) SetAt(deadline int64, cb func(error)) error {
+	dur := time.Duration(deadline - ClockNow(t.realtime))
+	if dur <= 0 {
+		dur = time.Nanosecond
+	}
+	return t.Set(dur, cb)
+}
+
 func (t *Timer) Unset() error {
 	if t.slot.Events&PollerReadEvent != PollerReadEvent {
 		return nil
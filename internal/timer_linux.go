@@ -19,36 +19,52 @@ type Timer struct {
 	b      [8]byte
 }
 
-func NewTimer(p Poller) (*Timer, error) {
-	fd, err := unix.TimerfdCreate(unix.CLOCK_REALTIME, unix.TFD_NONBLOCK)
+// NewTimer creates a timer backed by a timerfd on CLOCK_REALTIME if realtime is true, or on CLOCK_MONOTONIC otherwise.
+func NewTimer(p Poller, realtime bool) (*Timer, error) {
+	clock := unix.CLOCK_MONOTONIC
+	if realtime {
+		clock = unix.CLOCK_REALTIME
+	}
+
+	fd, err := unix.TimerfdCreate(clock, unix.TFD_NONBLOCK)
 	if err != nil {
 		return nil, os.NewSyscallError("timerfd_create", err)
 	}
 
 	t := &Timer{
 		fd:     fd,
-		poller: p.(*poller),
+		poller: p,
 	}
 	t.slot.Fd = t.fd
+	t.slot.Persistent = true
 	return t, nil
 }
 
-func (t *Timer) Set(dur time.Duration, cb func()) error {
-	// first, make sure there's not another timer setup on the same fd
-	if err := t.Unset(); err != nil {
-		return err
-	}
+// Set arms the timer to call cb once, after dur. If the timer is already armed, it is rearmed without deregistering
+// it from the poller. timerfd_settime discards any unread expiration, so the previous callback is not called.
+func (t *Timer) Set(dur time.Duration, cb func(error)) error {
+	return t.set(0, dur.Nanoseconds(), cb)
+}
+
+// SetAt arms the timer to call cb once, when the timer's clock reaches deadline. Unlike Set, a realtime timer armed
+// with SetAt follows changes of the system time.
+func (t *Timer) SetAt(deadline int64, cb func(error)) error {
+	return t.set(unix.TFD_TIMER_ABSTIME, deadline, cb)
+}
 
-	timespec := unix.NsecToTimespec(dur.Nanoseconds())
-	err := unix.TimerfdSettime(t.fd, 0, &unix.ItimerSpec{
+func (t *Timer) set(flags int, nanos int64, cb func(error)) error {
+	timespec := unix.NsecToTimespec(nanos)
+	err := unix.TimerfdSettime(t.fd, flags, &unix.ItimerSpec{
 		Interval: unix.Timespec{},
 		Value:    timespec,
 	}, nil)
 	if err == nil {
-		// TODO error checking here
-		t.slot.Set(ReadEvent, func(error) {
-			_, _ = syscall.Read(t.fd, t.b[:])
-			cb()
+		t.slot.Set(ReadEvent, func(err error) {
+			if err == nil {
+				// Only consumes the expiration count, which is at most 1 as the timer does not repeat.
+				_, _ = syscall.Read(t.fd, t.b[:])
+			}
+			cb(err)
 		})
 		err = t.poller.SetRead(&t.slot)
 	}
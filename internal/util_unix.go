@@ -6,32 +6,19 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
 	"syscall"
 
 	"github.com/talostrading/sonic/util"
 	"golang.org/x/sys/unix"
 )
 
-// TODO Handle IPv6
-
 func ToSockaddr(addr net.Addr) syscall.Sockaddr {
 	switch addr := addr.(type) {
 	case *net.TCPAddr:
-		return &syscall.SockaddrInet4{
-			Port: addr.Port,
-			Addr: func() (b [4]byte) {
-				copy(b[:], addr.IP.To4())
-				return
-			}(),
-		}
+		return toSockaddrInet(addr.IP, addr.Port, addr.Zone)
 	case *net.UDPAddr:
-		return &syscall.SockaddrInet4{
-			Port: addr.Port,
-			Addr: func() (b [4]byte) {
-				copy(b[:], addr.IP.To4())
-				return
-			}(),
-		}
+		return toSockaddrInet(addr.IP, addr.Port, addr.Zone)
 	case *net.UnixAddr:
 		panic("unix not supported")
 		return nil
@@ -40,6 +27,41 @@ func ToSockaddr(addr net.Addr) syscall.Sockaddr {
 	}
 }
 
+// toSockaddrInet returns an IPv4 address if ip is an IPv4 or IPv4-mapped address, or if it is unspecified, and an
+// IPv6 address otherwise.
+func toSockaddrInet(ip net.IP, port int, zone string) syscall.Sockaddr {
+	if ip == nil || ip.To4() != nil {
+		sa := &syscall.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip.To4())
+		return sa
+	}
+
+	sa := &syscall.SockaddrInet6{Port: port, ZoneId: zoneToIndex(zone)}
+	copy(sa.Addr[:], ip.To16())
+	return sa
+}
+
+func zoneToIndex(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	index, _ := strconv.ParseUint(zone, 10, 32)
+	return uint32(index)
+}
+
+func indexToZone(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(index)); err == nil {
+		return ifi.Name
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
+
 func FromSockaddr(sockAddr syscall.Sockaddr) net.Addr {
 	switch addr := sockAddr.(type) {
 	case *syscall.SockaddrInet4:
@@ -48,7 +70,11 @@ func FromSockaddr(sockAddr syscall.Sockaddr) net.Addr {
 			Port: addr.Port,
 		}
 	case *syscall.SockaddrInet6:
-		// TODO
+		return &net.TCPAddr{
+			IP:   append([]byte{}, addr.Addr[:]...),
+			Port: addr.Port,
+			Zone: indexToZone(addr.ZoneId),
+		}
 	case *syscall.SockaddrUnix:
 		return &net.UnixAddr{
 			Name: addr.Name,
@@ -85,7 +111,7 @@ func FromSockaddrUDP(sockAddr syscall.Sockaddr, to *net.UDPAddr) *net.UDPAddr {
 		to.IP = util.ExtendSlice(to.IP, net.IPv6len)
 		copy(to.IP, addr.Addr[:])
 		to.Port = addr.Port
-		// TODO zoneID (not sure the encoding)
+		to.Zone = indexToZone(addr.ZoneId)
 	default:
 		panic("not supported")
 	}
@@ -1,14 +1,18 @@
 package sonic
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/talostrading/sonic/internal"
 	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
 )
 
 // IO is the executor of all asynchronous operations and the way any object can schedule them. It runs fully in the
@@ -19,6 +23,9 @@ import (
 type IO struct {
 	poller internal.Poller
 
+	// submitter is the poller if it makes reads and writes itself, see internal.Submitter, and nil otherwise.
+	submitter internal.Submitter
+
 	// The below structures keep a pointer to a Slot struct usually owned by an object capable of asynchronous
 	// operations (essentially any object taking an IO* on construction). Keeping a Slot pointer keeps the owning
 	// object in the GC's object graph while an asynchronous operation is in progress. This ensures Slot references
@@ -36,23 +43,63 @@ type IO struct {
 		// 4096 goes here.
 		dynamic map[*internal.Slot]struct{}
 	}
-	pendingTimers map[*Timer]struct{} // XXX: should be embedded into the above pending struct
+
+	// timers holds the scheduled Timers.
+	timers timerQueue
+
+	// monitor reports to the IOObserver set with SetObserver, if any.
+	monitor *ioMonitor
+
+	// stopped is 1 if Stop has been called. It is checked by the Run* functions before each event loop cycle.
+	stopped uint32
+
+	// dispatching is true while a Run* or Poll* function polls the poller, which runs the ready handlers. Dispatch uses
+	// it to tell whether it is called from a handler.
+	dispatching bool
 }
 
-func NewIO() (*IO, error) {
-	poller, err := internal.NewPoller()
+// NewIO creates an IO backed by epoll on Linux and kqueue on BSD.
+//
+// Pass sonicopts.IOUring(true) to back the IO by io_uring instead. This is only supported on Linux 5.11 or newer. Files
+// and connections then submit the reads and writes which would block to io_uring, instead of waiting for readiness and
+// making them with syscalls.
+//
+// The IO's Timers follow the monotonic clock, unless sonicopts.RealtimeTimers(true) is passed.
+func NewIO(opts ...sonicopts.Option) (*IO, error) {
+	uring, realtime := false, false
+	for _, opt := range opts {
+		switch t := opt.Type(); t {
+		case sonicopts.TypeIOUring:
+			uring = opt.Value().(bool)
+		case sonicopts.TypeRealtimeTimers:
+			realtime = opt.Value().(bool)
+		default:
+			return nil, fmt.Errorf("unsupported IO option %s", t)
+		}
+	}
+
+	var (
+		poller internal.Poller
+		err    error
+	)
+	if uring {
+		poller, err = internal.NewUringPoller()
+	} else {
+		poller, err = internal.NewPoller()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &IO{
-		poller:        poller,
-		pendingTimers: make(map[*Timer]struct{}),
-	}, nil
+	ioc := &IO{poller: poller}
+	ioc.submitter, _ = poller.(internal.Submitter)
+	ioc.timers.ioc = ioc
+	ioc.timers.realtime = realtime
+	return ioc, nil
 }
 
-func MustIO() *IO {
-	ioc, err := NewIO()
+func MustIO(opts ...sonicopts.Option) *IO {
+	ioc, err := NewIO(opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -70,7 +117,13 @@ func (ioc *IO) Register(slot *internal.Slot) {
 	}
 }
 
+// Deregister is called by objects after one of their asynchronous operations completes. The Slot stays registered while
+// it has other asynchronous operations in progress.
 func (ioc *IO) Deregister(slot *internal.Slot) {
+	if slot.Events != 0 {
+		return
+	}
+
 	if slot.Fd >= len(ioc.pending.static) {
 		delete(ioc.pending.dynamic, slot)
 	} else {
@@ -86,24 +139,55 @@ func (ioc *IO) SetWrite(slot *internal.Slot) error {
 	return ioc.poller.SetWrite(slot)
 }
 
-// Run runs the event processing loop.
+// Del deregisters interest in all events on the provided slot. Objects owning a Slot must call it before closing the
+// Slot's file descriptor.
+func (ioc *IO) Del(slot *internal.Slot) error {
+	return ioc.poller.Del(slot)
+}
+
+// Run runs the event processing loop until Stop is called.
 func (ioc *IO) Run() error {
-	for {
-		if err := ioc.RunOne(); err != nil && err != sonicerrors.ErrTimeout {
+
+	for !ioc.Stopped() {
+		if _, err := ioc.poll(-1); err != nil && err != sonicerrors.ErrTimeout {
 			return err
 		}
 	}
+	return nil
+}
+
+// RunContext runs the event processing loop until ctx is done or Stop is called. It returns ctx.Err() if the loop
+// stopped because ctx is done.
+//
+// A helper goroutine waits on ctx and calls Stop. It exits when RunContext returns.
+func (ioc *IO) RunContext(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			ioc.Stop()
+		case <-done:
+		}
+	}()
+
+	if err := ioc.Run(); err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
 // RunPending runs the event processing loop to execute all the pending handlers. The function returns (and the event
-// loop stops running) when there are no more operations to complete.
+// loop stops running) when there are no more operations to complete or when Stop is called.
 func (ioc *IO) RunPending() error {
-	for {
+
+	for !ioc.Stopped() {
 		if ioc.poller.Pending() <= 0 {
 			break
 		}
 
-		if err := ioc.RunOne(); err != nil && err != sonicerrors.ErrTimeout {
+		if _, err := ioc.poll(-1); err != nil && err != sonicerrors.ErrTimeout {
 			return err
 		}
 	}
@@ -133,6 +217,7 @@ func (ioc *IO) RunOneFor(dur time.Duration) (err error) {
 		return err
 	}
 	ms := int(dur.Milliseconds())
+
 	_, err = ioc.poll(ms)
 	return
 }
@@ -147,6 +232,8 @@ const (
 // `busyCycles` of not processing anything, the event-loop is out of the warm-state and falls back to yielding with the
 // provided timeout. If at any moment an event occurs and something is processed, the  event-loop transitions to its
 // warm-state.
+//
+// RunWarm returns when Stop is called.
 func (ioc *IO) RunWarm(busyCycles int, timeout time.Duration) (err error) {
 	if busyCycles <= 0 {
 		return fmt.Errorf("busyCycles must be greater than 0")
@@ -160,7 +247,7 @@ func (ioc *IO) RunWarm(busyCycles int, timeout time.Duration) (err error) {
 		i = 0
 		n int
 	)
-	for {
+	for !ioc.Stopped() {
 		if i < busyCycles {
 			// We are still in the warm-period, we poll.
 			n, err = ioc.poll(0)
@@ -181,14 +268,16 @@ func (ioc *IO) RunWarm(busyCycles int, timeout time.Duration) (err error) {
 			i++
 		}
 	}
+	return nil
 }
 
 // Poll runs the event processing loop to execute ready handlers.
 //
 // This will return immediately in case there is no event to process.
 func (ioc *IO) Poll() error {
+
 	for {
-		if _, err := ioc.PollOne(); err != nil {
+		if _, err := ioc.poll(0); err != nil {
 			return err
 		}
 	}
@@ -198,11 +287,34 @@ func (ioc *IO) Poll() error {
 //
 // This will return immediately in case there is no event to process.
 func (ioc *IO) PollOne() (n int, err error) {
+
 	return ioc.poll(0)
 }
 
+// SetObserver attaches an IOObserver to the IO's event processing loop. A nil IOObserver detaches the current one.
+//
+// Observing the loop costs two clock reads per poll and per dispatched handler.
+func (ioc *IO) SetObserver(observer IOObserver) {
+	if observer == nil {
+		ioc.monitor = nil
+		ioc.poller.SetMonitor(nil)
+		return
+	}
+
+	ioc.monitor = &ioMonitor{observer: observer}
+	ioc.poller.SetMonitor(ioc.monitor)
+}
+
 func (ioc *IO) poll(timeoutMs int) (int, error) {
+	// Restore the previous value instead of clearing it: a handler may call RunOne or PollOne itself.
+	dispatching := ioc.dispatching
+	ioc.dispatching = true
 	n, err := ioc.poller.Poll(timeoutMs)
+	ioc.dispatching = dispatching
+
+	if ioc.monitor != nil {
+		ioc.monitor.onPoll(ioc.poller.Posted())
+	}
 
 	if err != nil {
 		if err == syscall.EINTR {
@@ -229,11 +341,28 @@ func (ioc *IO) poll(timeoutMs int) (int, error) {
 // Post schedules the provided handler to be run immediately by the event
 // processing loop in its own thread.
 //
+// Handlers are queued in a lock-free queue and run without holding any lock, so
+// a handler may call Post itself.
+//
 // It is safe to call Post concurrently.
 func (ioc *IO) Post(handler func()) error {
 	return ioc.poller.Post(handler)
 }
 
+// Dispatch runs the provided handler immediately if called from a handler run by the event processing loop, for example
+// from a completion handler. Otherwise, it schedules the handler with Post.
+//
+// Dispatch tells the two apart with a field the event processing loop sets while it runs handlers, which costs no
+// syscalls. Unlike Post, it must therefore only be called from the goroutine running the IO, or while no Run* or Poll*
+// function is running. Other goroutines must use Post.
+func (ioc *IO) Dispatch(handler func()) error {
+	if ioc.dispatching {
+		handler()
+		return nil
+	}
+	return ioc.Post(handler)
+}
+
 // Posted returns the number of handlers registered with Post.
 //
 // It is safe to call Posted concurrently.
@@ -245,10 +374,78 @@ func (ioc *IO) Pending() int64 {
 	return ioc.poller.Pending()
 }
 
+// Stop stops the event processing loop. Run, RunContext, RunPending and RunWarm return after the handler they are
+// executing, if any, completes. Stop does not cancel any pending operations, see Close for that.
+//
+// It is safe to call Stop concurrently.
+func (ioc *IO) Stop() {
+	if atomic.CompareAndSwapUint32(&ioc.stopped, 0, 1) && !ioc.Closed() {
+		// Wake up the event processing loop in case it is blocked in the poller.
+		_ = ioc.poller.Wake()
+	}
+}
+
+// Stopped returns true if Stop has been called and Restart has not been called since.
+//
+// It is safe to call Stopped concurrently.
+func (ioc *IO) Stopped() bool {
+	return atomic.LoadUint32(&ioc.stopped) == 1
+}
+
+// Restart resets a stopped IO such that the Run* functions can be called again.
+func (ioc *IO) Restart() {
+	atomic.StoreUint32(&ioc.stopped, 0)
+}
+
+// Close stops the IO, cancels all pending asynchronous operations and then closes the IO.
+//
+// The handlers of all pending reads, writes and accepts, and the callbacks of all scheduled timers, are called with
+// sonicerrors.ErrCancelled, in the calling goroutine, so objects can release their buffers deterministically. Handlers
+// must not schedule new operations from that point on. The scheduled timers are closed.
+//
+// Close must be called from the IO's goroutine.
 func (ioc *IO) Close() error {
+	if ioc.Closed() {
+		return io.EOF
+	}
+
+	ioc.Stop()
+
+	for _, slot := range ioc.pending.static {
+		if slot != nil {
+			ioc.cancel(slot)
+		}
+	}
+	for slot := range ioc.pending.dynamic {
+		ioc.cancel(slot)
+	}
+
+	_ = ioc.timers.close()
+
 	return ioc.poller.Close()
 }
 
+func (ioc *IO) cancel(slot *internal.Slot) {
+	if slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
+		err := ioc.poller.DelRead(slot)
+		if err == nil {
+			err = sonicerrors.ErrCancelled
+		}
+		slot.Handlers[internal.ReadEvent](err)
+	}
+
+	if slot.Events&internal.PollerWriteEvent == internal.PollerWriteEvent {
+		err := ioc.poller.DelWrite(slot)
+		if err == nil {
+			err = sonicerrors.ErrCancelled
+		}
+		slot.Handlers[internal.WriteEvent](err)
+	}
+
+	// The handlers deregister the slot, unless they are not the handlers of a sonic object.
+	ioc.Deregister(slot)
+}
+
 func (ioc *IO) Closed() bool {
 	return ioc.poller.Closed()
 }
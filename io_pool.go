@@ -0,0 +1,195 @@
+package sonic
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/talostrading/sonic/sonicopts"
+	"github.com/talostrading/sonic/util"
+)
+
+// IOPool runs multiple IOs, each in its own goroutine locked to an OS thread, optionally pinned to a CPU.
+//
+// The IOs must only be touched from their own goroutine once the pool is started. Use Post or PostLeastLoaded to run
+// code in them, like scheduling asynchronous operations.
+type IOPool struct {
+	iocs []*IO
+	cpus []int
+
+	started bool
+	wg      sync.WaitGroup
+
+	lck  sync.Mutex
+	errs []error
+}
+
+// NewIOPool creates a pool of n IOs. The provided options are passed to NewIO.
+//
+// If cpus is not empty, the goroutine of the i-th IO is pinned to cpus[i%len(cpus)] with util.PinTo once the pool is
+// started.
+func NewIOPool(n int, cpus []int, opts ...sonicopts.Option) (*IOPool, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("an IOPool needs at least one IO")
+	}
+
+	p := &IOPool{
+		iocs: make([]*IO, 0, n),
+		cpus: cpus,
+	}
+	for i := 0; i < n; i++ {
+		ioc, err := NewIO(opts...)
+		if err != nil {
+			for _, ioc := range p.iocs {
+				_ = ioc.Close()
+			}
+			return nil, err
+		}
+		p.iocs = append(p.iocs, ioc)
+	}
+	return p, nil
+}
+
+// Size returns the number of IOs in the pool.
+func (p *IOPool) Size() int {
+	return len(p.iocs)
+}
+
+// IO returns the i-th IO in the pool.
+func (p *IOPool) IO(i int) *IO {
+	return p.iocs[i]
+}
+
+// Listen creates one Listener per IO, all on the same address and with SO_REUSEPORT, such that the kernel spreads
+// incoming connections across them. The i-th Listener belongs to the i-th IO.
+//
+// The Listeners are nonblocking. Call AsyncAccept on each before the pool is started, or from a handler passed to Post
+// afterwards.
+func (p *IOPool) Listen(network, addr string, opts ...sonicopts.Option) ([]Listener, error) {
+	// Copy opts such that appending does not write into the caller's backing array.
+	opts = append(opts[:len(opts):len(opts)], sonicopts.ReusePort(true), sonicopts.Nonblocking(true))
+
+	lns := make([]Listener, 0, len(p.iocs))
+	for _, ioc := range p.iocs {
+		ln, err := Listen(ioc, network, addr, opts...)
+		if err != nil {
+			for _, ln := range lns {
+				_ = ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// Start runs each IO with Run in its own goroutine.
+func (p *IOPool) Start() {
+	p.start(func(ioc *IO) error {
+		return ioc.Run()
+	})
+}
+
+// StartWarm runs each IO with RunWarm in its own goroutine.
+func (p *IOPool) StartWarm(busyCycles int, timeout time.Duration) {
+	p.start(func(ioc *IO) error {
+		return ioc.RunWarm(busyCycles, timeout)
+	})
+}
+
+func (p *IOPool) start(run func(*IO) error) {
+	if p.started {
+		return
+	}
+	p.started = true
+
+	for i, ioc := range p.iocs {
+		p.wg.Add(1)
+		go func(i int, ioc *IO) {
+			defer p.wg.Done()
+
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+
+			if len(p.cpus) > 0 {
+				if err := util.PinTo(p.cpus[i%len(p.cpus)]); err != nil {
+					p.addErr(fmt.Errorf("io %d: %w", i, err))
+				}
+			}
+
+			if err := run(ioc); err != nil {
+				p.addErr(fmt.Errorf("io %d: %w", i, err))
+			}
+		}(i, ioc)
+	}
+}
+
+func (p *IOPool) addErr(err error) {
+	p.lck.Lock()
+	p.errs = append(p.errs, err)
+	p.lck.Unlock()
+}
+
+// Post schedules the handler to run in the i-th IO.
+//
+// It is safe to call Post concurrently.
+func (p *IOPool) Post(i int, handler func()) error {
+	return p.iocs[i].Post(handler)
+}
+
+// PostLeastLoaded schedules the handler to run in the IO with the lowest Load. It returns the index of that IO.
+//
+// It is safe to call PostLeastLoaded concurrently.
+func (p *IOPool) PostLeastLoaded(handler func()) (int, error) {
+	least, load := 0, p.Load(0)
+	for i := 1; i < len(p.iocs); i++ {
+		if l := p.Load(i); l < load {
+			least, load = i, l
+		}
+	}
+	return least, p.Post(least, handler)
+}
+
+// Load returns the number of handlers posted to the i-th IO which did not run yet, whether through the pool or
+// directly with IO.Post. The operations pending in the poller of the IO are not accounted for, as they can only be
+// read from the IO's own goroutine.
+//
+// It is safe to call Load concurrently.
+func (p *IOPool) Load(i int) int64 {
+	return int64(p.iocs[i].Posted())
+}
+
+// Stop stops all IOs without closing them. Call Wait to wait for their goroutines to exit.
+//
+// It is safe to call Stop concurrently.
+func (p *IOPool) Stop() {
+	for _, ioc := range p.iocs {
+		ioc.Stop()
+	}
+}
+
+// Wait waits for the goroutines of all IOs to exit and returns the errors they encountered, if any.
+func (p *IOPool) Wait() error {
+	p.wg.Wait()
+
+	p.lck.Lock()
+	defer p.lck.Unlock()
+	return errors.Join(p.errs...)
+}
+
+// Close stops all IOs, waits for their goroutines to exit and then closes them, which cancels all their pending
+// operations. It returns the errors encountered by the IOs, if any.
+//
+// Close must not be called from one of the pool's IOs.
+func (p *IOPool) Close() error {
+	p.Stop()
+	err := p.Wait()
+
+	// The IOs' goroutines exited, so it is safe to close them from here.
+	for _, ioc := range p.iocs {
+		_ = ioc.Close()
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package sonic
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func TestIOPoolPost(t *testing.T) {
+	pool, err := NewIOPool(4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+
+	var (
+		wg  sync.WaitGroup
+		ran [4]int64
+	)
+	for i := 0; i < pool.Size(); i++ {
+		for j := 0; j < 100; j++ {
+			wg.Add(1)
+			i := i
+			if err := pool.Post(i, func() {
+				atomic.AddInt64(&ran[i], 1)
+				wg.Done()
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for i := range ran {
+		if n := atomic.LoadInt64(&ran[i]); n != 100 {
+			t.Fatalf("expected io %d to run 100 handlers, ran %d", i, n)
+		}
+		if pool.Load(i) != 0 {
+			t.Fatalf("expected io %d to have no queued handlers", i)
+		}
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIOPoolPostLeastLoaded(t *testing.T) {
+	pool, err := NewIOPool(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool is not started, so posted handlers stay queued.
+	for i := 0; i < 10; i++ {
+		if _, err := pool.PostLeastLoaded(func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pool.Load(0) != 5 || pool.Load(1) != 5 {
+		t.Fatalf("expected the handlers to be spread evenly, got %d and %d", pool.Load(0), pool.Load(1))
+	}
+
+	if err := pool.Post(0, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if i, err := pool.PostLeastLoaded(func() {}); err != nil || i != 1 {
+		t.Fatalf("expected to post to io 1, posted to %d err=%v", i, err)
+	}
+
+	// Handlers posted straight to an IO count too.
+	if err := pool.IO(1).Post(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Load(1) != 7 {
+		t.Fatalf("expected io 1 to have 7 queued handlers, got %d", pool.Load(1))
+	}
+	if i, err := pool.PostLeastLoaded(func() {}); err != nil || i != 0 {
+		t.Fatalf("expected to post to io 0, posted to %d err=%v", i, err)
+	}
+
+	pool.Start()
+
+	start := time.Now()
+	for pool.Load(0) != 0 || pool.Load(1) != 0 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("queued handlers did not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIOPoolListen(t *testing.T) {
+	pool, err := NewIOPool(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Listen must not append into the spare capacity of opts.
+	opts := make([]sonicopts.Option, 1, 3)
+	opts[0] = sonicopts.ReuseAddr(true)
+	lns, err := pool.Listen("tcp", "localhost:8094", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spare := opts[1:3]; spare[0] != nil || spare[1] != nil {
+		t.Fatal("listen wrote into the options of the caller")
+	}
+	if len(lns) != pool.Size() {
+		t.Fatalf("expected %d listeners, got %d", pool.Size(), len(lns))
+	}
+
+	var accepted int64
+	for _, ln := range lns {
+		ln := ln
+		var onAccept AcceptCallback
+		onAccept = func(err error, conn Conn) {
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&accepted, 1)
+			conn.Close()
+			ln.AsyncAccept(onAccept)
+		}
+		ln.AsyncAccept(onAccept)
+	}
+
+	pool.Start()
+
+	for i := 0; i < 16; i++ {
+		conn, err := net.Dial("tcp", "localhost:8094")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	start := time.Now()
+	for atomic.LoadInt64(&accepted) != 16 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected 16 connections to be accepted, accepted %d", atomic.LoadInt64(&accepted))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
@@ -1,14 +1,18 @@
 package sonic
 
 import (
+	"context"
 	"errors"
 	"github.com/talostrading/sonic/internal"
 	"log"
+	"net"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
 )
 
 func TestPost(t *testing.T) {
@@ -97,7 +101,7 @@ func TestRightNumberOfPolledEvents(t *testing.T) {
 	}
 
 	dur := 500 * time.Millisecond
-	err = timer.ScheduleOnce(dur, func() {})
+	err = timer.ScheduleOnce(dur, func(error) {})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -202,9 +206,9 @@ func TestSleep(t *testing.T) {
 	}
 
 	scheduled := time.Now()
-	err = timer.ScheduleOnce(10*time.Millisecond, func() {
+	err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 		time.Sleep(10 * time.Millisecond)
-		err = timer.ScheduleOnce(10*time.Millisecond, func() {
+		err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 			time.Sleep(10 * time.Millisecond)
 		})
 		if err != nil {
@@ -252,7 +256,7 @@ func TestRunWarm(t *testing.T) {
 	defer ticker.Close()
 
 	i := 0
-	ticker.ScheduleRepeating(time.Millisecond, func() {
+	ticker.ScheduleRepeating(time.Millisecond, func(error) {
 		i++
 		if i >= 10 {
 			ioc.Close()
@@ -316,6 +320,147 @@ func TestIOPending(t *testing.T) {
 	}
 }
 
+func TestStop(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ioc.Stop()
+	}()
+
+	// Run blocks in the poller as there is nothing to do, so Stop must wake it up.
+	if err := ioc.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !ioc.Stopped() {
+		t.Fatal("ioc should be stopped")
+	}
+
+	ioc.Restart()
+	if ioc.Stopped() {
+		t.Fatal("ioc should not be stopped")
+	}
+
+	ran := false
+	ioc.Post(func() {
+		ran = true
+		ioc.Stop()
+	})
+	if err := ioc.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Fatal("ioc should have run the posted handler")
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := ioc.RunContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded err=%v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("RunContext returned too early")
+	}
+}
+
+func TestCloseCancelsPending(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:8093")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Never write anything such that the read below stays pending.
+		b := make([]byte, 128)
+		_, _ = conn.Read(b)
+	}()
+
+	ioc := MustIO()
+
+	sonicLn, err := Listen(ioc, "tcp", "localhost:8092", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sonicLn.Close()
+
+	conn, err := Dial(ioc, "tcp", "localhost:8093")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticker, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var acceptErr, readErr, timerErr, tickerErr error
+	sonicLn.AsyncAccept(func(err error, _ Conn) {
+		acceptErr = err
+	})
+	conn.AsyncRead(make([]byte, 128), func(err error, _ int) {
+		readErr = err
+	})
+	if err := timer.ScheduleOnce(time.Hour, func(err error) {
+		timerErr = err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ticker.ScheduleRepeating(time.Hour, func(err error) {
+		tickerErr = err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if acceptErr != nil || readErr != nil || timerErr != nil || tickerErr != nil {
+		t.Fatal("operations should be pending")
+	}
+
+	if err := ioc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(acceptErr, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected the accept to be cancelled err=%v", acceptErr)
+	}
+	if !errors.Is(readErr, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected the read to be cancelled err=%v", readErr)
+	}
+	if !errors.Is(timerErr, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected the timer to be cancelled err=%v", timerErr)
+	}
+	if !errors.Is(tickerErr, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected the repeating timer to be cancelled err=%v", tickerErr)
+	}
+	if ioc.timers.Len() != 0 {
+		t.Fatal("timers should have been closed")
+	}
+	if err := timer.ScheduleOnce(time.Hour, func(error) {}); !errors.Is(err, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected the timer to be closed err=%v", err)
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+}
+
 func BenchmarkPollOne(b *testing.B) {
 	ioc := MustIO()
 	defer ioc.Close()
@@ -329,3 +474,159 @@ func BenchmarkPollOne(b *testing.B) {
 		ioc.PollOne()
 	}
 }
+
+func TestPostFromPostedHandler(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	ran := 0
+	var post func()
+	post = func() {
+		ran++
+		if ran < 10 {
+			// This used to deadlock as posted handlers ran while holding the poller's lock.
+			ioc.Post(post)
+		}
+	}
+	ioc.Post(post)
+
+	start := time.Now()
+	for ran < 10 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected 10 posted handlers to run, ran %d", ran)
+		}
+		if _, err := ioc.PollOne(); err != nil && err != sonicerrors.ErrTimeout {
+			t.Fatal(err)
+		}
+	}
+	if ioc.Posted() != 0 {
+		t.Fatalf("expected no posted handlers, got %d", ioc.Posted())
+	}
+}
+
+func TestPostConcurrent(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	const (
+		producers = 8
+		posts     = 1000
+	)
+
+	var (
+		wg  sync.WaitGroup
+		ran int
+	)
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				ioc.Post(func() { ran++ })
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		if _, err := ioc.PollOne(); err != nil && err != sonicerrors.ErrTimeout {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+			if ioc.Posted() == 0 {
+				if ran != producers*posts {
+					t.Fatalf("expected %d handlers to run, ran %d", producers*posts, ran)
+				}
+				return
+			}
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	// Nothing polled the IO yet, so Dispatch posts.
+	ranOutside := false
+	ioc.Dispatch(func() { ranOutside = true })
+	if ranOutside {
+		t.Fatal("Dispatch should not run the handler inline before the IO is polled")
+	}
+	if ioc.Posted() != 1 {
+		t.Fatalf("expected 1 posted handler, got %d", ioc.Posted())
+	}
+
+	ranInline := false
+	ioc.Post(func() {
+		ioc.Dispatch(func() { ranInline = true })
+		if !ranInline {
+			t.Fatal("Dispatch should run the handler inline on the IO's goroutine")
+		}
+	})
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !ranOutside || !ranInline {
+		t.Fatal("expected both handlers to run")
+	}
+
+	// The loop has returned, so Dispatch posts even from the goroutine which ran it.
+	ranAfter := false
+	ioc.Dispatch(func() { ranAfter = true })
+	if ranAfter {
+		t.Fatal("Dispatch should not run the handler inline once the loop returned")
+	}
+
+	// The loop moves to another goroutine. Dispatch runs inline there and posts from the goroutine which ran it before.
+	ranMoved, ranPrevious := false, false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ioc.Post(func() {
+			ioc.Dispatch(func() { ranMoved = true })
+			if !ranMoved {
+				t.Error("Dispatch should run the handler inline on the goroutine the loop moved to")
+			}
+		})
+		if err := ioc.RunPending(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-done
+	ioc.Dispatch(func() { ranPrevious = true })
+	if ranPrevious {
+		t.Fatal("Dispatch should not run the handler inline on the goroutine the loop moved from")
+	}
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !ranAfter || !ranMoved || !ranPrevious {
+		t.Fatal("expected the dispatched handlers to run")
+	}
+
+	// Dispatch from another goroutine, while no Run* or Poll* function is running, posts.
+	ranOther := false
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		ioc.Dispatch(func() { ranOther = true })
+	}()
+	<-done
+	if ranOther {
+		t.Fatal("Dispatch should not run the handler inline on another goroutine")
+	}
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !ranOther {
+		t.Fatal("expected the dispatched handler to run")
+	}
+}
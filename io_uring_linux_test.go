@@ -0,0 +1,330 @@
+//go:build linux
+
+package sonic
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func mustUringIO(t *testing.T) *IO {
+	ioc, err := NewIO(sonicopts.IOUring(true))
+	if err != nil {
+		t.Skipf("io_uring not available err=%v", err)
+	}
+	return ioc
+}
+
+func TestIOUringPost(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	var xs [1000]bool
+	for i := 0; i < len(xs); i++ {
+		j := i
+		ioc.Post(func() {
+			xs[j] = true
+		})
+	}
+
+	if p := ioc.Pending(); p != 1000 {
+		t.Fatalf("not accounting for pending operations correctly expected=%d given=%d", 1000, p)
+	}
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, x := range xs {
+		if !x {
+			t.Fatalf("handler %d not set", i)
+		}
+	}
+
+	if p := ioc.Pending(); p != 0 {
+		t.Fatalf("not accounting for pending operations correctly expected=%d given=%d", 0, p)
+	}
+}
+
+func TestIOUringEmptyPoll(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	if _, err := ioc.PollOne(); !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected timeout as no operations are scheduled err=%v", err)
+	}
+
+	start := time.Now()
+	if err := ioc.RunOneFor(10 * time.Millisecond); !errors.Is(err, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected timeout as no operations are scheduled err=%v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("RunOneFor returned too early")
+	}
+}
+
+func TestIOUringTimer(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	fired := 0
+	if err := timer.ScheduleRepeating(time.Millisecond, func(error) {
+		fired++
+		if fired == 3 {
+			_ = timer.Cancel()
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fired != 3 {
+		t.Fatalf("expected the timer to fire 3 times, fired=%d", fired)
+	}
+}
+
+func TestIOUringTCPEcho(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	ln, err := Listen(ioc, "tcp", "localhost:9500", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", "localhost:9500")
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		b := make([]byte, 5)
+		for i := 0; i < 10; i++ {
+			time.Sleep(time.Millisecond)
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				return
+			}
+			if _, err := conn.Read(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	var (
+		echoed = 0
+		b      = make([]byte, 5)
+		conn   Conn
+		onRead AsyncCallback
+	)
+	onRead = func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != "hello" {
+			t.Fatalf("invalid message %s", string(b[:n]))
+		}
+		conn.AsyncWriteAll(b[:n], func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			echoed++
+			if echoed < 10 {
+				conn.AsyncReadAll(b, onRead)
+			}
+		})
+	}
+
+	ln.AsyncAccept(func(err error, c Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn = c
+		conn.AsyncReadAll(b, onRead)
+	})
+
+	start := time.Now()
+	for echoed < 10 && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if conn != nil {
+		conn.Close()
+	}
+
+	if echoed != 10 {
+		t.Fatalf("expected 10 echoes, got %d", echoed)
+	}
+}
+
+func TestIOUringFile(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(ioc, path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if ioc.submitter == nil {
+		t.Fatal("expected the io_uring IO to submit reads and writes")
+	}
+
+	// The read is submitted as the fifo is empty, and completes once the write below makes it readable.
+	var (
+		done    = false
+		readErr error
+		b       = make([]byte, 5)
+	)
+	f.AsyncReadAll(b, func(err error, _ int) {
+		done, readErr = true, err
+	})
+	if done {
+		t.Fatal("read completed before the write")
+	}
+	f.AsyncWriteAll([]byte("hello"), func(err error, _ int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	start := time.Now()
+	for !done && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected hello, got %q", b)
+	}
+}
+
+func TestIOUringFileReadTimeout(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(ioc, path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The submitted read is cancelled once the timeout passes.
+	var (
+		done    = false
+		readErr error
+		b       = make([]byte, 5)
+	)
+	f.AsyncReadTimeout(b, 5*time.Millisecond, func(err error, _ int) {
+		done, readErr = true, err
+	})
+
+	start := time.Now()
+	for !done && time.Since(start) < 5*time.Second {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !errors.Is(readErr, sonicerrors.ErrTimeout) {
+		t.Fatalf("expected timeout err=%v", readErr)
+	}
+
+	// The cancelled read does not complete later, and the file can still be read.
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	done, readErr = false, nil
+	f.AsyncReadAll(b, func(err error, _ int) {
+		if done {
+			t.Fatal("read completed twice")
+		}
+		done, readErr = true, err
+	})
+	for i := 0; i < 10; i++ {
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+	if !done || readErr != nil {
+		t.Fatalf("expected the read to complete done=%v err=%v", done, readErr)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected hello, got %q", b)
+	}
+}
+
+func TestIOUringPacketConn(t *testing.T) {
+	ioc := mustUringIO(t)
+	defer ioc.Close()
+
+	reader, err := NewPacketConn(ioc, "udp", "127.0.0.1:9501")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := NewPacketConn(ioc, "udp", "127.0.0.1:9502")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	var (
+		nread = 0
+		b     = make([]byte, 128)
+		from  net.Addr
+	)
+	var onRead AsyncReadCallbackPacket
+	onRead = func(err error, n int, addr net.Addr) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != "hello" {
+			t.Fatalf("invalid message %s", string(b[:n]))
+		}
+		nread, from = nread+1, addr
+		if nread < 10 {
+			reader.AsyncReadFrom(b, onRead)
+		}
+	}
+	reader.AsyncReadFrom(b, onRead)
+
+	start := time.Now()
+	for nread < 10 && time.Since(start) < 5*time.Second {
+		writer.AsyncWriteTo([]byte("hello"), reader.LocalAddr(), func(err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if nread != 10 {
+		t.Fatalf("expected 10 reads, got %d", nread)
+	}
+	if from.String() != writer.LocalAddr().String() {
+		t.Fatalf("expected to read from %s, got %s", writer.LocalAddr(), from)
+	}
+}
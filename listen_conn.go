@@ -4,6 +4,7 @@ import (
 	"net"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/talostrading/sonic/internal"
 	"github.com/talostrading/sonic/sonicerrors"
@@ -18,6 +19,9 @@ type listener struct {
 	addr net.Addr
 
 	dispatched int
+
+	// acceptDeadline is the deadline of AsyncAcceptTimeout.
+	acceptDeadline deadline
 }
 
 // Listen creates a Listener that listens for new connections on the local address.
@@ -67,6 +71,16 @@ func (l *listener) AsyncAccept(cb AcceptCallback) {
 	}
 }
 
+// AsyncAcceptTimeout is like AsyncAccept, but completes with sonicerrors.ErrTimeout if no connection is accepted within
+// the timeout.
+func (l *listener) AsyncAcceptTimeout(timeout time.Duration, cb AcceptCallback) {
+	l.acceptDeadline.op = time.Now().Add(timeout)
+	l.AsyncAccept(func(err error, conn Conn) {
+		l.acceptDeadline.op = time.Time{}
+		cb(err, conn)
+	})
+}
+
 func (l *listener) asyncAccept(cb AcceptCallback) {
 	l.slot.Set(internal.ReadEvent, l.handleAsyncAccept(cb))
 
@@ -74,12 +88,30 @@ func (l *listener) asyncAccept(cb AcceptCallback) {
 		cb(err, nil)
 	} else {
 		l.ioc.Register(&l.slot)
+		if err := l.acceptDeadline.arm(l.ioc, l.expireAccept); err != nil {
+			l.abortAccept(err)
+		}
+	}
+}
+
+func (l *listener) expireAccept(err error) {
+	l.abortAccept(deadlineError(err))
+}
+
+// abortAccept completes the pending accept, if any, with err.
+func (l *listener) abortAccept(err error) {
+	if l.slot.Events&internal.PollerReadEvent == internal.PollerReadEvent {
+		if derr := l.ioc.poller.DelRead(&l.slot); derr != nil {
+			err = derr
+		}
+		l.slot.Handlers[internal.ReadEvent](err)
 	}
 }
 
 func (l *listener) handleAsyncAccept(cb AcceptCallback) internal.Handler {
 	return func(err error) {
 		l.ioc.Deregister(&l.slot)
+		l.acceptDeadline.disarm()
 
 		if err != nil {
 			cb(err, nil)
@@ -113,6 +145,7 @@ func (l *listener) accept() (Conn, error) {
 }
 
 func (l *listener) Close() error {
+	l.acceptDeadline.close()
 	_ = l.ioc.poller.Del(&l.slot)
 	return syscall.Close(l.slot.Fd)
 }
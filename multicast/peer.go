@@ -209,6 +209,7 @@ func NewUDPPeer(ioc *sonic.IO, network string, addr string) (*UDPPeer, error) {
 	p.read = &readReactor{peer: p}
 	p.write = &writeReactor{peer: p}
 	p.slot.Fd = p.socket.RawFd()
+	p.slot.Persistent = true
 
 	if ipv == 4 {
 		p.outboundIP, err = ipv4.GetMulticastInterfaceAddr(p.socket)
@@ -676,6 +677,7 @@ func (p *UDPPeer) LocalAddr() *net.UDPAddr {
 func (p *UDPPeer) Close() error {
 	if !p.closed {
 		p.closed = true
+		_ = p.ioc.Del(&p.slot)
 		return p.socket.Close()
 	}
 	return nil
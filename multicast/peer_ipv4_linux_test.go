@@ -443,7 +443,7 @@ func TestUDPPeerIPv4_JoinReadBlockUnblockRead(t *testing.T) {
 	}
 	defer blockTimer.Close()
 
-	err = blockTimer.ScheduleOnce(2*time.Second, func() {
+	err = blockTimer.ScheduleOnce(2*time.Second, func(error) {
 		log.Printf("blocking source %s", writerAddr)
 		if err := r.BlockSource(
 			IP(multicastIP), SourceIP(writerAddr.Addr().String())); err != nil {
@@ -451,7 +451,7 @@ func TestUDPPeerIPv4_JoinReadBlockUnblockRead(t *testing.T) {
 		} else {
 			log.Printf("blocked source %s n_read=%d", writerAddr, nRead)
 			nRead = 0
-			err = blockTimer.ScheduleOnce(2*time.Second, func() {
+			err = blockTimer.ScheduleOnce(2*time.Second, func(error) {
 				log.Printf("unblocking source %s", writerAddr)
 				if err := r.UnblockSource(
 					IP(multicastIP),
@@ -0,0 +1,72 @@
+//go:build linux
+
+package multicast
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+func TestUDPPeerIPv4_IOUring(t *testing.T) {
+	ioc, err := sonic.NewIO(sonicopts.IOUring(true))
+	if err != nil {
+		t.Skipf("io_uring not available err=%v", err)
+	}
+	defer ioc.Close()
+
+	reader, err := NewUDPPeer(ioc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer, err := NewUDPPeer(ioc, "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	to := reader.LocalAddr().AddrPort()
+	var (
+		nread = 0
+		b     = make([]byte, 128)
+		from  netip.AddrPort
+	)
+	var onRead func(error, int, netip.AddrPort)
+	onRead = func(err error, n int, addr netip.AddrPort) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != "hello" {
+			t.Fatalf("invalid message %s", string(b[:n]))
+		}
+		nread, from = nread+1, addr
+		if nread < 10 {
+			reader.AsyncRead(b, onRead)
+		}
+	}
+	reader.AsyncRead(b, onRead)
+
+	start := time.Now()
+	for nread < 10 && time.Since(start) < 5*time.Second {
+		writer.AsyncWrite([]byte("hello"), to, func(err error, _ int) {
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		_ = ioc.RunOneFor(time.Millisecond)
+	}
+
+	if nread != 10 {
+		t.Fatalf("expected 10 reads, got %d", nread)
+	}
+	if from.Port() != uint16(writer.LocalAddr().Port) {
+		t.Fatalf("expected to read from %s, got %s", writer.LocalAddr(), from)
+	}
+	if reader.Stats().async.scheduledReads == 0 {
+		t.Fatal("expected reads to be scheduled on the poller")
+	}
+}
@@ -0,0 +1,151 @@
+package sonic
+
+import (
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// PollStats describes a single poll made by an IO's event processing loop.
+type PollStats struct {
+	// Events is the number of events returned by the poller, including the wake-ups caused by Post.
+	Events int
+
+	// Handlers is the number of handlers dispatched.
+	Handlers int
+
+	// Wait is the time spent blocked in the poller, i.e. in epoll_wait, kevent or io_uring_enter.
+	Wait time.Duration
+
+	// HandlerTime is the total time spent in handlers.
+	HandlerTime time.Duration
+
+	// SlowestHandler is the time taken by the slowest handler.
+	SlowestHandler time.Duration
+
+	// Posted is the number of handlers queued with Post after the poll.
+	Posted int
+}
+
+// IOObserver observes an IO's event processing loop. Attach it with IO.SetObserver. All methods are called in the IO's
+// goroutine.
+type IOObserver interface {
+	// OnHandler is called after each handler the IO dispatches, with the time the handler took.
+	OnHandler(took time.Duration)
+
+	// OnPoll is called after each poll, including the ones which timed out.
+	OnPoll(stats PollStats)
+}
+
+// ioMonitor adapts an IOObserver to the poller's monitoring hooks.
+type ioMonitor struct {
+	observer IOObserver
+	stats    PollStats
+}
+
+func (m *ioMonitor) OnWait(waited time.Duration, events int) {
+	m.stats.Wait = waited
+	m.stats.Events = events
+}
+
+func (m *ioMonitor) OnHandler(took time.Duration) {
+	m.stats.Handlers++
+	m.stats.HandlerTime += took
+	if took > m.stats.SlowestHandler {
+		m.stats.SlowestHandler = took
+	}
+	m.observer.OnHandler(took)
+}
+
+func (m *ioMonitor) onPoll(posted int) {
+	m.stats.Posted = posted
+	m.observer.OnPoll(m.stats)
+	m.stats = PollStats{}
+}
+
+var _ IOObserver = &IOMetrics{}
+
+// IOMetricsSnapshot aggregates the PollStats of an IO since the IOMetrics was created or last reset.
+type IOMetricsSnapshot struct {
+	Polls      int64 // Number of polls.
+	EmptyPolls int64 // Number of polls which did not dispatch any handler.
+	Events     int64 // Number of events returned by the poller.
+	Handlers   int64 // Number of dispatched handlers.
+
+	Wait        time.Duration // Total time spent blocked in the poller.
+	HandlerTime time.Duration // Total time spent in handlers.
+	MaxHandler  time.Duration // Time taken by the slowest handler.
+
+	// SlowHandlers is the number of handlers which took longer than the threshold passed to NewIOMetrics.
+	SlowHandlers int64
+
+	Posted    int // Number of handlers queued with Post after the last poll.
+	MaxPosted int // Maximum number of handlers queued with Post after a poll.
+}
+
+// IOMetrics is an IOObserver which aggregates the PollStats of an IO into an IOMetricsSnapshot.
+//
+// IOMetrics is not safe for concurrent use. Snapshot and Reset must be called in the IO's goroutine, for example from
+// a Timer callback or from a handler passed to Post.
+type IOMetrics struct {
+	slowHandler time.Duration
+	hist        *hdrhistogram.Histogram
+	snapshot    IOMetricsSnapshot
+}
+
+// NewIOMetrics creates an IOMetrics which counts the handlers taking longer than slowHandler. A zero slowHandler
+// disables counting slow handlers.
+//
+// If hist is not nil, the duration of each handler is recorded in it, in nanoseconds.
+func NewIOMetrics(slowHandler time.Duration, hist *hdrhistogram.Histogram) *IOMetrics {
+	return &IOMetrics{
+		slowHandler: slowHandler,
+		hist:        hist,
+	}
+}
+
+func (m *IOMetrics) OnHandler(took time.Duration) {
+	m.snapshot.Handlers++
+	m.snapshot.HandlerTime += took
+	if took > m.snapshot.MaxHandler {
+		m.snapshot.MaxHandler = took
+	}
+	if m.slowHandler > 0 && took > m.slowHandler {
+		m.snapshot.SlowHandlers++
+	}
+	if m.hist != nil {
+		// The duration might be outside the histogram's range, in which case we ignore it.
+		_ = m.hist.RecordValue(took.Nanoseconds())
+	}
+}
+
+func (m *IOMetrics) OnPoll(stats PollStats) {
+	m.snapshot.Polls++
+	if stats.Handlers == 0 {
+		m.snapshot.EmptyPolls++
+	}
+	m.snapshot.Events += int64(stats.Events)
+	m.snapshot.Wait += stats.Wait
+	m.snapshot.Posted = stats.Posted
+	if stats.Posted > m.snapshot.MaxPosted {
+		m.snapshot.MaxPosted = stats.Posted
+	}
+}
+
+// Snapshot returns the metrics aggregated so far.
+func (m *IOMetrics) Snapshot() IOMetricsSnapshot {
+	return m.snapshot
+}
+
+// Histogram returns the histogram passed to NewIOMetrics, if any.
+func (m *IOMetrics) Histogram() *hdrhistogram.Histogram {
+	return m.hist
+}
+
+// Reset resets the metrics and the histogram, if any.
+func (m *IOMetrics) Reset() {
+	m.snapshot = IOMetricsSnapshot{}
+	if m.hist != nil {
+		m.hist.Reset()
+	}
+}
@@ -0,0 +1,118 @@
+package sonic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestIOMetrics(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	hist := hdrhistogram.New(1, int64(time.Second), 1)
+	metrics := NewIOMetrics(time.Millisecond, hist)
+	ioc.SetObserver(metrics)
+
+	for i := 0; i < 3; i++ {
+		ioc.Post(func() {
+			time.Sleep(2 * time.Millisecond)
+		})
+	}
+	ioc.Post(func() {})
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := metrics.Snapshot()
+	if s.Handlers != 4 {
+		t.Fatalf("expected 4 handlers, got %d", s.Handlers)
+	}
+	if s.SlowHandlers != 3 {
+		t.Fatalf("expected 3 slow handlers, got %d", s.SlowHandlers)
+	}
+	if s.HandlerTime < 6*time.Millisecond {
+		t.Fatalf("expected at least 6ms in handlers, got %s", s.HandlerTime)
+	}
+	if s.MaxHandler < 2*time.Millisecond {
+		t.Fatalf("expected the slowest handler to take at least 2ms, got %s", s.MaxHandler)
+	}
+	if s.Polls == 0 || s.Events == 0 {
+		t.Fatalf("expected at least one poll with events, got %+v", s)
+	}
+	if hist.TotalCount() != 4 {
+		t.Fatalf("expected 4 values in the histogram, got %d", hist.TotalCount())
+	}
+
+	metrics.Reset()
+
+	// A signal might interrupt the poller before the timeout, which the IO also reports as a timeout. So we poll until
+	// one wait lasts the full 10ms.
+	waited := false
+	for i := 0; i < 100 && !waited; i++ {
+		before := metrics.Snapshot().Wait
+		if err := ioc.RunOneFor(10 * time.Millisecond); !errors.Is(err, sonicerrors.ErrTimeout) {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+		waited = metrics.Snapshot().Wait-before >= 10*time.Millisecond
+	}
+	if !waited {
+		t.Fatal("expected a wait of at least 10ms")
+	}
+	s = metrics.Snapshot()
+	if s.Polls == 0 || s.EmptyPolls != s.Polls {
+		t.Fatalf("expected only empty polls, got %+v", s)
+	}
+	if hist.TotalCount() != 0 {
+		t.Fatal("histogram should have been reset")
+	}
+
+	ioc.SetObserver(nil)
+	if err := ioc.RunOneFor(time.Millisecond); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if metrics.Snapshot().Polls != s.Polls {
+		t.Fatal("observer should have been detached")
+	}
+}
+
+func TestIOMetricsPostedQueue(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	metrics := NewIOMetrics(0, nil)
+	ioc.SetObserver(metrics)
+
+	for i := 0; i < 10; i++ {
+		ioc.Post(func() {})
+	}
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	fired := false
+	if err := timer.ScheduleOnce(time.Millisecond, func(error) { fired = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if !fired {
+		t.Fatal("timer should have fired")
+	}
+
+	s := metrics.Snapshot()
+	if s.Handlers != 11 {
+		t.Fatalf("expected 11 handlers, got %d", s.Handlers)
+	}
+	if s.Posted != 0 {
+		t.Fatalf("expected an empty post queue, got %d", s.Posted)
+	}
+}
@@ -43,7 +43,7 @@ func NewPacketConn(ioc *IO, network, addr string, opts ...sonicopts.Option) (Pac
 
 	return &packetConn{
 		ioc:       ioc,
-		slot:      internal.Slot{Fd: fd},
+		slot:      internal.Slot{Fd: fd, Persistent: true},
 		localAddr: localAddr,
 		closed:    0,
 	}, nil
@@ -195,6 +195,7 @@ func (c *packetConn) getWriteHandler(b []byte, to net.Addr, cb AsyncWriteCallbac
 
 func (c *packetConn) Close() error {
 	atomic.StoreUint32(&c.closed, 1)
+	_ = c.ioc.Del(&c.slot)
 	return syscall.Close(c.slot.Fd)
 }
 
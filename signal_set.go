@@ -0,0 +1,229 @@
+package sonic
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// SignalCallback is called with the signal that was delivered to the process, or with an error if the wait failed
+// or was cancelled.
+type SignalCallback func(err error, sig os.Signal)
+
+// SignalSet delivers POSIX signals to the IO's goroutine, like any other asynchronous operation, such that they can be
+// handled without synchronizing with the rest of the program.
+//
+// Signals are caught with os/signal and forwarded, one byte per signal, through a nonblocking pipe registered with the
+// IO. signalfd is not used: it only receives signals which are blocked in every thread of the process, and the Go
+// runtime unblocks signals on the threads it creates and handles them itself, so signalfd would miss most of them.
+//
+// Like POSIX signals, multiple deliveries of the same signal may be coalesced if the IO does not keep up.
+//
+// A SignalSet must only be used from the IO's goroutine, and must be closed.
+type SignalSet struct {
+	ioc  *IO
+	pipe *internal.Pipe
+
+	// ch receives the signals in sigs from os/signal. It lives as long as the SignalSet.
+	ch   chan os.Signal
+	sigs map[syscall.Signal]struct{}
+	wg   sync.WaitGroup
+
+	// queued holds the signals read from the pipe and not yet delivered to a callback.
+	queued []syscall.Signal
+	b      [64]byte
+
+	closed bool
+}
+
+// NewSignalSet creates a SignalSet which catches the provided signals. More signals can be added with Add.
+func NewSignalSet(ioc *IO, sigs ...os.Signal) (*SignalSet, error) {
+	pipe, err := internal.NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pipe.SetReadNonblock(); err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+	if err := pipe.SetWriteNonblock(); err != nil {
+		_ = pipe.Close()
+		return nil, err
+	}
+
+	s := &SignalSet{
+		ioc:  ioc,
+		pipe: pipe,
+		sigs: make(map[syscall.Signal]struct{}),
+	}
+	s.ch = make(chan os.Signal, len(s.b))
+	s.wg.Add(1)
+	go s.forward()
+
+	if err := s.Add(sigs...); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add catches the provided signals in addition to the ones already in the set.
+func (s *SignalSet) Add(sigs ...os.Signal) error {
+	if s.closed {
+		return sonicerrors.ErrCancelled
+	}
+
+	for _, sig := range sigs {
+		ssig, ok := sig.(syscall.Signal)
+		if !ok || ssig <= 0 || ssig > 255 {
+			return fmt.Errorf("unsupported signal %v", sig)
+		}
+	}
+	for _, sig := range sigs {
+		s.sigs[sig.(syscall.Signal)] = struct{}{}
+	}
+
+	// Notify adds the signals to the ones the channel already receives.
+	if len(sigs) > 0 {
+		signal.Notify(s.ch, sigs...)
+	}
+	return nil
+}
+
+// Remove stops catching the provided signals and restores their default behaviour. Signals which are already queued
+// and not in the set anymore are dropped.
+//
+// os/signal cannot remove a signal from a single channel, so the signals are reset with signal.Reset. This also stops
+// other parts of the program from catching them with os/signal.
+func (s *SignalSet) Remove(sigs ...os.Signal) {
+	if s.closed {
+		return
+	}
+
+	removed := make([]os.Signal, 0, len(sigs))
+	for _, sig := range sigs {
+		if ssig, ok := sig.(syscall.Signal); ok {
+			if _, ok := s.sigs[ssig]; ok {
+				delete(s.sigs, ssig)
+				removed = append(removed, sig)
+			}
+		}
+	}
+
+	// The signals left in the set stay subscribed, so none of them gets its default behaviour in the meantime.
+	if len(removed) > 0 {
+		signal.Reset(removed...)
+	}
+}
+
+func (s *SignalSet) forward() {
+	defer s.wg.Done()
+
+	var b [1]byte
+	for sig := range s.ch {
+		b[0] = byte(sig.(syscall.Signal))
+
+		// The pipe is nonblocking. If it is full, the IO is behind by many signals and this one is dropped.
+		_, _ = s.pipe.Write(b[:])
+	}
+}
+
+// AsyncWait waits for one of the signals in the set to be delivered to the process and calls the callback with it in
+// the IO's goroutine. There must be at most one pending AsyncWait.
+func (s *SignalSet) AsyncWait(cb SignalCallback) {
+	if s.closed {
+		cb(sonicerrors.ErrCancelled, nil)
+		return
+	}
+
+	if sig, ok := s.dequeue(); ok {
+		cb(nil, sig)
+		return
+	}
+
+	slot := s.pipe.Slot()
+	slot.Set(internal.ReadEvent, func(err error) {
+		s.ioc.Deregister(slot)
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+		s.read()
+		s.AsyncWait(cb)
+	})
+
+	if err := s.ioc.SetRead(slot); err != nil {
+		cb(err, nil)
+	} else {
+		s.ioc.Register(slot)
+	}
+}
+
+// read queues all signals available in the pipe.
+func (s *SignalSet) read() {
+	for {
+		n, err := s.pipe.Read(s.b[:])
+		for i := 0; i < n; i++ {
+			s.queued = append(s.queued, syscall.Signal(s.b[i]))
+		}
+		if err != nil || n < len(s.b) {
+			return
+		}
+	}
+}
+
+// dequeue returns the first queued signal which is still in the set.
+func (s *SignalSet) dequeue() (os.Signal, bool) {
+	for len(s.queued) > 0 {
+		sig := s.queued[0]
+		s.queued = s.queued[1:]
+		if _, ok := s.sigs[sig]; ok {
+			return sig, true
+		}
+	}
+	return nil, false
+}
+
+// Cancel cancels the pending AsyncWait, if any. Its callback is called with sonicerrors.ErrCancelled.
+func (s *SignalSet) Cancel() error {
+	slot := s.pipe.Slot()
+	if slot.Events&internal.PollerReadEvent != internal.PollerReadEvent {
+		return nil
+	}
+
+	handler := slot.Handlers[internal.ReadEvent]
+	if err := s.ioc.Del(slot); err != nil {
+		return err
+	}
+	s.ioc.Deregister(slot)
+	handler(sonicerrors.ErrCancelled)
+	return nil
+}
+
+// Close stops catching signals, cancels the pending AsyncWait, if any, and releases the SignalSet's resources.
+func (s *SignalSet) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	// After signal.Stop returns, os/signal does not send on the channel anymore, so it can be closed.
+	s.sigs = make(map[syscall.Signal]struct{})
+	signal.Stop(s.ch)
+	close(s.ch)
+	s.wg.Wait()
+
+	// Closed before cancelling such that the cancelled callback cannot wait again.
+	s.closed = true
+	s.queued = nil
+	err := s.Cancel()
+
+	if cerr := s.pipe.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package sonic
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+func TestSignalSet(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	s, err := NewSignalSet(ioc, syscall.SIGUSR1, syscall.SIGUSR2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var got []os.Signal
+	var onSignal SignalCallback
+	onSignal = func(err error, sig os.Signal) {
+		if err != nil {
+			return
+		}
+		got = append(got, sig)
+		s.AsyncWait(onSignal)
+	}
+	s.AsyncWait(onSignal)
+
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2} {
+		if err := syscall.Kill(os.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		for len(got) == 0 || got[len(got)-1] != sig {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("expected %v to be delivered, got %v", sig, got)
+			}
+			if err := ioc.RunOneFor(time.Millisecond); err != nil && err != sonicerrors.ErrTimeout {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSignalSetRemove(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	s, err := NewSignalSet(ioc, syscall.SIGUSR1, syscall.SIGUSR2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	// Let the signal reach the SignalSet's pipe.
+	time.Sleep(50 * time.Millisecond)
+
+	// The queued SIGUSR1 must be dropped as it is not in the set anymore.
+	s.Remove(syscall.SIGUSR1)
+
+	var got os.Signal
+	s.AsyncWait(func(err error, sig os.Signal) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = sig
+	})
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for got == nil {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected SIGUSR2 to be delivered")
+		}
+		if err := ioc.RunOneFor(time.Millisecond); err != nil && err != sonicerrors.ErrTimeout {
+			t.Fatal(err)
+		}
+	}
+	if got != syscall.SIGUSR2 {
+		t.Fatalf("expected SIGUSR2, got %v", got)
+	}
+}
+
+func TestSignalSetCancel(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	s, err := NewSignalSet(ioc, syscall.SIGUSR1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var waitErr error
+	s.AsyncWait(func(err error, _ os.Signal) {
+		waitErr = err
+	})
+	if ioc.Pending() != 1 {
+		t.Fatalf("expected 1 pending operation, got %d", ioc.Pending())
+	}
+
+	if err := s.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(waitErr, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected the wait to be cancelled, got %v", waitErr)
+	}
+	if ioc.Pending() != 0 {
+		t.Fatalf("expected no pending operations, got %d", ioc.Pending())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s.AsyncWait(func(err error, _ os.Signal) {
+		waitErr = err
+	})
+	if !errors.Is(waitErr, sonicerrors.ErrCancelled) {
+		t.Fatalf("expected waiting on a closed SignalSet to fail, got %v", waitErr)
+	}
+}
@@ -1,6 +1,11 @@
 package sonicerrors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+)
 
 var (
 	ErrWouldBlock             = errors.New("operation would block")
@@ -8,4 +13,33 @@ var (
 	ErrTimeout                = errors.New("operation timed out")
 	ErrNeedMore               = errors.New("need to read/write more bytes")
 	ErrNoBufferSpaceAvailable = errors.New("no buffer space available")
+
+	// ErrPeerReset is matched by errors.Is when the peer reset the connection.
+	ErrPeerReset = errors.New("connection reset by peer")
+
+	// ErrPeerHalfClosed is delivered to a pending read when the peer shut down its write side and there is nothing
+	// left to read. It wraps io.EOF.
+	ErrPeerHalfClosed = fmt.Errorf("peer half-closed the connection: %w", io.EOF)
+
+	// ErrHostNotIP is returned by the asynchronous dials when the host of the address is not an IP address. Resolving
+	// it would block the IO, dns.Resolver resolves names asynchronously instead.
+	ErrHostNotIP = errors.New("host is not an IP address")
 )
+
+// SocketError is delivered to pending asynchronous operations when the poller reports an error condition on a file
+// descriptor. Errno is the pending socket error, as reported by SO_ERROR.
+type SocketError struct {
+	Errno syscall.Errno
+}
+
+func (e *SocketError) Error() string {
+	return fmt.Sprintf("socket error: %s", e.Errno.Error())
+}
+
+func (e *SocketError) Unwrap() error {
+	return e.Errno
+}
+
+func (e *SocketError) Is(target error) bool {
+	return target == ErrPeerReset && e.Errno == syscall.ECONNRESET
+}
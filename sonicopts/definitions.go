@@ -16,6 +16,11 @@ const (
 	TypeNoDelay
 	TypeBindSocket
 	TypeMulticast
+	TypeIOUring
+	TypeRealtimeTimers
+	TypeHappyEyeballs
+	TypeSendBuffer
+	TypeRecvBuffer
 	MaxOption
 )
 
@@ -33,6 +38,16 @@ func (t OptionType) String() string {
 		return "bind_socket"
 	case TypeMulticast:
 		return "multicast"
+	case TypeIOUring:
+		return "io_uring"
+	case TypeRealtimeTimers:
+		return "realtime_timers"
+	case TypeHappyEyeballs:
+		return "happy_eyeballs"
+	case TypeSendBuffer:
+		return "send_buffer"
+	case TypeRecvBuffer:
+		return "recv_buffer"
 	default:
 		panic(fmt.Errorf("invalid option %d", t))
 	}
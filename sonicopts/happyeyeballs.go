@@ -0,0 +1,27 @@
+package sonicopts
+
+import "time"
+
+type happyEyeballs struct {
+	v time.Duration
+}
+
+// HappyEyeballs makes sonic.Dial and sonic.DialTimeout race the IPv6 and IPv4 addresses of a TCP host, as described by
+// RFC 8305, instead of connecting to its first address only.
+//
+// Connection attempts alternate between the two families, IPv6 first, and start delay apart, or as soon as the
+// previous one fails. The first connection established wins, the others are closed. RFC 8305 recommends a delay of
+// 250ms. The address which won is the RemoteAddr of the connection.
+func HappyEyeballs(delay time.Duration) Option {
+	return &happyEyeballs{
+		v: delay,
+	}
+}
+
+func (o *happyEyeballs) Type() OptionType {
+	return TypeHappyEyeballs
+}
+
+func (o *happyEyeballs) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,20 @@
+package sonicopts
+
+type ioUring struct {
+	v bool
+}
+
+// IOUring selects the io_uring backend when passed to sonic.NewIO. It is only supported on Linux 5.11 or newer.
+func IOUring(v bool) Option {
+	return &ioUring{
+		v: v,
+	}
+}
+
+func (o *ioUring) Type() OptionType {
+	return TypeIOUring
+}
+
+func (o *ioUring) Value() interface{} {
+	return o.v
+}
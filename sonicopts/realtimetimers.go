@@ -0,0 +1,24 @@
+package sonicopts
+
+type realtimeTimers struct {
+	v bool
+}
+
+// RealtimeTimers makes the Timers of an IO follow CLOCK_REALTIME instead of CLOCK_MONOTONIC when passed to
+// sonic.NewIO.
+//
+// Realtime Timers follow changes of the system time, like NTP steps, which makes them a fit for deadlines given in
+// wall-clock time. Monotonic Timers, the default, are not affected by such changes and are a fit for timeouts.
+func RealtimeTimers(v bool) Option {
+	return &realtimeTimers{
+		v: v,
+	}
+}
+
+func (o *realtimeTimers) Type() OptionType {
+	return TypeRealtimeTimers
+}
+
+func (o *realtimeTimers) Value() interface{} {
+	return o.v
+}
@@ -0,0 +1,21 @@
+package sonicopts
+
+type recvBuffer struct {
+	size int
+}
+
+// RecvBuffer sets the size of the kernel receive buffer of the socket
+// (SO_RCVBUF).
+func RecvBuffer(size int) Option {
+	return &recvBuffer{
+		size: size,
+	}
+}
+
+func (o *recvBuffer) Type() OptionType {
+	return TypeRecvBuffer
+}
+
+func (o *recvBuffer) Value() interface{} {
+	return o.size
+}
@@ -0,0 +1,21 @@
+package sonicopts
+
+type sendBuffer struct {
+	size int
+}
+
+// SendBuffer sets the size of the kernel send buffer of the socket
+// (SO_SNDBUF).
+func SendBuffer(size int) Option {
+	return &sendBuffer{
+		size: size,
+	}
+}
+
+func (o *sendBuffer) Type() OptionType {
+	return TypeSendBuffer
+}
+
+func (o *sendBuffer) Value() interface{} {
+	return o.size
+}
@@ -42,7 +42,7 @@ func main() {
 		panic(err)
 	}
 	defer ticker.Close()
-	if err := ticker.ScheduleRepeating(time.Second, func() {
+	if err := ticker.ScheduleRepeating(time.Second, func(error) {
 		log.Print("tick")
 	}); err != nil {
 		panic(err)
@@ -57,7 +57,7 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		t.ScheduleRepeating(*rate, func() {
+		t.ScheduleRepeating(*rate, func(error) {
 			conn.AsyncWriteAll(b, func(err error, _ int) {
 				if err != nil {
 					panic(err)
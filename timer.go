@@ -3,7 +3,6 @@ package sonic
 import (
 	"time"
 
-	"github.com/talostrading/sonic/internal"
 	"github.com/talostrading/sonic/sonicerrors"
 )
 
@@ -28,27 +27,36 @@ func (s timerState) String() string {
 	}
 }
 
+// Timer schedules callbacks to run in the IO's goroutine after a delay.
+//
+// All Timers of an IO share a single kernel timer, so creating a Timer does not make any syscalls and scheduling or
+// cancelling one only makes a syscall if it changes the IO's earliest deadline.
+//
+// Callbacks are called with a nil error once their deadline passes. If the IO is closed before that, they are called
+// with sonicerrors.ErrCancelled. Cancelling or closing the Timer itself does not call them. A repeating Timer is not
+// scheduled again once its callback is called with an error.
 type Timer struct {
 	ioc   *IO
-	it    *internal.Timer
 	state timerState
 
-	// This is only checked in ScheduleRepeating. It is set in Cancel.
-	// This ensures that we do not schedule the timer again if the ScheduleRepeating
+	// This is only checked in ScheduleRepeating and ScheduleRepeatingAt. It is set in Cancel.
+	// This ensures that we do not schedule the timer again if the repeating
 	// callback cancelled the timer.
 	cancelled bool
+
+	// deadline is on the clock of the IO's timer queue, see sonicopts.RealtimeTimers.
+	deadline int64
+	cb       func(err error)
+
+	// index is the position of the timer in the IO's timer queue, or -1 if it is not scheduled.
+	index int
 }
 
 func NewTimer(ioc *IO) (*Timer, error) {
-	it, err := internal.NewTimer(ioc.poller)
-	if err != nil {
-		return nil, err
-	}
-
 	return &Timer{
 		ioc:   ioc,
-		it:    it,
 		state: stateReady,
+		index: -1,
 	}, nil
 }
 
@@ -58,22 +66,13 @@ func NewTimer(ioc *IO) (*Timer, error) {
 // However, it is possible that it will be called a little after the delay.
 //
 // If the delay is negative or 0, the callback is executed as soon as possible.
-func (t *Timer) ScheduleOnce(delay time.Duration, cb func()) (err error) {
+func (t *Timer) ScheduleOnce(delay time.Duration, cb func(err error)) (err error) {
 	if t.state == stateReady {
 		t.cancelled = false
 		if delay <= 0 {
-			cb()
+			cb(nil)
 		} else {
-			err = t.it.Set(delay, func() {
-				delete(t.ioc.pendingTimers, t)
-				t.state = stateReady
-				cb()
-			})
-
-			if err == nil {
-				t.ioc.pendingTimers[t] = struct{}{}
-				t.state = stateScheduled
-			}
+			err = t.schedule(t.ioc.timers.now()+int64(delay), cb)
 		}
 	} else {
 		err = sonicerrors.ErrCancelled
@@ -81,30 +80,116 @@ func (t *Timer) ScheduleOnce(delay time.Duration, cb func()) (err error) {
 	return
 }
 
+// ScheduleAt schedules a callback for execution at the deadline.
+//
+// By default, Timers follow the monotonic clock, so the deadline is converted to the time left until it, and changes of
+// the system time after scheduling do not affect it. With sonicopts.RealtimeTimers, the callback runs when the system
+// time reaches the deadline, even if the system time changes in the meantime.
+//
+// If the deadline already passed, the callback is executed as soon as possible.
+func (t *Timer) ScheduleAt(deadline time.Time, cb func(err error)) (err error) {
+	if t.state == stateReady {
+		t.cancelled = false
+		if at := t.ioc.timers.deadline(deadline); at <= t.ioc.timers.now() {
+			cb(nil)
+		} else {
+			err = t.schedule(at, cb)
+		}
+	} else {
+		err = sonicerrors.ErrCancelled
+	}
+	return
+}
+
+// schedule adds the timer to the IO's timer queue. The deadline is on the queue's clock.
+func (t *Timer) schedule(deadline int64, cb func(err error)) (err error) {
+	t.deadline = deadline
+	t.cb = cb
+	t.state = stateScheduled
+
+	if err = t.ioc.timers.push(t); err != nil {
+		_ = t.ioc.timers.remove(t)
+		t.state = stateReady
+		t.cb = nil
+	}
+	return
+}
+
 // ScheduleRepeating schedules a callback for execution once per interval.
 //
 // The callback is guaranteed to never be called before the repeat delay.
 // However, it is possible that it will be called a little after the
-// repeat delay.
+// repeat delay. Such delays do not accumulate: the n-th call is due n
+// repeat delays after scheduling. See ScheduleRepeatingAt.
 //
 // If the delay is negative or 0, the operation is cancelled.
-func (t *Timer) ScheduleRepeating(repeat time.Duration, cb func()) error {
+func (t *Timer) ScheduleRepeating(repeat time.Duration, cb func(err error)) error {
 	if repeat <= 0 {
 		return sonicerrors.ErrCancelled
-	} else {
-		var ccb func()
-		ccb = func() {
-			cb()
-			if t.cancelled {
-				t.cancelled = false
-			} else {
-				// TODO this error should not be ignored
-				_ = t.ScheduleOnce(repeat, ccb)
-			}
+	}
+	return t.scheduleRepeating(t.ioc.timers.now()+int64(repeat), repeat, func(err error, _ int) { cb(err) })
+}
+
+// ScheduleRepeatingAt schedules a callback for execution at start and then once per interval, at start + n * interval.
+//
+// The deadlines do not drift, regardless of how late each call is. If the IO falls behind by more than an interval, the
+// missed calls are skipped and their number is passed to the callback as overruns, which is 0 otherwise.
+//
+// With sonicopts.RealtimeTimers, this keeps a Timer aligned to wall-clock boundaries:
+//
+//	start := time.Now().Truncate(time.Second).Add(time.Second)
+//	timer.ScheduleRepeatingAt(start, time.Second, heartbeat)
+//
+// If the interval is negative or 0, the operation is cancelled.
+func (t *Timer) ScheduleRepeatingAt(start time.Time, interval time.Duration, cb func(err error, overruns int)) error {
+	if interval <= 0 {
+		return sonicerrors.ErrCancelled
+	}
+	return t.scheduleRepeating(t.ioc.timers.deadline(start), interval, cb)
+}
+
+func (t *Timer) scheduleRepeating(first int64, interval time.Duration, cb func(err error, overruns int)) error {
+	if t.state != stateReady {
+		return sonicerrors.ErrCancelled
+	}
+	t.cancelled = false
+
+	deadline := first
+	var ccb func(error)
+	ccb = func(err error) {
+		if err != nil {
+			cb(err, 0)
+			return
 		}
 
-		return t.ScheduleOnce(repeat, ccb)
+		// The next deadline is a whole number of intervals after the previous one. Skip the ones which already passed.
+		next, overruns := deadline+int64(interval), 0
+		if now := t.ioc.timers.now(); next <= now {
+			overruns = int((now-next)/int64(interval)) + 1
+			next += int64(overruns) * int64(interval)
+		}
+
+		cb(nil, overruns)
+		if t.cancelled {
+			t.cancelled = false
+		} else if t.state == stateReady {
+			deadline = next
+			if err := t.schedule(next, ccb); err != nil {
+				// schedule left the timer ready, it is not called again.
+				cb(err, 0)
+			}
+		}
 	}
+
+	return t.schedule(first, ccb)
+}
+
+// expire is called by the IO's timer queue once the deadline passed.
+func (t *Timer) expire(err error) {
+	cb := t.cb
+	t.cb = nil
+	t.state = stateReady
+	cb(err)
 }
 
 func (t *Timer) Scheduled() bool {
@@ -112,10 +197,15 @@ func (t *Timer) Scheduled() bool {
 }
 
 func (t *Timer) Cancel() error {
-	err := t.it.Unset()
+	if t.state == stateClosed {
+		return nil
+	}
+
+	err := t.ioc.timers.remove(t)
 	if err == nil {
 		t.cancelled = true
 		t.state = stateReady
+		t.cb = nil
 	}
 	return err
 }
@@ -126,10 +216,10 @@ func (t *Timer) Cancel() error {
 // therefore never complete.
 func (t *Timer) Close() (err error) {
 	if t.state != stateClosed {
-		err = t.it.Close()
+		err = t.ioc.timers.remove(t)
 		if err == nil {
 			t.state = stateClosed
-			delete(t.ioc.pendingTimers, t)
+			t.cb = nil
 		}
 	}
 	return
@@ -0,0 +1,192 @@
+package sonic
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/talostrading/sonic/internal"
+	"github.com/talostrading/sonic/sonicerrors"
+)
+
+// timerQueue multiplexes all scheduled Timers of an IO onto a single internal timer, armed for the earliest deadline.
+// Scheduling and cancelling a Timer is a heap operation and only costs a syscall when the earliest deadline changes.
+type timerQueue struct {
+	ioc *IO
+
+	// it is created when the first Timer is scheduled.
+	it *internal.Timer
+
+	// realtime selects the clock of it and of the deadlines, see internal.ClockNow.
+	realtime bool
+
+	heap timerHeap
+
+	// armed is the deadline it is armed for. It is zero if it is not armed.
+	armed int64
+
+	// expiring is true while the callbacks of expired Timers run. it is rearmed once, after all of them ran.
+	expiring bool
+}
+
+// now returns the current time on the queue's clock.
+func (q *timerQueue) now() int64 {
+	return internal.ClockNow(q.realtime)
+}
+
+// deadline converts t to a deadline on the queue's clock.
+func (q *timerQueue) deadline(t time.Time) int64 {
+	return internal.ClockAt(q.realtime, t)
+}
+
+// Len returns the number of scheduled Timers.
+func (q *timerQueue) Len() int {
+	return len(q.heap)
+}
+
+func (q *timerQueue) push(t *Timer) error {
+	heap.Push(&q.heap, t)
+	if t.index == 0 {
+		return q.arm()
+	}
+	return nil
+}
+
+func (q *timerQueue) remove(t *Timer) error {
+	if t.index < 0 {
+		return nil
+	}
+	heap.Remove(&q.heap, t.index)
+
+	// If t had the earliest deadline, it stays armed for it and expire rearms it for the next one. That saves a
+	// syscall if a Timer is cancelled and then scheduled again, which is the common case for timeouts.
+	if len(q.heap) == 0 {
+		return q.arm()
+	}
+	return nil
+}
+
+// arm arms it for the earliest deadline, or disarms it if there are no scheduled Timers.
+func (q *timerQueue) arm() error {
+	if q.expiring {
+		return nil
+	}
+
+	if len(q.heap) == 0 {
+		if q.armed == 0 {
+			return nil
+		}
+		q.armed = 0
+		return q.it.Unset()
+	}
+
+	deadline := q.heap[0].deadline
+	if deadline == q.armed {
+		return nil
+	}
+
+	if q.it == nil {
+		it, err := internal.NewTimer(q.ioc.poller, q.realtime)
+		if err != nil {
+			return err
+		}
+		q.it = it
+	}
+
+	if err := q.it.SetAt(deadline, q.expire); err != nil {
+		return err
+	}
+	q.armed = deadline
+	return nil
+}
+
+// expire runs the callbacks of all expired Timers and then rearms it. If it failed with err, or if rearming it fails, no
+// scheduled Timer would ever expire, so their callbacks are called with the error instead.
+func (q *timerQueue) expire(err error) {
+	q.armed = 0
+
+	if err == nil {
+		q.expiring = true
+		now := q.now()
+		for len(q.heap) > 0 && q.heap[0].deadline <= now {
+			t := heap.Pop(&q.heap).(*Timer)
+			t.expire(nil)
+		}
+		q.expiring = false
+
+		err = q.arm()
+	}
+	if err != nil {
+		q.fail(err)
+	}
+}
+
+// fail calls the callbacks of all scheduled Timers with err. Timers scheduled by those callbacks are not failed, it is
+// armed for them instead.
+func (q *timerQueue) fail(err error) {
+	timers := q.heap
+	q.heap = nil
+	for _, t := range timers {
+		t.index = -1
+	}
+
+	for _, t := range timers {
+		// A callback may have cancelled t, or cancelled and scheduled it again.
+		if t.state == stateScheduled && t.index < 0 {
+			t.expire(err)
+		}
+	}
+}
+
+// close closes all scheduled Timers, calling their callbacks with sonicerrors.ErrCancelled, and releases it.
+func (q *timerQueue) close() (err error) {
+	// The callbacks may schedule other Timers, which are closed as well. it is not armed for them.
+	q.expiring = true
+	for len(q.heap) > 0 {
+		t := heap.Pop(&q.heap).(*Timer)
+		t.state = stateClosed
+		cb := t.cb
+		t.cb = nil
+		cb(sonicerrors.ErrCancelled)
+	}
+	q.expiring = false
+	q.armed = 0
+
+	if q.it != nil {
+		err = q.it.Close()
+		q.it = nil
+	}
+	return err
+}
+
+// timerHeap is a min-heap of Timers ordered by deadline. It implements heap.Interface.
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].deadline < h[j].deadline
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
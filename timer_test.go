@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
 )
 
 const TimerTestDuration = time.Millisecond
@@ -29,7 +30,7 @@ func TestTimerScheduleOnce(t *testing.T) {
 	}
 
 	done := false
-	err = timer.ScheduleOnce(TimerTestDuration, func() {
+	err = timer.ScheduleOnce(TimerTestDuration, func(error) {
 		done = true
 	})
 	if err != nil {
@@ -81,7 +82,7 @@ func TestTimerScheduleOnceAndClose(t *testing.T) {
 	}
 
 	done := false
-	err = timer.ScheduleOnce(TimerTestDuration, func() {
+	err = timer.ScheduleOnce(TimerTestDuration, func(error) {
 		done = true
 		timer.Close()
 	})
@@ -134,7 +135,7 @@ func TestTimerScheduleRepeating(t *testing.T) {
 	}
 
 	fired := 0
-	err = timer.ScheduleRepeating(TimerTestDuration, func() {
+	err = timer.ScheduleRepeating(TimerTestDuration, func(error) {
 		if timer.Scheduled() {
 			t.Fatal("timer should not be scheduled")
 		}
@@ -185,7 +186,7 @@ func TestTimerScheduleRepeatingAndClose(t *testing.T) {
 	}
 
 	fired := 0
-	err = timer.ScheduleRepeating(TimerTestDuration, func() {
+	err = timer.ScheduleRepeating(TimerTestDuration, func(error) {
 		if timer.Scheduled() {
 			t.Fatal("timer should not be scheduled")
 		}
@@ -236,7 +237,7 @@ func TestTimerCloseAfterScheduleOnce(t *testing.T) {
 
 	shouldNotBeTrue := false
 
-	err = timer.ScheduleOnce(TimerTestDuration, func() {
+	err = timer.ScheduleOnce(TimerTestDuration, func(error) {
 		shouldNotBeTrue = true
 	})
 	if err != nil {
@@ -284,7 +285,7 @@ func TestTimerCloseAfterScheduleRepeating(t *testing.T) {
 
 	shouldNotBeTrue := false
 
-	err = timer.ScheduleRepeating(TimerTestDuration, func() {
+	err = timer.ScheduleRepeating(TimerTestDuration, func(error) {
 		shouldNotBeTrue = true
 	})
 	if err != nil {
@@ -330,12 +331,12 @@ func TestTimerScheduleOnceWhileAlreadyScheduled(t *testing.T) {
 		}
 	}()
 
-	err = timer.ScheduleOnce(TimerTestDuration, func() {})
+	err = timer.ScheduleOnce(TimerTestDuration, func(error) {})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = timer.ScheduleOnce(TimerTestDuration, func() {})
+	err = timer.ScheduleOnce(TimerTestDuration, func(error) {})
 	if !errors.Is(err, sonicerrors.ErrCancelled) {
 		t.Fatal("operation should have been cancelled")
 	}
@@ -355,12 +356,12 @@ func TestTimerScheduleRepeatingWhileAlreadyScheduled(t *testing.T) {
 		}
 	}()
 
-	err = timer.ScheduleRepeating(TimerTestDuration, func() {})
+	err = timer.ScheduleRepeating(TimerTestDuration, func(error) {})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = timer.ScheduleOnce(TimerTestDuration, func() {})
+	err = timer.ScheduleOnce(TimerTestDuration, func(error) {})
 	if !errors.Is(err, sonicerrors.ErrCancelled) {
 		t.Fatal("operation should have been cancelled")
 	}
@@ -403,7 +404,7 @@ func TestTimerZeroTimeout(t *testing.T) {
 	}()
 
 	done := false
-	err = timer.ScheduleOnce(0, func() {
+	err = timer.ScheduleOnce(0, func(error) {
 		done = true
 	})
 	if err != nil {
@@ -428,7 +429,7 @@ func TestTimerCancel(t *testing.T) {
 
 	// schedule once and cancel before trigger
 	triggered := false
-	err = timer.ScheduleOnce(5*time.Millisecond, func() { triggered = true })
+	err = timer.ScheduleOnce(5*time.Millisecond, func(error) { triggered = true })
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -484,7 +485,7 @@ func TestTimerCancel(t *testing.T) {
 
 	// schedule again and let it trigger
 	triggered = false
-	err = timer.ScheduleOnce(10*time.Millisecond, func() {
+	err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 		triggered = true
 	})
 	if err != nil {
@@ -526,9 +527,9 @@ func TestTimerScheduleOnceConsecutively(t *testing.T) {
 
 	var start, end time.Time
 	start = time.Now()
-	err = timer.ScheduleOnce(10*time.Millisecond, func() {
-		err = timer.ScheduleOnce(10*time.Millisecond, func() {
-			err = timer.ScheduleOnce(10*time.Millisecond, func() {
+	err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
+		err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
+			err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 				end = time.Now()
 			})
 			if err != nil {
@@ -543,7 +544,7 @@ func TestTimerScheduleOnceConsecutively(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = timer.ScheduleOnce(-1, func() {})
+	err = timer.ScheduleOnce(-1, func(error) {})
 	if !errors.Is(err, sonicerrors.ErrCancelled) {
 		t.Fatalf("should not be able to schedule an already scheduled clock, expected=%s", sonicerrors.ErrCancelled)
 	}
@@ -573,11 +574,11 @@ func TestTimerScheduleOnceConsecutivelySleepInBetween(t *testing.T) {
 
 	var start, end time.Time
 	start = time.Now()
-	err = timer.ScheduleOnce(10*time.Millisecond, func() {
+	err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 		time.Sleep(10 * time.Millisecond)
-		err = timer.ScheduleOnce(10*time.Millisecond, func() {
+		err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 			time.Sleep(10 * time.Millisecond)
-			err = timer.ScheduleOnce(10*time.Millisecond, func() {
+			err = timer.ScheduleOnce(10*time.Millisecond, func(error) {
 				time.Sleep(10 * time.Millisecond)
 				end = time.Now()
 			})
@@ -593,7 +594,7 @@ func TestTimerScheduleOnceConsecutivelySleepInBetween(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = timer.ScheduleOnce(-1, func() {})
+	err = timer.ScheduleOnce(-1, func(error) {})
 	if !errors.Is(err, sonicerrors.ErrCancelled) {
 		t.Fatalf("should not be able to schedule an already scheduled clock, expected=%s", sonicerrors.ErrCancelled)
 	}
@@ -621,19 +622,19 @@ func TestTimerScheduleRepeatingConsecutively(t *testing.T) {
 
 	var start, end time.Time
 	start = time.Now()
-	err = timer.ScheduleRepeating(10*time.Millisecond, func() {
+	err = timer.ScheduleRepeating(10*time.Millisecond, func(error) {
 		err = timer.Cancel()
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		err = timer.ScheduleRepeating(10*time.Millisecond, func() {
+		err = timer.ScheduleRepeating(10*time.Millisecond, func(error) {
 			err = timer.Cancel()
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			err = timer.ScheduleRepeating(10*time.Millisecond, func() {
+			err = timer.ScheduleRepeating(10*time.Millisecond, func(error) {
 				err = timer.Cancel()
 				if err != nil {
 					t.Fatal(err)
@@ -653,7 +654,7 @@ func TestTimerScheduleRepeatingConsecutively(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = timer.ScheduleRepeating(-1, func() {})
+	err = timer.ScheduleRepeating(-1, func(error) {})
 	if !errors.Is(err, sonicerrors.ErrCancelled) {
 		t.Fatalf("should not be able to schedule an already scheduled clock, expected=%s", sonicerrors.ErrCancelled)
 	}
@@ -678,7 +679,7 @@ func TestTimerScheduleRepeatingAndCancel(t *testing.T) {
 
 	for i := 0; i < 10; i++ {
 		called := 0
-		err := timer.ScheduleRepeating(time.Millisecond, func() {
+		err := timer.ScheduleRepeating(time.Millisecond, func(error) {
 			called++
 			if called == 5 {
 				timer.Cancel()
@@ -692,7 +693,7 @@ func TestTimerScheduleRepeatingAndCancel(t *testing.T) {
 			if !timer.Scheduled() {
 				t.Fatal("timer should be scheduled")
 			}
-			if len(timer.ioc.pendingTimers) != 1 {
+			if timer.ioc.timers.Len() != 1 {
 				t.Fatal("there should be a pending timer")
 			}
 			ioc.RunOne()
@@ -706,20 +707,20 @@ func TestTimerScheduleRepeatingAndCancel(t *testing.T) {
 		if timer.state != stateReady {
 			t.Fatal("timer should be in ready state")
 		}
-		if len(timer.ioc.pendingTimers) != 0 {
+		if timer.ioc.timers.Len() != 0 {
 			t.Fatal("there should be no pending timers")
 		}
 	}
 
 	once := false
-	err = timer.ScheduleOnce(time.Millisecond, func() {
+	err = timer.ScheduleOnce(time.Millisecond, func(error) {
 		once = true
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
 	ioc.RunOne()
-	if len(timer.ioc.pendingTimers) != 0 {
+	if timer.ioc.timers.Len() != 0 {
 		t.Fatal("there should be no pending timers")
 	}
 	if !once {
@@ -748,7 +749,7 @@ func TestTimerCancelAndThenScheduleRepeating(t *testing.T) {
 
 	// Scheduling a timer & cancelling it after 5 iterations
 	called := 0
-	err = timer.ScheduleRepeating(time.Millisecond, func() {
+	err = timer.ScheduleRepeating(time.Millisecond, func(error) {
 		called++
 		if called == 5 {
 			timer.Cancel()
@@ -816,7 +817,7 @@ func BenchmarkTimerScheduleOnce(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		t.ScheduleOnce(time.Nanosecond, func() {})
+		t.ScheduleOnce(time.Nanosecond, func(error) {})
 	}
 	b.ReportAllocs()
 }
@@ -833,7 +834,263 @@ func BenchmarkTimerScheduleRepeating(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		t.ScheduleRepeating(time.Nanosecond, func() {})
+		t.ScheduleRepeating(time.Nanosecond, func(error) {})
 	}
 	b.ReportAllocs()
 }
+
+func TestTimerQueue(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	const n = 1000
+
+	timers := make([]*Timer, n)
+	for i := range timers {
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timers[i] = timer
+	}
+	if ioc.timers.it != nil {
+		t.Fatal("creating timers should not create the kernel timer")
+	}
+
+	// Schedule in reverse order of expiry, such that each timer becomes the earliest deadline. The deadlines are
+	// relative to the same base such that their order does not depend on how long scheduling takes.
+	var fired []int
+	base := time.Now().Add(10 * time.Millisecond)
+	for i := n - 1; i >= 0; i-- {
+		i := i
+		if err := timers[i].ScheduleAt(base.Add(time.Duration(i)*10*time.Microsecond), func(error) {
+			fired = append(fired, i)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ioc.timers.Len() != n {
+		t.Fatalf("expected %d scheduled timers, got %d", n, ioc.timers.Len())
+	}
+	if ioc.Pending() != 1 {
+		t.Fatalf("expected the timers to share one pending operation, got %d", ioc.Pending())
+	}
+
+	// Cancel every other timer.
+	for i := 0; i < n; i += 2 {
+		if err := timers[i].Cancel(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fired) != n/2 {
+		t.Fatalf("expected %d timers to fire, %d fired", n/2, len(fired))
+	}
+	for i, j := range fired {
+		if j != 2*i+1 {
+			t.Fatalf("expected timer %d to fire, timer %d fired", 2*i+1, j)
+		}
+	}
+	if ioc.timers.Len() != 0 || ioc.Pending() != 0 {
+		t.Fatal("expected no scheduled timers")
+	}
+}
+
+func TestTimerQueueCancelEarliest(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	first, _ := NewTimer(ioc)
+	second, _ := NewTimer(ioc)
+
+	start := time.Now()
+	firstFired, secondFired := false, false
+	if err := first.ScheduleOnce(time.Millisecond, func(error) { firstFired = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.ScheduleOnce(5*time.Millisecond, func(error) { secondFired = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The kernel timer may still go off at the cancelled deadline, which must not fire the second timer early.
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if firstFired || !secondFired {
+		t.Fatalf("expected only the second timer to fire, first=%v second=%v", firstFired, secondFired)
+	}
+	if time.Since(start) < 5*time.Millisecond {
+		t.Fatal("the second timer fired early")
+	}
+}
+
+func TestTimerQueueFail(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	once, _ := NewTimer(ioc)
+	ticker, _ := NewTimer(ioc)
+	cancelled, _ := NewTimer(ioc)
+	again, _ := NewTimer(ioc)
+
+	var onceErr, tickerErr error
+	if err := once.ScheduleOnce(time.Hour, func(err error) {
+		onceErr = err
+
+		// Timers scheduled by the callbacks of failed ones are armed for.
+		if err := again.ScheduleOnce(time.Millisecond, func(error) {}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cancelled.Cancel(); err != nil {
+			t.Fatal(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ticker.ScheduleRepeating(time.Hour, func(err error) { tickerErr = err }); err != nil {
+		t.Fatal(err)
+	}
+	if err := cancelled.ScheduleOnce(2*time.Hour, func(error) {
+		t.Fatal("cancelled timer fired")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The kernel timer or the poller failed.
+	failure := errors.New("failure")
+	ioc.timers.expire(failure)
+
+	if onceErr != failure || tickerErr != failure {
+		t.Fatalf("expected the timers to fail once=%v ticker=%v", onceErr, tickerErr)
+	}
+	if once.Scheduled() || ticker.Scheduled() || cancelled.Scheduled() {
+		t.Fatal("expected the failed timers to be ready")
+	}
+	if !again.Scheduled() || ioc.timers.Len() != 1 {
+		t.Fatal("expected the timer scheduled by a callback to stay scheduled")
+	}
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+	if again.Scheduled() {
+		t.Fatal("expected the timer scheduled by a callback to fire")
+	}
+}
+
+func TestTimerScheduleAt(t *testing.T) {
+	for _, realtime := range []bool{false, true} {
+		ioc := MustIO(sonicopts.RealtimeTimers(realtime))
+
+		timer, err := NewTimer(ioc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Millisecond)
+		var firedAt time.Time
+		if err := timer.ScheduleAt(deadline, func(error) {
+			firedAt = time.Now()
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioc.RunPending(); err != nil {
+			t.Fatal(err)
+		}
+		if firedAt.IsZero() {
+			t.Fatal("timer did not fire")
+		}
+		if firedAt.Before(deadline) {
+			t.Fatalf("realtime=%v: timer fired %s before the deadline", realtime, deadline.Sub(firedAt))
+		}
+
+		// A deadline in the past fires right away.
+		fired := false
+		if err := timer.ScheduleAt(time.Now().Add(-time.Second), func(error) {
+			fired = true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !fired {
+			t.Fatal("timer with a past deadline did not fire")
+		}
+
+		ioc.Close()
+	}
+}
+
+func TestTimerScheduleRepeatingNoDrift(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const interval = 2 * time.Millisecond
+
+	var deadlines []int64
+	err = timer.ScheduleRepeating(interval, func(error) {
+		deadlines = append(deadlines, timer.deadline)
+
+		// Make each call late, which used to delay all the following ones.
+		time.Sleep(time.Millisecond)
+
+		if len(deadlines) == 10 {
+			timer.Cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deadlines) != 10 {
+		t.Fatalf("expected 10 calls, got %d", len(deadlines))
+	}
+	for i := 1; i < len(deadlines); i++ {
+		if d := deadlines[i] - deadlines[0]; d%int64(interval) != 0 {
+			t.Fatalf("deadline %d is not aligned to the first one, %dns apart", i, d)
+		}
+	}
+}
+
+func TestTimerScheduleRepeatingAtOverruns(t *testing.T) {
+	ioc := MustIO()
+	defer ioc.Close()
+
+	timer, err := NewTimer(ioc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const interval = 10 * time.Millisecond
+
+	// Start 3.5 intervals in the past, so the first call is late by 3 whole intervals.
+	var overruns []int
+	err = timer.ScheduleRepeatingAt(time.Now().Add(-35*time.Millisecond), interval, func(_ error, n int) {
+		overruns = append(overruns, n)
+		if len(overruns) == 2 {
+			timer.Cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioc.RunPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(overruns) != 2 || overruns[0] != 3 || overruns[1] != 0 {
+		t.Fatalf("expected overruns [3 0], got %v", overruns)
+	}
+}
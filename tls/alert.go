@@ -0,0 +1,141 @@
+package tls
+
+import (
+	"errors"
+	"fmt"
+)
+
+// alert is the description of an alert, see RFC 8446 section 6.
+type alert uint8
+
+const (
+	alertCloseNotify            alert = 0
+	alertUnexpectedMessage      alert = 10
+	alertBadRecordMAC           alert = 20
+	alertRecordOverflow         alert = 22
+	alertHandshakeFailure       alert = 40
+	alertBadCertificate         alert = 42
+	alertUnsupportedCertificate alert = 43
+	alertCertificateExpired     alert = 45
+	alertCertificateUnknown     alert = 46
+	alertIllegalParameter       alert = 47
+	alertUnknownCA              alert = 48
+	alertDecodeError            alert = 50
+	alertDecryptError           alert = 51
+	alertProtocolVersion        alert = 70
+	alertInsufficientSecurity   alert = 71
+	alertInternalError          alert = 80
+	alertMissingExtension       alert = 109
+	alertUnsupportedExtension   alert = 110
+	alertUnrecognizedName       alert = 112
+	alertCertificateRequired    alert = 116
+	alertNoApplicationProtocol  alert = 120
+)
+
+const (
+	alertLevelWarning = 1
+	alertLevelFatal   = 2
+)
+
+func (a alert) String() string {
+	switch a {
+	case alertCloseNotify:
+		return "close notify"
+	case alertUnexpectedMessage:
+		return "unexpected message"
+	case alertBadRecordMAC:
+		return "bad record MAC"
+	case alertRecordOverflow:
+		return "record overflow"
+	case alertHandshakeFailure:
+		return "handshake failure"
+	case alertBadCertificate:
+		return "bad certificate"
+	case alertUnsupportedCertificate:
+		return "unsupported certificate"
+	case alertCertificateExpired:
+		return "certificate expired"
+	case alertCertificateUnknown:
+		return "certificate unknown"
+	case alertIllegalParameter:
+		return "illegal parameter"
+	case alertUnknownCA:
+		return "unknown certificate authority"
+	case alertDecodeError:
+		return "decode error"
+	case alertDecryptError:
+		return "decrypt error"
+	case alertProtocolVersion:
+		return "protocol version not supported"
+	case alertInsufficientSecurity:
+		return "insufficient security level"
+	case alertInternalError:
+		return "internal error"
+	case alertMissingExtension:
+		return "missing extension"
+	case alertUnsupportedExtension:
+		return "unsupported extension"
+	case alertUnrecognizedName:
+		return "unrecognized name"
+	case alertCertificateRequired:
+		return "certificate required"
+	case alertNoApplicationProtocol:
+		return "no application protocol"
+	default:
+		return fmt.Sprintf("alert(%d)", uint8(a))
+	}
+}
+
+// ErrVersion is returned by NewConfig for configurations which need a version older than TLS 1.3. It is also matched
+// by errors.Is when a handshake fails because the peer does not support TLS 1.3.
+var ErrVersion = errors.New("tls: only TLS 1.3 is supported")
+
+// AlertError is the error of a Conn which failed with a fatal alert, either sent to the peer or received from it.
+type AlertError struct {
+	// Alert is the description of the alert, see RFC 8446 section 6.
+	Alert uint8
+
+	// Remote is true if the peer sent the alert.
+	Remote bool
+
+	// Err is why the alert was sent. It is nil if Remote is true.
+	Err error
+}
+
+func (e *AlertError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("tls: received alert: %s", alert(e.Alert))
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("tls: %s: %s", alert(e.Alert), e.Err)
+	}
+	return fmt.Sprintf("tls: %s", alert(e.Alert))
+}
+
+func (e *AlertError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrVersion if the alert is protocol_version.
+func (e *AlertError) Is(target error) bool {
+	return target == ErrVersion && alert(e.Alert) == alertProtocolVersion
+}
+
+// fail returns the error of a Conn failing with the fatal alert a because of err.
+func fail(a alert, err error) error {
+	return &AlertError{Alert: uint8(a), Err: err}
+}
+
+// failf is like fail, with a formatted cause.
+func failf(a alert, format string, args ...any) error {
+	return fail(a, fmt.Errorf(format, args...))
+}
+
+// alertOf returns the alert to send to the peer because of err.
+func alertOf(err error) alert {
+	var ae *AlertError
+	if errors.As(err, &ae) {
+		return alert(ae.Alert)
+	}
+	return alertInternalError
+}
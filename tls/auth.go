@@ -0,0 +1,254 @@
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// Named groups, see RFC 8446 section 4.2.7.
+const (
+	groupP256   uint16 = uint16(tls.CurveP256)
+	groupX25519 uint16 = uint16(tls.X25519)
+)
+
+// defaultGroups are the supported groups, in order of preference.
+var defaultGroups = []uint16{groupX25519, groupP256}
+
+func curveOf(group uint16) ecdh.Curve {
+	switch group {
+	case groupX25519:
+		return ecdh.X25519()
+	case groupP256:
+		return ecdh.P256()
+	default:
+		return nil
+	}
+}
+
+// groupsOf returns the supported groups of the config, in order of preference.
+func groupsOf(cfg *tls.Config) []uint16 {
+	if len(cfg.CurvePreferences) == 0 {
+		return defaultGroups
+	}
+	var groups []uint16
+	for _, curve := range cfg.CurvePreferences {
+		if curveOf(uint16(curve)) != nil {
+			groups = append(groups, uint16(curve))
+		}
+	}
+	return groups
+}
+
+func generateKeyShare(group uint16) (*ecdh.PrivateKey, error) {
+	return curveOf(group).GenerateKey(rand.Reader)
+}
+
+// sharedSecret returns the (EC)DHE shared secret of key and the key share of the peer.
+func sharedSecret(key *ecdh.PrivateKey, peer []byte) ([]byte, error) {
+	pub, err := key.Curve().NewPublicKey(peer)
+	if err != nil {
+		return nil, fail(alertIllegalParameter, err)
+	}
+	secret, err := key.ECDH(pub)
+	if err != nil {
+		return nil, fail(alertIllegalParameter, err)
+	}
+	return secret, nil
+}
+
+// Signature schemes, see RFC 8446 section 4.2.3.
+const (
+	schemeECDSAP256SHA256 = uint16(tls.ECDSAWithP256AndSHA256)
+	schemeECDSAP384SHA384 = uint16(tls.ECDSAWithP384AndSHA384)
+	schemeECDSAP521SHA512 = uint16(tls.ECDSAWithP521AndSHA512)
+	schemePSSSHA256       = uint16(tls.PSSWithSHA256)
+	schemePSSSHA384       = uint16(tls.PSSWithSHA384)
+	schemePSSSHA512       = uint16(tls.PSSWithSHA512)
+	schemeEd25519         = uint16(tls.Ed25519)
+)
+
+// signatureSchemes are the supported signature schemes, in order of preference.
+var signatureSchemes = []uint16{
+	schemeECDSAP256SHA256,
+	schemeEd25519,
+	schemePSSSHA256,
+	schemeECDSAP384SHA384,
+	schemePSSSHA384,
+	schemeECDSAP521SHA512,
+	schemePSSSHA512,
+}
+
+func hashOf(scheme uint16) crypto.Hash {
+	switch scheme {
+	case schemeECDSAP256SHA256, schemePSSSHA256:
+		return crypto.SHA256
+	case schemeECDSAP384SHA384, schemePSSSHA384:
+		return crypto.SHA384
+	case schemeECDSAP521SHA512, schemePSSSHA512:
+		return crypto.SHA512
+	default:
+		return 0
+	}
+}
+
+// schemesOf returns the signature schemes a key can sign with.
+func schemesOf(pub crypto.PublicKey) []uint16 {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return []uint16{schemeECDSAP256SHA256}
+		case elliptic.P384():
+			return []uint16{schemeECDSAP384SHA384}
+		case elliptic.P521():
+			return []uint16{schemeECDSAP521SHA512}
+		}
+	case *rsa.PublicKey:
+		return []uint16{schemePSSSHA256, schemePSSSHA384, schemePSSSHA512}
+	case ed25519.PublicKey:
+		return []uint16{schemeEd25519}
+	}
+	return nil
+}
+
+// selectScheme returns the scheme to sign with key, out of those the peer supports.
+func selectScheme(key crypto.PrivateKey, peerSchemes []uint16) (crypto.Signer, uint16, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, 0, fmt.Errorf("private key of type %T cannot sign", key)
+	}
+	for _, scheme := range schemesOf(signer.Public()) {
+		for _, peerScheme := range peerSchemes {
+			if scheme == peerScheme {
+				return signer, scheme, nil
+			}
+		}
+	}
+	return nil, 0, errors.New("no common signature scheme")
+}
+
+const (
+	serverSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+	clientSignatureContext = "TLS 1.3, client CertificateVerify\x00"
+)
+
+// signedMessage returns the content covered by a CertificateVerify, see RFC 8446 section 4.4.3.
+func signedMessage(context string, transcript hash.Hash) []byte {
+	b := bytes.Repeat([]byte{0x20}, 64)
+	b = append(b, context...)
+	return transcript.Sum(b)
+}
+
+func sign(signer crypto.Signer, scheme uint16, msg []byte) ([]byte, error) {
+	if scheme == schemeEd25519 {
+		return signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	}
+
+	h := hashOf(scheme)
+	digest := h.New()
+	digest.Write(msg)
+
+	var opts crypto.SignerOpts = h
+	switch scheme {
+	case schemePSSSHA256, schemePSSSHA384, schemePSSSHA512:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h}
+	}
+	return signer.Sign(rand.Reader, digest.Sum(nil), opts)
+}
+
+func verify(pub crypto.PublicKey, scheme uint16, msg, sig []byte) error {
+	supported := false
+	for _, s := range schemesOf(pub) {
+		if s == scheme {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return failf(alertIllegalParameter, "signature scheme %#04x does not match the certificate", scheme)
+	}
+
+	if scheme == schemeEd25519 {
+		if !ed25519.Verify(pub.(ed25519.PublicKey), msg, sig) {
+			return failf(alertDecryptError, "invalid signature")
+		}
+		return nil
+	}
+
+	h := hashOf(scheme)
+	digest := h.New()
+	digest.Write(msg)
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest.Sum(nil), sig) {
+			return failf(alertDecryptError, "invalid signature")
+		}
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		if err := rsa.VerifyPSS(pub, h, digest.Sum(nil), sig, opts); err != nil {
+			return fail(alertDecryptError, err)
+		}
+	}
+	return nil
+}
+
+// verifyPeer parses the certificate chain of the peer and, unless skipVerify is true, verifies it with opts. The
+// VerifyPeerCertificate callback of cfg runs in both cases.
+func verifyPeer(
+	cfg *tls.Config,
+	raw [][]byte,
+	opts x509.VerifyOptions,
+	skipVerify bool,
+) (certs []*x509.Certificate, chains [][]*x509.Certificate, err error) {
+	for _, b := range raw {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, nil, fail(alertBadCertificate, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(schemesOf(certs[0].PublicKey)) == 0 {
+		return nil, nil, failf(alertUnsupportedCertificate, "public key of type %T", certs[0].PublicKey)
+	}
+
+	if !skipVerify {
+		opts.Intermediates = x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		chains, err = certs[0].Verify(opts)
+		if err != nil {
+			var (
+				unknownAuthority x509.UnknownAuthorityError
+				invalid          x509.CertificateInvalidError
+			)
+			switch {
+			case errors.As(err, &unknownAuthority):
+				return nil, nil, fail(alertUnknownCA, err)
+			case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+				return nil, nil, fail(alertCertificateExpired, err)
+			default:
+				return nil, nil, fail(alertBadCertificate, err)
+			}
+		}
+	}
+
+	if cfg.VerifyPeerCertificate != nil {
+		if err := cfg.VerifyPeerCertificate(raw, chains); err != nil {
+			return nil, nil, fail(alertBadCertificate, err)
+		}
+	}
+	return certs, chains, nil
+}
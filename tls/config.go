@@ -0,0 +1,190 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+// Config configures Conns. It must be created with NewConfig, and must not be modified once in use. It may be shared
+// by several Conns, on different IOs.
+type Config struct {
+	// Config holds the settings shared with crypto/tls, see the package documentation for those which are honoured.
+	*tls.Config
+
+	// Sessions caches the sessions of a client, by server name, such that later connections to the same server
+	// resume them. A nil cache disables resumption.
+	Sessions *SessionCache
+
+	// ticketAEAD protects the session tickets a server issues.
+	ticketAEAD cipher.AEAD
+}
+
+// NewConfig returns a Config wrapping cfg, which may be nil.
+func NewConfig(cfg *tls.Config) (*Config, error) {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.MaxVersion != 0 && cfg.MaxVersion < tls.VersionTLS13 {
+		return nil, ErrVersion
+	}
+
+	key := cfg.SessionTicketKey
+	if key == [32]byte{} {
+		if _, err := rand.Read(key[:]); err != nil {
+			return nil, err
+		}
+	}
+	// Do not use the configured key as is, crypto/tls does not either.
+	derived := sha256.Sum256(key[:])
+	aead, err := newAEAD(derived[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{Config: cfg, ticketAEAD: aead}, nil
+}
+
+func (c *Config) now() time.Time {
+	if c.Time != nil {
+		return c.Time()
+	}
+	return time.Now()
+}
+
+// session is a session a client may resume, see RFC 8446 section 4.6.1.
+type session struct {
+	suite    uint16
+	psk      []byte
+	ticket   []byte
+	ageAdd   uint32
+	lifetime time.Duration
+	received time.Time
+
+	alpn           string
+	peerCerts      []*x509.Certificate
+	verifiedChains [][]*x509.Certificate
+}
+
+func (s *session) expired(now time.Time) bool {
+	return now.Sub(s.received) >= s.lifetime
+}
+
+// age returns the obfuscated age of the ticket.
+func (s *session) age(now time.Time) uint32 {
+	return uint32(now.Sub(s.received)/time.Millisecond) + s.ageAdd
+}
+
+// SessionCache holds the sessions of clients. It is safe for concurrent use.
+type SessionCache struct {
+	mu       sync.Mutex
+	capacity int
+	sessions map[string]*session
+
+	// order is the insertion order of the sessions, oldest first, such that the oldest is evicted first.
+	order []string
+}
+
+// NewSessionCache returns a SessionCache holding up to capacity sessions.
+func NewSessionCache(capacity int) *SessionCache {
+	return &SessionCache{
+		capacity: capacity,
+		sessions: make(map[string]*session),
+	}
+}
+
+func (c *SessionCache) get(key string) *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sessions[key]
+}
+
+func (c *SessionCache) put(key string, s *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.sessions[key]; !ok {
+		if len(c.sessions) >= c.capacity && len(c.order) > 0 {
+			delete(c.sessions, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, key)
+	}
+	c.sessions[key] = s
+}
+
+// Len returns the number of cached sessions.
+func (c *SessionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.sessions)
+}
+
+// ticketLifetime is the lifetime of the session tickets servers issue, the maximum allowed by RFC 8446 section 4.6.1.
+const ticketLifetime = 7 * 24 * time.Hour
+
+// ticketState is the state of a session a server encrypts in the ticket it issues, such that it is stateless.
+type ticketState struct {
+	suite     uint16
+	created   time.Time
+	ageAdd    uint32
+	psk       []byte
+	alpn      string
+	peerCerts [][]byte
+}
+
+func (c *Config) sealTicket(s *ticketState) ([]byte, error) {
+	var b builder
+	b.u16(s.suite)
+	created := uint64(s.created.Unix())
+	b.u32(uint32(created >> 32))
+	b.u32(uint32(created))
+	b.u32(s.ageAdd)
+	b.vecBytes(1, s.psk)
+	b.vecBytes(1, []byte(s.alpn))
+	b.vec(3, func(b *builder) {
+		for _, cert := range s.peerCerts {
+			b.vecBytes(3, cert)
+		}
+	})
+
+	nonce := make([]byte, c.ticketAEAD.NonceSize(), c.ticketAEAD.NonceSize()+len(b.b)+c.ticketAEAD.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.ticketAEAD.Seal(nonce, nonce, b.b, nil), nil
+}
+
+// openTicket returns the state of a ticket, or nil if the ticket is invalid or was issued with another key.
+func (c *Config) openTicket(ticket []byte) *ticketState {
+	n := c.ticketAEAD.NonceSize()
+	if len(ticket) < n {
+		return nil
+	}
+	plaintext, err := c.ticketAEAD.Open(nil, ticket[:n], ticket[n:], nil)
+	if err != nil {
+		return nil
+	}
+
+	p := &parser{b: plaintext}
+	s := &ticketState{suite: p.u16()}
+	created := uint64(p.u32())<<32 | uint64(p.u32())
+	s.created = time.Unix(int64(created), 0)
+	s.ageAdd = p.u32()
+	s.psk = p.vec(1)
+	s.alpn = string(p.vec(1))
+	certs := p.sub(3)
+	for !certs.empty() {
+		s.peerCerts = append(s.peerCerts, certs.vec(3))
+	}
+	if !certs.ok() || !p.done() {
+		return nil
+	}
+	return s
+}
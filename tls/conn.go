@@ -0,0 +1,787 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+var _ sonic.Stream = &Conn{}
+
+// handshaker runs one side of the handshake. It is fed the handshake messages of the peer, and writes its own with
+// Conn.writeHandshake.
+type handshaker interface {
+	// start writes the first messages, if any.
+	start() error
+
+	// handle processes the handshake message msg, which includes its header.
+	handle(msg []byte) error
+}
+
+// Conn is a TLS connection over a sonic stream, the next layer.
+//
+// Reads and writes complete the handshake first if needed, such that calling AsyncHandshake is optional. Like the rest
+// of sonic, a Conn must only be used from the goroutine running the IO of its next layer, and only one read and one
+// write may be pending at any time.
+type Conn struct {
+	next     sonic.Stream
+	config   *Config
+	isClient bool
+
+	hs       handshaker
+	complete bool
+
+	in, out halfConn
+
+	// rbuf holds the bytes read from the next layer which do not make up a full record yet.
+	rbuf []byte
+
+	// hsbuf holds the handshake messages which do not fit in one record.
+	hsbuf []byte
+
+	// plain holds the application data received and not read yet.
+	plain []byte
+
+	// wbuf holds the records not written to the next layer yet.
+	wbuf []byte
+
+	// flushing is true while wbuf is written asynchronously. flushCbs are called once it is empty.
+	flushing bool
+	flushCbs []func(error)
+
+	// err is the fatal error of the connection, if any. It is returned by all later operations.
+	err error
+
+	// eof is true once the peer sent close_notify.
+	eof bool
+
+	// closeSent is true once close_notify was sent.
+	closeSent bool
+
+	state tls.ConnectionState
+
+	// resumptionSecret is the secret the pre-shared keys of the session tickets of a client derive from.
+	resumptionSecret []byte
+	suite            *cipherSuite
+}
+
+// Client returns a Conn running the client side of TLS over next. config must set ServerName, or
+// InsecureSkipVerify.
+func Client(next sonic.Stream, config *Config) *Conn {
+	return newConn(next, config, true)
+}
+
+// Server returns a Conn running the server side of TLS over next. config must set Certificates or GetCertificate.
+func Server(next sonic.Stream, config *Config) *Conn {
+	return newConn(next, config, false)
+}
+
+func newConn(next sonic.Stream, config *Config, isClient bool) *Conn {
+	c := &Conn{
+		next:     next,
+		config:   config,
+		isClient: isClient,
+		rbuf:     make([]byte, 0, 2*(recordHeaderLen+maxCiphertext)),
+	}
+	if isClient {
+		c.hs = &clientHandshake{c: c}
+	} else {
+		c.hs = &serverHandshake{c: c}
+	}
+	return c
+}
+
+// AsyncDial connects to addr and completes the handshake of a client Conn over the connection. If config does not
+// set ServerName, it is set to the host of addr.
+//
+// Like sonic.AsyncDial, the host of addr must be an IP address. A config without ServerName then only verifies
+// certificates issued for that IP address.
+func AsyncDial(
+	ioc *sonic.IO,
+	network, addr string,
+	config *Config,
+	cb func(error, *Conn),
+	opts ...sonicopts.Option,
+) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+		cfg := *config
+		cfg.Config = config.Config.Clone()
+		cfg.ServerName = host
+		config = &cfg
+	}
+
+	sonic.AsyncDial(ioc, network, addr, func(err error, conn sonic.Conn) {
+		if err != nil {
+			cb(err, nil)
+			return
+		}
+
+		c := Client(conn, config)
+		c.AsyncHandshake(func(err error) {
+			if err != nil {
+				_ = c.Close()
+				cb(err, nil)
+				return
+			}
+			cb(nil, c)
+		})
+	}, opts...)
+}
+
+// NextLayer returns the stream TLS runs over.
+func (c *Conn) NextLayer() sonic.Stream {
+	return c.next
+}
+
+func (c *Conn) RawFd() int {
+	return c.next.RawFd()
+}
+
+// ConnectionState returns the state of the connection. Only the fields relevant to TLS 1.3 are set.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+// HandshakeComplete returns true once the handshake completed successfully.
+func (c *Conn) HandshakeComplete() bool {
+	return c.complete
+}
+
+// AsyncHandshake runs the handshake, if it did not run yet.
+func (c *Conn) AsyncHandshake(cb func(error)) {
+	if c.complete {
+		cb(nil)
+		return
+	}
+	if c.err != nil {
+		cb(c.err)
+		return
+	}
+	if err := c.startHandshake(); err != nil {
+		c.asyncAbort(err, cb)
+		return
+	}
+	c.asyncHandshake(cb)
+}
+
+func (c *Conn) asyncHandshake(cb func(error)) {
+	if err := c.advance(func() bool { return c.complete }); err != nil {
+		c.asyncAbort(err, cb)
+		return
+	}
+
+	if len(c.wbuf) > 0 {
+		c.flush(func(err error) {
+			if err != nil {
+				cb(c.setErr(err))
+				return
+			}
+			c.asyncHandshake(cb)
+		})
+		return
+	}
+	if c.complete {
+		cb(nil)
+		return
+	}
+
+	c.readMore(func(err error) {
+		if err != nil {
+			cb(c.setErr(err))
+			return
+		}
+		c.asyncHandshake(cb)
+	})
+}
+
+// Handshake runs the handshake, if it did not run yet, blocking until it completes.
+func (c *Conn) Handshake() error {
+	if c.complete {
+		return nil
+	}
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.startHandshake(); err != nil {
+		return c.abort(err)
+	}
+
+	for {
+		if err := c.advance(func() bool { return c.complete }); err != nil {
+			return c.abort(err)
+		}
+		if err := c.flushSync(); err != nil {
+			return c.setErr(err)
+		}
+		if c.complete {
+			return nil
+		}
+		if err := c.readMoreSync(true); err != nil {
+			return c.setErr(err)
+		}
+	}
+}
+
+func (c *Conn) startHandshake() error {
+	if c.config == nil {
+		return errors.New("tls: missing config")
+	}
+	if c.hs == nil {
+		return nil
+	}
+
+	// The handshake is started once, it continues where it stopped if it is run again after a temporary error.
+	hs := c.hs
+	c.hs = nil
+	if err := hs.start(); err != nil {
+		return err
+	}
+	c.hs = hs
+	return nil
+}
+
+// AsyncRead reads up to len(b) bytes of application data into b.
+func (c *Conn) AsyncRead(b []byte, cb sonic.AsyncCallback) {
+	if !c.complete {
+		c.AsyncHandshake(func(err error) {
+			if err != nil {
+				cb(err, 0)
+				return
+			}
+			c.AsyncRead(b, cb)
+		})
+		return
+	}
+
+	if err := c.advance(c.readable); err != nil {
+		c.asyncAbort(err, func(err error) { cb(err, 0) })
+		return
+	}
+	c.flushPending()
+
+	if len(c.plain) > 0 {
+		n := copy(b, c.plain)
+		c.plain = c.plain[n:]
+		cb(nil, n)
+		return
+	}
+	if c.eof {
+		cb(io.EOF, 0)
+		return
+	}
+	if c.err != nil {
+		cb(c.err, 0)
+		return
+	}
+
+	c.readMore(func(err error) {
+		if err != nil {
+			cb(c.setErr(err), 0)
+			return
+		}
+		c.AsyncRead(b, cb)
+	})
+}
+
+// AsyncReadAll reads exactly len(b) bytes of application data into b.
+func (c *Conn) AsyncReadAll(b []byte, cb sonic.AsyncCallback) {
+	c.asyncReadAll(b, 0, cb)
+}
+
+func (c *Conn) asyncReadAll(b []byte, readBytes int, cb sonic.AsyncCallback) {
+	c.AsyncRead(b[readBytes:], func(err error, n int) {
+		readBytes += n
+		if err != nil || readBytes == len(b) {
+			cb(err, readBytes)
+			return
+		}
+		c.asyncReadAll(b, readBytes, cb)
+	})
+}
+
+// Read reads up to len(b) bytes of application data into b. If the next layer is nonblocking, it returns
+// sonicerrors.ErrWouldBlock if no application data is available.
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+
+	for {
+		if err := c.advance(c.readable); err != nil {
+			return 0, c.abort(err)
+		}
+		if len(c.wbuf) > 0 && !c.flushing {
+			if err := c.flushSync(); err != nil {
+				return 0, c.setErr(err)
+			}
+		}
+
+		if len(c.plain) > 0 {
+			n := copy(b, c.plain)
+			c.plain = c.plain[n:]
+			return n, nil
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		if err := c.readMoreSync(false); err != nil {
+			return 0, c.setErr(err)
+		}
+	}
+}
+
+// AsyncWrite writes b as application data. It completes once all of b is written to the next layer.
+func (c *Conn) AsyncWrite(b []byte, cb sonic.AsyncCallback) {
+	if !c.complete {
+		c.AsyncHandshake(func(err error) {
+			if err != nil {
+				cb(err, 0)
+				return
+			}
+			c.AsyncWrite(b, cb)
+		})
+		return
+	}
+	if c.err != nil {
+		cb(c.err, 0)
+		return
+	}
+	if c.closeSent {
+		cb(io.EOF, 0)
+		return
+	}
+
+	c.wbuf = c.out.seal(c.wbuf, recordTypeApplicationData, b)
+	c.flush(func(err error) {
+		if err != nil {
+			cb(c.setErr(err), 0)
+			return
+		}
+		cb(nil, len(b))
+	})
+}
+
+// AsyncWriteAll is the same as AsyncWrite: writes always complete once all of b is written.
+func (c *Conn) AsyncWriteAll(b []byte, cb sonic.AsyncCallback) {
+	c.AsyncWrite(b, cb)
+}
+
+// Write writes b as application data. It blocks until all of b is written to the next layer, even if the latter is
+// nonblocking: a record cannot be partially written.
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if c.closeSent {
+		return 0, io.EOF
+	}
+
+	c.wbuf = c.out.seal(c.wbuf, recordTypeApplicationData, b)
+	if err := c.flushSync(); err != nil {
+		return 0, c.setErr(err)
+	}
+	return len(b), nil
+}
+
+// Cancel cancels the pending operations of the next layer.
+func (c *Conn) Cancel() {
+	c.next.Cancel()
+}
+
+// Close sends close_notify, without waiting for it to be written, and closes the next layer. See AsyncClose to wait
+// for close_notify to be written.
+func (c *Conn) Close() error {
+	if c.sendCloseNotify() && !c.flushing {
+		// Best effort, such that Close does not block.
+		_, _ = c.next.Write(c.wbuf)
+	}
+	return c.next.Close()
+}
+
+// AsyncClose sends close_notify and closes the next layer once it is written.
+func (c *Conn) AsyncClose(cb func(error)) {
+	if !c.sendCloseNotify() {
+		cb(c.next.Close())
+		return
+	}
+	c.flush(func(err error) {
+		if cerr := c.next.Close(); err == nil {
+			err = cerr
+		}
+		cb(err)
+	})
+}
+
+func (c *Conn) sendCloseNotify() bool {
+	if c.closeSent || !c.complete || c.err != nil {
+		return false
+	}
+	c.closeSent = true
+	c.wbuf = c.out.seal(c.wbuf, recordTypeAlert, []byte{alertLevelWarning, byte(alertCloseNotify)})
+	return true
+}
+
+// readable returns true once application data, or the end of it, can be reported.
+func (c *Conn) readable() bool {
+	return len(c.plain) > 0 || c.eof
+}
+
+// advance processes the buffered records until done returns true or more bytes are needed.
+func (c *Conn) advance(done func() bool) error {
+	for !done() {
+		ok, err := c.processRecord()
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// processRecord processes the first record of rbuf. It returns false if rbuf does not hold a full record.
+func (c *Conn) processRecord() (bool, error) {
+	if c.err != nil {
+		return false, nil
+	}
+	if len(c.rbuf) < recordHeaderLen {
+		return false, nil
+	}
+	length := int(c.rbuf[3])<<8 | int(c.rbuf[4])
+	if length > maxCiphertext {
+		return false, failf(alertRecordOverflow, "record of %d bytes", length)
+	}
+	if len(c.rbuf) < recordHeaderLen+length {
+		return false, nil
+	}
+
+	typ, content, err := c.in.open(c.rbuf[:recordHeaderLen], c.rbuf[recordHeaderLen:recordHeaderLen+length])
+	if err == nil {
+		err = c.handleRecord(typ, content)
+	}
+	c.rbuf = c.rbuf[:copy(c.rbuf, c.rbuf[recordHeaderLen+length:])]
+	return err == nil, err
+}
+
+func (c *Conn) handleRecord(typ uint8, content []byte) error {
+	if c.eof {
+		return failf(alertUnexpectedMessage, "record after close_notify")
+	}
+
+	switch typ {
+	case recordTypeChangeCipherSpec:
+		// Sent for compatibility with middleboxes during the handshake, see RFC 8446 appendix D.4.
+		if c.complete || len(content) != 1 || content[0] != 1 {
+			return failf(alertUnexpectedMessage, "unexpected change_cipher_spec")
+		}
+	case recordTypeAlert:
+		if len(content) != 2 {
+			return failf(alertDecodeError, "alert of %d bytes", len(content))
+		}
+		if alert(content[1]) == alertCloseNotify {
+			c.eof = true
+			return nil
+		}
+		c.err = &AlertError{Alert: content[1], Remote: true}
+		return c.err
+	case recordTypeHandshake:
+		if len(content) == 0 {
+			return failf(alertUnexpectedMessage, "empty handshake record")
+		}
+		c.hsbuf = append(c.hsbuf, content...)
+		for len(c.hsbuf) >= handshakeHeaderLen {
+			length := int(c.hsbuf[1])<<16 | int(c.hsbuf[2])<<8 | int(c.hsbuf[3])
+			if length > maxHandshake {
+				return failf(alertUnexpectedMessage, "handshake message of %d bytes", length)
+			}
+			if len(c.hsbuf) < handshakeHeaderLen+length {
+				break
+			}
+			msg := append([]byte(nil), c.hsbuf[:handshakeHeaderLen+length]...)
+			c.hsbuf = c.hsbuf[:copy(c.hsbuf, c.hsbuf[handshakeHeaderLen+length:])]
+
+			if err := c.handleHandshake(msg); err != nil {
+				return err
+			}
+		}
+	case recordTypeApplicationData:
+		if !c.complete {
+			return failf(alertUnexpectedMessage, "application data during the handshake")
+		}
+		c.plain = append(c.plain, content...)
+	default:
+		return failf(alertUnexpectedMessage, "record of type %d", typ)
+	}
+	return nil
+}
+
+func (c *Conn) handleHandshake(msg []byte) error {
+	if !c.complete {
+		return c.hs.handle(msg)
+	}
+
+	// Post-handshake messages, see RFC 8446 section 4.6.
+	switch msg[0] {
+	case typeNewSessionTicket:
+		if !c.isClient {
+			return failf(alertUnexpectedMessage, "session ticket from a client")
+		}
+		var m newSessionTicket
+		if !m.unmarshal(msg) {
+			return failf(alertDecodeError, "invalid session ticket")
+		}
+		c.storeSession(&m)
+	case typeKeyUpdate:
+		var m keyUpdate
+		if !m.unmarshal(msg) {
+			return failf(alertDecodeError, "invalid key update")
+		}
+		if err := c.setReadKey(c.in.suite, c.in.suite.nextTrafficSecret(c.in.secret)); err != nil {
+			return err
+		}
+		if m.updateRequest && !c.closeSent {
+			c.writeHandshake((&keyUpdate{}).marshal())
+			if err := c.out.update(); err != nil {
+				return err
+			}
+		}
+	default:
+		return failf(alertUnexpectedMessage, "handshake message of type %d after the handshake", msg[0])
+	}
+	return nil
+}
+
+func (c *Conn) storeSession(m *newSessionTicket) {
+	sessions, key := c.config.Sessions, c.config.ServerName
+	if sessions == nil || key == "" || m.lifetime == 0 {
+		return
+	}
+
+	sessions.put(key, &session{
+		suite:          c.suite.id,
+		psk:            c.suite.resumptionPSK(c.resumptionSecret, m.nonce),
+		ticket:         m.ticket,
+		ageAdd:         m.ageAdd,
+		lifetime:       time.Duration(m.lifetime) * time.Second,
+		received:       c.config.now(),
+		alpn:           c.state.NegotiatedProtocol,
+		peerCerts:      c.state.PeerCertificates,
+		verifiedChains: c.state.VerifiedChains,
+	})
+}
+
+// writeHandshake queues the handshake message msg.
+func (c *Conn) writeHandshake(msg []byte) {
+	c.wbuf = c.out.seal(c.wbuf, recordTypeHandshake, msg)
+}
+
+// setReadKey changes the keys of incoming records. Handshake messages must not span a key change.
+func (c *Conn) setReadKey(suite *cipherSuite, secret []byte) error {
+	if len(c.hsbuf) > 0 {
+		return failf(alertUnexpectedMessage, "handshake message spans a key change")
+	}
+	return c.in.setKey(suite, secret)
+}
+
+// completeHandshake is called by the handshaker once the handshake completes successfully.
+func (c *Conn) completeHandshake(
+	suite *cipherSuite,
+	resumptionSecret []byte,
+	alpn string,
+	serverName string,
+	didResume bool,
+	peerCerts []*x509.Certificate,
+	verifiedChains [][]*x509.Certificate,
+) {
+	c.complete = true
+	c.hs = nil
+	c.suite = suite
+	c.resumptionSecret = resumptionSecret
+	c.state = tls.ConnectionState{
+		Version:                    tls.VersionTLS13,
+		HandshakeComplete:          true,
+		DidResume:                  didResume,
+		CipherSuite:                suite.id,
+		NegotiatedProtocol:         alpn,
+		NegotiatedProtocolIsMutual: true,
+		ServerName:                 serverName,
+		PeerCertificates:           peerCerts,
+		VerifiedChains:             verifiedChains,
+	}
+}
+
+// setErr records err as the fatal error of the connection unless it is temporary, and returns it.
+func (c *Conn) setErr(err error) error {
+	if errors.Is(err, io.EOF) {
+		if len(c.rbuf) > 0 || !c.complete {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err == sonicerrors.ErrWouldBlock || err == sonicerrors.ErrCancelled || err == sonicerrors.ErrTimeout {
+		return err
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	return err
+}
+
+// abort records the fatal error err and sends the matching alert to the peer, unless the peer sent it.
+func (c *Conn) abort(err error) error {
+	if !c.queueAlert(err) {
+		return c.setErr(err)
+	}
+	c.err = err
+	_ = c.flushSync()
+	return err
+}
+
+func (c *Conn) asyncAbort(err error, cb func(error)) {
+	if !c.queueAlert(err) {
+		cb(c.setErr(err))
+		return
+	}
+	c.err = err
+	c.flush(func(error) {
+		cb(err)
+	})
+}
+
+func (c *Conn) queueAlert(err error) bool {
+	var ae *AlertError
+	if errors.As(err, &ae) && ae.Remote {
+		return false
+	}
+	if c.err != nil {
+		return false
+	}
+	c.wbuf = c.out.seal(c.wbuf, recordTypeAlert, []byte{alertLevelFatal, byte(alertOf(err))})
+	return true
+}
+
+// flush writes wbuf to the next layer and calls cb once it is empty. Flushes are serialized.
+func (c *Conn) flush(cb func(error)) {
+	c.flushCbs = append(c.flushCbs, cb)
+	if c.flushing {
+		return
+	}
+	c.flushing = true
+	c.asyncFlush()
+}
+
+func (c *Conn) asyncFlush() {
+	if len(c.wbuf) == 0 {
+		c.flushed(nil)
+		return
+	}
+
+	c.next.AsyncWrite(c.wbuf, func(err error, n int) {
+		c.wbuf = c.wbuf[:copy(c.wbuf, c.wbuf[n:])]
+		if err != nil {
+			c.flushed(err)
+			return
+		}
+		c.asyncFlush()
+	})
+}
+
+func (c *Conn) flushed(err error) {
+	c.flushing = false
+	cbs := c.flushCbs
+	c.flushCbs = nil
+	for _, cb := range cbs {
+		cb(err)
+	}
+}
+
+// flushPending starts writing the records queued while reading, like key updates, if no write is pending.
+func (c *Conn) flushPending() {
+	if len(c.wbuf) > 0 && !c.flushing {
+		c.flush(func(err error) {
+			if err != nil {
+				_ = c.setErr(err)
+			}
+		})
+	}
+}
+
+// flushSync writes wbuf to the next layer, waiting for it to be writable if it is nonblocking.
+func (c *Conn) flushSync() error {
+	for len(c.wbuf) > 0 {
+		n, err := c.next.Write(c.wbuf)
+		if n > 0 {
+			c.wbuf = c.wbuf[:copy(c.wbuf, c.wbuf[n:])]
+		}
+		if err == sonicerrors.ErrWouldBlock {
+			err = c.wait(unix.POLLOUT)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMore reads from the next layer into rbuf.
+func (c *Conn) readMore(cb func(error)) {
+	c.next.AsyncRead(c.rbuf[len(c.rbuf):cap(c.rbuf)], func(err error, n int) {
+		c.rbuf = c.rbuf[:len(c.rbuf)+n]
+		if n > 0 {
+			err = nil
+		}
+		cb(err)
+	})
+}
+
+// readMoreSync reads from the next layer into rbuf. If block is true, it waits for the next layer to be readable if
+// it is nonblocking.
+func (c *Conn) readMoreSync(block bool) error {
+	for {
+		n, err := c.next.Read(c.rbuf[len(c.rbuf):cap(c.rbuf)])
+		if n > 0 {
+			c.rbuf = c.rbuf[:len(c.rbuf)+n]
+			return nil
+		}
+		if err == sonicerrors.ErrWouldBlock && block {
+			err = c.wait(unix.POLLIN)
+			if err == nil {
+				continue
+			}
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		return err
+	}
+}
+
+func (c *Conn) wait(events int16) error {
+	fds := []unix.PollFd{{Fd: int32(c.next.RawFd()), Events: events}}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err != syscall.EINTR {
+			if err != nil {
+				return os.NewSyscallError("poll", err)
+			}
+			return nil
+		}
+	}
+}
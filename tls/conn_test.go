@@ -0,0 +1,820 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/talostrading/sonic"
+	"github.com/talostrading/sonic/sonicerrors"
+	"github.com/talostrading/sonic/sonicopts"
+)
+
+// testPKI is a certificate authority issuing the certificates of the tests.
+type testPKI struct {
+	pool *x509.CertPool
+	ca   *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sonic test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{pool: pool, ca: ca, key: key}
+}
+
+// issue returns a certificate valid for the given names, for both servers and clients.
+func (p *testPKI) issue(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     names,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func mustConfig(t *testing.T, cfg *tls.Config) *Config {
+	config, err := NewConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// listenCryptoTLS runs a crypto/tls echo server on addr. The state of each connection is sent on the returned channel
+// once its handshake completes.
+func listenCryptoTLS(t *testing.T, addr string, cfg *tls.Config) (net.Listener, chan tls.ConnectionState) {
+	ln, err := tls.Listen("tcp", addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(chan tls.ConnectionState, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				tconn := conn.(*tls.Conn)
+				if err := tconn.Handshake(); err != nil {
+					return
+				}
+				states <- tconn.ConnectionState()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln, states
+}
+
+// dial connects a client Conn to addr, running ioc until the handshake completes. AsyncDial only takes IP addresses, so
+// localhost is dialed as 127.0.0.1 and is the default server name.
+func dial(ioc *sonic.IO, addr string, config *Config) (*Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "localhost" {
+		if config.ServerName == "" {
+			cfg := *config
+			cfg.Config = config.Config.Clone()
+			cfg.ServerName = host
+			config = &cfg
+		}
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	var (
+		done    = false
+		dialErr error
+		conn    *Conn
+	)
+	AsyncDial(ioc, "tcp", addr, config, func(err error, c *Conn) {
+		done = true
+		dialErr = err
+		conn = c
+	})
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	return conn, dialErr
+}
+
+// echo writes msg on conn and expects to read it back.
+func echo(t *testing.T, ioc *sonic.IO, conn *Conn, msg []byte) {
+	var (
+		done = false
+		b    = make([]byte, len(msg))
+	)
+	conn.AsyncWriteAll(msg, func(err error, n int) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(msg) {
+			t.Fatalf("wrote %d bytes instead of %d", n, len(msg))
+		}
+		conn.AsyncReadAll(b, func(err error, n int) {
+			done = true
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Fatal("invalid echo")
+	}
+}
+
+// serveEcho runs a Server echo loop on the connections accepted by ln. Each handshake result is sent on the returned
+// channel.
+func serveEcho(ln sonic.Listener, config *Config) chan error {
+	results := make(chan error, 16)
+
+	var onAccept sonic.AcceptCallback
+	onAccept = func(err error, conn sonic.Conn) {
+		if err != nil {
+			return
+		}
+		ln.AsyncAccept(onAccept)
+
+		c := Server(conn, config)
+		c.AsyncHandshake(func(err error) {
+			results <- err
+			if err != nil {
+				_ = c.Close()
+				return
+			}
+
+			b := make([]byte, 4096)
+			var onRead sonic.AsyncCallback
+			onRead = func(err error, n int) {
+				if err != nil {
+					_ = c.Close()
+					return
+				}
+				c.AsyncWriteAll(b[:n], func(err error, _ int) {
+					if err != nil {
+						_ = c.Close()
+						return
+					}
+					c.AsyncRead(b, onRead)
+				})
+			}
+			c.AsyncRead(b, onRead)
+		})
+	}
+	ln.AsyncAccept(onAccept)
+
+	return results
+}
+
+// runWhile runs ioc while f runs on another goroutine, and returns the error of f.
+func runWhile(ioc *sonic.IO, f func() error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- f()
+	}()
+	for {
+		select {
+		case err := <-result:
+			return err
+		default:
+			_ = ioc.RunOneFor(time.Millisecond)
+		}
+	}
+}
+
+// cryptoEcho dials addr with crypto/tls, checks the echo of msg and returns the state of the connection.
+func cryptoEcho(addr string, cfg *tls.Config, msg []byte) (state tls.ConnectionState, err error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return state, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(msg); err != nil {
+		return state, err
+	}
+	b := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return state, err
+	}
+	if !bytes.Equal(b, msg) {
+		return state, errors.New("invalid echo")
+	}
+	return conn.ConnectionState(), nil
+}
+
+func TestClient(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ln, states := listenCryptoTLS(t, "localhost:8108", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := dial(ioc, "localhost:8108", mustConfig(t, &tls.Config{
+		RootCAs:    pki.pool,
+		NextProtos: []string{"http/1.1"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if !state.HandshakeComplete || state.Version != tls.VersionTLS13 || state.DidResume {
+		t.Fatalf("invalid state %+v", state)
+	}
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Fatalf("negotiated %q", state.NegotiatedProtocol)
+	}
+	if len(state.VerifiedChains) != 1 || state.PeerCertificates[0].DNSNames[0] != "localhost" {
+		t.Fatal("server certificate not verified")
+	}
+	if serverState := <-states; serverState.ServerName != "localhost" {
+		t.Fatalf("client sent server name %q", serverState.ServerName)
+	}
+
+	echo(t, ioc, conn, []byte("hello"))
+
+	// Spans several records.
+	large := make([]byte, 5*maxPlaintext+123)
+	_, _ = rand.Read(large)
+	echo(t, ioc, conn, large)
+}
+
+func TestClientUnknownAuthority(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ln, _ := listenCryptoTLS(t, "localhost:8109", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	_, err := dial(ioc, "localhost:8109", mustConfig(t, &tls.Config{RootCAs: newTestPKI(t).pool}))
+	var alertErr *AlertError
+	if !errors.As(err, &alertErr) || alert(alertErr.Alert) != alertUnknownCA || alertErr.Remote {
+		t.Fatalf("expected an unknown authority error, got %v", err)
+	}
+
+	_, err = dial(ioc, "localhost:8109", mustConfig(t, &tls.Config{RootCAs: pki.pool, ServerName: "other"}))
+	if !errors.As(err, &alertErr) || alert(alertErr.Alert) != alertBadCertificate {
+		t.Fatalf("expected a bad certificate error, got %v", err)
+	}
+}
+
+func TestClientVersion(t *testing.T) {
+	if _, err := NewConfig(&tls.Config{MaxVersion: tls.VersionTLS12}); !errors.Is(err, ErrVersion) {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+
+	pki := newTestPKI(t)
+
+	ln, _ := listenCryptoTLS(t, "localhost:8144", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+		MaxVersion:   tls.VersionTLS12,
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	_, err := dial(ioc, "localhost:8144", mustConfig(t, &tls.Config{RootCAs: pki.pool}))
+	if !errors.Is(err, ErrVersion) {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8110", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	results := serveEcho(ln, mustConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}))
+
+	large := make([]byte, 3*maxPlaintext)
+	_, _ = rand.Read(large)
+
+	var state tls.ConnectionState
+	err = runWhile(ioc, func() (err error) {
+		state, err = cryptoEcho("localhost:8110", &tls.Config{
+			RootCAs:    pki.pool,
+			ServerName: "localhost",
+			NextProtos: []string{"http/1.1", "h2"},
+		}, large)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != tls.VersionTLS13 || state.NegotiatedProtocol != "h2" {
+		t.Fatalf("invalid state %+v", state)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8111", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	results := serveEcho(ln, mustConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	}))
+
+	// A crypto/tls client authenticates to our server.
+	err = runWhile(ioc, func() error {
+		_, err := cryptoEcho("localhost:8111", &tls.Config{
+			RootCAs:      pki.pool,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{pki.issue(t, "client")},
+		}, []byte("hello"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+
+	// Our client authenticates to our server.
+	conn, err := dial(ioc, "localhost:8111", mustConfig(t, &tls.Config{
+		RootCAs:      pki.pool,
+		Certificates: []tls.Certificate{pki.issue(t, "client")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, ioc, conn, []byte("hello"))
+	_ = conn.Close()
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+
+	// A client without a certificate is rejected.
+	_ = runWhile(ioc, func() error {
+		_, err := cryptoEcho("localhost:8111", &tls.Config{RootCAs: pki.pool, ServerName: "localhost"}, []byte("x"))
+		return err
+	})
+	var alertErr *AlertError
+	if err := <-results; !errors.As(err, &alertErr) || alert(alertErr.Alert) != alertCertificateRequired {
+		t.Fatalf("expected a certificate required error, got %v", err)
+	}
+
+	// A client whose certificate is not trusted is rejected.
+	_ = runWhile(ioc, func() error {
+		_, err := cryptoEcho("localhost:8111", &tls.Config{
+			RootCAs:      pki.pool,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{newTestPKI(t).issue(t, "client")},
+		}, []byte("x"))
+		return err
+	})
+	if err := <-results; !errors.As(err, &alertErr) || alert(alertErr.Alert) != alertUnknownCA {
+		t.Fatalf("expected an unknown authority error, got %v", err)
+	}
+}
+
+func TestMutualTLSClient(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ln, states := listenCryptoTLS(t, "localhost:8112", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client := pki.issue(t, "client")
+	conn, err := dial(ioc, "localhost:8112", mustConfig(t, &tls.Config{
+		RootCAs: pki.pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &client, nil
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	echo(t, ioc, conn, []byte("hello"))
+	if state := <-states; len(state.PeerCertificates) != 1 || state.PeerCertificates[0].Subject.CommonName != "client" {
+		t.Fatal("client did not authenticate")
+	}
+}
+
+func TestServerName(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8113", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	results := serveEcho(ln, mustConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "a.test"), pki.issue(t, "b.test")},
+	}))
+
+	for _, name := range []string{"a.test", "b.test"} {
+		var state tls.ConnectionState
+		err := runWhile(ioc, func() (err error) {
+			state, err = cryptoEcho("localhost:8113", &tls.Config{RootCAs: pki.pool, ServerName: name}, []byte("x"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+		if state.PeerCertificates[0].Subject.CommonName != name {
+			t.Fatalf("server sent the certificate of %s instead of %s", state.PeerCertificates[0].Subject.CommonName, name)
+		}
+	}
+}
+
+func TestClientResumption(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ln, states := listenCryptoTLS(t, "localhost:8114", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	config := mustConfig(t, &tls.Config{RootCAs: pki.pool})
+	config.Sessions = NewSessionCache(1)
+
+	for i := 0; i < 2; i++ {
+		conn, err := dial(ioc, "localhost:8114", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The ticket is sent after the handshake, the echo reads it.
+		echo(t, ioc, conn, []byte("hello"))
+		_ = conn.Close()
+
+		resumed := i > 0
+		if state := conn.ConnectionState(); state.DidResume != resumed || len(state.PeerCertificates) == 0 {
+			t.Fatalf("connection %d: invalid state %+v", i, state)
+		}
+		if state := <-states; state.DidResume != resumed {
+			t.Fatalf("connection %d: server resumed=%v", i, state.DidResume)
+		}
+		if config.Sessions.Len() != 1 {
+			t.Fatal("session not cached")
+		}
+	}
+}
+
+func TestServerResumption(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	ln, err := sonic.Listen(ioc, "tcp", "localhost:8115", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	results := serveEcho(ln, mustConfig(t, &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+	}))
+
+	cfg := &tls.Config{
+		RootCAs:            pki.pool,
+		ServerName:         "localhost",
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	for i := 0; i < 2; i++ {
+		var state tls.ConnectionState
+		err := runWhile(ioc, func() (err error) {
+			state, err = cryptoEcho("localhost:8115", cfg, []byte("hello"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+		if resumed := i > 0; state.DidResume != resumed {
+			t.Fatalf("connection %d: resumed=%v", i, state.DidResume)
+		}
+	}
+}
+
+func TestHelloRetryRequest(t *testing.T) {
+	pki := newTestPKI(t)
+
+	// The client sends an X25519 key share first, which the server does not support.
+	ln, states := listenCryptoTLS(t, "localhost:8116", &tls.Config{
+		Certificates:     []tls.Certificate{pki.issue(t, "localhost")},
+		CurvePreferences: []tls.CurveID{tls.CurveP256},
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	conn, err := dial(ioc, "localhost:8116", mustConfig(t, &tls.Config{RootCAs: pki.pool}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, ioc, conn, []byte("hello"))
+	_ = conn.Close()
+	if state := <-states; state.CurveID != tls.CurveP256 {
+		t.Fatalf("negotiated %v", state.CurveID)
+	}
+
+	// Our server asks a crypto/tls client for a P-256 key share.
+	sln, err := sonic.Listen(ioc, "tcp", "localhost:8117", sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sln.Close()
+	results := serveEcho(sln, mustConfig(t, &tls.Config{
+		Certificates:     []tls.Certificate{pki.issue(t, "localhost")},
+		CurvePreferences: []tls.CurveID{tls.CurveP256},
+	}))
+
+	err = runWhile(ioc, func() error {
+		state, err := cryptoEcho("localhost:8117", &tls.Config{
+			RootCAs:          pki.pool,
+			ServerName:       "localhost",
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		}, []byte("hello"))
+		if err == nil && state.CurveID != tls.CurveP256 {
+			err = errors.New("did not negotiate P-256")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncClient(t *testing.T) {
+	pki := newTestPKI(t)
+
+	ln, _ := listenCryptoTLS(t, "localhost:8118", &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "localhost")},
+	})
+	defer ln.Close()
+
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	next, err := sonic.Dial(ioc, "tcp", "localhost:8118")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := Client(next, mustConfig(t, &tls.Config{RootCAs: pki.pool, ServerName: "localhost"}))
+	defer conn.Close()
+
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	for n := 0; n < len(b); {
+		nn, err := conn.Read(b[n:])
+		if err == sonicerrors.ErrWouldBlock {
+			// The next layer is nonblocking, Read does not wait for data.
+			runtime.Gosched()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += nn
+	}
+	if string(b) != "hello" {
+		t.Fatalf("invalid echo %q", b)
+	}
+}
+
+// pair returns the two ends of a connection made of our client and server, listening on addr.
+func pair(t *testing.T, ioc *sonic.IO, addr string) (client, server *Conn) {
+	pki := newTestPKI(t)
+
+	ln, err := sonic.Listen(ioc, "tcp", addr, sonicopts.Nonblocking(true), sonicopts.ReuseAddr(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var (
+		serverErr error
+		clientErr error
+		done      = 0
+	)
+	serverConfig := mustConfig(t, &tls.Config{Certificates: []tls.Certificate{pki.issue(t, "localhost")}})
+	ln.AsyncAccept(func(err error, conn sonic.Conn) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		server = Server(conn, serverConfig)
+		server.AsyncHandshake(func(err error) {
+			done++
+			serverErr = err
+		})
+	})
+
+	next, err := sonic.Dial(ioc, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = Client(next, mustConfig(t, &tls.Config{RootCAs: pki.pool, ServerName: "localhost"}))
+	client.AsyncHandshake(func(err error) {
+		done++
+		clientErr = err
+	})
+
+	for done < 2 {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("server: %v client: %v", serverErr, clientErr)
+	}
+	return client, server
+}
+
+// transfer writes msg on from and expects to read it on to.
+func transfer(t *testing.T, ioc *sonic.IO, from, to *Conn, msg []byte) {
+	done := 0
+	from.AsyncWriteAll(msg, func(err error, _ int) {
+		done++
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	b := make([]byte, len(msg))
+	to.AsyncReadAll(b, func(err error, _ int) {
+		done++
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	for done < 2 {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Fatal("invalid transfer")
+	}
+}
+
+func TestKeyUpdate(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client, server := pair(t, ioc, "localhost:8120")
+	defer server.Close()
+	defer client.Close()
+
+	transfer(t, ioc, client, server, []byte("before"))
+
+	// The client updates its keys and asks the server to update its own.
+	client.writeHandshake((&keyUpdate{updateRequest: true}).marshal())
+	if err := client.out.update(); err != nil {
+		t.Fatal(err)
+	}
+	clientSecret, serverSecret := client.out.secret, server.out.secret
+
+	transfer(t, ioc, client, server, []byte("after"))
+	transfer(t, ioc, server, client, []byte("reply"))
+
+	if !bytes.Equal(server.in.secret, clientSecret) || bytes.Equal(server.out.secret, serverSecret) {
+		t.Fatal("keys not updated")
+	}
+	if !bytes.Equal(client.in.secret, server.out.secret) {
+		t.Fatal("client did not follow the key update of the server")
+	}
+}
+
+func TestCloseNotify(t *testing.T) {
+	ioc := sonic.MustIO()
+	defer ioc.Close()
+
+	client, server := pair(t, ioc, "localhost:8119")
+
+	client.AsyncClose(func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var (
+		done    = false
+		readErr error
+	)
+	server.AsyncRead(make([]byte, 16), func(err error, _ int) {
+		done = true
+		readErr = err
+	})
+	for !done {
+		_ = ioc.RunOneFor(10 * time.Millisecond)
+	}
+	if readErr != io.EOF {
+		t.Fatalf("expected io.EOF after close_notify, got %v", readErr)
+	}
+	_ = server.Close()
+}
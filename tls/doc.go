@@ -0,0 +1,17 @@
+// Package tls implements TLS 1.3 (RFC 8446) clients and servers on top of sonic streams, driven entirely by the
+// event loop: the handshake and the record layer run in the callbacks of the next layer, without goroutines.
+//
+// A Conn is configured by a Config, which embeds a crypto/tls.Config. The following crypto/tls settings are honoured:
+//   - Certificates and GetCertificate, to select the certificate of a server, by SNI if there are several.
+//   - Certificates and GetClientCertificate, to select the certificate of a client asked to authenticate.
+//   - RootCAs, ServerName, InsecureSkipVerify, VerifyPeerCertificate and Time, to verify servers.
+//   - ClientAuth and ClientCAs, to ask for and verify client certificates.
+//   - NextProtos, for ALPN.
+//   - CurvePreferences, to order and restrict the key exchanges.
+//   - SessionTicketsDisabled and SessionTicketKey, for session resumption on servers.
+//
+// Clients resume sessions through the Sessions cache of their Config.
+//
+// Only TLS 1.3 is supported, with the TLS_AES_128_GCM_SHA256 and TLS_AES_256_GCM_SHA384 cipher suites and the X25519
+// and P-256 key exchanges. Early data (0-RTT) is not supported.
+package tls
@@ -0,0 +1,422 @@
+package tls
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"hash"
+	"net"
+)
+
+type clientState uint8
+
+const (
+	clientWaitServerHello clientState = iota
+	clientWaitEncryptedExtensions
+	clientWaitCertificateOrRequest
+	clientWaitCertificate
+	clientWaitCertificateVerify
+	clientWaitFinished
+)
+
+// clientHandshake runs the client side of the handshake, see RFC 8446 section 2.
+type clientHandshake struct {
+	c     *Conn
+	state clientState
+
+	hello   *clientHello
+	key     *ecdh.PrivateKey
+	session *session
+	retried bool
+
+	suite      *cipherSuite
+	transcript hash.Hash
+	schedule   *keySchedule
+
+	clientSecret, serverSecret []byte
+
+	certRequest    *certificateRequest
+	alpn           string
+	didResume      bool
+	peerCerts      []*x509.Certificate
+	verifiedChains [][]*x509.Certificate
+}
+
+func (hs *clientHandshake) start() error {
+	cfg := hs.c.config
+
+	hs.hello = &clientHello{
+		random:            make([]byte, 32),
+		supportedVersions: []uint16{versionTLS13},
+		supportedGroups:   groupsOf(cfg.Config),
+		signatureSchemes:  signatureSchemes,
+		pskModes:          []uint8{pskModeDHE},
+		alpn:              cfg.NextProtos,
+	}
+	if _, err := rand.Read(hs.hello.random); err != nil {
+		return err
+	}
+	for _, suite := range cipherSuites {
+		hs.hello.cipherSuites = append(hs.hello.cipherSuites, suite.id)
+	}
+	if net.ParseIP(cfg.ServerName) == nil {
+		// IP addresses are not sent, see RFC 6066 section 3.
+		hs.hello.serverName = cfg.ServerName
+	}
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		return failf(alertInternalError, "either ServerName or InsecureSkipVerify must be set")
+	}
+	if len(hs.hello.supportedGroups) == 0 {
+		return failf(alertInternalError, "no supported group")
+	}
+
+	if err := hs.generateKeyShare(hs.hello.supportedGroups[0]); err != nil {
+		return err
+	}
+
+	if cfg.Sessions != nil && cfg.ServerName != "" {
+		s := cfg.Sessions.get(cfg.ServerName)
+		if s != nil && !s.expired(cfg.now()) && cipherSuiteByID(s.suite) != nil {
+			hs.session = s
+		}
+	}
+
+	hs.sendHello(nil)
+	return nil
+}
+
+func (hs *clientHandshake) generateKeyShare(group uint16) error {
+	key, err := generateKeyShare(group)
+	if err != nil {
+		return err
+	}
+	hs.key = key
+	hs.hello.keyShares = []keyShare{{group: group, data: key.PublicKey().Bytes()}}
+	return nil
+}
+
+// sendHello writes the ClientHello, offering the session if any. transcript holds the messages preceding the
+// ClientHello, it is nil for the first one.
+func (hs *clientHandshake) sendHello(transcript hash.Hash) {
+	if hs.session == nil {
+		hs.hello.pskIdentities = nil
+		hs.hello.pskBinders = nil
+		hs.hello.marshal()
+		hs.c.writeHandshake(hs.hello.raw)
+		return
+	}
+
+	suite := cipherSuiteByID(hs.session.suite)
+	hs.hello.pskIdentities = []pskIdentity{{
+		identity: hs.session.ticket,
+		age:      hs.session.age(hs.c.config.now()),
+	}}
+	hs.hello.pskBinders = [][]byte{make([]byte, suite.hashLen())}
+	hs.hello.marshal()
+
+	// The binder covers the ClientHello up to the binders, see RFC 8446 section 4.2.11.2.
+	if transcript == nil {
+		transcript = suite.newHash()
+	} else {
+		transcript = cloneHash(transcript, suite)
+	}
+	transcript.Write(hs.hello.raw[:len(hs.hello.raw)-hs.hello.bindersLen()])
+	binderKey := newKeySchedule(suite, hs.session.psk).binderKey()
+	hs.hello.updateBinders([][]byte{suite.finishedHash(binderKey, transcript)})
+
+	hs.c.writeHandshake(hs.hello.raw)
+}
+
+func (hs *clientHandshake) handle(msg []byte) error {
+	switch {
+	case msg[0] == typeServerHello && hs.state == clientWaitServerHello:
+		return hs.handleServerHello(msg)
+	case msg[0] == typeEncryptedExtensions && hs.state == clientWaitEncryptedExtensions:
+		return hs.handleEncryptedExtensions(msg)
+	case msg[0] == typeCertificateRequest && hs.state == clientWaitCertificateOrRequest:
+		return hs.handleCertificateRequest(msg)
+	case msg[0] == typeCertificate &&
+		(hs.state == clientWaitCertificateOrRequest || hs.state == clientWaitCertificate):
+		return hs.handleCertificate(msg)
+	case msg[0] == typeCertificateVerify && hs.state == clientWaitCertificateVerify:
+		return hs.handleCertificateVerify(msg)
+	case msg[0] == typeFinished && hs.state == clientWaitFinished:
+		return hs.handleFinished(msg)
+	default:
+		return failf(alertUnexpectedMessage, "unexpected handshake message of type %d", msg[0])
+	}
+}
+
+func (hs *clientHandshake) handleServerHello(msg []byte) error {
+	var m serverHello
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid ServerHello")
+	}
+	if m.supportedVersion != versionTLS13 {
+		return failf(alertProtocolVersion, "server selected version %#04x", m.supportedVersion)
+	}
+	if string(m.sessionID) != string(hs.hello.sessionID) {
+		return failf(alertIllegalParameter, "server did not echo the session id")
+	}
+	suite := cipherSuiteByID(m.cipherSuite)
+	if suite == nil || (hs.suite != nil && suite != hs.suite) {
+		return failf(alertIllegalParameter, "server selected cipher suite %#04x", m.cipherSuite)
+	}
+
+	if m.isHelloRetryRequest() {
+		return hs.handleHelloRetryRequest(&m, suite)
+	}
+
+	if hs.transcript == nil {
+		hs.suite = suite
+		hs.transcript = suite.newHash()
+		hs.transcript.Write(hs.hello.raw)
+	}
+	hs.transcript.Write(m.raw)
+
+	if m.keyShare.group != hs.hello.keyShares[0].group {
+		return failf(alertIllegalParameter, "server sent a key share for group %d", m.keyShare.group)
+	}
+
+	var psk []byte
+	if m.hasPSK {
+		if hs.session == nil || m.selectedIdentity != 0 || cipherSuiteByID(hs.session.suite).hash != suite.hash {
+			return failf(alertIllegalParameter, "server selected a pre-shared key which was not offered")
+		}
+		psk = hs.session.psk
+		hs.didResume = true
+	}
+
+	shared, err := sharedSecret(hs.key, m.keyShare.data)
+	if err != nil {
+		return err
+	}
+
+	hs.schedule = newKeySchedule(suite, psk)
+	hs.schedule.next(shared)
+	hs.clientSecret = hs.schedule.derive("c hs traffic", hs.transcript)
+	hs.serverSecret = hs.schedule.derive("s hs traffic", hs.transcript)
+	if err := hs.c.setReadKey(suite, hs.serverSecret); err != nil {
+		return err
+	}
+	if err := hs.c.out.setKey(suite, hs.clientSecret); err != nil {
+		return err
+	}
+
+	hs.state = clientWaitEncryptedExtensions
+	return nil
+}
+
+// handleHelloRetryRequest sends a second ClientHello with the key share the server asks for, see RFC 8446
+// section 4.1.4.
+func (hs *clientHandshake) handleHelloRetryRequest(m *serverHello, suite *cipherSuite) error {
+	if hs.retried {
+		return failf(alertUnexpectedMessage, "second HelloRetryRequest")
+	}
+	hs.retried = true
+
+	hs.suite = suite
+	hs.transcript = suite.newHash()
+	writeMessageHash(hs.transcript, suite, hs.hello.raw)
+	hs.transcript.Write(m.raw)
+
+	if m.selectedGroup != 0 {
+		supported := false
+		for _, group := range hs.hello.supportedGroups {
+			supported = supported || group == m.selectedGroup
+		}
+		if !supported || m.selectedGroup == hs.hello.keyShares[0].group {
+			return failf(alertIllegalParameter, "server selected group %d", m.selectedGroup)
+		}
+		if err := hs.generateKeyShare(m.selectedGroup); err != nil {
+			return err
+		}
+	} else if m.cookie == nil {
+		return failf(alertIllegalParameter, "HelloRetryRequest would not change the ClientHello")
+	}
+	hs.hello.cookie = m.cookie
+
+	// The session may only be resumed with a cipher suite of the same hash, see RFC 8446 section 4.1.4.
+	if hs.session != nil && cipherSuiteByID(hs.session.suite).hash != suite.hash {
+		hs.session = nil
+	}
+
+	hs.sendHello(hs.transcript)
+	hs.transcript.Write(hs.hello.raw)
+	return nil
+}
+
+func (hs *clientHandshake) handleEncryptedExtensions(msg []byte) error {
+	var m encryptedExtensions
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid EncryptedExtensions")
+	}
+	hs.transcript.Write(m.raw)
+
+	if m.alpn != "" {
+		offered := false
+		for _, proto := range hs.hello.alpn {
+			offered = offered || proto == m.alpn
+		}
+		if !offered {
+			return failf(alertUnsupportedExtension, "server selected application protocol %q", m.alpn)
+		}
+		hs.alpn = m.alpn
+	}
+
+	if hs.didResume {
+		hs.state = clientWaitFinished
+	} else {
+		hs.state = clientWaitCertificateOrRequest
+	}
+	return nil
+}
+
+func (hs *clientHandshake) handleCertificateRequest(msg []byte) error {
+	var m certificateRequest
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid CertificateRequest")
+	}
+	hs.transcript.Write(m.raw)
+
+	hs.certRequest = &m
+	hs.state = clientWaitCertificate
+	return nil
+}
+
+func (hs *clientHandshake) handleCertificate(msg []byte) error {
+	var m certificateMsg
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid Certificate")
+	}
+	if len(m.context) != 0 || len(m.certificates) == 0 {
+		return failf(alertDecodeError, "server sent no certificate")
+	}
+	hs.transcript.Write(m.raw)
+
+	cfg := hs.c.config
+	certs, chains, err := verifyPeer(cfg.Config, m.certificates, x509.VerifyOptions{
+		Roots:       cfg.RootCAs,
+		CurrentTime: cfg.now(),
+		DNSName:     cfg.ServerName,
+	}, cfg.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	hs.peerCerts, hs.verifiedChains = certs, chains
+
+	hs.state = clientWaitCertificateVerify
+	return nil
+}
+
+func (hs *clientHandshake) handleCertificateVerify(msg []byte) error {
+	var m certificateVerify
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid CertificateVerify")
+	}
+
+	err := verify(hs.peerCerts[0].PublicKey, m.scheme, signedMessage(serverSignatureContext, hs.transcript), m.signature)
+	if err != nil {
+		return err
+	}
+	hs.transcript.Write(m.raw)
+
+	hs.state = clientWaitFinished
+	return nil
+}
+
+func (hs *clientHandshake) handleFinished(msg []byte) error {
+	var m finished
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid Finished")
+	}
+	if !hmac.Equal(m.verifyData, hs.suite.finishedHash(hs.serverSecret, hs.transcript)) {
+		return failf(alertDecryptError, "invalid server Finished")
+	}
+	hs.transcript.Write(m.raw)
+
+	hs.schedule.next(nil)
+	clientSecret := hs.schedule.derive("c ap traffic", hs.transcript)
+	serverSecret := hs.schedule.derive("s ap traffic", hs.transcript)
+	if err := hs.c.setReadKey(hs.suite, serverSecret); err != nil {
+		return err
+	}
+
+	if hs.certRequest != nil {
+		if err := hs.sendCertificate(); err != nil {
+			return err
+		}
+	}
+
+	fin := &finished{verifyData: hs.suite.finishedHash(hs.clientSecret, hs.transcript)}
+	hs.c.writeHandshake(fin.marshal())
+	hs.transcript.Write(fin.raw)
+
+	if err := hs.c.out.setKey(hs.suite, clientSecret); err != nil {
+		return err
+	}
+
+	peerCerts, verifiedChains := hs.peerCerts, hs.verifiedChains
+	if hs.didResume {
+		peerCerts, verifiedChains = hs.session.peerCerts, hs.session.verifiedChains
+	}
+	hs.c.completeHandshake(
+		hs.suite,
+		hs.schedule.derive("res master", hs.transcript),
+		hs.alpn,
+		hs.c.config.ServerName,
+		hs.didResume,
+		peerCerts,
+		verifiedChains,
+	)
+	return nil
+}
+
+// sendCertificate answers a CertificateRequest, with an empty Certificate if the client has no suitable certificate.
+func (hs *clientHandshake) sendCertificate() error {
+	cfg := hs.c.config
+
+	var cert *tls.Certificate
+	if cfg.GetClientCertificate != nil {
+		info := &tls.CertificateRequestInfo{Version: tls.VersionTLS13}
+		for _, scheme := range hs.certRequest.signatureSchemes {
+			info.SignatureSchemes = append(info.SignatureSchemes, tls.SignatureScheme(scheme))
+		}
+		var err error
+		if cert, err = cfg.GetClientCertificate(info); err != nil {
+			return err
+		}
+	} else {
+		for i := range cfg.Certificates {
+			if _, _, err := selectScheme(cfg.Certificates[i].PrivateKey, hs.certRequest.signatureSchemes); err == nil {
+				cert = &cfg.Certificates[i]
+				break
+			}
+		}
+	}
+
+	m := &certificateMsg{context: hs.certRequest.context}
+	if cert != nil {
+		m.certificates = cert.Certificate
+	}
+	hs.c.writeHandshake(m.marshal())
+	hs.transcript.Write(m.raw)
+	if len(m.certificates) == 0 {
+		return nil
+	}
+
+	signer, scheme, err := selectScheme(cert.PrivateKey, hs.certRequest.signatureSchemes)
+	if err != nil {
+		return fail(alertHandshakeFailure, err)
+	}
+	signature, err := sign(signer, scheme, signedMessage(clientSignatureContext, hs.transcript))
+	if err != nil {
+		return err
+	}
+	verify := &certificateVerify{scheme: scheme, signature: signature}
+	hs.c.writeHandshake(verify.marshal())
+	hs.transcript.Write(verify.raw)
+	return nil
+}
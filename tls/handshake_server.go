@@ -0,0 +1,465 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"hash"
+	"time"
+)
+
+type serverState uint8
+
+const (
+	serverWaitClientHello serverState = iota
+	serverWaitSecondClientHello
+	serverWaitCertificate
+	serverWaitCertificateVerify
+	serverWaitFinished
+)
+
+// serverHandshake runs the server side of the handshake, see RFC 8446 section 2.
+type serverHandshake struct {
+	c     *Conn
+	state serverState
+
+	hello      *clientHello
+	suite      *cipherSuite
+	transcript hash.Hash
+	schedule   *keySchedule
+
+	// group is the group the server asked for in its HelloRetryRequest, if any.
+	group uint16
+
+	clientSecret     []byte
+	trafficSecret    []byte
+	certRequested    bool
+	psk              []byte
+	selectedIdentity uint16
+	didResume        bool
+	alpn             string
+
+	peerCerts      []*x509.Certificate
+	verifiedChains [][]*x509.Certificate
+}
+
+func (hs *serverHandshake) start() error {
+	return nil
+}
+
+func (hs *serverHandshake) handle(msg []byte) error {
+	switch {
+	case msg[0] == typeClientHello &&
+		(hs.state == serverWaitClientHello || hs.state == serverWaitSecondClientHello):
+		return hs.handleClientHello(msg)
+	case msg[0] == typeCertificate && hs.state == serverWaitCertificate:
+		return hs.handleCertificate(msg)
+	case msg[0] == typeCertificateVerify && hs.state == serverWaitCertificateVerify:
+		return hs.handleCertificateVerify(msg)
+	case msg[0] == typeFinished && hs.state == serverWaitFinished:
+		return hs.handleFinished(msg)
+	default:
+		return failf(alertUnexpectedMessage, "unexpected handshake message of type %d", msg[0])
+	}
+}
+
+func (hs *serverHandshake) handleClientHello(msg []byte) error {
+	cfg := hs.c.config
+
+	hs.hello = &clientHello{}
+	if !hs.hello.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid ClientHello")
+	}
+	supported := false
+	for _, v := range hs.hello.supportedVersions {
+		supported = supported || v == versionTLS13
+	}
+	if !supported {
+		return failf(alertProtocolVersion, "client does not support TLS 1.3")
+	}
+
+	var suite *cipherSuite
+	for _, id := range hs.hello.cipherSuites {
+		if suite = cipherSuiteByID(id); suite != nil {
+			break
+		}
+	}
+	if suite == nil || (hs.suite != nil && suite != hs.suite) {
+		return failf(alertHandshakeFailure, "no common cipher suite")
+	}
+	if hs.transcript == nil {
+		hs.suite = suite
+		hs.transcript = suite.newHash()
+	}
+
+	// The key share of the client, by order of preference of the server.
+	var share *keyShare
+	for _, group := range groupsOf(cfg.Config) {
+		for i := range hs.hello.keyShares {
+			if hs.hello.keyShares[i].group == group && share == nil {
+				share = &hs.hello.keyShares[i]
+			}
+		}
+	}
+	if hs.state == serverWaitSecondClientHello && (share == nil || share.group != hs.group) {
+		return failf(alertIllegalParameter, "client did not send a key share for group %d", hs.group)
+	}
+	if share == nil {
+		return hs.sendHelloRetryRequest()
+	}
+
+	if err := hs.selectPSK(); err != nil {
+		return err
+	}
+	hs.transcript.Write(hs.hello.raw)
+
+	if len(cfg.NextProtos) > 0 && len(hs.hello.alpn) > 0 {
+		for _, proto := range cfg.NextProtos {
+			for _, offered := range hs.hello.alpn {
+				if proto == offered && hs.alpn == "" {
+					hs.alpn = proto
+				}
+			}
+		}
+		if hs.alpn == "" {
+			return failf(alertNoApplicationProtocol, "no common application protocol")
+		}
+	}
+
+	var (
+		cert   *tls.Certificate
+		signer crypto.Signer
+		scheme uint16
+	)
+	if !hs.didResume {
+		var err error
+		if cert, err = hs.certificate(); err != nil {
+			return err
+		}
+		if signer, scheme, err = selectScheme(cert.PrivateKey, hs.hello.signatureSchemes); err != nil {
+			return fail(alertHandshakeFailure, err)
+		}
+	}
+
+	key, err := generateKeyShare(share.group)
+	if err != nil {
+		return err
+	}
+	shared, err := sharedSecret(key, share.data)
+	if err != nil {
+		return err
+	}
+
+	sh := &serverHello{
+		random:           make([]byte, 32),
+		sessionID:        hs.hello.sessionID,
+		cipherSuite:      suite.id,
+		supportedVersion: versionTLS13,
+		keyShare:         keyShare{group: share.group, data: key.PublicKey().Bytes()},
+		hasPSK:           hs.didResume,
+		selectedIdentity: hs.selectedIdentity,
+	}
+	if _, err := rand.Read(sh.random); err != nil {
+		return err
+	}
+	hs.c.writeHandshake(sh.marshal())
+	hs.transcript.Write(sh.raw)
+
+	hs.schedule = newKeySchedule(suite, hs.psk)
+	hs.schedule.next(shared)
+	hs.clientSecret = hs.schedule.derive("c hs traffic", hs.transcript)
+	serverSecret := hs.schedule.derive("s hs traffic", hs.transcript)
+	if err := hs.c.out.setKey(suite, serverSecret); err != nil {
+		return err
+	}
+	if err := hs.c.setReadKey(suite, hs.clientSecret); err != nil {
+		return err
+	}
+
+	ee := &encryptedExtensions{alpn: hs.alpn, serverNameAck: hs.hello.serverName != "" && !hs.didResume}
+	hs.c.writeHandshake(ee.marshal())
+	hs.transcript.Write(ee.raw)
+
+	if !hs.didResume {
+		if cfg.ClientAuth >= tls.RequestClientCert {
+			req := &certificateRequest{signatureSchemes: signatureSchemes}
+			hs.c.writeHandshake(req.marshal())
+			hs.transcript.Write(req.raw)
+			hs.certRequested = true
+		}
+
+		certMsg := &certificateMsg{certificates: cert.Certificate}
+		hs.c.writeHandshake(certMsg.marshal())
+		hs.transcript.Write(certMsg.raw)
+
+		signature, err := sign(signer, scheme, signedMessage(serverSignatureContext, hs.transcript))
+		if err != nil {
+			return err
+		}
+		verify := &certificateVerify{scheme: scheme, signature: signature}
+		hs.c.writeHandshake(verify.marshal())
+		hs.transcript.Write(verify.raw)
+	}
+
+	fin := &finished{verifyData: suite.finishedHash(serverSecret, hs.transcript)}
+	hs.c.writeHandshake(fin.marshal())
+	hs.transcript.Write(fin.raw)
+
+	hs.schedule.next(nil)
+	hs.trafficSecret = hs.schedule.derive("c ap traffic", hs.transcript)
+	if err := hs.c.out.setKey(suite, hs.schedule.derive("s ap traffic", hs.transcript)); err != nil {
+		return err
+	}
+
+	if hs.certRequested {
+		hs.state = serverWaitCertificate
+	} else {
+		hs.state = serverWaitFinished
+	}
+	return nil
+}
+
+// sendHelloRetryRequest asks the client for a key share of a group both support, see RFC 8446 section 4.1.4.
+func (hs *serverHandshake) sendHelloRetryRequest() error {
+	if hs.state == serverWaitSecondClientHello {
+		return failf(alertIllegalParameter, "second ClientHello without a suitable key share")
+	}
+
+	for _, group := range groupsOf(hs.c.config.Config) {
+		for _, offered := range hs.hello.supportedGroups {
+			if group == offered && hs.group == 0 {
+				hs.group = group
+			}
+		}
+	}
+	if hs.group == 0 {
+		return failf(alertHandshakeFailure, "no common group")
+	}
+
+	writeMessageHash(hs.transcript, hs.suite, hs.hello.raw)
+	hrr := &serverHello{
+		random:           helloRetryRequestRandom,
+		sessionID:        hs.hello.sessionID,
+		cipherSuite:      hs.suite.id,
+		supportedVersion: versionTLS13,
+		selectedGroup:    hs.group,
+	}
+	hs.c.writeHandshake(hrr.marshal())
+	hs.transcript.Write(hrr.raw)
+
+	hs.state = serverWaitSecondClientHello
+	return nil
+}
+
+// selectPSK resumes the session of the first valid ticket the client offers, if any. The transcript must not include
+// the ClientHello yet.
+func (hs *serverHandshake) selectPSK() error {
+	cfg := hs.c.config
+	if cfg.SessionTicketsDisabled || !hs.offersPSKDHE() {
+		return nil
+	}
+
+	for i, identity := range hs.hello.pskIdentities {
+		state := cfg.openTicket(identity.identity)
+		if state == nil || cfg.now().Sub(state.created) > ticketLifetime {
+			continue
+		}
+		if suite := cipherSuiteByID(state.suite); suite == nil || suite.hash != hs.suite.hash {
+			continue
+		}
+		if len(state.peerCerts) == 0 &&
+			(cfg.ClientAuth == tls.RequireAnyClientCert || cfg.ClientAuth == tls.RequireAndVerifyClientCert) {
+			continue
+		}
+
+		transcript := cloneHash(hs.transcript, hs.suite)
+		transcript.Write(hs.hello.raw[:len(hs.hello.raw)-hs.hello.bindersLen()])
+		binderKey := newKeySchedule(hs.suite, state.psk).binderKey()
+		if !hmac.Equal(hs.hello.pskBinders[i], hs.suite.finishedHash(binderKey, transcript)) {
+			return failf(alertDecryptError, "invalid pre-shared key binder")
+		}
+
+		for _, raw := range state.peerCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fail(alertInternalError, err)
+			}
+			hs.peerCerts = append(hs.peerCerts, cert)
+		}
+		hs.psk = state.psk
+		hs.selectedIdentity = uint16(i)
+		hs.didResume = true
+		return nil
+	}
+	return nil
+}
+
+func (hs *serverHandshake) offersPSKDHE() bool {
+	for _, mode := range hs.hello.pskModes {
+		if mode == pskModeDHE {
+			return true
+		}
+	}
+	return false
+}
+
+// certificate returns the certificate of the server: the one returned by GetCertificate if set, otherwise the first
+// one valid for the server name the client asks for, otherwise the first one.
+func (hs *serverHandshake) certificate() (*tls.Certificate, error) {
+	cfg := hs.c.config
+
+	if cfg.GetCertificate != nil {
+		info := &tls.ClientHelloInfo{
+			CipherSuites:      hs.hello.cipherSuites,
+			ServerName:        hs.hello.serverName,
+			SupportedProtos:   hs.hello.alpn,
+			SupportedVersions: hs.hello.supportedVersions,
+		}
+		for _, group := range hs.hello.supportedGroups {
+			info.SupportedCurves = append(info.SupportedCurves, tls.CurveID(group))
+		}
+		for _, scheme := range hs.hello.signatureSchemes {
+			info.SignatureSchemes = append(info.SignatureSchemes, tls.SignatureScheme(scheme))
+		}
+		cert, err := cfg.GetCertificate(info)
+		if err != nil {
+			return nil, fail(alertInternalError, err)
+		}
+		if cert != nil {
+			return cert, nil
+		}
+	}
+
+	if len(cfg.Certificates) == 0 {
+		return nil, failf(alertInternalError, "no certificate")
+	}
+	if hs.hello.serverName != "" {
+		for i := range cfg.Certificates {
+			leaf := cfg.Certificates[i].Leaf
+			if leaf == nil && len(cfg.Certificates[i].Certificate) > 0 {
+				leaf, _ = x509.ParseCertificate(cfg.Certificates[i].Certificate[0])
+			}
+			if leaf != nil && leaf.VerifyHostname(hs.hello.serverName) == nil {
+				return &cfg.Certificates[i], nil
+			}
+		}
+	}
+	return &cfg.Certificates[0], nil
+}
+
+func (hs *serverHandshake) handleCertificate(msg []byte) error {
+	var m certificateMsg
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid Certificate")
+	}
+	if len(m.context) != 0 {
+		return failf(alertIllegalParameter, "invalid certificate request context")
+	}
+	hs.transcript.Write(m.raw)
+
+	cfg := hs.c.config
+	if len(m.certificates) == 0 {
+		if cfg.ClientAuth == tls.RequireAnyClientCert || cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+			return failf(alertCertificateRequired, "client sent no certificate")
+		}
+		hs.state = serverWaitFinished
+		return nil
+	}
+
+	certs, chains, err := verifyPeer(cfg.Config, m.certificates, x509.VerifyOptions{
+		Roots:       cfg.ClientCAs,
+		CurrentTime: cfg.now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, cfg.ClientAuth < tls.VerifyClientCertIfGiven)
+	if err != nil {
+		return err
+	}
+	hs.peerCerts, hs.verifiedChains = certs, chains
+
+	hs.state = serverWaitCertificateVerify
+	return nil
+}
+
+func (hs *serverHandshake) handleCertificateVerify(msg []byte) error {
+	var m certificateVerify
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid CertificateVerify")
+	}
+
+	err := verify(hs.peerCerts[0].PublicKey, m.scheme, signedMessage(clientSignatureContext, hs.transcript), m.signature)
+	if err != nil {
+		return err
+	}
+	hs.transcript.Write(m.raw)
+
+	hs.state = serverWaitFinished
+	return nil
+}
+
+func (hs *serverHandshake) handleFinished(msg []byte) error {
+	var m finished
+	if !m.unmarshal(msg) {
+		return failf(alertDecodeError, "invalid Finished")
+	}
+	if !hmac.Equal(m.verifyData, hs.suite.finishedHash(hs.clientSecret, hs.transcript)) {
+		return failf(alertDecryptError, "invalid client Finished")
+	}
+	hs.transcript.Write(m.raw)
+
+	if err := hs.c.setReadKey(hs.suite, hs.trafficSecret); err != nil {
+		return err
+	}
+
+	resumptionSecret := hs.schedule.derive("res master", hs.transcript)
+	hs.c.completeHandshake(
+		hs.suite,
+		resumptionSecret,
+		hs.alpn,
+		hs.hello.serverName,
+		hs.didResume,
+		hs.peerCerts,
+		hs.verifiedChains,
+	)
+
+	if !hs.c.config.SessionTicketsDisabled && hs.offersPSKDHE() {
+		return hs.sendSessionTicket(resumptionSecret)
+	}
+	return nil
+}
+
+// sendSessionTicket issues a ticket the client may resume the session with, see RFC 8446 section 4.6.1.
+func (hs *serverHandshake) sendSessionTicket(resumptionSecret []byte) error {
+	var ageAdd [4]byte
+	if _, err := rand.Read(ageAdd[:]); err != nil {
+		return err
+	}
+
+	// A single ticket is issued per connection, so the nonce need not change.
+	nonce := []byte{0}
+	state := &ticketState{
+		suite:   hs.suite.id,
+		created: hs.c.config.now(),
+		ageAdd:  binary.BigEndian.Uint32(ageAdd[:]),
+		psk:     hs.suite.resumptionPSK(resumptionSecret, nonce),
+		alpn:    hs.alpn,
+	}
+	for _, cert := range hs.peerCerts {
+		state.peerCerts = append(state.peerCerts, cert.Raw)
+	}
+	ticket, err := hs.c.config.sealTicket(state)
+	if err != nil {
+		return err
+	}
+
+	m := &newSessionTicket{
+		lifetime: uint32(ticketLifetime / time.Second),
+		ageAdd:   state.ageAdd,
+		nonce:    nonce,
+		ticket:   ticket,
+	}
+	hs.c.writeHandshake(m.marshal())
+	return nil
+}
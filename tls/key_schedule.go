@@ -0,0 +1,182 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"hash"
+)
+
+// cipherSuite is a TLS 1.3 cipher suite: an AEAD and the hash of the key schedule, see RFC 8446 appendix B.4.
+type cipherSuite struct {
+	id     uint16
+	keyLen int
+	hash   crypto.Hash
+}
+
+// cipherSuites are the supported cipher suites, in order of preference.
+var cipherSuites = []*cipherSuite{
+	{id: tls.TLS_AES_128_GCM_SHA256, keyLen: 16, hash: crypto.SHA256},
+	{id: tls.TLS_AES_256_GCM_SHA384, keyLen: 32, hash: crypto.SHA384},
+}
+
+func cipherSuiteByID(id uint16) *cipherSuite {
+	for _, suite := range cipherSuites {
+		if suite.id == id {
+			return suite
+		}
+	}
+	return nil
+}
+
+func (s *cipherSuite) newHash() hash.Hash {
+	switch s.hash {
+	case crypto.SHA384:
+		return sha512.New384()
+	default:
+		return sha256.New()
+	}
+}
+
+func (s *cipherSuite) hashLen() int {
+	return s.hash.Size()
+}
+
+// extract is HKDF-Extract, see RFC 5869 section 2.2. A nil secret or salt stands for a string of hashLen zeros.
+func (s *cipherSuite) extract(secret, salt []byte) []byte {
+	if secret == nil {
+		secret = make([]byte, s.hashLen())
+	}
+	if salt == nil {
+		salt = make([]byte, s.hashLen())
+	}
+	mac := hmac.New(s.newHash, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// expand is HKDF-Expand, see RFC 5869 section 2.3.
+func (s *cipherSuite) expand(secret, info []byte, length int) []byte {
+	var (
+		out  = make([]byte, 0, length)
+		prev []byte
+		mac  = hmac.New(s.newHash, secret)
+	)
+	for i := byte(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// expandLabel is HKDF-Expand-Label, see RFC 8446 section 7.1.
+func (s *cipherSuite) expandLabel(secret []byte, label string, context []byte, length int) []byte {
+	var b builder
+	b.u16(uint16(length))
+	b.vecBytes(1, append([]byte("tls13 "), label...))
+	b.vecBytes(1, context)
+	return s.expand(secret, b.b, length)
+}
+
+// deriveSecret is Derive-Secret, see RFC 8446 section 7.1. transcript is the hash of the messages, or nil for none.
+func (s *cipherSuite) deriveSecret(secret []byte, label string, transcript hash.Hash) []byte {
+	if transcript == nil {
+		transcript = s.newHash()
+	}
+	return s.expandLabel(secret, label, transcript.Sum(nil), s.hashLen())
+}
+
+// trafficKey returns the key and iv of the traffic secret, see RFC 8446 section 7.3.
+func (s *cipherSuite) trafficKey(secret []byte) (key, iv []byte) {
+	key = s.expandLabel(secret, "key", nil, s.keyLen)
+	iv = s.expandLabel(secret, "iv", nil, aeadNonceLen)
+	return
+}
+
+// nextTrafficSecret returns the traffic secret following secret after a KeyUpdate, see RFC 8446 section 7.2.
+func (s *cipherSuite) nextTrafficSecret(secret []byte) []byte {
+	return s.expandLabel(secret, "traffic upd", nil, s.hashLen())
+}
+
+// finishedHash returns the verify_data of a Finished message, see RFC 8446 section 4.4.4.
+func (s *cipherSuite) finishedHash(baseKey []byte, transcript hash.Hash) []byte {
+	finishedKey := s.expandLabel(baseKey, "finished", nil, s.hashLen())
+	mac := hmac.New(s.newHash, finishedKey)
+	mac.Write(transcript.Sum(nil))
+	return mac.Sum(nil)
+}
+
+// resumptionPSK returns the pre-shared key of the session ticket with the given nonce, see RFC 8446 section 4.6.1.
+func (s *cipherSuite) resumptionPSK(resumptionSecret, nonce []byte) []byte {
+	return s.expandLabel(resumptionSecret, "resumption", nonce, s.hashLen())
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keySchedule tracks the secrets of a handshake, see RFC 8446 section 7.1.
+type keySchedule struct {
+	suite *cipherSuite
+
+	// secret is the early secret, then the handshake secret, then the master secret.
+	secret []byte
+}
+
+func newKeySchedule(suite *cipherSuite, psk []byte) *keySchedule {
+	return &keySchedule{suite: suite, secret: suite.extract(psk, nil)}
+}
+
+// binderKey returns the key of resumption PSK binders. It must be called on the early secret.
+func (k *keySchedule) binderKey() []byte {
+	return k.suite.deriveSecret(k.secret, "res binder", nil)
+}
+
+// next advances to the next secret of the schedule, mixing in ikm. A nil ikm stands for a string of zeros.
+func (k *keySchedule) next(ikm []byte) {
+	derived := k.suite.deriveSecret(k.secret, "derived", nil)
+	k.secret = k.suite.extract(ikm, derived)
+}
+
+func (k *keySchedule) derive(label string, transcript hash.Hash) []byte {
+	return k.suite.deriveSecret(k.secret, label, transcript)
+}
+
+// cloneHash returns a copy of the running transcript hash h.
+func cloneHash(h hash.Hash, suite *cipherSuite) hash.Hash {
+	type binaryMarshaler interface {
+		MarshalBinary() ([]byte, error)
+		UnmarshalBinary([]byte) error
+	}
+
+	state, err := h.(binaryMarshaler).MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	clone := suite.newHash()
+	if err := clone.(binaryMarshaler).UnmarshalBinary(state); err != nil {
+		panic(err)
+	}
+	return clone
+}
+
+// writeMessageHash writes to transcript the message_hash message replacing the first ClientHello of a handshake with a
+// HelloRetryRequest, see RFC 8446 section 4.4.1.
+func writeMessageHash(transcript hash.Hash, suite *cipherSuite, clientHello []byte) {
+	h := suite.newHash()
+	h.Write(clientHello)
+	transcript.Write([]byte{typeMessageHash, 0, 0, byte(suite.hashLen())})
+	transcript.Write(h.Sum(nil))
+}
@@ -0,0 +1,655 @@
+package tls
+
+// Handshake message types, see RFC 8446 section 4.
+const (
+	typeClientHello         uint8 = 1
+	typeServerHello         uint8 = 2
+	typeNewSessionTicket    uint8 = 4
+	typeEncryptedExtensions uint8 = 8
+	typeCertificate         uint8 = 11
+	typeCertificateRequest  uint8 = 13
+	typeCertificateVerify   uint8 = 15
+	typeFinished            uint8 = 20
+	typeKeyUpdate           uint8 = 24
+	typeMessageHash         uint8 = 254
+)
+
+// Extensions, see RFC 8446 section 4.2.
+const (
+	extServerName          uint16 = 0
+	extSupportedGroups     uint16 = 10
+	extSignatureAlgorithms uint16 = 13
+	extALPN                uint16 = 16
+	extPreSharedKey        uint16 = 41
+	extSupportedVersions   uint16 = 43
+	extCookie              uint16 = 44
+	extPSKModes            uint16 = 45
+	extKeyShare            uint16 = 51
+)
+
+const (
+	handshakeHeaderLen = 4
+
+	// maxHandshake bounds the size of handshake messages.
+	maxHandshake = 1 << 16
+
+	pskModeDHE uint8 = 1
+)
+
+// helloRetryRequestRandom is the random of a ServerHello which is a HelloRetryRequest, see RFC 8446 section 4.1.3.
+var helloRetryRequestRandom = []byte{
+	0xCF, 0x21, 0xAD, 0x74, 0xE5, 0x9A, 0x61, 0x11, 0xBE, 0x1D, 0x8C, 0x02, 0x1E, 0x65, 0xB8, 0x91,
+	0xC2, 0xA2, 0x11, 0x16, 0x7A, 0xBB, 0x8C, 0x5E, 0x07, 0x9E, 0x09, 0xE2, 0xC8, 0xA8, 0x33, 0x9C,
+}
+
+// marshalMessage returns the handshake message of type typ. f appends its body.
+func marshalMessage(typ uint8, f func(b *builder)) []byte {
+	var b builder
+	b.u8(typ)
+	b.vec(3, f)
+	return b.b
+}
+
+// extension appends an extension. f appends its data.
+func extension(b *builder, typ uint16, f func(b *builder)) {
+	b.u16(typ)
+	b.vec(2, f)
+}
+
+type keyShare struct {
+	group uint16
+	data  []byte
+}
+
+type pskIdentity struct {
+	identity []byte
+	age      uint32
+}
+
+type clientHello struct {
+	raw               []byte
+	random            []byte
+	sessionID         []byte
+	cipherSuites      []uint16
+	serverName        string
+	supportedGroups   []uint16
+	signatureSchemes  []uint16
+	supportedVersions []uint16
+	keyShares         []keyShare
+	pskModes          []uint8
+	alpn              []string
+	cookie            []byte
+	pskIdentities     []pskIdentity
+	pskBinders        [][]byte
+}
+
+func (m *clientHello) marshal() []byte {
+	m.raw = marshalMessage(typeClientHello, func(b *builder) {
+		b.u16(versionTLS12)
+		b.bytes(m.random)
+		b.vecBytes(1, m.sessionID)
+		b.vec(2, func(b *builder) {
+			for _, id := range m.cipherSuites {
+				b.u16(id)
+			}
+		})
+		b.vecBytes(1, []byte{0}) // null compression
+
+		b.vec(2, func(b *builder) {
+			if m.serverName != "" {
+				extension(b, extServerName, func(b *builder) {
+					b.vec(2, func(b *builder) {
+						b.u8(0) // host_name
+						b.vecBytes(2, []byte(m.serverName))
+					})
+				})
+			}
+			extension(b, extSupportedVersions, func(b *builder) {
+				b.vec(1, func(b *builder) {
+					for _, v := range m.supportedVersions {
+						b.u16(v)
+					}
+				})
+			})
+			extension(b, extSupportedGroups, func(b *builder) {
+				b.vec(2, func(b *builder) {
+					for _, g := range m.supportedGroups {
+						b.u16(g)
+					}
+				})
+			})
+			extension(b, extSignatureAlgorithms, func(b *builder) {
+				b.vec(2, func(b *builder) {
+					for _, s := range m.signatureSchemes {
+						b.u16(s)
+					}
+				})
+			})
+			extension(b, extKeyShare, func(b *builder) {
+				b.vec(2, func(b *builder) {
+					for _, ks := range m.keyShares {
+						b.u16(ks.group)
+						b.vecBytes(2, ks.data)
+					}
+				})
+			})
+			if len(m.alpn) > 0 {
+				extension(b, extALPN, func(b *builder) {
+					b.vec(2, func(b *builder) {
+						for _, proto := range m.alpn {
+							b.vecBytes(1, []byte(proto))
+						}
+					})
+				})
+			}
+			if m.cookie != nil {
+				extension(b, extCookie, func(b *builder) {
+					b.vecBytes(2, m.cookie)
+				})
+			}
+			if len(m.pskModes) > 0 {
+				extension(b, extPSKModes, func(b *builder) {
+					b.vecBytes(1, m.pskModes)
+				})
+			}
+			// The pre_shared_key extension must be the last one, see RFC 8446 section 4.2.11.
+			if len(m.pskIdentities) > 0 {
+				extension(b, extPreSharedKey, func(b *builder) {
+					b.vec(2, func(b *builder) {
+						for _, id := range m.pskIdentities {
+							b.vecBytes(2, id.identity)
+							b.u32(id.age)
+						}
+					})
+					b.vec(2, func(b *builder) {
+						for _, binder := range m.pskBinders {
+							b.vecBytes(1, binder)
+						}
+					})
+				})
+			}
+		})
+	})
+	return m.raw
+}
+
+// bindersLen returns the size of the binders of the pre_shared_key extension, which end the message.
+func (m *clientHello) bindersLen() int {
+	n := 2
+	for _, binder := range m.pskBinders {
+		n += 1 + len(binder)
+	}
+	return n
+}
+
+// updateBinders replaces the binders at the end of raw, which must have the same sizes.
+func (m *clientHello) updateBinders(binders [][]byte) {
+	m.pskBinders = binders
+	off := len(m.raw) - m.bindersLen() + 2
+	for _, binder := range binders {
+		off++
+		off += copy(m.raw[off:], binder)
+	}
+}
+
+func (m *clientHello) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	if p.u16() != versionTLS12 {
+		return false
+	}
+	m.random = p.take(32)
+	m.sessionID = p.vec(1)
+	if len(m.sessionID) > 32 {
+		return false
+	}
+	suites := p.sub(2)
+	for !suites.empty() {
+		m.cipherSuites = append(m.cipherSuites, suites.u16())
+	}
+	if !suites.ok() {
+		return false
+	}
+	if compression := p.vec(1); len(compression) != 1 || compression[0] != 0 {
+		return false
+	}
+	if p.empty() {
+		// Extensions are mandatory in TLS 1.3, this is an older version.
+		return p.ok()
+	}
+
+	seen := make(map[uint16]bool)
+	exts := p.sub(2)
+	for !exts.empty() {
+		typ := exts.u16()
+		data := exts.sub(2)
+		if !exts.ok() || seen[typ] {
+			return false
+		}
+		seen[typ] = true
+
+		switch typ {
+		case extServerName:
+			names := data.sub(2)
+			for !names.empty() {
+				nameType := names.u8()
+				name := names.vec(2)
+				if nameType == 0 && names.ok() {
+					m.serverName = string(name)
+				}
+			}
+			if !names.ok() {
+				return false
+			}
+		case extSupportedVersions:
+			versions := data.sub(1)
+			for !versions.empty() {
+				m.supportedVersions = append(m.supportedVersions, versions.u16())
+			}
+		case extSupportedGroups:
+			groups := data.sub(2)
+			for !groups.empty() {
+				m.supportedGroups = append(m.supportedGroups, groups.u16())
+			}
+		case extSignatureAlgorithms:
+			schemes := data.sub(2)
+			for !schemes.empty() {
+				m.signatureSchemes = append(m.signatureSchemes, schemes.u16())
+			}
+		case extKeyShare:
+			shares := data.sub(2)
+			for !shares.empty() {
+				ks := keyShare{group: shares.u16(), data: shares.vec(2)}
+				if !shares.ok() {
+					return false
+				}
+				m.keyShares = append(m.keyShares, ks)
+			}
+		case extALPN:
+			protos := data.sub(2)
+			for !protos.empty() {
+				proto := protos.vec(1)
+				if len(proto) == 0 {
+					return false
+				}
+				m.alpn = append(m.alpn, string(proto))
+			}
+		case extCookie:
+			m.cookie = data.vec(2)
+		case extPSKModes:
+			m.pskModes = data.vec(1)
+		case extPreSharedKey:
+			if !exts.empty() {
+				return false
+			}
+			ids := data.sub(2)
+			for !ids.empty() {
+				id := pskIdentity{identity: ids.vec(2), age: ids.u32()}
+				if !ids.ok() {
+					return false
+				}
+				m.pskIdentities = append(m.pskIdentities, id)
+			}
+			binders := data.sub(2)
+			for !binders.empty() {
+				binder := binders.vec(1)
+				if !binders.ok() {
+					return false
+				}
+				m.pskBinders = append(m.pskBinders, binder)
+			}
+			if len(m.pskIdentities) == 0 || len(m.pskIdentities) != len(m.pskBinders) {
+				return false
+			}
+		default:
+			continue
+		}
+		if !data.done() {
+			return false
+		}
+	}
+	return exts.ok() && p.done()
+}
+
+type serverHello struct {
+	raw              []byte
+	random           []byte
+	sessionID        []byte
+	cipherSuite      uint16
+	supportedVersion uint16
+	keyShare         keyShare
+	selectedGroup    uint16
+	hasPSK           bool
+	selectedIdentity uint16
+	cookie           []byte
+}
+
+func (m *serverHello) isHelloRetryRequest() bool {
+	return string(m.random) == string(helloRetryRequestRandom)
+}
+
+func (m *serverHello) marshal() []byte {
+	m.raw = marshalMessage(typeServerHello, func(b *builder) {
+		b.u16(versionTLS12)
+		b.bytes(m.random)
+		b.vecBytes(1, m.sessionID)
+		b.u16(m.cipherSuite)
+		b.u8(0) // null compression
+
+		b.vec(2, func(b *builder) {
+			extension(b, extSupportedVersions, func(b *builder) {
+				b.u16(m.supportedVersion)
+			})
+			if m.selectedGroup != 0 {
+				extension(b, extKeyShare, func(b *builder) {
+					b.u16(m.selectedGroup)
+				})
+			} else if m.keyShare.group != 0 {
+				extension(b, extKeyShare, func(b *builder) {
+					b.u16(m.keyShare.group)
+					b.vecBytes(2, m.keyShare.data)
+				})
+			}
+			if m.hasPSK {
+				extension(b, extPreSharedKey, func(b *builder) {
+					b.u16(m.selectedIdentity)
+				})
+			}
+			if m.cookie != nil {
+				extension(b, extCookie, func(b *builder) {
+					b.vecBytes(2, m.cookie)
+				})
+			}
+		})
+	})
+	return m.raw
+}
+
+func (m *serverHello) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	if p.u16() != versionTLS12 {
+		return false
+	}
+	m.random = p.take(32)
+	m.sessionID = p.vec(1)
+	m.cipherSuite = p.u16()
+	if p.u8() != 0 {
+		return false
+	}
+	if p.empty() {
+		return p.ok()
+	}
+
+	hrr := m.isHelloRetryRequest()
+	seen := make(map[uint16]bool)
+	exts := p.sub(2)
+	for !exts.empty() {
+		typ := exts.u16()
+		data := exts.sub(2)
+		if !exts.ok() || seen[typ] {
+			return false
+		}
+		seen[typ] = true
+
+		switch typ {
+		case extSupportedVersions:
+			m.supportedVersion = data.u16()
+		case extKeyShare:
+			if hrr {
+				m.selectedGroup = data.u16()
+			} else {
+				m.keyShare = keyShare{group: data.u16(), data: data.vec(2)}
+			}
+		case extPreSharedKey:
+			m.hasPSK = true
+			m.selectedIdentity = data.u16()
+		case extCookie:
+			m.cookie = data.vec(2)
+		default:
+			continue
+		}
+		if !data.done() {
+			return false
+		}
+	}
+	return exts.ok() && p.done()
+}
+
+type encryptedExtensions struct {
+	raw           []byte
+	alpn          string
+	serverNameAck bool
+}
+
+func (m *encryptedExtensions) marshal() []byte {
+	m.raw = marshalMessage(typeEncryptedExtensions, func(b *builder) {
+		b.vec(2, func(b *builder) {
+			if m.serverNameAck {
+				extension(b, extServerName, func(b *builder) {})
+			}
+			if m.alpn != "" {
+				extension(b, extALPN, func(b *builder) {
+					b.vec(2, func(b *builder) {
+						b.vecBytes(1, []byte(m.alpn))
+					})
+				})
+			}
+		})
+	})
+	return m.raw
+}
+
+func (m *encryptedExtensions) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	exts := p.sub(2)
+	for !exts.empty() {
+		typ := exts.u16()
+		data := exts.sub(2)
+		if !exts.ok() {
+			return false
+		}
+
+		switch typ {
+		case extServerName:
+			m.serverNameAck = true
+		case extALPN:
+			protos := data.sub(2)
+			m.alpn = string(protos.vec(1))
+			if m.alpn == "" || !protos.done() {
+				return false
+			}
+		default:
+			continue
+		}
+		if !data.done() {
+			return false
+		}
+	}
+	return exts.ok() && p.done()
+}
+
+type certificateRequest struct {
+	raw              []byte
+	context          []byte
+	signatureSchemes []uint16
+}
+
+func (m *certificateRequest) marshal() []byte {
+	m.raw = marshalMessage(typeCertificateRequest, func(b *builder) {
+		b.vecBytes(1, m.context)
+		b.vec(2, func(b *builder) {
+			extension(b, extSignatureAlgorithms, func(b *builder) {
+				b.vec(2, func(b *builder) {
+					for _, s := range m.signatureSchemes {
+						b.u16(s)
+					}
+				})
+			})
+		})
+	})
+	return m.raw
+}
+
+func (m *certificateRequest) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	m.context = p.vec(1)
+	exts := p.sub(2)
+	for !exts.empty() {
+		typ := exts.u16()
+		data := exts.sub(2)
+		if !exts.ok() {
+			return false
+		}
+
+		if typ == extSignatureAlgorithms {
+			schemes := data.sub(2)
+			for !schemes.empty() {
+				m.signatureSchemes = append(m.signatureSchemes, schemes.u16())
+			}
+			if !schemes.ok() || !data.done() {
+				return false
+			}
+		}
+	}
+	return exts.ok() && p.done() && len(m.signatureSchemes) > 0
+}
+
+type certificateMsg struct {
+	raw          []byte
+	context      []byte
+	certificates [][]byte
+}
+
+func (m *certificateMsg) marshal() []byte {
+	m.raw = marshalMessage(typeCertificate, func(b *builder) {
+		b.vecBytes(1, m.context)
+		b.vec(3, func(b *builder) {
+			for _, cert := range m.certificates {
+				b.vecBytes(3, cert)
+				b.u16(0) // no extensions
+			}
+		})
+	})
+	return m.raw
+}
+
+func (m *certificateMsg) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	m.context = p.vec(1)
+	certs := p.sub(3)
+	for !certs.empty() {
+		cert := certs.vec(3)
+		_ = certs.vec(2) // extensions, like OCSP staples, are ignored
+		if !certs.ok() || len(cert) == 0 {
+			return false
+		}
+		m.certificates = append(m.certificates, cert)
+	}
+	return certs.ok() && p.done()
+}
+
+type certificateVerify struct {
+	raw       []byte
+	scheme    uint16
+	signature []byte
+}
+
+func (m *certificateVerify) marshal() []byte {
+	m.raw = marshalMessage(typeCertificateVerify, func(b *builder) {
+		b.u16(m.scheme)
+		b.vecBytes(2, m.signature)
+	})
+	return m.raw
+}
+
+func (m *certificateVerify) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	m.scheme = p.u16()
+	m.signature = p.vec(2)
+	return p.done()
+}
+
+type finished struct {
+	raw        []byte
+	verifyData []byte
+}
+
+func (m *finished) marshal() []byte {
+	m.raw = marshalMessage(typeFinished, func(b *builder) {
+		b.bytes(m.verifyData)
+	})
+	return m.raw
+}
+
+func (m *finished) unmarshal(raw []byte) bool {
+	m.raw = raw
+	m.verifyData = raw[handshakeHeaderLen:]
+	return true
+}
+
+type newSessionTicket struct {
+	raw      []byte
+	lifetime uint32
+	ageAdd   uint32
+	nonce    []byte
+	ticket   []byte
+}
+
+func (m *newSessionTicket) marshal() []byte {
+	m.raw = marshalMessage(typeNewSessionTicket, func(b *builder) {
+		b.u32(m.lifetime)
+		b.u32(m.ageAdd)
+		b.vecBytes(1, m.nonce)
+		b.vecBytes(2, m.ticket)
+		b.u16(0) // no extensions
+	})
+	return m.raw
+}
+
+func (m *newSessionTicket) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	m.lifetime = p.u32()
+	m.ageAdd = p.u32()
+	m.nonce = p.vec(1)
+	m.ticket = p.vec(2)
+	_ = p.vec(2) // extensions, like early_data, are ignored
+	return p.done() && len(m.ticket) > 0
+}
+
+type keyUpdate struct {
+	raw           []byte
+	updateRequest bool
+}
+
+func (m *keyUpdate) marshal() []byte {
+	m.raw = marshalMessage(typeKeyUpdate, func(b *builder) {
+		if m.updateRequest {
+			b.u8(1)
+		} else {
+			b.u8(0)
+		}
+	})
+	return m.raw
+}
+
+func (m *keyUpdate) unmarshal(raw []byte) bool {
+	m.raw = raw
+	p := &parser{b: raw[handshakeHeaderLen:]}
+
+	request := p.u8()
+	m.updateRequest = request == 1
+	return p.done() && request <= 1
+}
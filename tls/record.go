@@ -0,0 +1,150 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+)
+
+// Record content types, see RFC 8446 section 5.1.
+const (
+	recordTypeChangeCipherSpec uint8 = 20
+	recordTypeAlert            uint8 = 21
+	recordTypeHandshake        uint8 = 22
+	recordTypeApplicationData  uint8 = 23
+)
+
+const (
+	recordHeaderLen = 5
+
+	// maxPlaintext is the maximum size of the content of a record.
+	maxPlaintext = 1 << 14
+
+	// maxCiphertext is the maximum size of a protected record, without its header.
+	maxCiphertext = maxPlaintext + 256
+
+	aeadNonceLen = 12
+
+	versionTLS12 = 0x0303
+	versionTLS13 = 0x0304
+)
+
+// halfConn protects the records of one direction of a Conn. Records are not protected until keys are set.
+type halfConn struct {
+	suite  *cipherSuite
+	secret []byte
+	aead   cipher.AEAD
+	iv     []byte
+	seq    uint64
+	nonce  [aeadNonceLen]byte
+}
+
+// setKey starts protecting records with the keys of the traffic secret.
+func (h *halfConn) setKey(suite *cipherSuite, secret []byte) error {
+	key, iv := suite.trafficKey(secret)
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	h.suite = suite
+	h.secret = secret
+	h.aead = aead
+	h.iv = iv
+	h.seq = 0
+	return nil
+}
+
+// update moves on to the next traffic secret, see RFC 8446 section 4.6.3.
+func (h *halfConn) update() error {
+	return h.setKey(h.suite, h.suite.nextTrafficSecret(h.secret))
+}
+
+func (h *halfConn) protected() bool {
+	return h.aead != nil
+}
+
+// nextNonce returns the nonce of the next record, see RFC 8446 section 5.3.
+func (h *halfConn) nextNonce() []byte {
+	copy(h.nonce[:], h.iv)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], h.seq)
+	for i, b := range seq {
+		h.nonce[aeadNonceLen-8+i] ^= b
+	}
+	h.seq++
+	return h.nonce[:]
+}
+
+// seal appends to b the records carrying content of type typ, split such that each fits in a record.
+func (h *halfConn) seal(b []byte, typ uint8, content []byte) []byte {
+	for {
+		n := len(content)
+		if n > maxPlaintext {
+			n = maxPlaintext
+		}
+		b = h.sealRecord(b, typ, content[:n])
+		content = content[n:]
+		if len(content) == 0 {
+			return b
+		}
+	}
+}
+
+func (h *halfConn) sealRecord(b []byte, typ uint8, content []byte) []byte {
+	if !h.protected() {
+		b = append(b, typ, versionTLS12>>8, versionTLS12&0xff, byte(len(content)>>8), byte(len(content)))
+		return append(b, content...)
+	}
+
+	// The content type is appended to the content and the record looks like application data, see RFC 8446
+	// section 5.2.
+	length := len(content) + 1 + h.aead.Overhead()
+	start := len(b)
+	b = append(b, recordTypeApplicationData, versionTLS12>>8, versionTLS12&0xff, byte(length>>8), byte(length))
+	b = append(b, content...)
+	b = append(b, typ)
+
+	// Make room for the tag such that the record is sealed in place.
+	end := len(b)
+	for i := 0; i < h.aead.Overhead(); i++ {
+		b = append(b, 0)
+	}
+
+	header := b[start : start+recordHeaderLen]
+	inner := b[start+recordHeaderLen : end]
+	h.aead.Seal(inner[:0], h.nextNonce(), inner, header)
+	return b
+}
+
+var errBadRecordMAC = errors.New("record authentication failed")
+
+// open decrypts the record with header in place, and returns its actual content type and content.
+func (h *halfConn) open(header, payload []byte) (typ uint8, content []byte, err error) {
+	typ = header[0]
+	if !h.protected() || typ == recordTypeChangeCipherSpec {
+		return typ, payload, nil
+	}
+	if typ != recordTypeApplicationData {
+		return 0, nil, failf(alertUnexpectedMessage, "unprotected record of type %d", typ)
+	}
+
+	content, err = h.aead.Open(payload[:0], h.nextNonce(), payload, header)
+	if err != nil {
+		return 0, nil, fail(alertBadRecordMAC, errBadRecordMAC)
+	}
+
+	// Strip the padding, the content type is the last non-zero byte.
+	i := len(content) - 1
+	for i >= 0 && content[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, failf(alertUnexpectedMessage, "record without content type")
+	}
+	typ, content = content[i], content[:i]
+	if len(content) > maxPlaintext {
+		return 0, nil, failf(alertRecordOverflow, "record of %d bytes", len(content))
+	}
+	return typ, content, nil
+}
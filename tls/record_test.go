@@ -0,0 +1,94 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"testing"
+)
+
+func TestKeyScheduleRFC8448(t *testing.T) {
+	// The early secret and the derived secret of a handshake without PSK, see RFC 8448 section 3.
+	suite := cipherSuiteByID(tls.TLS_AES_128_GCM_SHA256)
+	ks := newKeySchedule(suite, nil)
+	if got := hex.EncodeToString(ks.secret); got != "33ad0a1c607ec03b09e6cd9893680ce210adf300aa1f2660e1b22e10f170f92a" {
+		t.Fatalf("invalid early secret %s", got)
+	}
+	derived := ks.derive("derived", nil)
+	if got := hex.EncodeToString(derived); got != "6f2615a108c702c5678f54fc9dbab69716c076189c48250cebeac3576c3611ba" {
+		t.Fatalf("invalid derived secret %s", got)
+	}
+}
+
+func TestRecordSealOpen(t *testing.T) {
+	for _, suite := range cipherSuites {
+		secret := bytes.Repeat([]byte{1}, suite.hashLen())
+
+		var out, in halfConn
+		if err := out.setKey(suite, secret); err != nil {
+			t.Fatal(err)
+		}
+		if err := in.setKey(suite, secret); err != nil {
+			t.Fatal(err)
+		}
+
+		content := bytes.Repeat([]byte("sonic"), maxPlaintext/2)
+		b := out.seal(nil, recordTypeApplicationData, content)
+
+		var opened []byte
+		for len(b) > 0 {
+			length := int(b[3])<<8 | int(b[4])
+			if b[0] != recordTypeApplicationData || length > maxCiphertext {
+				t.Fatalf("invalid record header %v", b[:recordHeaderLen])
+			}
+			typ, plain, err := in.open(b[:recordHeaderLen], b[recordHeaderLen:recordHeaderLen+length])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != recordTypeApplicationData {
+				t.Fatalf("invalid content type %d", typ)
+			}
+			opened = append(opened, plain...)
+			b = b[recordHeaderLen+length:]
+		}
+		if !bytes.Equal(opened, content) {
+			t.Fatal("invalid content")
+		}
+
+		// Tampered records are rejected.
+		b = out.seal(nil, recordTypeHandshake, []byte("hello"))
+		b[len(b)-1] ^= 1
+		if _, _, err := in.open(b[:recordHeaderLen], b[recordHeaderLen:]); alertOf(err) != alertBadRecordMAC {
+			t.Fatalf("expected a bad record MAC, got %v", err)
+		}
+	}
+}
+
+func TestClientHelloBinders(t *testing.T) {
+	m := &clientHello{
+		random:            make([]byte, 32),
+		cipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		serverName:        "example.com",
+		supportedVersions: []uint16{versionTLS13},
+		supportedGroups:   defaultGroups,
+		signatureSchemes:  signatureSchemes,
+		keyShares:         []keyShare{{group: groupX25519, data: make([]byte, 32)}},
+		pskModes:          []uint8{pskModeDHE},
+		alpn:              []string{"h2"},
+		pskIdentities:     []pskIdentity{{identity: []byte("ticket"), age: 42}},
+		pskBinders:        [][]byte{make([]byte, 32)},
+	}
+	m.marshal()
+	m.updateBinders([][]byte{bytes.Repeat([]byte{7}, 32)})
+
+	var parsed clientHello
+	if !parsed.unmarshal(m.raw) {
+		t.Fatal("invalid ClientHello")
+	}
+	if parsed.serverName != "example.com" || parsed.alpn[0] != "h2" || string(parsed.pskIdentities[0].identity) != "ticket" {
+		t.Fatalf("invalid ClientHello %+v", parsed)
+	}
+	if !bytes.Equal(parsed.pskBinders[0], bytes.Repeat([]byte{7}, 32)) {
+		t.Fatal("binders not updated")
+	}
+}
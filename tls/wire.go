@@ -0,0 +1,131 @@
+package tls
+
+// builder appends the TLS presentation language encodings of values to a byte slice.
+type builder struct {
+	b []byte
+}
+
+func (b *builder) u8(v uint8) {
+	b.b = append(b.b, v)
+}
+
+func (b *builder) u16(v uint16) {
+	b.b = append(b.b, byte(v>>8), byte(v))
+}
+
+func (b *builder) u24(v int) {
+	b.b = append(b.b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *builder) u32(v uint32) {
+	b.b = append(b.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *builder) bytes(v []byte) {
+	b.b = append(b.b, v...)
+}
+
+// vec appends a vector whose length is encoded on n bytes. f appends its content.
+func (b *builder) vec(n int, f func(b *builder)) {
+	start := len(b.b)
+	for i := 0; i < n; i++ {
+		b.b = append(b.b, 0)
+	}
+
+	f(b)
+
+	length := len(b.b) - start - n
+	for i := n - 1; i >= 0; i-- {
+		b.b[start+i] = byte(length)
+		length >>= 8
+	}
+}
+
+// vecBytes appends v as a vector whose length is encoded on n bytes.
+func (b *builder) vecBytes(n int, v []byte) {
+	b.vec(n, func(b *builder) { b.bytes(v) })
+}
+
+// parser decodes the TLS presentation language encodings of values. Errors are sticky: once it runs out of bytes, all
+// reads return zero values and ok returns false.
+type parser struct {
+	b   []byte
+	bad bool
+}
+
+func (p *parser) take(n int) []byte {
+	if p.bad || len(p.b) < n {
+		p.bad = true
+		p.b = nil
+		return nil
+	}
+	v := p.b[:n]
+	p.b = p.b[n:]
+	return v
+}
+
+func (p *parser) u8() uint8 {
+	if v := p.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (p *parser) u16() uint16 {
+	if v := p.take(2); v != nil {
+		return uint16(v[0])<<8 | uint16(v[1])
+	}
+	return 0
+}
+
+func (p *parser) u24() int {
+	if v := p.take(3); v != nil {
+		return int(v[0])<<16 | int(v[1])<<8 | int(v[2])
+	}
+	return 0
+}
+
+func (p *parser) u32() uint32 {
+	if v := p.take(4); v != nil {
+		return uint32(v[0])<<24 | uint32(v[1])<<16 | uint32(v[2])<<8 | uint32(v[3])
+	}
+	return 0
+}
+
+// vec returns the content of a vector whose length is encoded on n bytes.
+func (p *parser) vec(n int) []byte {
+	var length int
+	for i := 0; i < n; i++ {
+		length = length<<8 | int(p.u8())
+	}
+	if p.bad {
+		return nil
+	}
+	if v := p.take(length); v != nil {
+		return v
+	}
+	if length == 0 && !p.bad {
+		return []byte{}
+	}
+	return nil
+}
+
+// sub returns a parser over the content of a vector whose length is encoded on n bytes.
+func (p *parser) sub(n int) *parser {
+	v := p.vec(n)
+	return &parser{b: v, bad: p.bad}
+}
+
+func (p *parser) empty() bool {
+	return len(p.b) == 0
+}
+
+// ok returns true if all reads succeeded.
+func (p *parser) ok() bool {
+	return !p.bad
+}
+
+// done returns true if all reads succeeded and all bytes were read.
+func (p *parser) done() bool {
+	return !p.bad && len(p.b) == 0
+}
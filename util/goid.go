@@ -0,0 +1,27 @@
+package util
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+var goroutinePrefix = []byte("goroutine ")
+
+// GoroutineID returns the id of the calling goroutine.
+//
+// The runtime does not expose goroutine ids, so they are parsed from the header of the goroutine's stack trace:
+// "goroutine 18 [running]:". This is slow, so callers should cache the result.
+func GoroutineID() int64 {
+	var b [64]byte
+	s := b[:runtime.Stack(b[:], false)]
+	s = bytes.TrimPrefix(s, goroutinePrefix)
+	if i := bytes.IndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	id, err := strconv.ParseInt(string(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,21 @@
+package util
+
+import "testing"
+
+func TestGoroutineID(t *testing.T) {
+	id := GoroutineID()
+	if id <= 0 {
+		t.Fatalf("expected a positive goroutine id, got %d", id)
+	}
+	if GoroutineID() != id {
+		t.Fatal("expected the same goroutine id on the same goroutine")
+	}
+
+	other := make(chan int64)
+	go func() {
+		other <- GoroutineID()
+	}()
+	if otherID := <-other; otherID <= 0 || otherID == id {
+		t.Fatalf("expected a different positive goroutine id, got %d and %d", id, otherID)
+	}
+}
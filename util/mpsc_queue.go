@@ -0,0 +1,53 @@
+package util
+
+import "sync/atomic"
+
+type mpscNode[T any] struct {
+	v    T
+	next atomic.Pointer[mpscNode[T]]
+}
+
+// MPSCQueue is an unbounded, lock-free, multi-producer single-consumer FIFO queue.
+//
+// Push is safe for concurrent use. Pop must only be called by a single consumer at a time.
+type MPSCQueue[T any] struct {
+	// head is the most recently pushed node. Producers swap it.
+	head atomic.Pointer[mpscNode[T]]
+
+	// tail is the most recently popped node, or the initial stub node. Only the consumer touches it.
+	tail *mpscNode[T]
+}
+
+func NewMPSCQueue[T any]() *MPSCQueue[T] {
+	stub := &mpscNode[T]{}
+	q := &MPSCQueue[T]{tail: stub}
+	q.head.Store(stub)
+	return q
+}
+
+// Push adds v to the back of the queue.
+func (q *MPSCQueue[T]) Push(v T) {
+	node := &mpscNode[T]{v: v}
+	prev := q.head.Swap(node)
+	// Between the above swap and the below store, the node is not reachable by the consumer, which sees the queue as
+	// ending at prev.
+	prev.next.Store(node)
+}
+
+// Pop removes and returns the value at the front of the queue. It returns false if the queue is empty or if the
+// producer of the value at the front did not finish pushing it yet.
+func (q *MPSCQueue[T]) Pop() (v T, ok bool) {
+	next := q.tail.next.Load()
+	if next == nil {
+		return v, false
+	}
+
+	v = next.v
+
+	// next becomes the stub, so we must not keep a reference to its value.
+	var zero T
+	next.v = zero
+	q.tail = next
+
+	return v, true
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestMPSCQueue(t *testing.T) {
+	q := NewMPSCQueue[int]()
+
+	if _, ok := q.Pop(); ok {
+		t.Fatal("queue should be empty")
+	}
+
+	for i := 0; i < 10; i++ {
+		q.Push(i)
+	}
+	for i := 0; i < 10; i++ {
+		v, ok := q.Pop()
+		if !ok || v != i {
+			t.Fatalf("expected %d, got %d ok=%v", i, v, ok)
+		}
+	}
+
+	if _, ok := q.Pop(); ok {
+		t.Fatal("queue should be empty")
+	}
+}
+
+func TestMPSCQueueConcurrent(t *testing.T) {
+	const (
+		producers = 8
+		n         = 10000
+	)
+
+	q := NewMPSCQueue[[2]int]()
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				q.Push([2]int{p, i})
+			}
+		}(p)
+	}
+
+	// Values pushed by the same producer must be popped in order.
+	var next [producers]int
+	for popped := 0; popped < producers*n; {
+		v, ok := q.Pop()
+		if !ok {
+			// Let the producers run, the consumer would otherwise starve them on a single CPU.
+			runtime.Gosched()
+			continue
+		}
+		if v[1] != next[v[0]] {
+			t.Fatalf("producer %d: expected %d, got %d", v[0], next[v[0]], v[1])
+		}
+		next[v[0]]++
+		popped++
+	}
+	wg.Wait()
+
+	if _, ok := q.Pop(); ok {
+		t.Fatal("queue should be empty")
+	}
+}
+
+func BenchmarkMPSCQueue(b *testing.B) {
+	q := NewMPSCQueue[int]()
+	for i := 0; i < b.N; i++ {
+		q.Push(i)
+		q.Pop()
+	}
+	b.ReportAllocs()
+}